@@ -0,0 +1,87 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strconv"
+)
+
+// FromACMEMaterial encodes the identity issued by an ACME CA, as saved by
+// certbot and similar clients, in one call.  privateKeyDER is the private
+// key of the certificate in PKCS#8, PKCS#1 or SEC 1 DER, and chainPEM holds
+// the PEM certificates of the issued chain, leaf first, as in certbot's
+// fullchain.pem; other PEM blocks are ignored.
+//
+// The key must match the leaf, or ErrKeyMismatch is returned, and each
+// certificate must be issued by the one following it, so that a chain in
+// the wrong order is refused rather than imported with a leaf that is not
+// the identity's.  The certificates get aliases as by WithGeneratedAliases,
+// so that browsers and the Windows certificate store list the identity
+// under the name of the leaf.
+func (enc *Encoder) FromACMEMaterial(privateKeyDER, chainPEM []byte, password string) (pfxData []byte, err error) {
+	privateKey, err := parseACMEKey(privateKeyDER)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []*x509.Certificate
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.New("pkcs12: error parsing certificate " + strconv.Itoa(len(chain)) + " of the chain: " + err.Error())
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("pkcs12: no certificate in the chain")
+	}
+
+	if err = MatchKeyToCert(privateKey, chain[0]); err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(chain); i++ {
+		if err = chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return nil, errors.New("pkcs12: certificate " + strconv.Itoa(i) + " of the chain is not issued by the next one: " + err.Error())
+		}
+	}
+
+	return enc.WithGeneratedAliases(true).Encode(privateKey, chain[0], chain[1:], password)
+}
+
+// FromACMEMaterial is equivalent to profile.FromACMEMaterial, or to
+// Modern.FromACMEMaterial if profile is nil.  Identities for Windows before
+// Windows Server 2019 need LegacyDES.AllowWeakAlgorithms().
+func FromACMEMaterial(privateKeyDER, chainPEM []byte, password string, profile *Encoder) (pfxData []byte, err error) {
+	if profile == nil {
+		profile = Modern
+	}
+	return profile.FromACMEMaterial(privateKeyDER, chainPEM, password)
+}
+
+// parseACMEKey parses der, a private key in PKCS#8, PKCS#1 or SEC 1 form.
+func parseACMEKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("pkcs12: private key is not in PKCS#8, PKCS#1 or SEC 1 form")
+}
@@ -0,0 +1,80 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestFromACMEMaterial(t *testing.T) {
+	rootKey, root := makeTestCertificate(t, "Test Root", true, nil, nil)
+	intermediateKey, intermediate := makeTestCertificate(t, "Test Intermediate", true, root, rootKey)
+	key, leaf := makeTestCertificate(t, "leaf.example.com", false, intermediate, intermediateKey)
+	otherKey, _ := makeTestCertificate(t, "other.example.com", false, nil, nil)
+
+	chainPEM := func(certs ...*x509.Certificate) []byte {
+		var out []byte
+		for _, cert := range certs {
+			out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+		return out
+	}
+	pkcs8Key, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, der := range [][]byte{pkcs8Key, ecKey} {
+		pfxData, err := FromACMEMaterial(der, chainPEM(leaf, intermediate), DefaultPassword, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decodedKey, decodedLeaf, caCerts, err := DecodeChain(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.Equal(decodedKey) || !decodedLeaf.Equal(leaf) || len(caCerts) != 1 || !caCerts[0].Equal(intermediate) {
+			t.Error("identity differs from the ACME material")
+		}
+		contents, err := DecodeContents(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := contents[0].Entries[0].FriendlyName(); name != "leaf.example.com" {
+			t.Errorf("expected the leaf to be named leaf.example.com, got %q", name)
+		}
+	}
+
+	if _, err := LegacyDES.AllowWeakAlgorithms().FromACMEMaterial(pkcs8Key, chainPEM(leaf), DefaultPassword); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := FromACMEMaterial(pkcs8Key, chainPEM(intermediate, leaf), DefaultPassword, nil); err != ErrKeyMismatch {
+		t.Errorf("expected ErrKeyMismatch for a chain with the leaf last, got %v", err)
+	}
+	if _, err := FromACMEMaterial(pkcs8Key, chainPEM(leaf, root), DefaultPassword, nil); err == nil {
+		t.Error("accepted a chain missing its intermediate")
+	}
+	otherDER, err := x509.MarshalPKCS8PrivateKey(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromACMEMaterial(otherDER, chainPEM(leaf), DefaultPassword, nil); err != ErrKeyMismatch {
+		t.Errorf("expected ErrKeyMismatch, got %v", err)
+	}
+	if _, err := FromACMEMaterial(pkcs8Key, nil, DefaultPassword, nil); err == nil {
+		t.Error("accepted an empty chain")
+	}
+	if _, err := FromACMEMaterial([]byte("not a key"), chainPEM(leaf), DefaultPassword, nil); err == nil {
+		t.Error("accepted a malformed private key")
+	}
+}
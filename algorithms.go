@@ -0,0 +1,158 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"encoding/asn1"
+)
+
+// An AlgorithmUsage identifies the part of a PKCS#12 file that an algorithm
+// is used for.
+type AlgorithmUsage string
+
+const (
+	// UsageMAC is the integrity MAC over the authenticated safe.
+	UsageMAC AlgorithmUsage = "mac"
+	// UsageSafeContents is the encryption of a SafeContents.
+	UsageSafeContents AlgorithmUsage = "safeContents"
+	// UsageKeyBag is the encryption of a PKCS#8 shrouded key bag.
+	UsageKeyBag AlgorithmUsage = "keyBag"
+)
+
+// algorithmNames maps the OIDs this package knows about to the names used
+// by OpenSSL.
+var algorithmNames = map[string]string{
+	oidSHA1.String():   "sha1",
+	oidSHA224.String(): "sha224",
+	oidSHA256.String(): "sha256",
+	oidSHA384.String(): "sha384",
+	oidSHA512.String(): "sha512",
+
+	oidSHA512_224.String(): "sha512-224",
+	oidSHA512_256.String(): "sha512-256",
+
+	oidPBEWithSHAAnd3KeyTripleDESCBC.String(): "pbeWithSHA1And3-KeyTripleDES-CBC",
+	oidPBEWithSHAAnd40BitRC2CBC.String():      "pbeWithSHA1And40BitRC2-CBC",
+
+	oidPBES2.String():  "PBES2",
+	oidPBKDF2.String(): "PBKDF2",
+
+	oidHmacWithSHA1.String():       "hmacWithSHA1",
+	oidHmacWithSHA224.String():     "hmacWithSHA224",
+	oidHmacWithSHA256.String():     "hmacWithSHA256",
+	oidHmacWithSHA384.String():     "hmacWithSHA384",
+	oidHmacWithSHA512.String():     "hmacWithSHA512",
+	oidHmacWithSHA512_224.String(): "hmacWithSHA512-224",
+	oidHmacWithSHA512_256.String(): "hmacWithSHA512-256",
+
+	oidAES128CBC.String(): "AES-128-CBC",
+	oidAES192CBC.String(): "AES-192-CBC",
+	oidAES256CBC.String(): "AES-256-CBC",
+
+	oidCamellia128CBC.String(): "CAMELLIA-128-CBC",
+	oidCamellia192CBC.String(): "CAMELLIA-192-CBC",
+	oidCamellia256CBC.String(): "CAMELLIA-256-CBC",
+	oidSEEDCBC.String():        "SEED-CBC",
+	oidDESEDE3CBC.String():     "DES-EDE3-CBC",
+
+	oidAES128GCM.String(): "AES-128-GCM",
+	oidAES192GCM.String(): "AES-192-GCM",
+	oidAES256GCM.String(): "AES-256-GCM",
+
+	oidChaCha20Poly1305.String(): "ChaCha20-Poly1305",
+}
+
+// algorithmName returns a human-readable name for algorithm, or its dotted
+// OID if it is unknown.
+func algorithmName(algorithm asn1.ObjectIdentifier) string {
+	if name, ok := algorithmNames[algorithm.String()]; ok {
+		return name
+	}
+	return algorithm.String()
+}
+
+// An AlgorithmRole is the part an algorithm plays in protecting a PKCS#12
+// file.
+type AlgorithmRole string
+
+const (
+	// RoleMAC is the digest of the integrity MAC.
+	RoleMAC AlgorithmRole = "mac"
+	// RoleEncryption is a password-based encryption scheme of
+	// SafeContents and PKCS#8 shrouded key bags.
+	RoleEncryption AlgorithmRole = "encryption"
+	// RoleKDF is the key derivation function of PBES2.
+	RoleKDF AlgorithmRole = "kdf"
+	// RolePRF is the pseudorandom function of PBKDF2.
+	RolePRF AlgorithmRole = "prf"
+	// RoleCipher is the encryption scheme of PBES2.
+	RoleCipher AlgorithmRole = "cipher"
+)
+
+// A SupportedAlgorithm is an algorithm this package can decode and encode.
+type SupportedAlgorithm struct {
+	Role      AlgorithmRole
+	Algorithm asn1.ObjectIdentifier
+	// Name is the OpenSSL name of Algorithm.
+	Name string
+	// Weak is set for the algorithms an Encoder only uses after
+	// AllowWeakAlgorithms, and which WeakAlgorithms reports.
+	Weak bool
+	// FIPS is set for the algorithms WithFIPSMode accepts.
+	FIPS bool
+	// OptIn is set for the algorithms a Decoder only accepts once allowed,
+	// such as ChaCha20-Poly1305 with AllowChaCha20Poly1305.
+	OptIn bool
+}
+
+// SupportedAlgorithms returns the password-based algorithms this build of
+// the package supports, so that an application can check at startup that
+// it handles the files it will be given.  The hash functions are those
+// linked into the binary, see crypto.Hash.Available.
+func SupportedAlgorithms() []SupportedAlgorithm {
+	var supported []SupportedAlgorithm
+	add := func(role AlgorithmRole, algorithm asn1.ObjectIdentifier, weak, fips, optIn bool) {
+		supported = append(supported, SupportedAlgorithm{
+			Role:      role,
+			Algorithm: algorithm,
+			Name:      algorithmName(algorithm),
+			Weak:      weak,
+			FIPS:      fips,
+			OptIn:     optIn,
+		})
+	}
+
+	for _, algorithm := range []asn1.ObjectIdentifier{oidSHA1, oidSHA224, oidSHA256, oidSHA384, oidSHA512, oidSHA512_224, oidSHA512_256} {
+		if h, err := macHashFor(algorithm); err == nil && h.Available() {
+			add(RoleMAC, algorithm, h == crypto.SHA1, fipsApprovedMac(algorithm), false)
+		}
+	}
+
+	add(RoleEncryption, oidPBEWithSHAAnd3KeyTripleDESCBC, true, false, false)
+	add(RoleEncryption, oidPBEWithSHAAnd40BitRC2CBC, true, false, false)
+	add(RoleEncryption, oidPBES2, false, true, false)
+	add(RoleKDF, oidPBKDF2, false, true, false)
+
+	for _, algorithm := range []asn1.ObjectIdentifier{oidHmacWithSHA1, oidHmacWithSHA224, oidHmacWithSHA256, oidHmacWithSHA384, oidHmacWithSHA512, oidHmacWithSHA512_224, oidHmacWithSHA512_256} {
+		if h, err := prfFor(algorithm); err == nil && h.Available() {
+			add(RolePRF, algorithm, h == crypto.SHA1, fipsApprovedPRF(algorithm), false)
+		}
+	}
+
+	for _, algorithm := range []asn1.ObjectIdentifier{
+		oidAES128CBC, oidAES192CBC, oidAES256CBC,
+		oidAES128GCM, oidAES192GCM, oidAES256GCM,
+		oidCamellia128CBC, oidCamellia192CBC, oidCamellia256CBC,
+		oidSEEDCBC, oidChaCha20Poly1305,
+	} {
+		if _, err := pbes2KeyLen(algorithm); err == nil {
+			add(RoleCipher, algorithm, false, fipsApprovedCipher(algorithm), algorithm.Equal(oidChaCha20Poly1305))
+		}
+	}
+
+	return supported
+}
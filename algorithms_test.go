@@ -0,0 +1,65 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import "testing"
+
+func TestSupportedAlgorithms(t *testing.T) {
+	supported := make(map[string]SupportedAlgorithm)
+	for _, algorithm := range SupportedAlgorithms() {
+		if algorithm.Name == algorithm.Algorithm.String() {
+			t.Errorf("%s algorithm %v has no name", algorithm.Role, algorithm.Algorithm)
+		}
+		supported[string(algorithm.Role)+" "+algorithm.Name] = algorithm
+	}
+
+	for name, expected := range map[string]SupportedAlgorithm{
+		"mac sha1":                              {Weak: true},
+		"mac sha256":                            {FIPS: true},
+		"encryption pbeWithSHA1And40BitRC2-CBC": {Weak: true},
+		"encryption pbeWithSHA1And3-KeyTripleDES-CBC": {Weak: true},
+		"encryption PBES2":         {FIPS: true},
+		"kdf PBKDF2":               {FIPS: true},
+		"prf hmacWithSHA1":         {Weak: true},
+		"prf hmacWithSHA512":       {FIPS: true},
+		"cipher AES-256-CBC":       {FIPS: true},
+		"cipher AES-128-GCM":       {FIPS: true},
+		"cipher CAMELLIA-128-CBC":  {},
+		"cipher ChaCha20-Poly1305": {OptIn: true},
+	} {
+		algorithm, ok := supported[name]
+		if !ok {
+			t.Errorf("%s is not supported", name)
+			continue
+		}
+		if algorithm.Weak != expected.Weak || algorithm.FIPS != expected.FIPS || algorithm.OptIn != expected.OptIn {
+			t.Errorf("%s: unexpected %+v", name, algorithm)
+		}
+	}
+
+	// Every supported cipher and PRF encodes and decodes.
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	for _, algorithm := range SupportedAlgorithms() {
+		enc := *Modern.AllowWeakAlgorithms()
+		switch algorithm.Role {
+		case RoleCipher:
+			enc.pbes2Cipher = algorithm.Algorithm
+		case RolePRF:
+			enc.pbes2PRF = algorithm.Algorithm
+		default:
+			continue
+		}
+		dec := DefaultDecoder.AllowChaCha20Poly1305()
+		pfxData, err := enc.Encode(key, cert, nil, DefaultPassword)
+		if err != nil {
+			t.Errorf("%s: %v", algorithm.Name, err)
+			continue
+		}
+		if _, _, err := dec.Decode(pfxData, DefaultPassword); err != nil {
+			t.Errorf("%s: %v", algorithm.Name, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"strconv"
+	"strings"
+)
+
+// WithGeneratedAliases creates a new Encoder identical to enc except that,
+// if generate is true, Encode and EncodeKeyReference give the private key
+// and every certificate bag a friendlyName attribute, and EncodeTrustStore
+// replaces an empty alias, instead of leaving the names to the importer.
+//
+// The aliases are derived from the certificates the way the java keytool
+// stores them: the common name of the subject, lowercased, or the serial
+// number in hexadecimal if the subject has no common name.  Aliases which
+// are already used, ignoring case, get "-2", "-3" and so on appended, in
+// order.  The private key shares the alias of the end-entity certificate.
+// The same certificates therefore always get the same aliases.
+func (enc Encoder) WithGeneratedAliases(generate bool) *Encoder {
+	enc.generateAliases = generate
+	return &enc
+}
+
+// generatedAlias returns the alias WithGeneratedAliases derives from cert,
+// before it is made unique.
+func generatedAlias(cert *x509.Certificate) string {
+	if cn := strings.TrimSpace(cert.Subject.CommonName); cn != "" {
+		return strings.ToLower(cn)
+	}
+	return cert.SerialNumber.Text(16)
+}
+
+// uniqueAlias returns alias, made unique among the lowercased aliases in
+// used, and adds it to used.
+func uniqueAlias(used map[string]bool, alias string) string {
+	unique := alias
+	for n := 2; used[strings.ToLower(unique)]; n++ {
+		unique = alias + "-" + strconv.Itoa(n)
+	}
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// addGeneratedAliases adds a friendlyName attribute to each of certBags,
+// which hold certs, and to keyBag, if it is not nil, as selected by
+// WithGeneratedAliases.
+func (enc *Encoder) addGeneratedAliases(certBags []safeBag, certs []*x509.Certificate, keyBag *safeBag) error {
+	if !enc.generateAliases {
+		return nil
+	}
+
+	used := make(map[string]bool, len(certs))
+	for i, cert := range certs {
+		attribute, err := friendlyNameBagAttribute(uniqueAlias(used, generatedAlias(cert)), enc.nameEncoding)
+		if err != nil {
+			return err
+		}
+		certBags[i].Attributes = append(certBags[i].Attributes, attribute)
+		if i == 0 && keyBag != nil {
+			keyBag.Attributes = append(keyBag.Attributes, attribute)
+		}
+	}
+	return nil
+}
+
+// friendlyNameBagAttribute returns the friendlyName attribute of a safe bag
+// holding name.
+func friendlyNameBagAttribute(name string, encoding NameEncoding) (attribute pkcs12Attribute, err error) {
+	attribute.Id = oidFriendlyName
+	attribute.Value.Class = 0
+	attribute.Value.Tag = asn1.TagSet
+	attribute.Value.IsCompound = true
+	attribute.Value.Bytes, err = marshalFriendlyName(name, encoding)
+	return attribute, err
+}
+
+// trustStoreAlias returns the alias EncodeTrustStore gives cert, stored
+// with an empty alias among aliases: the empty alias, or a generated one
+// if WithGeneratedAliases is set.
+func (enc *Encoder) trustStoreAlias(aliases []string, cert *x509.Certificate) string {
+	if !enc.generateAliases {
+		return ""
+	}
+	used := make(map[string]bool, len(aliases))
+	for _, alias := range aliases {
+		used[strings.ToLower(alias)] = true
+	}
+	return uniqueAlias(used, generatedAlias(cert))
+}
@@ -0,0 +1,77 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestGeneratedAliases(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	_, otherCA := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "Leaf.Example.com", false, caCert, caKey)
+	_, unnamed := makeTestCertificate(t, "", true, nil, nil)
+
+	names := func(pfxData []byte) []string {
+		t.Helper()
+		contents, err := DecodeContents(pfxData, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, sc := range contents {
+			for _, entry := range sc.Entries {
+				names = append(names, entry.FriendlyName())
+			}
+		}
+		return names
+	}
+	check := func(what string, got, want []string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Errorf("%s: expected names %q, got %q", what, want, got)
+			return
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: expected names %q, got %q", what, want, got)
+				return
+			}
+		}
+	}
+
+	caCerts := []*x509.Certificate{caCert, otherCA, unnamed}
+	pfxData, err := Modern.Encode(key, cert, caCerts, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("default", names(pfxData), []string{"", "", "", "", ""})
+
+	enc := Modern.WithGeneratedAliases(true)
+	pfxData, err = enc.Encode(key, cert, caCerts, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"leaf.example.com", "test ca", "test ca-2", unnamed.SerialNumber.Text(16), "leaf.example.com"}
+	check("Encode", names(pfxData), expected)
+
+	pfxData, err = enc.EncodeKeyReference(key, cert, caCerts, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("EncodeKeyReference", names(pfxData), expected[:4])
+
+	certs := map[string]*x509.Certificate{"": caCert, "Test CA": otherCA}
+	pfxData, err = enc.EncodeTrustStore(certs, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("EncodeTrustStore", names(pfxData), []string{"test ca-2", "Test CA"})
+	if _, err := DefaultDecoder.WithDuplicateNames(DuplicateNamesError).DecodeTrustStore(pfxData, "password"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+)
+
+// pemType is the PEM block type of an armored PKCS#12 file.
+const pemType = "PKCS12"
+
+// An Armor selects the text encoding, if any, of the files an Encoder
+// produces.
+type Armor int
+
+const (
+	// NoArmor produces binary DER.  This is the default.
+	NoArmor Armor = iota
+	// Base64Armor produces the DER encoded in standard base64, on a single
+	// line without a trailing newline, for embedding in YAML or JSON.
+	Base64Armor
+	// PEMArmor produces the DER wrapped in a "-----BEGIN PKCS12-----" PEM
+	// block.
+	PEMArmor
+)
+
+// WithArmor creates a new Encoder identical to enc except that it produces
+// files in the given armor.  Decoders detect and strip it, see
+// Decoder.WithArmorDetection.
+func (enc Encoder) WithArmor(armor Armor) *Encoder {
+	enc.armor = armor
+	return &enc
+}
+
+// applyArmor encodes pfxData in the armor of enc.
+func (enc *Encoder) applyArmor(pfxData []byte) []byte {
+	switch enc.armor {
+	case Base64Armor:
+		armored := make([]byte, base64.StdEncoding.EncodedLen(len(pfxData)))
+		base64.StdEncoding.Encode(armored, pfxData)
+		return armored
+	case PEMArmor:
+		return pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: pfxData})
+	}
+	return pfxData
+}
+
+// WithArmorDetection creates a new Decoder identical to dec except that it
+// does (if detect is true, the default) or does not recognize files wrapped
+// in a "-----BEGIN PKCS12-----" PEM block or encoded in base64, and
+// strips that armor before decoding.  Without detection such input is
+// rejected as malformed DER.
+func (dec Decoder) WithArmorDetection(detect bool) *Decoder {
+	dec.noArmor = !detect
+	return &dec
+}
+
+// unarmor returns p12Data with the armor, if any, removed, unless dec does
+// not detect armor, and converted from BER to DER, see berToDER.
+func (dec *Decoder) unarmor(p12Data []byte) ([]byte, error) {
+	if !dec.noArmor {
+		var err error
+		if p12Data, err = unarmor(p12Data); err != nil {
+			return nil, err
+		}
+	}
+	return dec.limits.withDefaults().berToDER(p12Data)
+}
+
+// unarmor returns the DER encoded PFX wrapped in p12Data by a PEM block of
+// type PKCS12 or by base64.  Data starting like DER, and data that is
+// neither PEM nor base64, is returned as it is, to fail or succeed as DER.
+func unarmor(p12Data []byte) ([]byte, error) {
+	if len(p12Data) == 0 || p12Data[0] == 0x30 {
+		return p12Data, nil
+	}
+
+	trimmed := bytes.TrimSpace(p12Data)
+	if bytes.HasPrefix(trimmed, []byte("-----BEGIN ")) {
+		block, _ := pem.Decode(trimmed)
+		if block == nil {
+			return nil, errors.New("pkcs12: malformed PEM armor")
+		}
+		if block.Type != pemType {
+			return nil, errors.New("pkcs12: unexpected PEM block type " + block.Type)
+		}
+		return block.Bytes, nil
+	}
+
+	stripped := stripSpace(trimmed)
+	der := make([]byte, base64.StdEncoding.DecodedLen(len(stripped)))
+	n, err := base64.StdEncoding.Decode(der, stripped)
+	if err != nil || n == 0 || der[0] != 0x30 {
+		return p12Data, nil
+	}
+	return der[:n], nil
+}
+
+// stripSpace removes the white space with which base64 is wrapped and
+// indented.
+func stripSpace(data []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, data)
+}
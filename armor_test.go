@@ -0,0 +1,89 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestArmor(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(pfxData)
+	var wrapped strings.Builder
+	for len(encoded) > 64 {
+		wrapped.WriteString(encoded[:64] + "\r\n")
+		encoded = encoded[64:]
+	}
+	wrapped.WriteString(encoded + "\n")
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"PEM", pem.EncodeToMemory(&pem.Block{Type: "PKCS12", Bytes: pfxData})},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(pfxData))},
+		{"wrapped base64", []byte(wrapped.String())},
+	}
+	for _, test := range tests {
+		_, certificate, err := Decode(test.data, "password")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !certificate.Equal(cert) {
+			t.Errorf("%s: unexpected certificate", test.name)
+		}
+		if _, err := Probe(test.data); err != nil {
+			t.Errorf("%s: Probe: %v", test.name, err)
+		}
+		if _, _, err := DefaultDecoder.WithArmorDetection(false).Decode(test.data, "password"); err == nil {
+			t.Errorf("%s: expected an error without armor detection", test.name)
+		}
+	}
+
+	if _, _, err := Decode(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), "password"); err == nil || !strings.Contains(err.Error(), "PEM block type") {
+		t.Errorf("expected a PEM block type error, got %v", err)
+	}
+}
+
+func TestWithArmor(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	for _, armor := range []Armor{NoArmor, Base64Armor, PEMArmor} {
+		pfxData, err := Modern.WithArmor(armor).Encode(key, cert, nil, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch armor {
+		case NoArmor:
+			if pfxData[0] != 0x30 {
+				t.Errorf("expected DER, got %q", pfxData[:10])
+			}
+		case Base64Armor:
+			if _, err := base64.StdEncoding.DecodeString(string(pfxData)); err != nil {
+				t.Errorf("expected base64: %v", err)
+			}
+		case PEMArmor:
+			if block, _ := pem.Decode(pfxData); block == nil || block.Type != "PKCS12" {
+				t.Errorf("expected a PKCS12 PEM block, got %q", pfxData[:20])
+			}
+		}
+
+		if _, certificate, err := Decode(pfxData, "password"); err != nil {
+			t.Errorf("armor %d: %v", armor, err)
+		} else if !certificate.Equal(cert) {
+			t.Errorf("armor %d: unexpected certificate", armor)
+		}
+	}
+}
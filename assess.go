@@ -0,0 +1,195 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// A Severity grades a Finding of Assess or Validate.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityCritical:
+		return "critical"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// A Finding is a single observation made by Assess or Validate.
+type Finding struct {
+	Severity Severity
+	// Usage is the part of the file the finding is about, or empty if it
+	// concerns the file as a whole or a certificate.
+	Usage   AlgorithmUsage
+	Message string
+}
+
+// A Report is the result of Assess.
+type Report struct {
+	// Score rates the file from 0 (worthless protection) to 100 (nothing
+	// to complain about).
+	Score    int
+	Findings []Finding
+	// Probe is the structure Assess based its findings on, or nil if the
+	// file could not be parsed.
+	Probe *ProbeResult
+}
+
+// Worst returns the highest severity among the findings, or SeverityInfo if
+// there are none.
+func (r *Report) Worst() Severity {
+	worst := SeverityInfo
+	for _, f := range r.Findings {
+		if f.Severity > worst {
+			worst = f.Severity
+		}
+	}
+	return worst
+}
+
+// Penalties subtracted from the score of a Report.
+const (
+	penaltyCritical = 40
+	penaltyWarning  = 15
+)
+
+// minIterations is the iteration count below which a KDF is considered
+// too cheap to slow down password guessing.  It matches the OpenSSL default.
+const minIterations = 2048
+
+// certExpiryWarning is how long before NotAfter an expiring certificate is
+// reported.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// Assess rates how well pfxData is protected, without needing the password:
+// the MAC algorithm, the encryption algorithms and their iteration counts,
+// and, for certificates stored in plain SafeContents, key sizes and
+// expiry.  Certificates in encrypted SafeContents and the algorithms of key
+// bags inside them cannot be seen and are not rated.
+//
+// Assess never fails; a file that cannot be parsed is reported with a
+// critical finding and a score of zero.
+func Assess(pfxData []byte) Report {
+	return assess(pfxData, time.Now())
+}
+
+func assess(pfxData []byte, now time.Time) Report {
+	var report Report
+
+	probe, err := Probe(pfxData)
+	if err != nil {
+		report.Findings = append(report.Findings, Finding{Severity: SeverityCritical, Message: "cannot parse file: " + err.Error()})
+		return report
+	}
+	report.Probe = probe
+
+	if probe.Version != 3 {
+		report.add(SeverityWarning, "", "file has version %d instead of 3", probe.Version)
+	}
+	if probe.MAC == nil {
+		report.add(SeverityCritical, UsageMAC, "file has no MAC")
+	} else {
+		if probe.MAC.Algorithm.Equal(oidSHA1) {
+			report.add(SeverityWarning, UsageMAC, "MAC uses SHA-1")
+		}
+		report.checkIterations(UsageMAC, probe.MAC.Name, probe.MAC.Iterations)
+	}
+
+	for _, sc := range probe.SafeContents {
+		if sc.Encryption == nil {
+			for _, bag := range sc.Bags {
+				switch {
+				case bag.Encryption != nil:
+					report.checkEncryption(UsageKeyBag, bag.Encryption)
+				case bag.Certificate != nil:
+					report.checkCertificate(bag.Certificate, now)
+				}
+			}
+			continue
+		}
+		report.checkEncryption(UsageSafeContents, sc.Encryption)
+	}
+
+	report.Score = 100
+	for _, f := range report.Findings {
+		switch f.Severity {
+		case SeverityCritical:
+			report.Score -= penaltyCritical
+		case SeverityWarning:
+			report.Score -= penaltyWarning
+		}
+	}
+	if report.Score < 0 {
+		report.Score = 0
+	}
+
+	return report
+}
+
+func (r *Report) add(severity Severity, usage AlgorithmUsage, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Severity: severity, Usage: usage, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) checkIterations(usage AlgorithmUsage, name string, iterations int) {
+	if iterations < minIterations {
+		r.add(SeverityWarning, usage, "%s uses only %d iterations", name, iterations)
+	}
+}
+
+func (r *Report) checkEncryption(usage AlgorithmUsage, info *EncryptionInfo) {
+	switch {
+	case info.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		r.add(SeverityCritical, usage, "%s uses a 40-bit key", info.Name)
+	case info.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC):
+		r.add(SeverityWarning, usage, "%s uses 3DES and SHA-1", info.Name)
+	case info.Algorithm.Equal(oidPBES2):
+		if info.PRF.Equal(oidHmacWithSHA1) {
+			r.add(SeverityWarning, usage, "%s uses HMAC-SHA-1", info.Name)
+		}
+	default:
+		r.add(SeverityWarning, usage, "unknown encryption algorithm %s", info.Name)
+		return
+	}
+	r.checkIterations(usage, info.Name, info.Iterations)
+}
+
+func (r *Report) checkCertificate(cert *x509.Certificate, now time.Time) {
+	subject := cert.Subject.String()
+
+	switch {
+	case now.After(cert.NotAfter):
+		r.add(SeverityCritical, "", "certificate %q expired on %s", subject, cert.NotAfter.Format(time.RFC3339))
+	case now.Add(certExpiryWarning).After(cert.NotAfter):
+		r.add(SeverityWarning, "", "certificate %q expires on %s", subject, cert.NotAfter.Format(time.RFC3339))
+	}
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := pub.N.BitLen(); bits < 2048 {
+			r.add(SeverityCritical, "", "certificate %q has a %d-bit RSA key", subject, bits)
+		}
+	case *ecdsa.PublicKey:
+		if bits := pub.Curve.Params().BitSize; bits < 256 {
+			r.add(SeverityCritical, "", "certificate %q has a %d-bit EC key", subject, bits)
+		}
+	}
+}
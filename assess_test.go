@@ -0,0 +1,165 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	pfxData, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if probe.Version != 3 {
+		t.Errorf("unexpected version %d", probe.Version)
+	}
+	if probe.MAC == nil || !probe.MAC.Algorithm.Equal(oidSHA256) || probe.MAC.Iterations != 2048 || probe.MAC.SaltLen != 16 {
+		t.Errorf("unexpected MAC info %+v", probe.MAC)
+	}
+	if len(probe.SafeContents) != 2 {
+		t.Fatalf("expected 2 SafeContents, got %d", len(probe.SafeContents))
+	}
+
+	certs := probe.SafeContents[0]
+	if certs.Encryption == nil || !certs.Encryption.Algorithm.Equal(oidPBES2) || !certs.Encryption.PRF.Equal(oidHmacWithSHA256) || !certs.Encryption.Cipher.Equal(oidAES256CBC) {
+		t.Errorf("unexpected cert SafeContents encryption %+v", certs.Encryption)
+	}
+	if certs.Bags != nil {
+		t.Errorf("bags of an encrypted SafeContents should not be visible")
+	}
+
+	keys := probe.SafeContents[1]
+	if keys.Encryption != nil || len(keys.Bags) != 1 || !keys.Bags[0].Type.Equal(oidPKCS8ShroundedKeyBag) {
+		t.Fatalf("unexpected key SafeContents %+v", keys)
+	}
+	if enc := keys.Bags[0].Encryption; enc == nil || enc.Iterations != 2048 || enc.Name != "PBES2(hmacWithSHA256, AES-256-CBC)" {
+		t.Errorf("unexpected key bag encryption %+v", enc)
+	}
+}
+
+func TestProbeKDFCost(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	pfxData, err := Modern.WithIterations(10000).Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The MAC, the certificates and the key.
+	expected := KDFCost{Derivations: 3, Iterations: 3 * 10000, MaxIterations: 10000, Incomplete: true}
+	if cost := probe.KDFCost(); cost != expected {
+		t.Errorf("unexpected cost %+v, expected %+v", cost, expected)
+	}
+	if enc := probe.SafeContents[1].Bags[0].Encryption; !enc.KDF.Equal(oidPBKDF2) || enc.Scrypt != nil {
+		t.Errorf("unexpected key bag encryption %+v", enc)
+	}
+
+	// A key bag encrypted with scrypt, which cannot be decrypted.
+	scrypt, err := asn1.Marshal(scryptParams{Salt: make([]byte, 16), CostParameter: 1 << 20, BlockSize: 8, ParallelizationParameter: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := asn1.Marshal(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		Kdf:              pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: scrypt}},
+		EncryptionScheme: pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: iv}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := describeEncryption(pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}})
+	if !info.KDF.Equal(oidScrypt) || info.Scrypt == nil || *info.Scrypt != (ScryptParams{N: 1 << 20, R: 8, P: 1}) || info.Name != "PBES2(scrypt, AES-256-CBC)" {
+		t.Errorf("unexpected scrypt encryption %+v", info)
+	}
+	probe = &ProbeResult{SafeContents: []SafeContentsInfo{{Bags: []BagInfo{{Type: oidPKCS8ShroundedKeyBag, Encryption: info}}}}}
+	expected = KDFCost{Derivations: 1, ScryptMemory: 128 << 23}
+	if cost := probe.KDFCost(); cost != expected {
+		t.Errorf("scrypt: unexpected cost %+v, expected %+v", cost, expected)
+	}
+
+	probe.MAC = &MACInfo{Iterations: math.MaxInt}
+	probe.SafeContents[0].Bags[0].Encryption.Scrypt.N = math.MaxInt
+	if cost := probe.KDFCost(); cost.Iterations != math.MaxInt || cost.ScryptMemory != math.MaxInt {
+		t.Errorf("huge parameters: unexpected cost %+v", cost)
+	}
+}
+
+func TestAssess(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	modern, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := Assess(modern); report.Score != 100 || len(report.Findings) != 0 {
+		t.Errorf("Modern: unexpected report %+v", report)
+	}
+
+	legacy, err := LegacyRC2.AllowWeakAlgorithms().WithIterations(1000).Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := Assess(legacy)
+	if report.Worst() != SeverityCritical {
+		t.Errorf("LegacyRC2: expected a critical finding, got %+v", report.Findings)
+	}
+	// SHA-1 MAC, MAC iterations, RC2, RC2 iterations, 3DES, 3DES iterations
+	if len(report.Findings) != 6 || report.Score != 0 {
+		t.Errorf("LegacyRC2: unexpected report %+v", report)
+	}
+
+	if report := Assess([]byte("garbage")); report.Score != 0 || report.Worst() != SeverityCritical || report.Probe != nil {
+		t.Errorf("garbage: unexpected report %+v", report)
+	}
+}
+
+func TestAssessCertificateExpiry(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	// Store the cert bags in a plain SafeContents so Assess can see them.
+	enc := *LegacyRC2.AllowWeakAlgorithms()
+	enc.certAlgorithm = nil
+	pfxData, err := enc.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := func(report Report) (n int) {
+		for _, f := range report.Findings {
+			if f.Usage == "" {
+				n++
+			}
+		}
+		return n
+	}
+	if report := assess(pfxData, cert.NotAfter.Add(-2*certExpiryWarning)); findings(report) != 0 {
+		t.Errorf("unexpected certificate findings %+v", report.Findings)
+	}
+	if report := assess(pfxData, cert.NotAfter.Add(-time.Hour)); findings(report) != 1 {
+		t.Errorf("expected an expiry warning, got %+v", report.Findings)
+	}
+	if report := assess(pfxData, cert.NotAfter.Add(time.Hour)); findings(report) != 1 || report.Worst() != SeverityCritical {
+		t.Errorf("expected an expiry error, got %+v", report.Findings)
+	}
+}
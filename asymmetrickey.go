@@ -0,0 +1,124 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+// oneAsymmetricKeyVersion is the version of a PKCS#8 private key carrying
+// a public key, the v2 of https://tools.ietf.org/html/rfc5958#section-2.
+const oneAsymmetricKeyVersion = 1
+
+// WithEmbeddedPublicKey creates a new Encoder identical to enc except that,
+// if embed is true, the EC, Ed25519 and X25519 private keys it encodes carry
+// their public key, in a version 2 OneAsymmetricKey, which some HSM import
+// tools check the private key against.  RSA keys are encoded as before, as
+// an RSAPrivateKey holds its public key already.  The public key of an
+// Entry, if set, is written instead.
+func (enc Encoder) WithEmbeddedPublicKey(embed bool) *Encoder {
+	enc.embedPublicKey = embed
+	return &enc
+}
+
+// subjectPublicKeyInfo is the SubjectPublicKeyInfo of an X.509 public key,
+// whose publicKey is encoded the same way in a OneAsymmetricKey.
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// embeddedPublicKey returns the public key of privateKey as stored in a
+// OneAsymmetricKey, or nil for an RSA key.
+func embeddedPublicKey(privateKey interface{}) ([]byte, error) {
+	if _, ok := privateKey.(*rsa.PrivateKey); ok {
+		return nil, nil
+	}
+	signer, ok := privateKey.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return nil, NotImplementedError(fmt.Sprintf("the public keys of private keys of type %T cannot be embedded", privateKey))
+	}
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, errors.New("pkcs12: error encoding public key: " + err.Error())
+	}
+	var info subjectPublicKeyInfo
+	if err := unmarshal(spki, &info); err != nil {
+		return nil, errors.New("pkcs12: error encoding public key: " + err.Error())
+	}
+	return info.PublicKey.Bytes, nil
+}
+
+// setPublicKey stores publicKey in info, making it a version 2
+// OneAsymmetricKey.
+func (info *privateKeyInfo) setPublicKey(publicKey []byte) {
+	info.Version = oneAsymmetricKeyVersion
+	info.PublicKey = asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)}
+}
+
+// parseKeyExtras returns the attributes of the DER-encoded PKCS#8
+// OneAsymmetricKey pkData other than keyUsage, and its public key, as
+// Ed25519 keys written by some tools carry.  A key which x509 can parse but
+// which does not decode as a OneAsymmetricKey has neither.  The results are
+// copies, as pkData is wiped once the key is parsed.
+func parseKeyExtras(pkData []byte) (attributes []Attribute, publicKey []byte, err error) {
+	var info privateKeyInfo
+	if _, err := asn1.Unmarshal(pkData, &info); err != nil {
+		return nil, nil, nil
+	}
+	for _, attribute := range info.Attributes {
+		if attribute.Id.Equal(OIDKeyUsage) {
+			continue
+		}
+		a := Attribute{Type: attribute.Id}
+		for rest := attribute.Value.Bytes; len(rest) > 0; {
+			var value asn1.RawValue
+			if rest, err = asn1.Unmarshal(rest, &value); err != nil {
+				return nil, nil, errors.New("pkcs12: error decoding PKCS#8 attribute " + attribute.Id.String() + ": " + err.Error())
+			}
+			a.Values = append(a.Values, append([]byte(nil), value.FullBytes...))
+		}
+		attributes = append(attributes, a)
+	}
+	if info.PublicKey.BitLength != 0 {
+		if info.PublicKey.BitLength%8 != 0 {
+			return nil, nil, errors.New("pkcs12: PKCS#8 public key is not a whole number of bytes")
+		}
+		publicKey = append([]byte(nil), info.PublicKey.Bytes...)
+	}
+	return attributes, publicKey, nil
+}
+
+// marshalEntryKey returns the private key of entry as a DER-encoded PKCS#8
+// OneAsymmetricKey, with the key usage of enc and the key attributes and
+// public key of entry.  The caller should wipe the result once it is no
+// longer needed.
+func (enc *Encoder) marshalEntryKey(entry *Entry) ([]byte, error) {
+	pkData, err := enc.marshalPKCS8(entry.PrivateKey)
+	if err != nil || (len(entry.KeyAttributes) == 0 && entry.PublicKey == nil) {
+		return pkData, err
+	}
+	defer wipe(pkData)
+
+	var info privateKeyInfo
+	if err := unmarshal(pkData, &info); err != nil {
+		return nil, errors.New("pkcs12: error encoding PKCS#8 private key: " + err.Error())
+	}
+	info.Attributes = append(info.Attributes, encodeAttributes(entry.KeyAttributes)...)
+	if entry.PublicKey != nil {
+		info.setPublicKey(entry.PublicKey)
+	}
+	if pkData, err = asn1.Marshal(info); err != nil {
+		return nil, errors.New("pkcs12: error encoding PKCS#8 private key: " + err.Error())
+	}
+	return pkData, nil
+}
@@ -0,0 +1,191 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestOneAsymmetricKey(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkData, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info privateKeyInfo
+	if err := unmarshal(pkData, &info); err != nil {
+		t.Fatal(err)
+	}
+	name, err := FriendlyNameAttribute("ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Version = oneAsymmetricKeyVersion
+	info.Attributes = encodeAttributes([]Attribute{name})
+	info.PublicKey = asn1.BitString{Bytes: publicKey, BitLength: 8 * len(publicKey)}
+	if pkData, err = asn1.Marshal(info); err != nil {
+		t.Fatal(err)
+	}
+
+	var keyBag safeBag
+	keyBag.Id = oidKeyBag
+	keyBag.Value.Class = 2
+	keyBag.Value.Tag = 0
+	keyBag.Value.IsCompound = true
+	keyBag.Value.Bytes = pkData
+	password, _ := bmpString(DefaultPassword)
+	ci, err := Modern.makeSafeContents([]safeBag{keyBag}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := Modern.marshalPFX([]contentInfo{ci}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := contents[0].Entries[0]
+	if !privateKey.Equal(entry.PrivateKey) || !bytes.Equal(entry.PublicKey, publicKey) {
+		t.Error("the key or its public key did not decode")
+	}
+	if !equalAttributes(entry.KeyAttributes, []Attribute{name}) {
+		t.Errorf("unexpected key attributes %v", entry.KeyAttributes)
+	}
+
+	// The public key and the attributes are kept when the key is
+	// encrypted, and written as they were read.
+	contents[0].Entries[0].BagType = PKCS8ShroudedKeyBag
+	if pfxData, err = Modern.EncodeContents(contents, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !EqualContents(contents, decoded) {
+		t.Error("the key did not round-trip")
+	}
+	contents[0].Entries[0].BagType = KeyBag
+	if pfxData, err = Modern.EncodeContents(contents, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err = DecodeContents(pfxData, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	var bag safeBag
+	if err := unmarshal(decoded[0].Entries[0].RawBag, &bag); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bag.Value.Bytes, pkData) {
+		t.Error("the key was not written as it was read")
+	}
+
+	// A key without them is written as a version 1 PrivateKeyInfo.
+	contents[0].Entries[0].KeyAttributes, contents[0].Entries[0].PublicKey = nil, nil
+	if EqualContents(contents, decoded) {
+		t.Error("EqualContents ignored the public key and the key attributes")
+	}
+	if pfxData, err = Modern.EncodeContents(contents, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err = DecodeContents(pfxData, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if err := unmarshal(decoded[0].Entries[0].RawBag, &bag); err != nil {
+		t.Fatal(err)
+	}
+	info = privateKeyInfo{}
+	if err := unmarshal(bag.Value.Bytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 0 || info.PublicKey.BitLength != 0 || len(info.Attributes) != 0 {
+		t.Errorf("unexpected version %d key", info.Version)
+	}
+}
+
+func TestWithEmbeddedPublicKey(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	ecdhKey, err := key.PublicKey.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := Modern.WithEmbeddedPublicKey(true)
+	for _, test := range []struct {
+		name      string
+		key       interface{}
+		publicKey []byte
+	}{
+		{"ECDSA", key, ecdhKey.Bytes()},
+		{"Ed25519", edKey, edPublicKey},
+		{"RSA", rsaKey, nil},
+	} {
+		for _, bagType := range []BagType{KeyBag, PKCS8ShroudedKeyBag} {
+			contents := []SafeContents{{Entries: []Entry{{BagType: bagType, PrivateKey: test.key}}}}
+			pfxData, err := enc.EncodeContents(contents, DefaultPassword)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			decoded, err := DecodeContents(pfxData, DefaultPassword)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if publicKey := decoded[0].Entries[0].PublicKey; !bytes.Equal(publicKey, test.publicKey) {
+				t.Errorf("%s: %s has public key %x, expected %x", test.name, bagType, publicKey, test.publicKey)
+			}
+		}
+	}
+
+	// Encode embeds the public key too, and DecodeChain still reads the
+	// key.
+	pfxData, err := enc.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey := contents[1].Entries[0].PublicKey; !bytes.Equal(publicKey, ecdhKey.Bytes()) {
+		t.Errorf("Encode embedded public key %x, expected %x", publicKey, ecdhKey.Bytes())
+	}
+	decodedKey, _, _, err := DecodeChain(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(decodedKey) {
+		t.Error("DecodeChain returned another key")
+	}
+	if pfxData, err = enc.WithEmbeddedPublicKey(false).Encode(key, cert, nil, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err = DecodeContents(pfxData, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if publicKey := contents[1].Entries[0].PublicKey; publicKey != nil {
+		t.Errorf("embedded public key %x although disabled", publicKey)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/asn1"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Types of attributes commonly found on safe bags: the PKCS#9 attributes of
+// https://tools.ietf.org/html/rfc2985#section-5, the X.509 keyUsage used
+// as a key attribute, the cryptographic provider name used by Windows, and
+// the attribute marking a trusted certificate for Java.
+var (
+	OIDFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	OIDLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	OIDContentType         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	OIDSigningTime         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	OIDKeyUsage            = asn1.ObjectIdentifier{2, 5, 29, 15}
+	OIDMicrosoftCSP        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 17, 1}
+	OIDJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+)
+
+// NewAttribute returns an attribute of type attrType whose values are the
+// DER encodings of values, as produced by asn1.Marshal.  Values which need
+// a particular encoding, such as a BMPString, can be passed as
+// asn1.RawValue.
+func NewAttribute(attrType asn1.ObjectIdentifier, values ...interface{}) (Attribute, error) {
+	a := Attribute{Type: attrType}
+	for _, value := range values {
+		der, err := asn1.Marshal(value)
+		if err != nil {
+			return Attribute{}, errors.New("pkcs12: error encoding attribute " + attrType.String() + ": " + err.Error())
+		}
+		a.Values = append(a.Values, der)
+	}
+	return a, nil
+}
+
+// FriendlyNameAttribute returns a friendlyName attribute holding name, as
+// a BMPString.  Names with characters outside the Basic Multilingual Plane
+// are refused; Encoder.FriendlyNameAttribute can encode them.
+func FriendlyNameAttribute(name string) (Attribute, error) {
+	der, err := marshalBmpString(name)
+	if err != nil {
+		return Attribute{}, err
+	}
+	return Attribute{Type: OIDFriendlyName, Values: [][]byte{der}}, nil
+}
+
+// LocalKeyIDAttribute returns a localKeyId attribute holding id.
+func LocalKeyIDAttribute(id []byte) Attribute {
+	a, _ := NewAttribute(OIDLocalKeyID, id)
+	return a
+}
+
+// SigningTimeAttribute returns a signingTime attribute holding t, encoded
+// as a UTCTime or, outside the years 1950 to 2049, a GeneralizedTime.
+func SigningTimeAttribute(t time.Time) (Attribute, error) {
+	return NewAttribute(OIDSigningTime, t)
+}
+
+// Unmarshal decodes the i-th value of a into out, like asn1.Unmarshal.
+func (a Attribute) Unmarshal(i int, out interface{}) error {
+	if i < 0 || i >= len(a.Values) {
+		return errors.New("pkcs12: attribute " + a.Type.String() + " has no value " + strconv.Itoa(i))
+	}
+	if err := unmarshal(a.Values[i], out); err != nil {
+		return errors.New("pkcs12: error decoding attribute " + a.Type.String() + ": " + err.Error())
+	}
+	return nil
+}
+
+// Attribute returns the attribute of e of type attrType, and whether there
+// is one.
+func (e *Entry) Attribute(attrType asn1.ObjectIdentifier) (Attribute, bool) {
+	for _, a := range e.Attributes {
+		if a.Type.Equal(attrType) {
+			return a, true
+		}
+	}
+	return Attribute{}, false
+}
+
+// SetAttribute replaces the attribute of e of the same type as a, or adds
+// a if there is none.
+func (e *Entry) SetAttribute(a Attribute) {
+	for i := range e.Attributes {
+		if e.Attributes[i].Type.Equal(a.Type) {
+			e.Attributes[i] = a
+			return
+		}
+	}
+	e.Attributes = append(e.Attributes, a)
+}
+
+// RemoveAttribute removes the attributes of e of type attrType.
+func (e *Entry) RemoveAttribute(attrType asn1.ObjectIdentifier) {
+	attributes := e.Attributes[:0]
+	for _, a := range e.Attributes {
+		if !a.Type.Equal(attrType) {
+			attributes = append(attributes, a)
+		}
+	}
+	e.Attributes = attributes
+}
@@ -0,0 +1,94 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"testing"
+	"time"
+)
+
+func TestAttributes(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	signingTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	vendorType := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+
+	friendlyName, err := FriendlyNameAttribute("Ünïcode name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeAttribute, err := SigningTimeAttribute(signingTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendor, err := NewAttribute(vendorType, "marker", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyEntry := Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key}
+	keyEntry.SetAttribute(LocalKeyIDAttribute([]byte{1, 2, 3}))
+	keyEntry.SetAttribute(friendlyName)
+	keyEntry.SetAttribute(timeAttribute)
+	keyEntry.SetAttribute(vendor)
+	keyEntry.SetAttribute(LocalKeyIDAttribute([]byte{4, 5, 6}))
+	if len(keyEntry.Attributes) != 4 {
+		t.Fatalf("expected SetAttribute to replace the localKeyId, got %d attributes", len(keyEntry.Attributes))
+	}
+
+	pfxData, err := Modern.EncodeContents([]SafeContents{
+		{Encrypted: true, Entries: []Entry{{BagType: CertBag, Certificate: cert}}},
+		{Entries: []Entry{keyEntry}},
+	}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := contents[1].Entries[0]
+
+	if name := decoded.FriendlyName(); name != "Ünïcode name" {
+		t.Errorf("unexpected friendlyName %q", name)
+	}
+	if id := decoded.LocalKeyID(); !bytes.Equal(id, []byte{4, 5, 6}) {
+		t.Errorf("unexpected localKeyId %x", id)
+	}
+
+	a, ok := decoded.Attribute(OIDSigningTime)
+	if !ok {
+		t.Fatal("signingTime missing")
+	}
+	var decodedTime time.Time
+	if err := a.Unmarshal(0, &decodedTime); err != nil {
+		t.Fatal(err)
+	}
+	if !decodedTime.Equal(signingTime) {
+		t.Errorf("expected signingTime %v, got %v", signingTime, decodedTime)
+	}
+
+	if a, ok = decoded.Attribute(vendorType); !ok {
+		t.Fatal("vendor attribute missing")
+	}
+	var marker string
+	var number int
+	if err := a.Unmarshal(0, &marker); err != nil || marker != "marker" {
+		t.Errorf("unexpected first value %q: %v", marker, err)
+	}
+	if err := a.Unmarshal(1, &number); err != nil || number != 42 {
+		t.Errorf("unexpected second value %d: %v", number, err)
+	}
+	if err := a.Unmarshal(2, &number); err == nil {
+		t.Error("expected an error for a missing value")
+	}
+
+	decoded.RemoveAttribute(vendorType)
+	if _, ok := decoded.Attribute(vendorType); ok || len(decoded.Attributes) != 3 {
+		t.Error("RemoveAttribute did not remove the attribute")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/asn1"
+	"errors"
+)
+
+// An AuthSafeEncoding selects how the authenticated safe is wrapped into
+// the data ContentInfo of the PFX PDU.  Every Decoder accepts all of them.
+type AuthSafeEncoding int
+
+const (
+	// AuthSafeExplicit is the default: the content is an OCTET STRING
+	// under an explicit [0] tag, as RFC 7292 and PKCS#7 define it and as
+	// OpenSSL writes it.
+	AuthSafeExplicit AuthSafeEncoding = iota
+	// AuthSafeConstructed splits the OCTET STRING of AuthSafeExplicit into
+	// a constructed OCTET STRING of 1000-byte segments, the form of the
+	// CER encoding and of writers using BER, for consumers which expect
+	// it.
+	AuthSafeConstructed
+	// AuthSafeImplicit leaves out the OCTET STRING and tags the
+	// authenticated safe with an implicit [0], as if the content of the
+	// ContentInfo were not explicitly tagged, for consumers which expect
+	// it.
+	AuthSafeImplicit
+)
+
+// segmentSize is the size of the segments of a constructed OCTET STRING,
+// as CER prescribes.
+const segmentSize = 1000
+
+// WithAuthSafeEncoding creates a new Encoder identical to enc except that
+// it wraps the authenticated safe with encoding.  The MAC is computed over
+// the same bytes in every case.  Files with another encoding than
+// AuthSafeExplicit are not DER-encoded PKCS#12 and should only be written
+// for consumers which need them.
+func (enc Encoder) WithAuthSafeEncoding(encoding AuthSafeEncoding) *Encoder {
+	enc.authSafeEncoding = encoding
+	return &enc
+}
+
+// content returns the content of the data ContentInfo holding the
+// authenticated safe authenticatedSafe.
+func (e AuthSafeEncoding) content(authenticatedSafe []byte) (content asn1.RawValue, err error) {
+	content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true}
+	switch e {
+	case AuthSafeExplicit:
+		content.Bytes, err = asn1.Marshal(authenticatedSafe)
+	case AuthSafeConstructed:
+		var segments []byte
+		for rest := authenticatedSafe; len(rest) > 0; {
+			n := len(rest)
+			if n > segmentSize {
+				n = segmentSize
+			}
+			segment, err := asn1.Marshal(rest[:n])
+			if err != nil {
+				return content, err
+			}
+			segments = append(segments, segment...)
+			rest = rest[n:]
+		}
+		content.Bytes, err = asn1.Marshal(asn1.RawValue{Tag: asn1.TagOctetString, IsCompound: true, Bytes: segments})
+	case AuthSafeImplicit:
+		content.IsCompound = false
+		content.Bytes = authenticatedSafe
+	default:
+		err = errors.New("pkcs12: unknown AuthSafeEncoding")
+	}
+	return content, err
+}
+
+// parseAuthSafeContent replaces content, the content of the data
+// ContentInfo of the PFX PDU, by the OCTET STRING it holds, whose Bytes
+// are the authenticated safe, accepting every AuthSafeEncoding.
+func parseAuthSafeContent(content *asn1.RawValue) error {
+	if content.Class != asn1.ClassContextSpecific || content.Tag != 0 {
+		return errors.New("expected the content of the authSafe")
+	}
+	if !content.IsCompound {
+		// AuthSafeImplicit
+		*content = asn1.RawValue{Tag: asn1.TagOctetString, Bytes: content.Bytes, FullBytes: content.FullBytes}
+		return nil
+	}
+	var octets asn1.RawValue
+	if err := unmarshal(content.Bytes, &octets); err != nil {
+		return err
+	}
+	if octets.Class != asn1.ClassUniversal || octets.Tag != asn1.TagOctetString {
+		return errors.New("expected an OCTET STRING")
+	}
+	if octets.IsCompound {
+		// AuthSafeConstructed
+		var authenticatedSafe []byte
+		for rest := octets.Bytes; len(rest) > 0; {
+			var segment asn1.RawValue
+			var err error
+			if rest, err = asn1.Unmarshal(rest, &segment); err != nil {
+				return err
+			}
+			if segment.Class != asn1.ClassUniversal || segment.Tag != asn1.TagOctetString || segment.IsCompound {
+				return errors.New("expected a segment of the OCTET STRING")
+			}
+			authenticatedSafe = append(authenticatedSafe, segment.Bytes...)
+		}
+		octets.Bytes = authenticatedSafe
+	}
+	*content = octets
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestWithAuthSafeEncoding(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	for _, test := range []struct {
+		name     string
+		encoding AuthSafeEncoding
+		// header is the start of the content of the ContentInfo.
+		header []byte
+	}{
+		{"explicit", AuthSafeExplicit, []byte{0xa0, 0x82}},
+		{"constructed", AuthSafeConstructed, []byte{0xa0, 0x82}},
+		{"implicit", AuthSafeImplicit, []byte{0x80, 0x82}},
+	} {
+		pfxData, err := Modern.WithAuthSafeEncoding(test.encoding).Encode(key, cert, []*x509.Certificate{caCert}, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var pfx struct {
+			Version  int
+			AuthSafe struct {
+				ContentType asn1.ObjectIdentifier
+				Content     asn1.RawValue
+			}
+		}
+		if _, err := asn1.Unmarshal(pfxData, &pfx); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if content := pfx.AuthSafe.Content.FullBytes; !bytes.HasPrefix(content, test.header) {
+			t.Errorf("%s: content starts with % x, expected % x", test.name, content[:4], test.header)
+		}
+		if test.encoding == AuthSafeConstructed {
+			if octets := pfx.AuthSafe.Content.Bytes; octets[0] != 0x24 || len(octets) < segmentSize {
+				t.Errorf("%s: expected a constructed OCTET STRING of more than one segment, got % x", test.name, octets[:4])
+			}
+		}
+
+		privateKey, certificate, caCerts, err := DecodeChain(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if MatchKeyToCert(privateKey, certificate) != nil || !certificate.Equal(cert) || len(caCerts) != 1 || !caCerts[0].Equal(caCert) {
+			t.Errorf("%s: unexpected chain", test.name)
+		}
+	}
+
+	if _, err := Modern.WithAuthSafeEncoding(-1).Encode(key, cert, nil, DefaultPassword); err == nil {
+		t.Error("encoded with an unknown AuthSafeEncoding")
+	}
+}
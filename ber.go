@@ -0,0 +1,220 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// NSS, and so pk12util and Firefox, writes PKCS#12 files in BER: its
+// constructed elements have indefinite lengths and its OCTET STRINGs are
+// split into segments, at every level from the PFX PDU down to the
+// SafeContents.  Such input is converted to DER before it is parsed, one
+// level at a time, since the MAC is computed over the contents of the
+// authSafe OCTET STRING as they were written.
+
+var errMalformedBER = errors.New("pkcs12: malformed BER encoding")
+
+// berToDER returns the DER encoding of data, a sequence of BER-encoded
+// elements, converting indefinite lengths into definite ones and
+// constructed OCTET STRINGs into primitive ones.  The contents of primitive
+// elements are not descended into.  Data which is already DER, as far as
+// parseDERElement tells, is returned as it is, so that its elements can be
+// located; so is data which is not BER either, to fail when it is parsed.
+// Only LimitErrors are returned.
+func (l Limits) berToDER(data []byte) ([]byte, error) {
+	if l.isDER(data, 1) {
+		return data, nil
+	}
+	var der []byte
+	for rest := data; len(rest) > 0; {
+		var err error
+		if der, rest, err = l.appendDER(der, rest, 1); err == errMalformedBER {
+			return data, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return der, nil
+}
+
+// isDER reports whether every element of data, down to depth MaxDepth, is
+// accepted by parseDERElement and none of them is a constructed OCTET
+// STRING.
+func (l Limits) isDER(data []byte, depth int) bool {
+	if depth > l.MaxDepth {
+		return false
+	}
+	for len(data) > 0 {
+		constructed, content, rest, ok := parseDERElement(data)
+		if !ok || data[0] == 0x24 {
+			return false
+		}
+		if constructed && !l.isDER(content, depth+1) {
+			return false
+		}
+		data = rest
+	}
+	return true
+}
+
+// appendDER appends the DER encoding of the first element of ber to der
+// and returns the rest of ber.
+func (l Limits) appendDER(der, ber []byte, depth int) (out, rest []byte, err error) {
+	if depth > l.MaxDepth {
+		return nil, nil, &LimitError{"nesting depth", l.MaxDepth}
+	}
+	identifier, constructed, length, ber, ok := parseBERHeader(ber)
+	if !ok {
+		return nil, nil, errMalformedBER
+	}
+	if length > l.MaxElementSize {
+		return nil, nil, &LimitError{"element size", l.MaxElementSize}
+	}
+	if !constructed {
+		return appendElement(der, identifier, ber[:length]), ber[length:], nil
+	}
+
+	contents := ber
+	if length >= 0 {
+		contents, ber = ber[:length], ber[length:]
+	}
+	var body []byte
+	for {
+		if length < 0 && len(contents) >= 2 && contents[0] == 0 && contents[1] == 0 {
+			// end-of-contents
+			ber = contents[2:]
+			break
+		}
+		if length >= 0 && len(contents) == 0 {
+			break
+		}
+		if body, contents, err = l.appendDER(body, contents, depth+1); err != nil {
+			return nil, nil, err
+		}
+		if len(body) > l.MaxElementSize {
+			return nil, nil, &LimitError{"element size", l.MaxElementSize}
+		}
+	}
+
+	if len(identifier) == 1 && identifier[0] == 0x24 {
+		// The segments of a constructed OCTET STRING, which
+		// have been converted to primitive OCTET STRINGs.
+		var octets []byte
+		for segments := body; len(segments) > 0; {
+			_, content, rest, ok := parseDERElement(segments)
+			if !ok || segments[0] != 0x04 {
+				return nil, nil, errMalformedBER
+			}
+			octets = append(octets, content...)
+			segments = rest
+		}
+		identifier, body = []byte{0x04}, octets
+	}
+	return appendElement(der, identifier, body), ber, nil
+}
+
+// segmentedContent returns the encrypted content of encryptedData, the DER
+// encoding of an EncryptedData, if it is in the constructed form of its
+// [0] IMPLICIT OCTET STRING, which asn1.Unmarshal leaves out as if the
+// content were detached.  berToDER cannot tell it from an explicitly
+// tagged element; NSS writes it so.
+func segmentedContent(encryptedData []byte) ([]byte, bool) {
+	seq, ok := readSequenceOf(encryptedData)
+	if !ok || seq.PeekASN1Tag(cryptobyte_asn1.INTEGER) && !seq.SkipASN1(cryptobyte_asn1.INTEGER) {
+		return nil, false
+	}
+	var eci, segments cryptobyte.String
+	if !seq.ReadASN1(&eci, cryptobyte_asn1.SEQUENCE) || !eci.SkipASN1(cryptobyte_asn1.OBJECT_IDENTIFIER) ||
+		!eci.SkipASN1(cryptobyte_asn1.SEQUENCE) || !eci.ReadASN1(&segments, explicitTag0) {
+		return nil, false
+	}
+	content := []byte{}
+	for !segments.Empty() {
+		var segment cryptobyte.String
+		if !segments.ReadASN1(&segment, cryptobyte_asn1.OCTET_STRING) {
+			return nil, false
+		}
+		content = append(content, segment...)
+	}
+	return content, true
+}
+
+// parseBERHeader splits the identifier and length octets off data,
+// returning the identifier octets, whether the element is constructed, the
+// length of its contents, or -1 for an indefinite length, and the rest of
+// data.  A definite length is checked against the input.
+func parseBERHeader(data []byte) (identifier []byte, constructed bool, length int, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return nil, false, 0, nil, false
+	}
+	constructed = data[0]&0x20 != 0
+
+	offset := 1
+	if data[0]&0x1f == 0x1f {
+		// high tag number form
+		for {
+			if offset >= len(data) {
+				return nil, false, 0, nil, false
+			}
+			b := data[offset]
+			offset++
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+	identifier = data[:offset]
+
+	if offset >= len(data) {
+		return nil, false, 0, nil, false
+	}
+	length = int(data[offset])
+	offset++
+	if length == 0x80 {
+		// indefinite length, only allowed for constructed elements
+		return identifier, constructed, -1, data[offset:], constructed
+	}
+	if length&0x80 != 0 {
+		numBytes := length & 0x7f
+		if numBytes > 4 {
+			return nil, false, 0, nil, false
+		}
+		length = 0
+		for i := 0; i < numBytes; i++ {
+			if offset >= len(data) {
+				return nil, false, 0, nil, false
+			}
+			length = length<<8 | int(data[offset])
+			offset++
+		}
+	}
+
+	if length > len(data)-offset {
+		return nil, false, 0, nil, false
+	}
+	return identifier, constructed, length, data[offset:], true
+}
+
+// appendElement appends the DER encoding of an element with the given
+// identifier octets and contents to der.
+func appendElement(der, identifier, contents []byte) []byte {
+	der = append(der, identifier...)
+	if n := len(contents); n < 0x80 {
+		der = append(der, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		der = append(der, 0x80|byte(len(length)))
+		der = append(der, length...)
+	}
+	return append(der, contents...)
+}
@@ -0,0 +1,92 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestBERToDER(t *testing.T) {
+	for _, test := range []struct {
+		name, ber, der string
+	}{
+		{"DER", "3006040102020103", "3006040102020103"},
+		{"indefinite length", "30800401020201030000", "3006040102020103"},
+		{"nested indefinite lengths", "a080" + "3080040102" + "0000" + "0000", "a0053003040102"},
+		{"constructed OCTET STRING", "24800401aa0402bbcc0000", "0403aabbcc"},
+		{"nested segments", "3080" + "2480" + "2480040101" + "0000" + "040102" + "0000" + "0000", "300404020102"},
+		{"empty constructed OCTET STRING", "24800000", "0400"},
+		{"definite constructed OCTET STRING", "24060401aa0401bb", "0402aabb"},
+		{"primitive contents left alone", "0404" + "30800000", "0404" + "30800000"},
+		{"sequence of elements", "30800000" + "0500", "3000" + "0500"},
+		{"high tag number", "bf1f80020101" + "0000", "bf1f03020101"},
+	} {
+		ber, _ := hex.DecodeString(test.ber)
+		der, err := DefaultLimits.berToDER(ber)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if hex.EncodeToString(der) != test.der {
+			t.Errorf("%s: got %x, want %s", test.name, der, test.der)
+		}
+	}
+
+	der, _ := hex.DecodeString("3006040102020103")
+	if got, _ := DefaultLimits.berToDER(der); &got[0] != &der[0] {
+		t.Error("DER was copied")
+	}
+
+	// A long content gets a long-form length.
+	ber := append([]byte{0x30, 0x80, 0x04, 0x81, 0xc8}, bytes.Repeat([]byte{1}, 200)...)
+	ber = append(ber, 0, 0)
+	if got, _ := DefaultLimits.berToDER(ber); !bytes.Equal(got, append([]byte{0x30, 0x81, 0xcb}, ber[2:len(ber)-2]...)) {
+		t.Errorf("unexpected long form %x", got[:5])
+	}
+
+	// Malformed input is returned as it is.
+	for _, malformed := range []string{"3080040102", "2480020101" + "0000", "0480", "30"} {
+		in, _ := hex.DecodeString(malformed)
+		if got, err := DefaultLimits.berToDER(in); err != nil || !bytes.Equal(got, in) {
+			t.Errorf("%s: got %x, %v", malformed, got, err)
+		}
+	}
+
+	var limitErr *LimitError
+	deep := append(bytes.Repeat([]byte{0x30, 0x80}, 40), bytes.Repeat([]byte{0, 0}, 40)...)
+	if _, err := DefaultLimits.berToDER(deep); !errors.As(err, &limitErr) || limitErr.Limit != "nesting depth" {
+		t.Errorf("expected a nesting depth LimitError, got %v", err)
+	}
+	small := Limits{MaxElementSize: 100}.withDefaults()
+	if _, err := small.berToDER(ber); !errors.As(err, &limitErr) || limitErr.Limit != "element size" {
+		t.Errorf("expected an element size LimitError, got %v", err)
+	}
+	segments := append([]byte{0x24, 0x80}, bytes.Repeat([]byte{0x04, 0x40}, 1)...)
+	segments = append(segments, bytes.Repeat([]byte{2}, 0x40)...)
+	segments = append(append(segments, segments[2:]...), 0, 0)
+	if _, err := small.berToDER(segments); !errors.As(err, &limitErr) || limitErr.Limit != "element size" {
+		t.Errorf("expected an element size LimitError for the segments, got %v", err)
+	}
+}
+
+func TestSegmentedContent(t *testing.T) {
+	// An EncryptedData whose content is split into two segments.
+	encryptedData, _ := hex.DecodeString("301c020100" + "3017" + "06092a864886f70d010701" + "3000" + "a0080401aa0403bbccdd")
+	if content, ok := segmentedContent(encryptedData); !ok || hex.EncodeToString(content) != "aabbccdd" {
+		t.Errorf("got %x, %v", content, ok)
+	}
+	detached, _ := hex.DecodeString("3012020100" + "300d" + "06092a864886f70d010701" + "3000")
+	if _, ok := segmentedContent(detached); ok {
+		t.Error("found the content of a detached EncryptedData")
+	}
+	primitive, _ := hex.DecodeString("3016020100" + "3011" + "06092a864886f70d010701" + "3000" + "8002aabb")
+	if _, ok := segmentedContent(primitive); ok {
+		t.Error("found segments in a primitive content")
+	}
+}
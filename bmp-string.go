@@ -9,9 +9,18 @@ import (
 	"errors"
 	"math/bits"
 	"unicode/utf16"
+	"unicode/utf8"
 )
 
-// bmpString returns s encoded in UCS-2 with a zero terminator.
+// MaxPasswordLength is the longest password, in characters, that can be
+// used to encode or decode a PKCS#12 file, the size of the password buffer
+// of OpenSSL.  The key derivation hashes the whole password on every
+// iteration, so its cost grows with the length of the password.
+const MaxPasswordLength = 1024
+
+// bmpString returns the password s encoded in UCS-2 with a zero
+// terminator.  A password which cannot be encoded is reported by a
+// *PasswordError.
 func bmpString(s string) ([]byte, error) {
 	// References:
 	// https://tools.ietf.org/html/rfc7292#appendix-B.1
@@ -20,13 +29,43 @@ func bmpString(s string) ([]byte, error) {
 	//	  EncodeRune returns 0xfffd if the rune does not need special encoding
 	//  - the above RFC provides the info that BMPStrings are NULL terminated.
 
+	if n := utf8.RuneCountInString(s); n > MaxPasswordLength {
+		return nil, &PasswordError{Length: n}
+	}
 	ret := make([]byte, 0, 2*len(s)+2)
 
-	for _, r := range s {
+	index := 0
+	for offset, r := range s {
 		if t, _ := utf16.EncodeRune(r); t != 0xfffd {
-			return nil, errors.New("pkcs12: string contains characters that cannot be encoded in UCS-2")
+			return nil, &PasswordError{Rune: r, Index: index, Offset: offset}
+		}
+		ret = append(ret, byte(r/256), byte(r%256))
+		index++
+	}
+
+	return append(ret, 0, 0), nil
+}
+
+// bmpStringBytes is like bmpString, but takes the UTF-8 encoded string as
+// a byte slice.  On error the partially encoded result is wiped.
+func bmpStringBytes(s []byte) ([]byte, error) {
+	if n := utf8.RuneCount(s); n > MaxPasswordLength {
+		return nil, &PasswordError{Length: n}
+	}
+	ret := make([]byte, 0, 2*len(s)+2)
+
+	for index, offset := 0, 0; offset < len(s); index++ {
+		r, size := utf8.DecodeRune(s[offset:])
+		if r == utf8.RuneError && size == 1 {
+			wipe(ret)
+			return nil, &PasswordError{Rune: r, Index: index, Offset: offset, InvalidUTF8: true}
+		}
+		if t, _ := utf16.EncodeRune(r); t != 0xfffd {
+			wipe(ret)
+			return nil, &PasswordError{Rune: r, Index: index, Offset: offset}
 		}
 		ret = append(ret, byte(r/256), byte(r%256))
+		offset += size
 	}
 
 	return append(ret, 0, 0), nil
@@ -51,6 +90,26 @@ func decodeBMPString(bmpString []byte) (string, error) {
 	return string(utf16.Decode(s)), nil
 }
 
+// bmpToUTF8 converts a NUL-terminated UCS-2 password, as produced by
+// bmpString, back to UTF-8.  Unlike decodeBMPString it returns a byte slice
+// so that the caller can wipe it.
+func bmpToUTF8(bmpString []byte) ([]byte, error) {
+	if len(bmpString)%2 != 0 {
+		return nil, errors.New("pkcs12: odd-length BMP string")
+	}
+
+	// strip terminator if present
+	if l := len(bmpString); l >= 2 && bmpString[l-1] == 0 && bmpString[l-2] == 0 {
+		bmpString = bmpString[:l-2]
+	}
+
+	ret := make([]byte, 0, len(bmpString)*3/2)
+	for ; len(bmpString) > 0; bmpString = bmpString[2:] {
+		ret = utf8.AppendRune(ret, rune(bmpString[0])<<8+rune(bmpString[1]))
+	}
+	return ret, nil
+}
+
 // marshalBmpString marshals a string into a ASN1 type 30 (BMP) string. DER encoding is used by marshalling.
 // See https://en.wikipedia.org/wiki/X.690#DER_encoding
 func marshalBmpString(s string) ([]byte, error) {
@@ -59,7 +118,7 @@ func marshalBmpString(s string) ([]byte, error) {
 	// - one byte for the type
 	// - len octet(s)
 	// - string
-	ret := make([]byte, 0, 2*len(s)+1+int(lenOctetsSize))
+	ret := make([]byte, 0, 2*utf8.RuneCountInString(s)+1+int(lenOctetsSize))
 
 	ret = append(ret, 30)
 	ret = append(ret, lenOctets...)
@@ -98,7 +157,7 @@ func unmarshalBmpString(derString []byte) (string, error) {
 // computeBmpStringSizeBytes calculates the lentgh field size of the BMP string according the DER encoding rules.
 // See https://en.wikipedia.org/wiki/X.690#Length_octets
 func computeBmpStringSizeBytes(s string) (lengthBytes []byte, lengthBytesSize byte) {
-	var stringSize uint = uint(len(s)) * 2
+	var stringSize uint = uint(utf8.RuneCountInString(s)) * 2
 
 	// Short form
 	if stringSize <= 126 {
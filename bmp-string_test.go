@@ -7,8 +7,10 @@ package pkcs12
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 type bpmStringSizeBytesTest struct {
@@ -50,6 +52,11 @@ var (
 			stringVersion:     "",
 			marshalledVersion: []byte{30, 0},
 		},
+		{
+			// the length counts characters, not UTF-8 bytes
+			stringVersion:     "Ünï",
+			marshalledVersion: []byte{30, 6, 0, 0xdc, 0, 0x6e, 0, 0xef},
+		},
 		{
 			stringVersion: "short string",
 			marshalledVersion: []byte{30, 24, 0, 115, 0, 104, 0, 111, 0, 114, 0, 116, 0,
@@ -115,6 +122,61 @@ func TestBMPString(t *testing.T) {
 	}
 }
 
+func TestBMPStringBytes(t *testing.T) {
+	for i, test := range bmpStringTests {
+		expected, err := bmpString(test.in)
+		out, err2 := bmpStringBytes([]byte(test.in))
+		if (err != nil) != (err2 != nil) {
+			t.Errorf("#%d: bmpString error %v, bmpStringBytes error %v", i, err, err2)
+			continue
+		}
+		if !bytes.Equal(out, expected) {
+			t.Errorf("#%d: expected %x, got %x", i, expected, out)
+		}
+	}
+
+	if _, err := bmpStringBytes([]byte{'a', 0xff}); err == nil {
+		t.Error("expected invalid UTF-8 to fail")
+	}
+}
+
+func TestPasswordError(t *testing.T) {
+	tests := []struct {
+		password []byte
+		expected PasswordError
+	}{
+		{[]byte("ab\U0001F512c"), PasswordError{Rune: 0x1F512, Index: 2, Offset: 2}},
+		{[]byte("\u00e9t\u00e9 \U0001F31E"), PasswordError{Rune: 0x1F31E, Index: 4, Offset: 6}},
+		{[]byte("\u00e9t\xff"), PasswordError{Rune: utf8.RuneError, Index: 2, Offset: 3, InvalidUTF8: true}},
+		{bytes.Repeat([]byte("\u00e9"), MaxPasswordLength+1), PasswordError{Length: MaxPasswordLength + 1}},
+	}
+	for _, test := range tests {
+		_, err := bmpStringBytes(test.password)
+		var passwordErr *PasswordError
+		if !errors.As(err, &passwordErr) || *passwordErr != test.expected {
+			t.Errorf("%q: expected %+v, got %v", test.password, test.expected, err)
+			continue
+		}
+		if test.expected.InvalidUTF8 {
+			continue
+		}
+		if _, err := bmpString(string(test.password)); !errors.As(err, &passwordErr) || *passwordErr != test.expected {
+			t.Errorf("%q: expected %+v from bmpString, got %v", test.password, test.expected, err)
+		}
+	}
+
+	if _, err := bmpString(strings.Repeat("\u00e9", MaxPasswordLength)); err != nil {
+		t.Errorf("password of the maximum length refused: %v", err)
+	}
+
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	_, err := Modern.Encode(key, cert, nil, "ab\U0001F512c")
+	var passwordErr *PasswordError
+	if !errors.As(err, &passwordErr) || passwordErr.Index != 2 || !strings.Contains(err.Error(), "U+1F512") {
+		t.Errorf("expected a PasswordError from Encode, got %v", err)
+	}
+}
+
 func TestComputeBmpStringSizeBytes(t *testing.T) {
 	testData := []bpmStringSizeBytesTest{
 		{
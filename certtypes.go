@@ -0,0 +1,92 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/asn1"
+	"errors"
+)
+
+// Types of certificates a certificate bag can hold, identified by its
+// certId, see https://tools.ietf.org/html/rfc7292#section-4.2.3.
+var (
+	OIDX509Certificate = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	OIDSDSICertificate = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 2}
+)
+
+// A CertParser parses value, the DER encoding of the certValue of a
+// certificate bag holding a certificate other than an X.509 one, such as
+// the IA5String of an SDSI certificate.
+type CertParser func(value []byte) (interface{}, error)
+
+type certParser struct {
+	certType asn1.ObjectIdentifier
+	parse    CertParser
+}
+
+// WithCertParser creates a new Decoder identical to dec except that
+// DecodeContents parses the certificates of type certType with parse and
+// stores the result in the OtherCertificate field of their entries.
+// Certificates of types without a parser are kept unparsed in Value.
+// X.509 certificates are always parsed by crypto/x509.
+func (dec Decoder) WithCertParser(certType asn1.ObjectIdentifier, parse CertParser) *Decoder {
+	dec.certParsers = append(dec.certParsers[:len(dec.certParsers):len(dec.certParsers)], certParser{certType, parse})
+	return &dec
+}
+
+// certParserFor returns the CertParser of dec for certType, or nil.  The
+// last one given for a type wins.
+func (dec *Decoder) certParserFor(certType asn1.ObjectIdentifier) CertParser {
+	for i := len(dec.certParsers) - 1; i >= 0; i-- {
+		if dec.certParsers[i].certType.Equal(certType) {
+			return dec.certParsers[i].parse
+		}
+	}
+	return nil
+}
+
+// anyCertBag is a CertBag holding a certificate of any type.  Value is the
+// whole explicitly tagged certValue, as encoding/asn1 does not unwrap an
+// explicit tag around a RawValue.
+type anyCertBag struct {
+	Id    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// decodeAnyCertBag returns the certId of the CertBag asn1Data and the DER
+// encoding of its certValue.
+func decodeAnyCertBag(asn1Data []byte) (certType asn1.ObjectIdentifier, value []byte, err error) {
+	bag := new(anyCertBag)
+	if err := unmarshal(asn1Data, bag); err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding cert bag: " + err.Error())
+	}
+	if bag.Value.Class != 2 || bag.Value.Tag != 0 || !bag.Value.IsCompound {
+		return nil, nil, errors.New("pkcs12: error decoding cert bag: certValue is not explicitly tagged")
+	}
+	return bag.Id, bag.Value.Bytes, nil
+}
+
+// makeOtherCertBag returns a cert bag holding value, the DER encoding of a
+// certificate of type certType.
+func makeOtherCertBag(certType asn1.ObjectIdentifier, value []byte, attributes []pkcs12Attribute) (bag *safeBag, err error) {
+	bag = new(safeBag)
+	bag.Id = oidCertBag
+	bag.Value.Class = 2
+	bag.Value.Tag = 0
+	bag.Value.IsCompound = true
+	certValue := asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: value}
+	if bag.Value.Bytes, err = asn1.Marshal(anyCertBag{Id: certType, Value: certValue}); err != nil {
+		return nil, errors.New("pkcs12: error encoding cert bag: " + err.Error())
+	}
+	bag.Attributes = attributes
+	return bag, nil
+}
+
+// isX509CertType reports whether certType, the CertType of an entry,
+// stands for an X.509 certificate.
+func isX509CertType(certType asn1.ObjectIdentifier) bool {
+	return certType == nil || certType.Equal(OIDX509Certificate)
+}
@@ -0,0 +1,72 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"testing"
+)
+
+func TestCertTypes(t *testing.T) {
+	_, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	sdsi, err := asn1.MarshalWithParams("KHB1YmxpYy1rZXkp", "ia5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	friendlyName, err := FriendlyNameAttribute("sdsi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []SafeContents{{Encrypted: true, Entries: []Entry{
+		{BagType: CertBag, Certificate: cert},
+		{BagType: CertBag, CertType: OIDSDSICertificate, Value: sdsi, Attributes: []Attribute{friendlyName}},
+	}}}
+	pfxData, err := Modern.EncodeContents(contents, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !EqualContents(contents, decoded) {
+		t.Error("the SDSI certificate did not round-trip")
+	}
+	x509Entry, sdsiEntry := decoded[0].Entries[0], decoded[0].Entries[1]
+	if !x509Entry.CertType.Equal(OIDX509Certificate) || !x509Entry.Certificate.Equal(cert) {
+		t.Errorf("unexpected X.509 entry of type %v", x509Entry.CertType)
+	}
+	if !sdsiEntry.CertType.Equal(OIDSDSICertificate) || !bytes.Equal(sdsiEntry.Value, sdsi) || sdsiEntry.Certificate != nil || sdsiEntry.OtherCertificate != nil || sdsiEntry.FriendlyName() != "sdsi" {
+		t.Errorf("unexpected SDSI entry of type %v", sdsiEntry.CertType)
+	}
+
+	parseSDSI := func(value []byte) (interface{}, error) {
+		var s string
+		_, err := asn1.UnmarshalWithParams(value, &s, "ia5")
+		return s, err
+	}
+	dec := DefaultDecoder.WithCertParser(OIDSDSICertificate, parseSDSI)
+	if decoded, err = dec.DecodeContents(pfxData, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if parsed, ok := decoded[0].Entries[1].OtherCertificate.(string); !ok || parsed != "KHB1YmxpYy1rZXkp" {
+		t.Errorf("unexpected parsed SDSI certificate %v", decoded[0].Entries[1].OtherCertificate)
+	}
+
+	failing := errors.New("unsupported")
+	dec = dec.WithCertParser(OIDSDSICertificate, func([]byte) (interface{}, error) { return nil, failing })
+	if _, err := dec.DecodeContents(pfxData, DefaultPassword); !errors.Is(err, failing) {
+		t.Errorf("expected the error of the last CertParser, got %v", err)
+	}
+
+	contents[0].Entries[1].Value = nil
+	if _, err := Modern.EncodeContents(contents, DefaultPassword); err == nil {
+		t.Error("encoded a certificate bag of another type without a value")
+	}
+}
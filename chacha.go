@@ -0,0 +1,52 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+// oidChaCha20Poly1305 is id-alg-AEADChaCha20Poly1305 from
+// https://tools.ietf.org/html/rfc8103, whose parameters are the 12-byte
+// nonce as an OCTET STRING.  RFC 8103 defines it for CMS
+// AuthEnvelopedData; no standard allows it as a PBES2 encryption scheme,
+// so files using it can only be read by this package.
+var oidChaCha20Poly1305 = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 9, 16, 3, 18})
+
+// WithChaCha20Poly1305 creates a new Encoder identical to enc except that
+// PBES2 encrypts with ChaCha20-Poly1305 instead of AES.  This is not
+// standard: OpenSSL, Java and Windows cannot read the result, and this
+// package only decodes it with a Decoder created by AllowChaCha20Poly1305.
+// It is meant for archives whose producer and consumer are both under your
+// control.  Like the PBKDF2 PRF, the cipher only matters to encoders using
+// PBES2, and FIPS mode refuses it.
+func (enc Encoder) WithChaCha20Poly1305() *Encoder {
+	enc.pbes2Cipher = oidChaCha20Poly1305
+	return &enc
+}
+
+// AllowChaCha20Poly1305 creates a new Decoder identical to dec except that
+// it decrypts PBES2 with ChaCha20-Poly1305, as written by an Encoder
+// created by WithChaCha20Poly1305.  Other Decoders refuse such files with
+// a NotImplementedError.
+func (dec Decoder) AllowChaCha20Poly1305() *Decoder {
+	dec.chacha20Poly1305 = true
+	return &dec
+}
+
+// checkChaCha20Poly1305 refuses PBES2 with ChaCha20-Poly1305 unless dec
+// allows it.
+func (dec *Decoder) checkChaCha20Poly1305(algorithm pkix.AlgorithmIdentifier) error {
+	if dec.chacha20Poly1305 || !algorithm.Algorithm.Equal(oidPBES2) {
+		return nil
+	}
+	params, _, err := parsePBES2Params(algorithm)
+	if err == nil && params.EncryptionScheme.Algorithm.Equal(oidChaCha20Poly1305) {
+		return NotImplementedError("pbes2 encryption scheme ChaCha20-Poly1305 is only decoded by a Decoder created by AllowChaCha20Poly1305")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChaCha20Poly1305(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	enc := Modern.WithChaCha20Poly1305()
+	dec := DefaultDecoder.AllowChaCha20Poly1305()
+
+	pfxData, err := enc.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cipher := probe.SafeContents[0].Encryption.Cipher; !cipher.Equal(oidChaCha20Poly1305) {
+		t.Errorf("unexpected certificate cipher %s", algorithmName(cipher))
+	}
+	if cipher := probe.SafeContents[1].Bags[0].Encryption.Cipher; !cipher.Equal(oidChaCha20Poly1305) {
+		t.Errorf("unexpected key cipher %s", algorithmName(cipher))
+	}
+
+	if _, certificate, err := dec.Decode(pfxData, "password"); err != nil {
+		t.Error(err)
+	} else if !certificate.Equal(cert) {
+		t.Error("unexpected certificate")
+	}
+	if _, _, err := dec.Decode(pfxData, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+	if _, _, err := Decode(pfxData, "password"); !errors.As(err, new(NotImplementedError)) {
+		t.Errorf("expected DefaultDecoder to refuse ChaCha20-Poly1305, got %v", err)
+	}
+
+	der, err := enc.EncryptPrivateKey(key, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privateKey, err := dec.DecryptPrivateKey(der, "password"); err != nil {
+		t.Error(err)
+	} else if !key.Equal(privateKey) {
+		t.Error("unexpected private key")
+	}
+	if _, err := DefaultDecoder.DecryptPrivateKey(der, "password"); !errors.As(err, new(NotImplementedError)) {
+		t.Errorf("expected DefaultDecoder to refuse ChaCha20-Poly1305, got %v", err)
+	}
+
+	if _, err := enc.WithFIPSMode().Encode(key, cert, nil, "password"); !errors.Is(err, ErrNonFIPSAlgorithm) {
+		t.Errorf("expected ErrNonFIPSAlgorithm, got %v", err)
+	}
+}
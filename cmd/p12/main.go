@@ -0,0 +1,493 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Command p12 creates, inspects and converts PKCS#12 files.
+//
+// Usage:
+//
+//	p12 create -key key.pem -cert cert.pem [-chain ca.pem] [-out out.p12]
+//	p12 from-pem [-in bundle.pem] [-out out.p12]
+//	p12 to-pem -in file.p12 [-out bundle.pem]
+//	p12 info -in file.p12 [-password password]
+//	p12 change-password -in file.p12 -new-password password [-out out.p12]
+//	p12 truststore [-out out.p12] ca.pem...
+//
+// Passwords are given with -password, or read from the first line of the
+// file named by -password-file, so that they do not show up in the process
+// list; likewise -new-password-file and -key-password-file.  Files are written using the PBES2 and
+// HMAC-SHA-256 defaults of OpenSSL 3 unless -format selects legacy-des,
+// legacy-rc2 or the format read by a platform: ios12, android10, win10 or
+// java8.  Without -in or -out, standard input or output is used.
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	pkcs12 "github.com/nevissecurity/go-pkcs12"
+	"github.com/nevissecurity/go-pkcs12/pkcs8"
+)
+
+var commands = map[string]func(*command) error{
+	"create":          create,
+	"from-pem":        fromPEM,
+	"to-pem":          toPEM,
+	"info":            info,
+	"change-password": changePassword,
+	"truststore":      trustStore,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "p12:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprintln(stderr, "usage: p12 create|from-pem|to-pem|info|change-password|truststore [flags]")
+		return flag.ErrHelp
+	}
+	cmd := &command{
+		FlagSet: flag.NewFlagSet(args[0], flag.ContinueOnError),
+		args:    args[1:],
+		stdin:   stdin,
+		stdout:  stdout,
+	}
+	cmd.SetOutput(stderr)
+	return commands[args[0]](cmd)
+}
+
+// A command holds the flags of a subcommand, registered on demand by the
+// subcommand, and the streams it works on.
+type command struct {
+	*flag.FlagSet
+	args   []string
+	stdin  io.Reader
+	stdout io.Writer
+
+	in, out                      string
+	password, passwordFile       string
+	format                       string
+	newPassword, newPasswordFile string
+	keyPassword, keyPasswordFile string
+}
+
+func (cmd *command) inputFlag(usage string) {
+	cmd.StringVar(&cmd.in, "in", "", usage+" (default standard input)")
+}
+
+func (cmd *command) outputFlags() {
+	cmd.StringVar(&cmd.out, "out", "", "output file (default standard output)")
+	cmd.StringVar(&cmd.format, "format", "modern", "encryption of the output: modern, legacy-des, legacy-rc2, or that of a platform: ios12, android10, win10 or java8")
+}
+
+func (cmd *command) passwordFlags() {
+	cmd.StringVar(&cmd.password, "password", "", "password of the PKCS#12 file")
+	cmd.StringVar(&cmd.passwordFile, "password-file", "", "file whose first line is the password")
+}
+
+func (cmd *command) keyPasswordFlags() {
+	cmd.StringVar(&cmd.keyPassword, "key-password", "", "password of an encrypted private key")
+	cmd.StringVar(&cmd.keyPasswordFile, "key-password-file", "", "file whose first line is the password of an encrypted private key")
+}
+
+func (cmd *command) parse() error {
+	return cmd.Parse(cmd.args)
+}
+
+func (cmd *command) readPassword() (string, error) {
+	return readPassword(cmd.password, cmd.passwordFile)
+}
+
+// readPassword returns password, or the first line of file if it is not
+// empty.
+func readPassword(password, file string) (string, error) {
+	if file == "" {
+		return password, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (cmd *command) readInput() ([]byte, error) {
+	if cmd.in == "" {
+		return io.ReadAll(cmd.stdin)
+	}
+	return os.ReadFile(cmd.in)
+}
+
+func (cmd *command) writeOutput(data []byte) error {
+	if cmd.out == "" {
+		_, err := cmd.stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(cmd.out, data, 0600)
+}
+
+// platforms are the formats selecting a pkcs12.Platform.
+var platforms = map[string]pkcs12.Platform{
+	"ios12":     pkcs12.IOS12,
+	"android10": pkcs12.Android10,
+	"win10":     pkcs12.Win10,
+	"java8":     pkcs12.Java8,
+}
+
+func (cmd *command) encoder() (*pkcs12.Encoder, error) {
+	switch cmd.format {
+	case "modern":
+		return pkcs12.Modern, nil
+	case "legacy-des":
+		return pkcs12.LegacyDES.AllowWeakAlgorithms(), nil
+	case "legacy-rc2":
+		return pkcs12.LegacyRC2.AllowWeakAlgorithms(), nil
+	}
+	if platform, ok := platforms[cmd.format]; ok {
+		return pkcs12.Modern.WithPlatformTarget(platform).AllowWeakAlgorithms(), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", cmd.format)
+}
+
+func create(cmd *command) error {
+	var keyFile, certFile, chainFile string
+	cmd.StringVar(&keyFile, "key", "", "PEM file holding the private key")
+	cmd.StringVar(&certFile, "cert", "", "PEM file holding the certificate, optionally followed by its chain")
+	cmd.StringVar(&chainFile, "chain", "", "PEM file holding CA certificates")
+	cmd.keyPasswordFlags()
+	cmd.outputFlags()
+	cmd.passwordFlags()
+	if err := cmd.parse(); err != nil {
+		return err
+	}
+	if keyFile == "" || certFile == "" {
+		return errors.New("create: -key and -cert are required")
+	}
+
+	var bundle []byte
+	for _, name := range []string{keyFile, certFile, chainFile} {
+		if name == "" {
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		bundle = append(append(bundle, data...), '\n')
+	}
+	return cmd.encodeBundle(bundle)
+}
+
+func fromPEM(cmd *command) error {
+	cmd.inputFlag("PEM file holding a private key and its certificates")
+	cmd.keyPasswordFlags()
+	cmd.outputFlags()
+	cmd.passwordFlags()
+	if err := cmd.parse(); err != nil {
+		return err
+	}
+	bundle, err := cmd.readInput()
+	if err != nil {
+		return err
+	}
+	return cmd.encodeBundle(bundle)
+}
+
+// encodeBundle writes the private key and certificates found in the PEM
+// blocks of bundle as a PKCS#12 file.  The certificate matching the key is
+// the end-entity certificate; the others are stored as CA certificates.
+func (cmd *command) encodeBundle(bundle []byte) error {
+	keyPassword, err := readPassword(cmd.keyPassword, cmd.keyPasswordFile)
+	if err != nil {
+		return err
+	}
+	key, certs, err := parseBundle(bundle, keyPassword)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return errors.New("no private key found")
+	}
+	leaf := -1
+	for i, cert := range certs {
+		if pkcs12.MatchKeyToCert(key, cert) == nil {
+			leaf = i
+			break
+		}
+	}
+	if leaf < 0 {
+		return errors.New("no certificate matches the private key")
+	}
+	caCerts := append(append([]*x509.Certificate(nil), certs[:leaf]...), certs[leaf+1:]...)
+
+	enc, err := cmd.encoder()
+	if err != nil {
+		return err
+	}
+	password, err := cmd.readPassword()
+	if err != nil {
+		return err
+	}
+	pfxData, err := enc.Encode(key, certs[leaf], caCerts, password)
+	if err != nil {
+		return err
+	}
+	return cmd.writeOutput(pfxData)
+}
+
+// parseBundle returns the private key and the certificates of the PEM blocks
+// in data.  Blocks of other types are ignored.
+func parseBundle(data []byte, keyPassword string) (key crypto.PrivateKey, certs []*x509.Certificate, err error) {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return key, certs, nil
+		}
+
+		var parsed crypto.PrivateKey
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, cert)
+			continue
+		case "PRIVATE KEY":
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			parsed, err = x509.ParseECPrivateKey(block.Bytes)
+		case pkcs8.PEMType:
+			parsed, err = pkcs8.Decrypt(block.Bytes, keyPassword)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if key != nil {
+			return nil, nil, errors.New("more than one private key found")
+		}
+		key = parsed
+	}
+}
+
+func toPEM(cmd *command) error {
+	cmd.inputFlag("PKCS#12 file")
+	cmd.StringVar(&cmd.out, "out", "", "output file (default standard output)")
+	cmd.passwordFlags()
+	if err := cmd.parse(); err != nil {
+		return err
+	}
+	contents, err := cmd.decodeContents()
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	for _, sc := range contents {
+		for _, entry := range sc.Entries {
+			switch {
+			case entry.PrivateKey != nil:
+				der, err := x509.MarshalPKCS8PrivateKey(entry.PrivateKey)
+				if err != nil {
+					return err
+				}
+				out = append(out, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})...)
+			case entry.Certificate != nil:
+				out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: entry.Certificate.Raw})...)
+			}
+		}
+	}
+	return cmd.writeOutput(out)
+}
+
+func (cmd *command) decodeContents() ([]pkcs12.SafeContents, error) {
+	pfxData, err := cmd.readInput()
+	if err != nil {
+		return nil, err
+	}
+	password, err := cmd.readPassword()
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12.DecodeContents(pfxData, password)
+}
+
+func info(cmd *command) error {
+	cmd.inputFlag("PKCS#12 file")
+	cmd.passwordFlags()
+	if err := cmd.parse(); err != nil {
+		return err
+	}
+	pfxData, err := cmd.readInput()
+	if err != nil {
+		return err
+	}
+
+	report := pkcs12.Assess(pfxData)
+	if report.Probe == nil {
+		return errors.New("not a PKCS#12 file")
+	}
+	w := cmd.stdout
+	if mac := report.Probe.MAC; mac != nil {
+		fmt.Fprintf(w, "MAC: %s, %d iterations, %d byte salt\n", mac.Name, mac.Iterations, mac.SaltLen)
+	} else {
+		fmt.Fprintln(w, "MAC: none")
+	}
+	for i, sc := range report.Probe.SafeContents {
+		fmt.Fprintf(w, "SafeContents %d: %s\n", i, describeEncryption(sc.Encryption))
+	}
+	cost := report.Probe.KDFCost()
+	fmt.Fprintf(w, "KDF cost: %d derivations, %d iterations", cost.Derivations, cost.Iterations)
+	if cost.ScryptMemory != 0 {
+		fmt.Fprintf(w, ", %d bytes of scrypt memory", cost.ScryptMemory)
+	}
+	if cost.Incomplete {
+		fmt.Fprint(w, " or more")
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Score: %d\n", report.Score)
+	for _, finding := range report.Findings {
+		fmt.Fprintf(w, "  %s: %s\n", finding.Severity, finding.Message)
+	}
+
+	if cmd.password == "" && cmd.passwordFile == "" {
+		return nil
+	}
+	password, err := cmd.readPassword()
+	if err != nil {
+		return err
+	}
+	contents, err := pkcs12.DecodeContents(pfxData, password)
+	if err != nil {
+		return err
+	}
+	for i, sc := range contents {
+		fmt.Fprintf(w, "SafeContents %d:\n", i)
+		for _, entry := range sc.Entries {
+			fmt.Fprintf(w, "  %s\n", entry.BagType)
+			if name := entry.FriendlyName(); name != "" {
+				fmt.Fprintf(w, "    friendlyName: %s\n", strconv.Quote(name))
+			}
+			if id := entry.LocalKeyID(); id != nil {
+				fmt.Fprintf(w, "    localKeyId: %s\n", hex.EncodeToString(id))
+			}
+			switch {
+			case entry.PrivateKey != nil:
+				fmt.Fprintf(w, "    key: %T\n", entry.PrivateKey)
+			case entry.Certificate != nil:
+				cert := entry.Certificate
+				fmt.Fprintf(w, "    subject: %s\n    issuer: %s\n    notAfter: %s\n", cert.Subject, cert.Issuer, cert.NotAfter.UTC().Format("2006-01-02 15:04:05Z"))
+			}
+		}
+	}
+	return nil
+}
+
+func describeEncryption(info *pkcs12.EncryptionInfo) string {
+	if info == nil {
+		return "not encrypted"
+	}
+	return fmt.Sprintf("%s, %d iterations", info.Name, info.Iterations)
+}
+
+func changePassword(cmd *command) error {
+	cmd.inputFlag("PKCS#12 file")
+	cmd.outputFlags()
+	cmd.passwordFlags()
+	cmd.StringVar(&cmd.newPassword, "new-password", "", "password of the output")
+	cmd.StringVar(&cmd.newPasswordFile, "new-password-file", "", "file whose first line is the password of the output")
+	if err := cmd.parse(); err != nil {
+		return err
+	}
+	newPassword, err := readPassword(cmd.newPassword, cmd.newPasswordFile)
+	if err != nil {
+		return err
+	}
+	contents, err := cmd.decodeContents()
+	if err != nil {
+		return err
+	}
+	enc, err := cmd.encoder()
+	if err != nil {
+		return err
+	}
+	pfxData, err := enc.EncodeContents(contents, newPassword)
+	if err != nil {
+		return err
+	}
+	return cmd.writeOutput(pfxData)
+}
+
+func trustStore(cmd *command) error {
+	cmd.outputFlags()
+	cmd.passwordFlags()
+	if err := cmd.parse(); err != nil {
+		return err
+	}
+	if cmd.NArg() == 0 {
+		return errors.New("truststore: no certificate files given")
+	}
+
+	certs := make(map[string]*x509.Certificate)
+	for _, name := range cmd.Args() {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		_, parsed, err := parseBundle(data, "")
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, cert := range parsed {
+			alias := strings.ToLower(cert.Subject.CommonName)
+			if alias == "" {
+				alias = "cert"
+			}
+			unique := alias
+			for n := 2; certs[unique] != nil; n++ {
+				unique = alias + "-" + strconv.Itoa(n)
+			}
+			certs[unique] = cert
+		}
+	}
+
+	enc, err := cmd.encoder()
+	if err != nil {
+		return err
+	}
+	password, err := cmd.readPassword()
+	if err != nil {
+		return err
+	}
+	pfxData, err := enc.EncodeTrustStore(certs, password)
+	if err != nil {
+		return err
+	}
+	return cmd.writeOutput(pfxData)
+}
@@ -0,0 +1,77 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+)
+
+// DecodeContext is like Decode, but stops with the error of ctx if ctx is
+// cancelled while a key is being derived from the password.  Servers which
+// decode uploaded files should use it with the context of the request,
+// since a file can ask for millions of KDF iterations.
+func (dec *Decoder) DecodeContext(ctx context.Context, pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, err error) {
+	return dec.withContext(ctx).Decode(pfxData, password)
+}
+
+// DecodeChainContext is like DecodeChain, but stops with the error of ctx
+// if ctx is cancelled while a key is being derived from the password.
+func (dec *Decoder) DecodeChainContext(ctx context.Context, pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	return dec.withContext(ctx).DecodeChain(pfxData, password)
+}
+
+// DecodeContentsContext is like DecodeContents, but stops with the error of
+// ctx if ctx is cancelled while a key is being derived from the password.
+func (dec *Decoder) DecodeContentsContext(ctx context.Context, pfxData []byte, password string) ([]SafeContents, error) {
+	return dec.withContext(ctx).DecodeContents(pfxData, password)
+}
+
+// DecodeContext decodes pfxData using the DefaultDecoder, stopping if ctx is
+// cancelled.  See Decoder.DecodeContext.
+func DecodeContext(ctx context.Context, pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, err error) {
+	return DefaultDecoder.DecodeContext(ctx, pfxData, password)
+}
+
+// DecodeChainContext decodes pfxData using the DefaultDecoder, stopping if
+// ctx is cancelled.  See Decoder.DecodeChainContext.
+func DecodeChainContext(ctx context.Context, pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	return DefaultDecoder.DecodeChainContext(ctx, pfxData, password)
+}
+
+// EncodeContext is like Encode, but stops with the error of ctx if ctx is
+// cancelled while a key is being derived from the password.
+func (enc *Encoder) EncodeContext(ctx context.Context, privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) (pfxData []byte, err error) {
+	withContext := *enc
+	withContext.ctx = ctx
+	return withContext.Encode(privateKey, certificate, caCerts, password)
+}
+
+func (dec Decoder) withContext(ctx context.Context) *Decoder {
+	dec.ctx = ctx
+	return &dec
+}
+
+// kdfContext returns the context bounding the key derivations of dec,
+// which also carries the KDF and HSM selected by WithKDF and WithHSM.
+func (dec *Decoder) kdfContext() context.Context {
+	ctx := dec.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return withHSM(withKDF(ctx, dec.kdf), dec.hsm)
+}
+
+// kdfContext returns the context bounding the key derivations of enc,
+// which also carries the KDF and HSM selected by WithKDF and WithHSM.
+func (enc *Encoder) kdfContext() context.Context {
+	ctx := enc.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return withHSM(withKDF(ctx, enc.kdf), enc.hsm)
+}
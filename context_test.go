@@ -0,0 +1,70 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"encoding/asn1"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDecodeContext(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := DecodeContext(context.Background(), pfxData, "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := DecodeContext(ctx, pfxData, "password"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	// Raise the MAC iteration count to one that takes minutes to derive:
+	// the deadline must stop the derivation rather than be noticed after
+	// it.
+	var pfx pfxPdu
+	if err := unmarshal(pfxData, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	pfx.MacData.Iterations = 1 << 30
+	if pfxData, err = asn1.Marshal(pfx); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, _, err := DecodeChainContext(ctx, pfxData, "password"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("decoding took %v after the deadline", elapsed)
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Modern.WithIterations(1<<30).EncodeContext(ctx, key, cert, nil, "password"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	pfxData, err := Modern.EncodeContext(context.Background(), key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Decode(pfxData, "password"); err != nil {
+		t.Error(err)
+	}
+}
@@ -7,93 +7,40 @@ package pkcs12
 
 import (
 	"bytes"
+	"context"
 	"crypto/cipher"
-	"crypto/des"
+	"crypto/subtle"
 	"crypto/x509/pkix"
-	"encoding/asn1"
 	"errors"
 
-	"github.com/nevissecurity/go-pkcs12/internal/rc2"
+	"github.com/nevissecurity/go-pkcs12/pbe"
 )
 
 var (
-	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 3})
-	oidPBEWithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 6})
+	oidPBEWithSHAAnd3KeyTripleDESCBC = pbe.OIDSHAAnd3KeyTripleDESCBC
+	oidPBEWithSHAAnd40BitRC2CBC      = pbe.OIDSHAAnd40BitRC2CBC
 )
 
-// pbeCipher is an abstraction of a PKCS#12 cipher.
-type pbeCipher interface {
-	// create returns a cipher.Block given a key.
-	create(key []byte) (cipher.Block, error)
-	// deriveKey returns a key derived from the given password and salt.
-	deriveKey(salt, password []byte, iterations int) []byte
-	// deriveKey returns an IV derived from the given password and salt.
-	deriveIV(salt, password []byte, iterations int) []byte
-}
-
-type shaWithTripleDESCBC struct{}
-
-func (shaWithTripleDESCBC) create(key []byte) (cipher.Block, error) {
-	return des.NewTripleDESCipher(key)
-}
-
-func (shaWithTripleDESCBC) deriveKey(salt, password []byte, iterations int) []byte {
-	return pbkdf(sha1Sum, 20, 64, salt, password, iterations, 1, 24)
-}
-
-func (shaWithTripleDESCBC) deriveIV(salt, password []byte, iterations int) []byte {
-	return pbkdf(sha1Sum, 20, 64, salt, password, iterations, 2, 8)
-}
-
-type shaWith40BitRC2CBC struct{}
-
-func (shaWith40BitRC2CBC) create(key []byte) (cipher.Block, error) {
-	return rc2.New(key, len(key)*8)
-}
-
-func (shaWith40BitRC2CBC) deriveKey(salt, password []byte, iterations int) []byte {
-	return pbkdf(sha1Sum, 20, 64, salt, password, iterations, 1, 5)
-}
-
-func (shaWith40BitRC2CBC) deriveIV(salt, password []byte, iterations int) []byte {
-	return pbkdf(sha1Sum, 20, 64, salt, password, iterations, 2, 8)
-}
-
 type pbeParams struct {
 	Salt       []byte
 	Iterations int
 }
 
-func pbeCipherFor(algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.Block, []byte, error) {
-	var cipherType pbeCipher
-
+func pbeCipherFor(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.Block, []byte, error) {
 	switch {
-	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC):
-		cipherType = shaWithTripleDESCBC{}
-	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
-		cipherType = shaWith40BitRC2CBC{}
-	default:
-		return nil, nil, NotImplementedError("algorithm " + algorithm.Algorithm.String() + " is not supported")
-	}
-
-	var params pbeParams
-	if err := unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
-		return nil, nil, err
-	}
-
-	key := cipherType.deriveKey(params.Salt, password, params.Iterations)
-	iv := cipherType.deriveIV(params.Salt, password, params.Iterations)
-
-	block, err := cipherType.create(key)
-	if err != nil {
-		return nil, nil, err
+	case algorithm.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC), algorithm.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		if hsmFor(ctx) != nil {
+			return nil, nil, hsmUnsupported(algorithmName(algorithm.Algorithm))
+		}
+		return pbe.NewCipherFunc(ctx, algorithm, password, kdfFor(ctx).PKCS12)
+	case algorithm.Algorithm.Equal(oidPBES2):
+		return pbes2CipherFor(ctx, algorithm, password)
 	}
-
-	return block, iv, nil
+	return nil, nil, NotImplementedError("algorithm " + algorithm.Algorithm.String() + " is not supported")
 }
 
-func pbDecrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.BlockMode, int, error) {
-	block, iv, err := pbeCipherFor(algorithm, password)
+func pbDecrypterFor(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.BlockMode, int, error) {
+	block, iv, err := pbeCipherFor(ctx, algorithm, password)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -101,13 +48,21 @@ func pbDecrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte) (cipher
 	return cipher.NewCBCDecrypter(block, iv), block.BlockSize(), nil
 }
 
-func pbDecrypt(info decryptable, password []byte) (decrypted []byte, err error) {
-	cbc, blockSize, err := pbDecrypterFor(info.Algorithm(), password)
+func pbDecrypt(ctx context.Context, info decryptable, password []byte) (decrypted []byte, err error) {
+	if usesAEAD(info.Algorithm()) {
+		return pbDecryptAEAD(ctx, info, password)
+	}
+
+	cbc, blockSize, err := pbDecrypterFor(ctx, info.Algorithm(), password)
 	if err != nil {
 		return nil, err
 	}
+	return cbcDecrypt(cbc, blockSize, info.Data())
+}
 
-	encrypted := info.Data()
+// cbcDecrypt decrypts encrypted with cbc and removes the PKCS#7 padding,
+// returning ErrDecryption if the padding is wrong.
+func cbcDecrypt(cbc cipher.BlockMode, blockSize int, encrypted []byte) (decrypted []byte, err error) {
 	if len(encrypted) == 0 {
 		return nil, errors.New("pkcs12: empty encrypted data")
 	}
@@ -117,31 +72,50 @@ func pbDecrypt(info decryptable, password []byte) (decrypted []byte, err error)
 	decrypted = make([]byte, len(encrypted))
 	cbc.CryptBlocks(decrypted, encrypted)
 
+	// Check the padding in constant time, looking at the whole last block
+	// whatever its value, so that the timing does not reveal how much of
+	// the padding was correct.
 	psLen := int(decrypted[len(decrypted)-1])
-	if psLen == 0 || psLen > blockSize {
-		return nil, ErrDecryption
+	good := subtle.ConstantTimeLessOrEq(1, psLen) & subtle.ConstantTimeLessOrEq(psLen, blockSize)
+	for i := 1; i <= blockSize; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i, psLen)
+		matches := subtle.ConstantTimeByteEq(decrypted[len(decrypted)-i], byte(psLen))
+		good &= subtle.ConstantTimeSelect(inPadding, matches, 1)
 	}
-
-	if len(decrypted) < psLen {
+	if good != 1 {
+		wipe(decrypted)
 		return nil, ErrDecryption
 	}
-	ps := decrypted[len(decrypted)-psLen:]
 	decrypted = decrypted[:len(decrypted)-psLen]
-	if bytes.Compare(ps, bytes.Repeat([]byte{byte(psLen)}, psLen)) != 0 {
-		return nil, ErrDecryption
-	}
 
 	return
 }
 
+func pbDecryptAEAD(ctx context.Context, info decryptable, password []byte) ([]byte, error) {
+	aead, nonce, err := pbes2AEADFor(ctx, info.Algorithm(), password)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := info.Data()
+	if len(encrypted) < aead.Overhead() {
+		return nil, errors.New("pkcs12: encrypted data is shorter than the authentication tag")
+	}
+	decrypted, err := aead.Open(nil, nonce, encrypted, nil)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return decrypted, nil
+}
+
 // decryptable abstracts an object that contains ciphertext.
 type decryptable interface {
 	Algorithm() pkix.AlgorithmIdentifier
 	Data() []byte
 }
 
-func pbEncrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.BlockMode, int, error) {
-	block, iv, err := pbeCipherFor(algorithm, password)
+func pbEncrypterFor(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.BlockMode, int, error) {
+	block, iv, err := pbeCipherFor(ctx, algorithm, password)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -149,8 +123,17 @@ func pbEncrypterFor(algorithm pkix.AlgorithmIdentifier, password []byte) (cipher
 	return cipher.NewCBCEncrypter(block, iv), block.BlockSize(), nil
 }
 
-func pbEncrypt(info encryptable, decrypted []byte, password []byte) error {
-	cbc, blockSize, err := pbEncrypterFor(info.Algorithm(), password)
+func pbEncrypt(ctx context.Context, info encryptable, decrypted []byte, password []byte) error {
+	if usesAEAD(info.Algorithm()) {
+		aead, nonce, err := pbes2AEADFor(ctx, info.Algorithm(), password)
+		if err != nil {
+			return err
+		}
+		info.SetData(aead.Seal(nil, nonce, decrypted, nil))
+		return nil
+	}
+
+	cbc, blockSize, err := pbEncrypterFor(ctx, info.Algorithm(), password)
 	if err != nil {
 		return err
 	}
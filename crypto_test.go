@@ -7,6 +7,7 @@ package pkcs12
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"testing"
@@ -28,13 +29,13 @@ func TestPbDecrypterFor(t *testing.T) {
 
 	pass, _ := bmpString("Sesame open")
 
-	_, _, err := pbDecrypterFor(alg, pass)
+	_, _, err := pbDecrypterFor(context.Background(), alg, pass)
 	if _, ok := err.(NotImplementedError); !ok {
 		t.Errorf("expected not implemented error, got: %T %s", err, err)
 	}
 
 	alg.Algorithm = sha1WithTripleDES
-	cbc, blockSize, err := pbDecrypterFor(alg, pass)
+	cbc, blockSize, err := pbDecrypterFor(context.Background(), alg, pass)
 	if err != nil {
 		t.Errorf("unexpected error from pbDecrypterFor %v", err)
 	}
@@ -66,13 +67,13 @@ func TestPbEncrypterFor(t *testing.T) {
 
 	pass, _ := bmpString("Sesame open")
 
-	_, _, err := pbEncrypterFor(alg, pass)
+	_, _, err := pbEncrypterFor(context.Background(), alg, pass)
 	if _, ok := err.(NotImplementedError); !ok {
 		t.Errorf("expected not implemented error, got: %T %s", err, err)
 	}
 
 	alg.Algorithm = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 3})
-	cbc, _, err := pbEncrypterFor(alg, pass)
+	cbc, _, err := pbEncrypterFor(context.Background(), alg, pass)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -127,7 +128,7 @@ func TestPbDecrypt(t *testing.T) {
 		}
 		password, _ := bmpString("sesame")
 
-		plaintext, err := pbDecrypt(decryptable, password)
+		plaintext, err := pbDecrypt(context.Background(), decryptable, password)
 		if err != test.expectedError {
 			t.Errorf("#%d: got error %q, but wanted %q", i, err, test.expectedError)
 			continue
@@ -161,7 +162,7 @@ func TestPbEncrypt(t *testing.T) {
 		}
 		p, _ := bmpString("sesame")
 
-		err := pbEncrypt(&td, c, p)
+		err := pbEncrypt(context.Background(), &td, c, p)
 		if err != nil {
 			t.Errorf("error encrypting %d: %v", c, err)
 		}
@@ -172,6 +173,41 @@ func TestPbEncrypt(t *testing.T) {
 	}
 }
 
+// TestUniformDecryptionErrors checks that a key bag which does not decrypt
+// with the password that verified the MAC is reported with the same error
+// as a wrong password.
+func TestUniformDecryptionErrors(t *testing.T) {
+	key, _ := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	password, _ := bmpString(DefaultPassword)
+	otherPassword, _ := bmpString("other")
+
+	var keyBag safeBag
+	keyBag.Id = oidPKCS8ShroundedKeyBag
+	keyBag.Value.Class = 2
+	keyBag.Value.Tag = 0
+	keyBag.Value.IsCompound = true
+	var err error
+	if keyBag.Value.Bytes, err = LegacyRC2.encodePkcs8ShroudedKeyBag(key, otherPassword); err != nil {
+		t.Fatal(err)
+	}
+	ci, err := LegacyRC2.makeSafeContents([]safeBag{keyBag}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := LegacyRC2.marshalPFX([]contentInfo{ci}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecryptPrivateKeyInto(make([]byte, 4096), pfxData, DefaultPassword); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+	if _, err := DecryptPrivateKeyInto(make([]byte, 4096), pfxData, "other"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+}
+
 type testDecryptable struct {
 	data      []byte
 	algorithm pkix.AlgorithmIdentifier
@@ -0,0 +1,60 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/asn1"
+	"time"
+)
+
+// WithCreationDate creates a new Encoder identical to enc except that,
+// unless date is the zero time, Encode and EncodeKeyReference add a
+// signingTime attribute holding date to the private key and end-entity
+// certificate bags, and EncodeTrustStore to every certificate bag.  The
+// attribute records when the keystore entries were created, the way JKS
+// keystores do, and is read back by Entry.CreationDate.  It is stored in
+// UTC, to the second.
+func (enc Encoder) WithCreationDate(date time.Time) *Encoder {
+	enc.creationDate = date
+	return &enc
+}
+
+// addCreationDate adds the signingTime attribute selected by
+// WithCreationDate to bags.
+func (enc *Encoder) addCreationDate(bags ...*safeBag) error {
+	if enc.creationDate.IsZero() {
+		return nil
+	}
+
+	value, err := asn1.Marshal(enc.creationDate.UTC().Truncate(time.Second))
+	if err != nil {
+		return err
+	}
+	attribute := pkcs12Attribute{Id: OIDSigningTime}
+	attribute.Value.Class = 0
+	attribute.Value.Tag = asn1.TagSet
+	attribute.Value.IsCompound = true
+	attribute.Value.Bytes = value
+	for _, bag := range bags {
+		bag.Attributes = append(bag.Attributes, attribute)
+	}
+	return nil
+}
+
+// CreationDate returns the signingTime attribute of e, which keystores
+// use as the creation date of an entry, and whether e has one which can
+// be decoded.
+func (e *Entry) CreationDate() (time.Time, bool) {
+	a, ok := e.Attribute(OIDSigningTime)
+	if !ok {
+		return time.Time{}, false
+	}
+	var date time.Time
+	if err := a.Unmarshal(0, &date); err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
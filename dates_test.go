@@ -0,0 +1,66 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestCreationDate(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	for _, date := range []time.Time{
+		time.Date(2017, 7, 14, 4, 40, 0, 123456789, time.FixedZone("CEST", 2*60*60)),
+		time.Date(2051, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		expected := date.Truncate(time.Second)
+		enc := Modern.WithCreationDate(date)
+
+		pfxData, err := enc.Encode(key, cert, []*x509.Certificate{caCert}, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := DecodeContents(pfxData, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		leafEntry, caEntry, keyEntry := contents[0].Entries[0], contents[0].Entries[1], contents[1].Entries[0]
+		for _, entry := range []Entry{leafEntry, keyEntry} {
+			if got, ok := entry.CreationDate(); !ok || !got.Equal(expected) || got.Location() != time.UTC {
+				t.Errorf("%s entry: expected creation date %v, got %v", entry.BagType, expected, got)
+			}
+		}
+		if _, ok := caEntry.CreationDate(); ok {
+			t.Error("CA certificate has a creation date")
+		}
+
+		pfxData, err = enc.EncodeTrustStore(map[string]*x509.Certificate{"ca": caCert}, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contents, err = DecodeContents(pfxData, "password"); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := contents[0].Entries[0].CreationDate(); !ok || !got.Equal(expected) {
+			t.Errorf("trusted certificate: expected creation date %v, got %v", expected, got)
+		}
+	}
+
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := contents[1].Entries[0].CreationDate(); ok {
+		t.Error("creation date written without WithCreationDate")
+	}
+}
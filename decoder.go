@@ -0,0 +1,841 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// A Decoder contains methods for decoding PKCS#12 files.  The zero Decoder
+// decodes every file this package supports; the With* methods return a
+// modified copy which restricts or relaxes that behavior and leave the
+// receiver untouched.  A Decoder is safe for concurrent use by multiple
+// goroutines, provided the PasswordFunc given to the *Func methods is.
+type Decoder struct {
+	fips   bool
+	limits Limits
+
+	// passwordFunc is set by the *Func decoding methods to ask for
+	// passwords beyond the one of the MAC.
+	passwordFunc PasswordFunc
+	// ctx is set by the *Context decoding methods on the copy of the
+	// Decoder they use, and bounds the key derivations.
+	ctx context.Context
+
+	// parallelism bounds the number of goroutines deriving keys at the
+	// same time; see WithParallelism.
+	parallelism int
+
+	// noArmor turns off the detection of PEM and base64 armor; see
+	// WithArmorDetection.
+	noArmor bool
+	// anyVersion is set by AllowAnyVersion.
+	anyVersion bool
+	// missingKey is set by AllowMissingKey.
+	missingKey bool
+	// unterminatedPasswords is set by AllowUnterminatedPasswords.
+	unterminatedPasswords bool
+	// chacha20Poly1305 is set by AllowChaCha20Poly1305.
+	chacha20Poly1305 bool
+	// recipients are added by WithRecipient.
+	recipients []recipient
+	// certParsers are added by WithCertParser.
+	certParsers []certParser
+	// duplicateNames is set by WithDuplicateNames.
+	duplicateNames DuplicateNames
+	// kdf and hsm are set by WithKDF and WithHSM.
+	kdf KDF
+	hsm HSM
+	// partial is set by DecodeContentsPartial on the copy of the Decoder
+	// it uses, and skips the SafeContents and private keys which do not
+	// decrypt.
+	partial bool
+}
+
+// DefaultDecoder is the Decoder used by the package-level Decode,
+// DecodeChain, DecodeTrustStore and ToPEM functions.
+var DefaultDecoder = &Decoder{}
+
+// WithFIPSMode creates a new Decoder identical to dec except that it refuses
+// to decode files which use algorithms that are not approved by FIPS 140-3.
+// Such files are rejected with an error wrapping ErrNonFIPSAlgorithm before
+// any decryption is attempted.
+func (dec Decoder) WithFIPSMode() *Decoder {
+	dec.fips = true
+	return &dec
+}
+
+// AllowAnyVersion creates a new Decoder identical to dec except that it
+// decodes files whose PFX version is not 3, the only version defined by
+// RFC 7292, as long as their content is otherwise standard.  Some
+// appliances write such files.  Probe reports the version, and Assess and
+// Validate warn about it.  Fields appended to the PFX PDU, as a later
+// version might define, are ignored by every Decoder.
+//
+// The Decoder also accepts an EncryptedData, which holds an encrypted
+// SafeContents, with a version other than 0, such as the 2 that CMS
+// prescribes when unprotected attributes follow, or with the version left
+// out, as some generators write it.
+func (dec Decoder) AllowAnyVersion() *Decoder {
+	dec.anyVersion = true
+	return &dec
+}
+
+// AllowMissingKey creates a new Decoder identical to dec except that
+// Decode and DecodeChain accept files without a private key, such as the
+// CA bundles distributed by MDM systems, returning a nil private key and
+// the certificates: the first one as the leaf certificate and the others
+// as the CA certificates.  A file without any bag, whose authenticated
+// safe is empty, then decodes to no key and no certificates.  A file with
+// a private key still needs a certificate.
+func (dec Decoder) AllowMissingKey() *Decoder {
+	dec.missingKey = true
+	return &dec
+}
+
+// AllowUnterminatedPasswords creates a new Decoder identical to dec except
+// that, if the MAC does not verify, it is tried once more with the
+// BMPString of the password without its NUL terminator, a convention some
+// implementations use for every password.  The SafeContents and keys are
+// then decrypted with the password that verified the MAC.  Every wrong
+// password then costs two MAC key derivations instead of one, which is why
+// the retry is off by default.  The empty password, which many
+// implementations turn into an empty byte array, is retried by every
+// Decoder.
+func (dec Decoder) AllowUnterminatedPasswords() *Decoder {
+	dec.unterminatedPasswords = true
+	return &dec
+}
+
+// ToPEM converts all "safe bags" contained in pfxData to PEM blocks.
+// DO NOT USE THIS FUNCTION. ToPEM creates invalid PEM blocks; private keys
+// are encoded as raw RSA or EC private keys rather than PKCS#8 despite being
+// labeled "PRIVATE KEY".  To decode a PKCS#12 file, use DecodeChain instead,
+// and use the encoding/pem package to convert to PEM if necessary.
+func (dec *Decoder) ToPEM(pfxData []byte, password string) ([]*pem.Block, error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, ErrIncorrectPassword
+	}
+	defer wipe(encodedPassword)
+
+	var spent usage
+	bags, encodedPassword, err := dec.getSafeContents(pfxData, encodedPassword, nil, &spent)
+
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([]*pem.Block, 0, len(bags))
+	for _, bag := range bags {
+		block, err := dec.convertBag(&bag, encodedPassword, &spent)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
+func (dec *Decoder) convertBag(bag *safeBag, password []byte, spent *usage) (*pem.Block, error) {
+	block := &pem.Block{
+		Headers: make(map[string]string),
+	}
+
+	for _, attribute := range bag.Attributes {
+		k, v, err := convertAttribute(&attribute)
+		if err != nil {
+			return nil, err
+		}
+		// The first value wins if an attribute is repeated.
+		if block.Headers[k] == "" {
+			block.Headers[k] = v
+		}
+	}
+
+	switch {
+	case bag.Id.Equal(oidCertBag):
+		block.Type = certificateType
+		certsData, err := decodeCertBag(bag.Value.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		block.Bytes = certsData
+	case bag.Id.Equal(oidPKCS8ShroundedKeyBag), bag.Id.Equal(oidKeyBag):
+		block.Type = privateKeyType
+
+		var key interface{}
+		var err error
+		if bag.Id.Equal(oidKeyBag) {
+			key, err = DecodeKeyBag(bag.Value.Bytes)
+		} else {
+			key, err = dec.decodePkcs8ShroudedKeyBag(bag.Value.Bytes, password, spent)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch key := key.(type) {
+		case *rsa.PrivateKey:
+			block.Bytes = x509.MarshalPKCS1PrivateKey(key)
+		case *ecdsa.PrivateKey:
+			block.Bytes, err = x509.MarshalECPrivateKey(key)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("found unknown private key type in PKCS#8 wrapping")
+		}
+	default:
+		return nil, errors.New("don't know how to convert a safe bag of type " + bag.Id.String())
+	}
+	return block, nil
+}
+
+// Decode extracts a certificate and private key from pfxData. This function
+// assumes that there is only one certificate and only one private key in the
+// pfxData.  Since PKCS#12 files often contain more than one certificate, you
+// probably want to use DecodeChain instead.
+func (dec *Decoder) Decode(pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, err error) {
+	var caCerts []*x509.Certificate
+	privateKey, certificate, caCerts, err = dec.DecodeChain(pfxData, password)
+	if len(caCerts) != 0 {
+		err = errors.New("pkcs12: expected exactly two safe bags in the PFX PDU")
+	}
+	return
+}
+
+// DecodeBytesPassword is like Decode, but takes the password as UTF-8
+// encoded bytes, which the caller can wipe after use.
+func (dec *Decoder) DecodeBytesPassword(pfxData, password []byte) (privateKey crypto.PrivateKey, certificate *x509.Certificate, err error) {
+	var caCerts []*x509.Certificate
+	privateKey, certificate, caCerts, err = dec.DecodeChainBytesPassword(pfxData, password)
+	if len(caCerts) != 0 {
+		err = errors.New("pkcs12: expected exactly two safe bags in the PFX PDU")
+	}
+	return
+}
+
+// DecodeTrustStore extracts CA certificates from pfxData, keyed by friendly
+// name.  It fails if a bag is not a certificate or has no friendly name;
+// DecodeTrustStoreCerts tolerates both and reports which certificates are
+// CAs.  Certificates sharing a name are handled as selected by
+// WithDuplicateNames.
+func (dec *Decoder) DecodeTrustStore(pfxData []byte, password string) (certs map[string]*x509.Certificate, err error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeTrustStore(pfxData, encodedPassword)
+}
+
+// DecodeTrustStoreBytesPassword is like DecodeTrustStore, but takes the
+// password as UTF-8 encoded bytes, which the caller can wipe after use.
+func (dec *Decoder) DecodeTrustStoreBytesPassword(pfxData, password []byte) (certs map[string]*x509.Certificate, err error) {
+	encodedPassword, err := bmpStringBytes(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeTrustStore(pfxData, encodedPassword)
+}
+
+func (dec *Decoder) decodeTrustStore(pfxData, encodedPassword []byte) (certs map[string]*x509.Certificate, err error) {
+	bags, encodedPassword, err := dec.getSafeContents(pfxData, encodedPassword, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bags) == 0 {
+		err = errors.New("pkcs12: no bag was found in trust store")
+		return nil, err
+	}
+
+	var bagCerts []*x509.Certificate
+	var friendlyNames []string
+	for _, bag := range bags {
+		if !bag.Id.Equal(oidCertBag) {
+			err = errors.New("pkcs12: expected only cert bags in trust store")
+			return nil, err
+		}
+
+		bagCertsData, err := decodeCertBag(bag.Value.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		bagCert, err := x509.ParseCertificate(bagCertsData)
+		if err != nil {
+			return nil, err
+		}
+
+		friendlyName, err := certBagFriendlyName(bag.Attributes)
+		if err != nil {
+			return nil, err
+		}
+
+		bagCerts = append(bagCerts, bagCert)
+		friendlyNames = append(friendlyNames, friendlyName)
+	}
+	if err := dec.applyDuplicateNames(friendlyNames); err != nil {
+		return nil, err
+	}
+
+	certs = make(map[string]*x509.Certificate, len(bagCerts))
+	for i, bagCert := range bagCerts {
+		certs[friendlyNames[i]] = bagCert
+	}
+
+	return
+}
+
+// DecodeChain extracts a certificate, a CA certificate chain, and private key
+// from pfxData. This function checks if there is at least one certificate
+// and only one private key in the pfxData.  The leaf certificate is the one
+// whose localKeyId attribute matches that of the private key or, if there is
+// no such certificate, the first one whose public key matches the private
+// key.  If neither is found, the first certificate is assumed to be the leaf
+// certificate.  The other certificates, if any, are assumed to comprise the
+// CA certificate chain, in the order they appear in pfxData.
+//
+// The private key is one of *rsa.PrivateKey, *ecdsa.PrivateKey or
+// ed25519.PrivateKey, and always implements crypto.Signer.  If the leaf
+// certificate was not found by its public key, DecodeChain does not check
+// that the key belongs to it; use MatchKeyToCert for that.
+func (dec *Decoder) DecodeChain(pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeChain(pfxData, encodedPassword)
+}
+
+// DecodeChainBytesPassword is like DecodeChain, but takes the password as
+// UTF-8 encoded bytes, which the caller can wipe after use.
+func (dec *Decoder) DecodeChainBytesPassword(pfxData, password []byte) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	encodedPassword, err := bmpStringBytes(password)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeChain(pfxData, encodedPassword)
+}
+
+func (dec *Decoder) decodeChain(pfxData, encodedPassword []byte) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	var spent usage
+	bags, encodedPassword, err := dec.getSafeContents(pfxData, encodedPassword, nil, &spent)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var certs []*x509.Certificate
+	var certIDs [][]byte
+	var keyID []byte
+	for _, bag := range bags {
+		switch {
+		case bag.Id.Equal(oidCertBag):
+			certsData, err := decodeCertBag(bag.Value.Bytes)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			parsed, err := x509.ParseCertificates(certsData)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if len(parsed) != 1 {
+				err = errors.New("pkcs12: expected exactly one certificate in the certBag")
+				return nil, nil, nil, err
+			}
+			certs = append(certs, parsed[0])
+			certIDs = append(certIDs, bagLocalKeyID(bag.Attributes))
+
+		case bag.Id.Equal(oidPKCS8ShroundedKeyBag), bag.Id.Equal(oidKeyBag):
+			if privateKey != nil {
+				err = errors.New("pkcs12: expected exactly one key bag")
+				return nil, nil, nil, err
+			}
+
+			if bag.Id.Equal(oidKeyBag) {
+				// An unencrypted key, as written by
+				// "openssl pkcs12 -export -keypbe NONE".
+				if privateKey, err = DecodeKeyBag(bag.Value.Bytes); err != nil {
+					return nil, nil, nil, err
+				}
+			} else if privateKey, err = dec.decodePkcs8ShroudedKeyBag(bag.Value.Bytes, encodedPassword, &spent); err != nil {
+				return nil, nil, nil, err
+			}
+			if _, ok := privateKey.(crypto.Signer); !ok {
+				return nil, nil, nil, NotImplementedError(fmt.Sprintf("private keys of type %T, which cannot sign, are not supported", privateKey))
+			}
+			keyID = bagLocalKeyID(bag.Attributes)
+		}
+	}
+
+	if len(certs) == 0 {
+		if privateKey == nil && dec.missingKey {
+			return nil, nil, nil, nil
+		}
+		return nil, nil, nil, errors.New("pkcs12: certificate missing")
+	}
+	if privateKey == nil && !dec.missingKey {
+		return nil, nil, nil, errors.New("pkcs12: private key missing")
+	}
+
+	leaf := findLeaf(privateKey, keyID, certs, certIDs)
+	certificate = certs[leaf]
+	for i, cert := range certs {
+		if i != leaf {
+			caCerts = append(caCerts, cert)
+		}
+	}
+
+	return
+}
+
+// findLeaf returns the index of the certificate in certs that belongs to
+// privateKey: the one whose localKeyId, given in certIDs, equals keyID, or
+// failing that the first one whose public key matches privateKey, like
+// OpenSSL does.  If neither is found, the first certificate is assumed to be
+// the leaf.
+func findLeaf(privateKey crypto.PrivateKey, keyID []byte, certs []*x509.Certificate, certIDs [][]byte) int {
+	if len(keyID) != 0 {
+		for i, id := range certIDs {
+			if bytes.Equal(id, keyID) {
+				return i
+			}
+		}
+	}
+	for i, cert := range certs {
+		if MatchKeyToCert(privateKey, cert) == nil {
+			return i
+		}
+	}
+	return 0
+}
+
+// bagLocalKeyID returns the value of the localKeyId attribute among
+// attributes, or nil if there is none.
+func bagLocalKeyID(attributes []pkcs12Attribute) []byte {
+	id, _ := parseOctetString(firstAttributeValue(attributes, oidLocalKeyID))
+	return id
+}
+
+// DecryptPrivateKey decrypts a DER-encoded PKCS#8 EncryptedPrivateKeyInfo,
+// the content of an "ENCRYPTED PRIVATE KEY" PEM block, with the algorithms
+// supported for the keys of PKCS#12 files.  A wrong password is reported as
+// ErrIncorrectPassword.
+func (dec *Decoder) DecryptPrivateKey(der []byte, password string) (crypto.PrivateKey, error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodePkcs8ShroudedKeyBag(der, encodedPassword, new(usage))
+}
+
+// DecryptPrivateKeyInto decrypts the only private key in pfxData and copies
+// its DER-encoded PKCS#8 form into dst, returning the number of bytes
+// written.  It is meant for callers that keep key material in buffers they
+// manage themselves, for example locked memory that is wiped after use:
+// every other copy of the decrypted key made by this package is wiped before
+// DecryptPrivateKeyInto returns.  If dst is too small, nothing is written and
+// io.ErrShortBuffer is returned.
+func (dec *Decoder) DecryptPrivateKeyInto(dst, pfxData []byte, password string) (n int, err error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return 0, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decryptPrivateKeyInto(dst, pfxData, encodedPassword)
+}
+
+// DecryptPrivateKeyIntoBytesPassword is like DecryptPrivateKeyInto, but
+// takes the password as UTF-8 encoded bytes, which the caller can wipe after
+// use.
+func (dec *Decoder) DecryptPrivateKeyIntoBytesPassword(dst, pfxData, password []byte) (n int, err error) {
+	encodedPassword, err := bmpStringBytes(password)
+	if err != nil {
+		return 0, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decryptPrivateKeyInto(dst, pfxData, encodedPassword)
+}
+
+func (dec *Decoder) decryptPrivateKeyInto(dst, pfxData, encodedPassword []byte) (n int, err error) {
+	var spent usage
+	bags, encodedPassword, err := dec.getSafeContents(pfxData, encodedPassword, nil, &spent)
+	if err != nil {
+		return 0, err
+	}
+
+	var keyBag *safeBag
+	for i := range bags {
+		if bags[i].Id.Equal(oidPKCS8ShroundedKeyBag) || bags[i].Id.Equal(oidKeyBag) {
+			if keyBag != nil {
+				return 0, errors.New("pkcs12: expected exactly one key bag")
+			}
+			keyBag = &bags[i]
+		}
+	}
+	if keyBag == nil {
+		return 0, errors.New("pkcs12: private key missing")
+	}
+
+	var pkData []byte
+	if keyBag.Id.Equal(oidKeyBag) {
+		// Wiping it below also clears the key from the decrypted
+		// SafeContents.
+		pkData = keyBag.Value.Bytes
+	} else {
+		if pkData, err = dec.decryptPkcs8ShroudedKeyBag(keyBag.Value.Bytes, encodedPassword); err != nil {
+			return 0, err
+		}
+		if err := dec.limits.withDefaults().addDecrypted(&spent, len(pkData)); err != nil {
+			wipe(pkData)
+			return 0, err
+		}
+	}
+	defer wipe(pkData)
+
+	if len(dst) < len(pkData) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(dst, pkData), nil
+}
+
+// getSafeContents verifies the MAC of p12Data and returns the bags of all
+// its SafeContents, including those nested in safeContentsBags, so that keys
+// and certificates are paired across the whole file however it is grouped.
+// If used is not nil, the MAC and SafeContents encryption algorithms
+// encountered are appended to it.  If spent is not nil, the SafeContents
+// are added to it, so that the keys decrypted by the caller count towards
+// the same limits.
+func (dec *Decoder) getSafeContents(p12Data, password []byte, used *[]usedAlgorithm, spent *usage) (bags []safeBag, updatedPassword []byte, err error) {
+	contents, updatedPassword, err := dec.getAuthenticatedSafe(p12Data, password, used, spent)
+	if err != nil {
+		return nil, nil, err
+	}
+	limits := dec.limits.withDefaults()
+	for _, sc := range contents {
+		if sc.detached {
+			return nil, nil, ErrDetachedContent
+		}
+		if bags, err = appendNestedBags(bags, sc.bags, limits, 1); err != nil {
+			return nil, nil, err
+		}
+	}
+	return bags, updatedPassword, nil
+}
+
+// appendNestedBags appends bags to all, replacing every safeContentsBag by
+// the bags it holds.  depth is the nesting level of bags.
+func appendNestedBags(all, bags []safeBag, limits Limits, depth int) ([]safeBag, error) {
+	for _, bag := range bags {
+		if !bag.Id.Equal(oidSafeContentsBag) {
+			all = append(all, bag)
+			continue
+		}
+		if depth >= limits.MaxDepth {
+			return nil, &LimitError{"nesting depth", limits.MaxDepth}
+		}
+		nested, err := parseSafeContents(bag.Value.Bytes)
+		if err != nil {
+			return nil, errors.New("pkcs12: error decoding safeContentsBag: " + err.Error())
+		}
+		if all, err = appendNestedBags(all, nested, limits, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	if err := limits.checkBagCount(len(all)); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// decodedSafeContents is one SafeContents of an authenticated safe, after
+// decryption.
+type decodedSafeContents struct {
+	encrypted bool
+	bags      []safeBag
+	// undecrypted is set for an encrypted SafeContents which the password
+	// does not decrypt, if dec.partial is set.
+	undecrypted bool
+	// detached is set for an encrypted SafeContents without its encrypted
+	// content, see ErrDetachedContent.
+	detached bool
+	// der is the buffer holding the bags: the decrypted content of an
+	// encrypted SafeContents, or else the file.  It locates ParseErrors.
+	der []byte
+}
+
+// decrypted reports whether sc holds bags which were decrypted.
+func (sc *decodedSafeContents) decrypted() bool {
+	return sc.encrypted && !sc.undecrypted && !sc.detached
+}
+
+// getAuthenticatedSafe is like getSafeContents, but keeps the bags of each
+// SafeContents apart.
+func (dec *Decoder) getAuthenticatedSafe(p12Data, password []byte, used *[]usedAlgorithm, spent *usage) (contents []decodedSafeContents, updatedPassword []byte, err error) {
+	if p12Data, err = dec.unarmor(p12Data); err != nil {
+		return nil, nil, err
+	}
+	authenticatedSafe, decrypted, password, err := dec.verifyAuthenticatedSafe(p12Data, password, used)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limits := dec.limits.withDefaults()
+	if spent == nil {
+		spent = new(usage)
+	}
+	for i := range authenticatedSafe {
+		var ahead *decryption
+		if decrypted != nil {
+			ahead = decrypted[i]
+		}
+		sc, err := dec.decodeSafeContents(&authenticatedSafe[i], ahead, password, limits, used)
+		if err == ErrDetachedContent {
+			// Left to the callers, which may keep the other
+			// SafeContents.
+			sc = decodedSafeContents{encrypted: true, detached: true}
+		} else if err == ErrIncorrectPassword && dec.partial {
+			sc = decodedSafeContents{encrypted: true, undecrypted: true}
+		} else if err != nil {
+			return nil, nil, locateParseError(err, "authSafe["+strconv.Itoa(i)+"]", p12Data)
+		}
+		if err := limits.add(spent, &sc); err != nil {
+			return nil, nil, err
+		}
+		if sc.der == nil {
+			sc.der = p12Data
+		}
+		contents = append(contents, sc)
+	}
+
+	return contents, password, nil
+}
+
+// verifyAuthenticatedSafe parses p12Data, which must not be armored, and
+// verifies its MAC with
+// password, returning the content infos of its authenticated safe and the
+// password that verified the MAC.  decrypted holds the SafeContents
+// decrypted while the MAC was verified, see verifyMacAndDecrypt.
+func (dec *Decoder) verifyAuthenticatedSafe(p12Data, password []byte, used *[]usedAlgorithm) (authenticatedSafe []contentInfo, decrypted []*decryption, updatedPassword []byte, err error) {
+	limits := dec.limits.withDefaults()
+	pfx, err := parsePFX(p12Data, limits)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if pfx.Version != 3 && !dec.anyVersion {
+		return nil, nil, nil, NotImplementedError("can only decode v3 PFX PDU's")
+	}
+
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) == 0 {
+		return nil, nil, nil, errors.New("pkcs12: no MAC in data")
+	}
+
+	if err := dec.checkMacAlgorithm(pfx.MacData.Mac.Algorithm.Algorithm); err != nil {
+		return nil, nil, nil, err
+	}
+	if used != nil {
+		*used = append(*used, usedAlgorithm{UsageMAC, pfx.MacData.Mac.Algorithm})
+	}
+
+	// The MAC is verified over the authenticated safe as it was
+	// written, which is parsed once converted from BER.
+	authenticatedSafeDER, err := limits.berToDER(pfx.AuthSafe.Content.Bytes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	authenticatedSafe, authenticatedSafeErr := parseAuthenticatedSafe(authenticatedSafeDER)
+
+	decrypted, err = dec.verifyMacAndDecrypt(pfx, authenticatedSafe, password)
+	if err != nil {
+		if l := len(password); err == ErrIncorrectPassword && l >= 2 && password[l-2] == 0 && password[l-1] == 0 && (l == 2 || dec.unterminatedPasswords) {
+			// Some implementations leave out the NUL terminator of
+			// the BMPString: most for the empty password, which
+			// becomes an empty byte array, and, with
+			// AllowUnterminatedPasswords, some for any password.
+			// Try one more time without it; the SafeContents and
+			// keys are then decrypted with the password that
+			// verified the MAC.
+			password = password[:l-2]
+			if l == 2 {
+				password = nil
+			}
+			wipeDecrypted(decrypted)
+			decrypted = nil
+			err = verifyMac(dec.kdfContext(), &pfx.MacData, pfx.AuthSafe.Content.Bytes, password)
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if authenticatedSafeErr != nil {
+		return nil, nil, nil, locateParseError(newParseError("authSafe", pfx.AuthSafe.Content.FullBytes, authenticatedSafeErr), "", p12Data)
+	}
+
+	return authenticatedSafe, decrypted, password, nil
+}
+
+// decodeSafeContents decrypts ci, if it is encrypted, and decodes its bags.
+// decrypted is the result of decrypting ci ahead of time, or nil.
+func (dec *Decoder) decodeSafeContents(ci *contentInfo, decrypted *decryption, password []byte, limits Limits, used *[]usedAlgorithm) (sc decodedSafeContents, err error) {
+	var data []byte
+
+	switch {
+	case ci.ContentType.Equal(oidDataContentType):
+		// The content is decoded as a RawValue, which, unlike a
+		// []byte, refers to the file, so that errors can be located.
+		var octets asn1.RawValue
+		if err := unmarshal(ci.Content.Bytes, &octets); err != nil {
+			return sc, newParseError(".content", ci.Content.Bytes, err)
+		}
+		if octets.Class != asn1.ClassUniversal || octets.Tag != asn1.TagOctetString || octets.IsCompound {
+			return sc, newParseError(".content", ci.Content.Bytes, errors.New("expected an OCTET STRING"))
+		}
+		data = octets.Bytes
+	case ci.ContentType.Equal(oidEncryptedDataContentType):
+		info, err := dec.encryptedContentInfo(ci)
+		if err != nil {
+			return sc, err
+		}
+		if used != nil {
+			*used = append(*used, usedAlgorithm{UsageSafeContents, info.Algorithm()})
+		}
+		if decrypted != nil {
+			data, err = decrypted.data, decrypted.err
+		} else {
+			data, err = pbDecrypt(dec.kdfContext(), info, password)
+		}
+		if err == ErrDecryption && dec.passwordFunc != nil {
+			err = dec.retryPassword(PasswordHintSafeContents, func(password []byte) (err error) {
+				data, err = pbDecrypt(dec.kdfContext(), info, password)
+				return
+			})
+		}
+		if err != nil {
+			if err == ErrDecryption {
+				err = ErrIncorrectPassword
+			}
+			return sc, err
+		}
+	case ci.ContentType.Equal(oidEnvelopedDataContentType):
+		var info *encryptedContentInfo
+		if data, info, err = dec.openEnvelopedData(ci); err != nil {
+			return sc, err
+		}
+		if used != nil {
+			*used = append(*used, usedAlgorithm{UsageSafeContents, info.Algorithm()})
+		}
+	default:
+		return sc, NotImplementedError("only data, encryptedData and envelopedData content types are supported in authenticated safe")
+	}
+
+	if data, err = limits.berToDER(data); err != nil {
+		return sc, err
+	}
+	if err := limits.checkDER(data); err != nil {
+		return sc, err
+	}
+	sc.encrypted = !ci.ContentType.Equal(oidDataContentType)
+	if sc.bags, err = parseSafeContents(data); err != nil {
+		switch {
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			// Like a padding failure, a result which is not DER
+			// means that the password is incorrect.
+			return sc, ErrIncorrectPassword
+		case sc.encrypted:
+			return sc, locateParseError(newParseError(".safeContents", data, err), "", data)
+		}
+		return sc, newParseError(".safeContents", data, err)
+	}
+	if sc.encrypted {
+		sc.der = data
+	}
+	return sc, nil
+}
+
+// encryptedContentInfo returns the encrypted content of ci, whose content
+// type is encryptedData, if dec supports decrypting it.
+func (dec *Decoder) encryptedContentInfo(ci *contentInfo) (*encryptedContentInfo, error) {
+	var encryptedData encryptedData
+	if err := unmarshal(ci.Content.Bytes, &encryptedData); err != nil {
+		var lenient lenientEncryptedData
+		if unmarshal(ci.Content.Bytes, &lenient) != nil {
+			return nil, newParseError(".content", ci.Content.Bytes, err)
+		}
+		if !dec.anyVersion {
+			return nil, NotImplementedError("EncryptedData without a version is not supported")
+		}
+		encryptedData.Version, encryptedData.EncryptedContentInfo = lenient.Version, lenient.EncryptedContentInfo
+	}
+	if encryptedData.Version != 0 && !dec.anyVersion {
+		return nil, NotImplementedError("only version 0 of EncryptedData is supported")
+	}
+	if encryptedData.EncryptedContentInfo.EncryptedContent == nil {
+		content, ok := segmentedContent(ci.Content.Bytes)
+		if !ok {
+			return nil, ErrDetachedContent
+		}
+		encryptedData.EncryptedContentInfo.EncryptedContent = content
+	}
+	if err := dec.checkEncryptionAlgorithm(encryptedData.EncryptedContentInfo.Algorithm()); err != nil {
+		return nil, err
+	}
+	return &encryptedData.EncryptedContentInfo, nil
+}
+
+// parsePFX decodes the outer PFX PDU of p12Data, checking it and the
+// authenticated safe against limits.  The version is left to the caller to
+// check.  On return,
+// pfx.AuthSafe.Content.Bytes holds the DER encoding of the authenticated
+// safe; the MAC has not been verified.
+func parsePFX(p12Data []byte, limits Limits) (pfx *pfxPdu, err error) {
+	if err := limits.checkDER(p12Data); err != nil {
+		return nil, err
+	}
+
+	if pfx, err = parsePFXPdu(p12Data); err != nil {
+		return nil, &ParseError{Path: "pfx", Err: err}
+	}
+
+	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, NotImplementedError("only password-protected PFX is implemented")
+	}
+
+	// unmarshal the explicit bytes in the content for type 'data'
+	if err := parseAuthSafeContent(&pfx.AuthSafe.Content); err != nil {
+		return nil, locateParseError(newParseError("authSafe.content", pfx.AuthSafe.Content.Bytes, err), "", p12Data)
+	}
+	if err := limits.checkDER(pfx.AuthSafe.Content.Bytes); err != nil {
+		return nil, err
+	}
+
+	return pfx, nil
+}
@@ -0,0 +1,346 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"sync"
+	"testing"
+)
+
+func TestDecodeChainPairing(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	localKeyID := func(id byte) []Attribute {
+		return []Attribute{{Type: oidLocalKeyID, Values: [][]byte{{0x04, 0x01, id}}}}
+	}
+
+	for name, test := range map[string]struct {
+		caAttributes, leafAttributes, keyAttributes []Attribute
+	}{
+		// the key matches the second certificate by localKeyId
+		"localKeyId": {localKeyID(1), localKeyID(2), localKeyID(2)},
+		// no localKeyId at all, the leaf is found by its public key
+		"public key": {nil, nil, nil},
+		// the key has a localKeyId but the certificates do not
+		"key only": {nil, nil, localKeyID(1)},
+	} {
+		contents := []SafeContents{
+			{Encrypted: true, Entries: []Entry{
+				{BagType: CertBag, Certificate: caCert, Attributes: test.caAttributes},
+				{BagType: CertBag, Certificate: cert, Attributes: test.leafAttributes},
+			}},
+			{Entries: []Entry{
+				{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: test.keyAttributes},
+			}},
+		}
+		pfxData, err := Modern.EncodeContents(contents, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		_, decodedCert, caCerts, err := DecodeChain(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !decodedCert.Equal(cert) {
+			t.Errorf("%s: expected leaf %s, got %s", name, cert.Subject, decodedCert.Subject)
+		}
+		if len(caCerts) != 1 || !caCerts[0].Equal(caCert) {
+			t.Errorf("%s: unexpected CA certificates", name)
+		}
+	}
+}
+
+// nestBags returns a safeContentsBag entry holding bags.
+func nestBags(t *testing.T, bags ...safeBag) Entry {
+	der, err := asn1.Marshal(bags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Entry{BagType: SafeContentsBag, Value: der}
+}
+
+func TestDecodeChainNestedSafeContents(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	localKeyID := []pkcs12Attribute{{Id: oidLocalKeyID, Value: asn1.RawValue{Class: 0, Tag: 17, IsCompound: true, Bytes: []byte{0x04, 0x01, 0x07}}}}
+
+	leafBag, err := makeCertBag(cert.Raw, localKeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := nestBags(t, *leafBag)
+	innerBag, err := Modern.makeEntryBag(&inner, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is in the plain SafeContents, its certificate two levels
+	// down in the encrypted one, next to the CA.
+	contents := []SafeContents{
+		{Entries: []Entry{
+			{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{LocalKeyIDAttribute([]byte{7})}},
+		}},
+		{Encrypted: true, Entries: []Entry{
+			{BagType: CertBag, Certificate: caCert},
+			nestBags(t, *innerBag),
+		}},
+	}
+	pfxData, err := Modern.EncodeContents(contents, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, decodedCert, caCerts, err := DecodeChain(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decodedCert.Equal(cert) {
+		t.Errorf("expected leaf %s, got %s", cert.Subject, decodedCert.Subject)
+	}
+	if len(caCerts) != 1 || !caCerts[0].Equal(caCert) {
+		t.Error("unexpected CA certificates")
+	}
+	if err := MatchKeyToCert(privateKey, decodedCert); err != nil {
+		t.Error(err)
+	}
+
+	// Nesting beyond the depth limit is refused.
+	deep := nestBags(t, *leafBag)
+	for i := 0; i < 3; i++ {
+		bag, err := Modern.makeEntryBag(&deep, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deep = nestBags(t, *bag)
+	}
+	pfxData, err = Modern.EncodeContents([]SafeContents{{Entries: []Entry{deep}}}, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, _, err = DefaultDecoder.WithLimits(Limits{MaxDepth: 3}).DecodeChain(pfxData, DefaultPassword)
+	if _, ok := err.(*LimitError); !ok {
+		t.Errorf("expected a LimitError, got %v", err)
+	}
+}
+
+func TestDecodeChainMissingKey(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	_, otherCA := makeTestCertificate(t, "Other CA", true, nil, nil)
+	_, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	pfxData, err := Modern.EncodeTrustStore(map[string]*x509.Certificate{"ca": caCert, "other": otherCA, "leaf": cert}, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := DecodeChain(pfxData, DefaultPassword); err == nil {
+		t.Error("decoded a file without a private key")
+	}
+
+	dec := DefaultDecoder.AllowMissingKey()
+	privateKey, leaf, caCerts, err := dec.DecodeChain(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privateKey != nil || leaf == nil || len(caCerts) != 2 {
+		t.Errorf("expected three certificates and no key, got %v, %v and %d CA certificates", privateKey, leaf, len(caCerts))
+	}
+
+	pfxData, err = Modern.EncodeTrustStore(map[string]*x509.Certificate{"ca": caCert}, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privateKey, leaf, err := dec.Decode(pfxData, DefaultPassword); err != nil || privateKey != nil || !leaf.Equal(caCert) {
+		t.Errorf("expected the CA certificate alone, got %v, %v", leaf, err)
+	}
+
+	pfxData, err = Modern.EncodeContents(nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := DecodeChain(pfxData, DefaultPassword); err == nil {
+		t.Error("decoded an empty authenticated safe")
+	}
+	if privateKey, leaf, caCerts, err := dec.DecodeChain(pfxData, DefaultPassword); err != nil || privateKey != nil || leaf != nil || caCerts != nil {
+		t.Errorf("expected an empty result, got %v", err)
+	}
+}
+
+// TestDecoderConcurrentUse shares one Decoder between goroutines which
+// decode with it and derive new Decoders from it; run it with -race.
+func TestDecoderConcurrentUse(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.WithIterations(1).Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := DefaultDecoder.WithLimits(Limits{MaxBags: 4})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			derived := dec.AllowMissingKey().WithParallelism(2)
+			for _, d := range []*Decoder{dec, derived} {
+				if _, _, err := d.Decode(pfxData, DefaultPassword); err != nil {
+					t.Error(err)
+				}
+				if _, err := d.DecodeContentsPartial(pfxData, DefaultPassword); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if dec.missingKey || dec.parallelism != 0 || dec.partial {
+		t.Error("the shared Decoder was modified")
+	}
+}
+
+func BenchmarkDecodeChain(b *testing.B) {
+	key, cert, caCerts := makeBenchmarkChain(b)
+	for _, bench := range benchmarkEncoders {
+		pfxData, err := bench.enc.Encode(key, cert, caCerts, "password")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, _, _, err := DecodeChain(pfxData, "password"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowAnyVersion(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pfx pfxPdu
+	if err := unmarshal(pfxData, &pfx); err != nil {
+		t.Fatal(err)
+	}
+	// A later version might append fields, which are ignored.
+	future := struct {
+		Version   int
+		AuthSafe  contentInfo
+		MacData   macData
+		Extension int
+	}{4, pfx.AuthSafe, pfx.MacData, 1}
+	if pfxData, err = asn1.Marshal(future); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Decode(pfxData, DefaultPassword); err == nil {
+		t.Error("expected version 4 to be refused by default")
+	}
+	dec := DefaultDecoder.AllowAnyVersion()
+	if _, certificate, err := dec.Decode(pfxData, DefaultPassword); err != nil {
+		t.Error(err)
+	} else if !certificate.Equal(cert) {
+		t.Error("unexpected certificate")
+	}
+
+	if probe, err := Probe(pfxData); err != nil || probe.Version != 4 {
+		t.Errorf("unexpected probe %+v, %v", probe, err)
+	}
+	if report := Assess(pfxData); len(report.Findings) != 1 || report.Findings[0].Severity != SeverityWarning {
+		t.Errorf("expected a version warning, got %+v", report.Findings)
+	}
+	var warned bool
+	for _, f := range dec.Validate(pfxData, DefaultPassword) {
+		if f.Severity == SeverityCritical {
+			t.Errorf("unexpected finding %+v", f)
+		}
+		warned = warned || f.Message == "file has version 4 instead of 3"
+	}
+	if !warned {
+		t.Error("expected Validate to warn about the version")
+	}
+}
+
+func TestAllowAnyVersionEncryptedData(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := bmpString(DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite replaces the EncryptedData of the certificates by the
+	// encoding of encrypted and computes the MAC anew.
+	rewrite := func(encrypted func(info encryptedContentInfo) interface{}) []byte {
+		pfx, err := parsePFX(pfxData, DefaultLimits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var authenticatedSafe []contentInfo
+		if err := unmarshal(pfx.AuthSafe.Content.Bytes, &authenticatedSafe); err != nil {
+			t.Fatal(err)
+		}
+		var data encryptedData
+		if err := unmarshal(authenticatedSafe[0].Content.Bytes, &data); err != nil {
+			t.Fatal(err)
+		}
+		content, err := asn1.Marshal(encrypted(data.EncryptedContentInfo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticatedSafe[0].Content = asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: content}
+		authenticatedSafeBytes, err := asn1.Marshal(authenticatedSafe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := computeMac(context.Background(), &pfx.MacData, authenticatedSafeBytes, password); err != nil {
+			t.Fatal(err)
+		}
+		pfx.AuthSafe.Content = asn1.RawValue{Class: 2, Tag: 0, IsCompound: true}
+		if pfx.AuthSafe.Content.Bytes, err = asn1.Marshal(authenticatedSafeBytes); err != nil {
+			t.Fatal(err)
+		}
+		rewritten, err := asn1.Marshal(*pfx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rewritten
+	}
+
+	for name, rewritten := range map[string][]byte{
+		"version 2": rewrite(func(info encryptedContentInfo) interface{} {
+			return encryptedData{Version: 2, EncryptedContentInfo: info}
+		}),
+		"no version": rewrite(func(info encryptedContentInfo) interface{} {
+			return struct{ EncryptedContentInfo encryptedContentInfo }{info}
+		}),
+	} {
+		if _, _, err := Decode(rewritten, DefaultPassword); err == nil {
+			t.Errorf("%s: expected to be refused by default", name)
+		} else if _, ok := err.(NotImplementedError); !ok {
+			t.Errorf("%s: expected a NotImplementedError, got %v", name, err)
+		}
+		if _, certificate, err := DefaultDecoder.AllowAnyVersion().Decode(rewritten, DefaultPassword); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !certificate.Equal(cert) {
+			t.Errorf("%s: unexpected certificate", name)
+		}
+		if probe, err := Probe(rewritten); err != nil || probe.SafeContents[0].Encryption == nil {
+			t.Errorf("%s: unexpected probe %+v, %v", name, probe, err)
+		}
+	}
+}
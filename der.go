@@ -0,0 +1,121 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// A CertSource provides a certificate to encode, in DER.  RawCertificate
+// and ParsedCertificate adapt the two representations the application may
+// hold: the DER as it is, and an *x509.Certificate, converted without
+// copying by (*ParsedCertificate)(cert).
+//
+// CertSources are taken by EncodeSource and EncodeTrustStoreSource only,
+// the counterparts of Encode and EncodeTrustStore.  The other functions
+// taking certificates, such as EncodeKeyReference, EncodeContext,
+// KeyStore.SetKeyEntry and EncodeP7B, take *x509.Certificate.
+type CertSource interface {
+	DER() []byte
+}
+
+// A RawCertificate is a DER-encoded certificate, stored as it is.
+type RawCertificate []byte
+
+// DER returns c.
+func (c RawCertificate) DER() []byte {
+	return c
+}
+
+// A ParsedCertificate is an x509.Certificate used as a CertSource.
+type ParsedCertificate x509.Certificate
+
+// DER returns the Raw field of c.
+func (c *ParsedCertificate) DER() []byte {
+	return c.Raw
+}
+
+// CertSources returns certs as CertSources.
+func CertSources(certs []*x509.Certificate) []CertSource {
+	sources := make([]CertSource, len(certs))
+	for i, cert := range certs {
+		sources[i] = (*ParsedCertificate)(cert)
+	}
+	return sources
+}
+
+// EncodeDER is like Encode, but takes the certificates in DER, as they are
+// kept by a database, and stores them as they are without parsing them, so
+// that no parse and no round trip through x509.Certificate is needed.  The
+// certificates are only parsed if WithGeneratedAliases is set, which needs
+// their names.
+func (enc *Encoder) EncodeDER(privateKey interface{}, certificate []byte, caCerts [][]byte, password string) (pfxData []byte, err error) {
+	sources := make([]CertSource, len(caCerts))
+	for i, der := range caCerts {
+		sources[i] = RawCertificate(der)
+	}
+	return enc.EncodeSource(privateKey, RawCertificate(certificate), sources, password)
+}
+
+// EncodeSource is like Encode, but takes the certificates as CertSources,
+// so that parsed and DER-encoded ones can be mixed.  Those in DER are
+// handled as by EncodeDER.
+func (enc *Encoder) EncodeSource(privateKey interface{}, certificate CertSource, caCerts []CertSource, password string) (pfxData []byte, err error) {
+	leaf, err := enc.sourceCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+	cas := make([]*x509.Certificate, len(caCerts))
+	for i, source := range caCerts {
+		if cas[i], err = enc.sourceCertificate(source); err != nil {
+			return nil, err
+		}
+	}
+	return enc.Encode(privateKey, leaf, cas, password)
+}
+
+// EncodeTrustStoreDER is like EncodeTrustStore, but takes the certificates
+// in DER, as EncodeDER does.
+func (enc *Encoder) EncodeTrustStoreDER(certs map[string][]byte, password string) (pfxData []byte, err error) {
+	sources := make(map[string]CertSource, len(certs))
+	for alias, der := range certs {
+		sources[alias] = RawCertificate(der)
+	}
+	return enc.EncodeTrustStoreSource(sources, password)
+}
+
+// EncodeTrustStoreSource is like EncodeTrustStore, but takes the
+// certificates as CertSources, as EncodeSource does.
+func (enc *Encoder) EncodeTrustStoreSource(certs map[string]CertSource, password string) (pfxData []byte, err error) {
+	parsed := make(map[string]*x509.Certificate, len(certs))
+	for alias, source := range certs {
+		if parsed[alias], err = enc.sourceCertificate(source); err != nil {
+			return nil, err
+		}
+	}
+	return enc.EncodeTrustStore(parsed, password)
+}
+
+// sourceCertificate returns the certificate provided by source: the
+// x509.Certificate of a ParsedCertificate, the parsed DER if
+// WithGeneratedAliases is set, or else a certificate with only its Raw
+// field set, which is all the cert bags are made from.
+func (enc *Encoder) sourceCertificate(source CertSource) (*x509.Certificate, error) {
+	if parsed, ok := source.(*ParsedCertificate); ok && parsed != nil {
+		return (*x509.Certificate)(parsed), nil
+	} else if ok || source == nil {
+		return nil, errors.New("pkcs12: certificate missing")
+	}
+	der := source.DER()
+	if len(der) == 0 {
+		return nil, errors.New("pkcs12: empty certificate")
+	}
+	if enc.generateAliases {
+		return x509.ParseCertificate(der)
+	}
+	return &x509.Certificate{Raw: der}, nil
+}
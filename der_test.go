@@ -0,0 +1,77 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+)
+
+func TestEncodeDER(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	for _, enc := range []*Encoder{Modern, Modern.WithGeneratedAliases(true)} {
+		pfxData, err := enc.EncodeDER(key, cert.Raw, [][]byte{caCert.Raw}, DefaultPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := enc.Encode(key, cert, []*x509.Certificate{caCert}, DefaultPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := DecodeContents(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedContents, err := DecodeContents(expected, DefaultPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, entry := range contents[0].Entries {
+			if expectedEntry := expectedContents[0].Entries[i]; !bytes.Equal(entry.RawCertDER, expectedEntry.RawCertDER) || entry.FriendlyName() != expectedEntry.FriendlyName() || !bytes.Equal(entry.LocalKeyID(), expectedEntry.LocalKeyID()) {
+				t.Errorf("certificate %d differs from the one written by Encode", i)
+			}
+		}
+		if _, _, _, err := DecodeChain(pfxData, DefaultPassword); err != nil {
+			t.Error(err)
+		}
+
+		pfxData, err = enc.EncodeTrustStoreDER(map[string][]byte{"ca": caCert.Raw}, DefaultPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs, err := DecodeTrustStore(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != 1 || !certs["ca"].Equal(caCert) {
+			t.Errorf("unexpected trust store %v", certs)
+		}
+	}
+
+	pfxData, err := Modern.EncodeSource(key, (*ParsedCertificate)(cert), []CertSource{RawCertificate(caCert.Raw)}, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, decodedCert, caCerts, err := DecodeChain(pfxData, DefaultPassword); err != nil || !decodedCert.Equal(cert) || len(caCerts) != 1 || !caCerts[0].Equal(caCert) {
+		t.Errorf("unexpected chain from mixed CertSources: %v", err)
+	}
+	if _, err := Modern.EncodeTrustStoreSource(map[string]CertSource{"ca": CertSources([]*x509.Certificate{caCert})[0]}, DefaultPassword); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := Modern.EncodeDER(key, nil, nil, DefaultPassword); err == nil {
+		t.Error("encoded an empty certificate")
+	}
+	if _, err := Modern.WithGeneratedAliases(true).EncodeDER(key, badCert, nil, DefaultPassword); err == nil {
+		t.Error("generated an alias for a certificate that does not parse")
+	}
+	if _, err := Modern.EncodeSource(key, (*ParsedCertificate)(nil), nil, DefaultPassword); err == nil {
+		t.Error("encoded a nil certificate")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// WithDeterministicEncoding creates a new Encoder identical to enc except
+// that the salts and IVs it needs are derived from seed and from the data
+// they protect, with HKDF-SHA-256, instead of being read from the random
+// number generator.  Encoding the same keys and certificates with the same
+// password then produces byte-identical output, as content-addressed
+// artifact stores and reproducible builds need.
+//
+// The password is not used for the derivation, so that the salts do not
+// allow checking a guess of the password more cheaply than the KDF does.
+// Anyone who knows seed can however tell whether two files hold the same
+// content, and salts repeat whenever the content does, so seed should be
+// kept secret where that matters, and the option is not meant for files
+// produced on demand for many parties.
+func (enc Encoder) WithDeterministicEncoding(seed []byte) *Encoder {
+	enc.deterministic = true
+	enc.seed = append([]byte(nil), seed...)
+	return &enc
+}
+
+// Labels distinguishing the values derived for deterministic encoding.
+const (
+	saltLabel    = "salt"
+	ivLabel      = "IV"
+	macSaltLabel = "MAC salt"
+)
+
+// saltFor fills out with bytes for the purpose named by label, protecting
+// content: random ones, or, for deterministic encoding, ones derived from
+// the seed and content.
+func (enc *Encoder) saltFor(out []byte, label string, content []byte) error {
+	if !enc.deterministic {
+		_, err := enc.rand.Read(out)
+		return err
+	}
+
+	// HKDF (RFC 5869) with the seed as salt, content as input keying
+	// material and label as info.
+	extract := hmac.New(sha256.New, enc.seed)
+	extract.Write(content)
+	prk := extract.Sum(nil)
+	defer wipe(prk)
+
+	expand := hmac.New(sha256.New, prk)
+	var t []byte
+	for i := byte(1); len(out) > 0; i++ {
+		expand.Reset()
+		expand.Write(t)
+		expand.Write([]byte(label))
+		expand.Write([]byte{i})
+		t = expand.Sum(t[:0])
+		out = out[copy(out, t):]
+	}
+	return nil
+}
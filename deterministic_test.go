@@ -0,0 +1,79 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"testing"
+)
+
+func TestWithDeterministicEncoding(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	_, otherCA := makeTestCertificate(t, "Other CA", true, nil, nil)
+
+	for name, enc := range map[string]*Encoder{
+		"Modern":    Modern,
+		"LegacyDES": LegacyDES.AllowWeakAlgorithms(),
+		"LegacyRC2": LegacyRC2.AllowWeakAlgorithms(),
+	} {
+		deterministic := enc.WithDeterministicEncoding([]byte("seed"))
+		encode := func(enc *Encoder, password string) []byte {
+			pfxData, err := enc.Encode(key, cert, []*x509.Certificate{caCert}, password)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return pfxData
+		}
+
+		first := encode(deterministic, "password")
+		if !bytes.Equal(first, encode(deterministic, "password")) {
+			t.Errorf("%s: deterministic encoding differs between calls", name)
+		}
+		if bytes.Equal(first, encode(enc.WithDeterministicEncoding([]byte("other seed")), "password")) {
+			t.Errorf("%s: seed not used", name)
+		}
+		if bytes.Equal(first, encode(enc, "password")) {
+			t.Errorf("%s: default encoding is deterministic", name)
+		}
+		if _, _, _, err := DecodeChain(first, "password"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		certs := map[string]*x509.Certificate{"ca": caCert, "other": otherCA, "leaf": cert}
+		trustStore, err := deterministic.EncodeTrustStore(certs, "password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for i := 0; i < 5; i++ {
+			again, err := deterministic.EncodeTrustStore(certs, "password")
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(trustStore, again) {
+				t.Fatalf("%s: deterministic trust store differs between calls", name)
+			}
+		}
+	}
+}
+
+// TestSaltForHKDF checks the derivation against test case 1 of RFC 5869.
+func TestSaltForHKDF(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c")
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	expected, _ := hex.DecodeString("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+
+	okm := make([]byte, len(expected))
+	if err := Modern.WithDeterministicEncoding(seed).saltFor(okm, string(info), ikm); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(okm, expected) {
+		t.Errorf("got %x, wanted %x", okm, expected)
+	}
+}
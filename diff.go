@@ -0,0 +1,214 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"strings"
+)
+
+// A DiffReport lists the differences Diff found between two PKCS#12 files,
+// such as the old and the new version of a trust store under review.
+type DiffReport struct {
+	// Added lists the entries of b which are not in a, in the order of b.
+	Added []DiffEntry
+	// Removed lists the entries of a which are not in b, in the order of
+	// a.
+	Removed []DiffEntry
+	// Changed lists the entries found in both files which differ, in the
+	// order of a.
+	Changed []DiffEntry
+}
+
+// Empty reports whether r lists no differences.
+func (r *DiffReport) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Changed) == 0
+}
+
+// A DiffEntry is a keystore entry listed by a DiffReport: a private key
+// together with the certificate carrying its localKeyId, or a certificate
+// on its own.
+type DiffEntry struct {
+	// Alias is the friendly name of the entry, as found in b unless the
+	// entry was removed.
+	Alias string
+	// SPKIHash is the SHA-256 hash of the SubjectPublicKeyInfo of the
+	// certificate of the entry, or of the public key of a private key
+	// without a certificate, as found in b unless the entry was removed.
+	SPKIHash []byte
+	// A and B are the entry in a and in b, nil if it is missing from the
+	// file.  For a private key they are the key bag, whose certificate is
+	// in CertA and CertB.
+	A, B *Entry
+	// CertA and CertB are the certificate of the entry in a and in b, or
+	// nil.
+	CertA, CertB *x509.Certificate
+}
+
+// Diff decodes the PKCS#12 files a and b, protected by passA and passB,
+// and reports the entries added, removed or changed from a to b.  Entries
+// are matched by alias, ignoring case as the java keytool does, and the
+// remaining ones by the SPKI hash of their certificate, so that a renamed
+// certificate is reported as changed rather than as removed and added.  An
+// entry has changed if its alias, certificate, private key or attributes
+// differ; the localKeyId linking a key to its certificate is not compared.
+// Bags other than key and certificate bags are not compared.
+func (dec *Decoder) Diff(a, b []byte, passA, passB string) (*DiffReport, error) {
+	contentsA, err := dec.DecodeContents(a, passA)
+	if err != nil {
+		return nil, err
+	}
+	contentsB, err := dec.DecodeContents(b, passB)
+	if err != nil {
+		return nil, err
+	}
+	itemsA, itemsB := diffItems(contentsA), diffItems(contentsB)
+
+	matches := make([]*diffItem, len(itemsA))
+	matched := make([]bool, len(itemsB))
+	match := func(same func(x, y *diffItem) bool) {
+		for i, x := range itemsA {
+			if matches[i] != nil {
+				continue
+			}
+			for j, y := range itemsB {
+				if !matched[j] && same(x, y) {
+					matches[i], matched[j] = y, true
+					break
+				}
+			}
+		}
+	}
+	match(func(x, y *diffItem) bool {
+		return x.alias != "" && strings.EqualFold(x.alias, y.alias)
+	})
+	match(func(x, y *diffItem) bool {
+		return x.spki != nil && bytes.Equal(x.spki, y.spki)
+	})
+
+	report := new(DiffReport)
+	for i, x := range itemsA {
+		if y := matches[i]; y == nil {
+			report.Removed = append(report.Removed, DiffEntry{Alias: x.alias, SPKIHash: x.spki, A: x.entry, CertA: x.cert()})
+		} else if !sameDiffItems(x, y) {
+			report.Changed = append(report.Changed, DiffEntry{Alias: y.alias, SPKIHash: y.spki, A: x.entry, B: y.entry, CertA: x.cert(), CertB: y.cert()})
+		}
+	}
+	for j, y := range itemsB {
+		if !matched[j] {
+			report.Added = append(report.Added, DiffEntry{Alias: y.alias, SPKIHash: y.spki, B: y.entry, CertB: y.cert()})
+		}
+	}
+	return report, nil
+}
+
+// Diff is equivalent to DefaultDecoder.Diff.
+func Diff(a, b []byte, passA, passB string) (*DiffReport, error) {
+	return DefaultDecoder.Diff(a, b, passA, passB)
+}
+
+// A diffItem is a keystore entry compared by Diff.
+type diffItem struct {
+	alias string
+	spki  []byte
+	// entry is the key bag of a private key, or the certificate bag of a
+	// certificate on its own.
+	entry *Entry
+	// certEntry is the certificate bag of a private key, or nil.
+	certEntry *Entry
+}
+
+// cert returns the certificate of item, or nil.
+func (item *diffItem) cert() *x509.Certificate {
+	if item.certEntry != nil {
+		return item.certEntry.Certificate
+	}
+	return item.entry.Certificate
+}
+
+// diffItems returns the keystore entries of contents, in order.
+func diffItems(contents []SafeContents) []*diffItem {
+	var entries []Entry
+	for i := range contents {
+		entries = appendEntries(entries, contents[i].Entries)
+	}
+
+	var keyIDs [][]byte
+	for i := range entries {
+		if entries[i].PrivateKey != nil {
+			keyIDs = append(keyIDs, entries[i].LocalKeyID())
+		}
+	}
+
+	var items []*diffItem
+	for i := range entries {
+		entry := &entries[i]
+		switch {
+		case entry.PrivateKey != nil:
+			item := &diffItem{alias: entry.FriendlyName(), entry: entry}
+			for j := range entries {
+				if entries[j].Certificate != nil && hasID([][]byte{entry.LocalKeyID()}, entries[j].LocalKeyID()) {
+					item.certEntry = &entries[j]
+					break
+				}
+			}
+			if item.certEntry != nil {
+				if item.alias == "" {
+					item.alias = item.certEntry.FriendlyName()
+				}
+				item.spki = spkiHash(item.certEntry.Certificate.RawSubjectPublicKeyInfo)
+			} else if key, ok := entry.PrivateKey.(interface{ Public() crypto.PublicKey }); ok {
+				if der, err := x509.MarshalPKIXPublicKey(key.Public()); err == nil {
+					item.spki = spkiHash(der)
+				}
+			}
+			items = append(items, item)
+		case entry.Certificate != nil && !hasID(keyIDs, entry.LocalKeyID()):
+			items = append(items, &diffItem{alias: entry.FriendlyName(), spki: spkiHash(entry.Certificate.RawSubjectPublicKeyInfo), entry: entry})
+		}
+	}
+	return items
+}
+
+// spkiHash returns the SHA-256 hash of spki, or nil if spki is empty, as
+// for a certificate encoded from DER without parsing it.
+func spkiHash(spki []byte) []byte {
+	if len(spki) == 0 {
+		return nil
+	}
+	sum := sha256.Sum256(spki)
+	return sum[:]
+}
+
+// sameDiffItems reports whether x and y are the same keystore entry.
+func sameDiffItems(x, y *diffItem) bool {
+	if x.alias != y.alias || (x.certEntry == nil) != (y.certEntry == nil) {
+		return false
+	}
+	if !sameDiffEntries(x.entry, y.entry) {
+		return false
+	}
+	return x.certEntry == nil || sameDiffEntries(x.certEntry, y.certEntry)
+}
+
+// sameDiffEntries is like equalEntries, but ignores the localKeyId
+// attribute.
+func sameDiffEntries(a, b *Entry) bool {
+	x, y := withoutLocalKeyID(a), withoutLocalKeyID(b)
+	return equalEntries(&x, &y)
+}
+
+// withoutLocalKeyID returns a copy of entry without its localKeyId
+// attribute.
+func withoutLocalKeyID(entry *Entry) Entry {
+	e := *entry
+	e.Attributes = append([]Attribute(nil), entry.Attributes...)
+	e.RemoveAttribute(oidLocalKeyID)
+	return e
+}
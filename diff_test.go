@@ -0,0 +1,72 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	_, kept := makeTestCertificate(t, "kept", true, nil, nil)
+	_, renamed := makeTestCertificate(t, "renamed", true, nil, nil)
+	_, removed := makeTestCertificate(t, "removed", true, nil, nil)
+	_, added := makeTestCertificate(t, "added", true, nil, nil)
+
+	a, err := Modern.EncodeTrustStore(map[string]*x509.Certificate{"kept": kept, "old name": renamed, "removed": removed}, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The keytool compares aliases ignoring case.
+	b, err := Modern.EncodeTrustStore(map[string]*x509.Certificate{"KEPT": kept, "new name": renamed, "added": added}, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Diff(a, b, DefaultPassword, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added) != 1 || report.Added[0].Alias != "added" || !report.Added[0].CertB.Equal(added) || report.Added[0].A != nil {
+		t.Errorf("unexpected added entries %+v", report.Added)
+	}
+	if len(report.Removed) != 1 || report.Removed[0].Alias != "removed" || !report.Removed[0].CertA.Equal(removed) || report.Removed[0].B != nil {
+		t.Errorf("unexpected removed entries %+v", report.Removed)
+	}
+	if len(report.Changed) != 2 {
+		t.Fatalf("expected 2 changed entries, got %+v", report.Changed)
+	}
+	for _, change := range report.Changed {
+		if change.Alias != "KEPT" && (change.Alias != "new name" || change.A.FriendlyName() != "old name" || !change.CertA.Equal(renamed)) {
+			t.Errorf("unexpected changed entry %+v", change)
+		}
+	}
+
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	otherKey, otherCert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	identity, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err := Diff(identity, identity, DefaultPassword, DefaultPassword); err != nil || !report.Empty() {
+		t.Errorf("expected no differences between a file and itself, got %+v, %v", report, err)
+	}
+	otherIdentity, err := Modern.Encode(otherKey, otherCert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = Diff(identity, otherIdentity, DefaultPassword, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added) != 1 || len(report.Removed) != 1 || len(report.Changed) != 0 || !report.Removed[0].CertA.Equal(cert) || !report.Added[0].CertB.Equal(otherCert) {
+		t.Errorf("expected one removed and one added identity, got %+v", report)
+	}
+
+	if _, err := Diff(a, b, DefaultPassword, DefaultPassword); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+}
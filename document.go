@@ -0,0 +1,201 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"strings"
+)
+
+// A PFX is an editable PKCS#12 file: it is read with Unmarshal, changed by
+// adding and removing entries or by setting a new password, and written
+// with Marshal.  Everything DecodeContents preserves, the grouping of the
+// bags, their order, their attributes and bags of unknown types, survives
+// the round trip.
+//
+// To use a PFX as an in-memory keystore, like a java.security.KeyStore, wrap
+// it in a KeyStore.
+type PFX struct {
+	// Contents are the SafeContents of the file, in order.
+	Contents []SafeContents
+	// Decoder is used by Unmarshal; if nil, DefaultDecoder is used.
+	Decoder *Decoder
+	// Encoder is used by Marshal; if nil, Modern is used.
+	Encoder *Encoder
+
+	password string
+}
+
+// NewPFX returns an empty PFX protected by password.
+func NewPFX(password string) *PFX {
+	return &PFX{password: password}
+}
+
+// Unmarshal replaces the contents of p with those of pfxData, which is
+// decoded with the password of p.
+func (p *PFX) Unmarshal(pfxData []byte) error {
+	dec := p.Decoder
+	if dec == nil {
+		dec = DefaultDecoder
+	}
+	contents, err := dec.DecodeContents(pfxData, p.password)
+	if err != nil {
+		return err
+	}
+	p.Contents = contents
+	return nil
+}
+
+// Marshal encodes p, see Encoder.EncodeContents.  Keys and encrypted
+// SafeContents are encrypted anew, with fresh salts, every time.
+func (p *PFX) Marshal() (pfxData []byte, err error) {
+	enc := p.Encoder
+	if enc == nil {
+		enc = Modern
+	}
+	return enc.EncodeContents(p.Contents, p.password)
+}
+
+// SetPassword changes the password with which Marshal protects p.
+func (p *PFX) SetPassword(password string) {
+	p.password = password
+}
+
+// AddEntry adds entry to p where Encode would put it: private keys, which
+// are protected by their own encryption, in the first unencrypted
+// SafeContents, and other entries in the first encrypted one.  A
+// SafeContents is appended if there is none of the kind needed.
+func (p *PFX) AddEntry(entry Entry) {
+	encrypted := entry.BagType != PKCS8ShroudedKeyBag
+	for i := range p.Contents {
+		if p.Contents[i].Encrypted == encrypted {
+			p.Contents[i].Entries = append(p.Contents[i].Entries, entry)
+			return
+		}
+	}
+	p.Contents = append(p.Contents, SafeContents{Encrypted: encrypted, Entries: []Entry{entry}})
+}
+
+// RemoveEntry removes every entry of p for which match returns true, and
+// returns how many were removed.  Entries nested in safeContentsBags are
+// not examined.  SafeContents left empty are kept.
+func (p *PFX) RemoveEntry(match func(*Entry) bool) int {
+	var removed int
+	for i := range p.Contents {
+		entries := p.Contents[i].Entries[:0]
+		for j := range p.Contents[i].Entries {
+			if match(&p.Contents[i].Entries[j]) {
+				removed++
+				continue
+			}
+			entries = append(entries, p.Contents[i].Entries[j])
+		}
+		p.Contents[i].Entries = entries
+	}
+	return removed
+}
+
+// Aliases returns the aliases of the entries of p, as the aliases method
+// of a java.security.KeyStore does: the friendlyName of every private key,
+// and of every certificate which does not belong to a key by its
+// localKeyId, in the order they appear.  Aliases are compared ignoring
+// case, like the java keytool compares them, and each is returned once.
+// Entries without a friendlyName and entries nested in safeContentsBags
+// have no alias.
+func (p *PFX) Aliases() []string {
+	keyIDs := p.keyIDs()
+	seen := map[string]bool{}
+	var aliases []string
+	for _, entry := range p.aliasedEntries() {
+		if entry.Certificate != nil && hasID(keyIDs, entry.LocalKeyID()) {
+			continue
+		}
+		alias := entry.FriendlyName()
+		if !seen[strings.ToLower(alias)] {
+			seen[strings.ToLower(alias)] = true
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// Key returns the private key stored under alias, ignoring case, like
+// KeyStore.getKey, or nil if alias does not name a private key.
+func (p *PFX) Key(alias string) crypto.PrivateKey {
+	if key := p.keyEntry(alias); key != nil {
+		return key.PrivateKey
+	}
+	return nil
+}
+
+// Certificate returns the certificate stored under alias, ignoring case,
+// like KeyStore.getCertificate: the certificate of the private key, found
+// by its localKeyId, if alias names a key, or else the certificate with
+// that friendlyName.  It returns nil if there is neither.
+func (p *PFX) Certificate(alias string) *x509.Certificate {
+	if key := p.keyEntry(alias); key != nil && len(key.LocalKeyID()) != 0 {
+		for _, entry := range p.allEntries() {
+			if entry.Certificate != nil && bytes.Equal(entry.LocalKeyID(), key.LocalKeyID()) {
+				return entry.Certificate
+			}
+		}
+		return nil
+	}
+
+	keyIDs := p.keyIDs()
+	for _, entry := range p.aliasedEntries() {
+		if entry.Certificate != nil && !hasID(keyIDs, entry.LocalKeyID()) && strings.EqualFold(entry.FriendlyName(), alias) {
+			return entry.Certificate
+		}
+	}
+	return nil
+}
+
+// allEntries returns the entries of p, excluding nested entries.
+func (p *PFX) allEntries() []*Entry {
+	var entries []*Entry
+	for i := range p.Contents {
+		for j := range p.Contents[i].Entries {
+			entries = append(entries, &p.Contents[i].Entries[j])
+		}
+	}
+	return entries
+}
+
+// aliasedEntries returns the private keys and certificates of p which
+// have a friendlyName, excluding nested entries.
+func (p *PFX) aliasedEntries() []*Entry {
+	var entries []*Entry
+	for _, entry := range p.allEntries() {
+		if (entry.PrivateKey != nil || entry.Certificate != nil) && entry.FriendlyName() != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// keyIDs returns the localKeyIds of the private keys of p.
+func (p *PFX) keyIDs() [][]byte {
+	var ids [][]byte
+	for _, entry := range p.allEntries() {
+		if entry.PrivateKey != nil {
+			ids = append(ids, entry.LocalKeyID())
+		}
+	}
+	return ids
+}
+
+// keyEntry returns the first private key of p named alias, ignoring case.
+func (p *PFX) keyEntry(alias string) *Entry {
+	for _, entry := range p.aliasedEntries() {
+		if entry.PrivateKey != nil && strings.EqualFold(entry.FriendlyName(), alias) {
+			return entry
+		}
+	}
+	return nil
+}
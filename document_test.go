@@ -0,0 +1,118 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"testing"
+)
+
+func TestPFX(t *testing.T) {
+	pfxData, err := os.ReadFile("testdata/openssl-legacy-chain.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPFX("password")
+	if err := p.Unmarshal(pfxData); err != nil {
+		t.Fatal(err)
+	}
+	_, extra := makeTestCertificate(t, "Extra CA", true, nil, nil)
+	friendlyName, err := FriendlyNameAttribute("extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.AddEntry(Entry{BagType: CertBag, Certificate: extra, Attributes: []Attribute{friendlyName}})
+	p.SetPassword("new password")
+
+	edited, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeContents(edited, "password"); err != ErrIncorrectPassword {
+		t.Errorf("expected the old password to fail, got %v", err)
+	}
+
+	reread := NewPFX("new password")
+	if err := reread.Unmarshal(edited); err != nil {
+		t.Fatal(err)
+	}
+	if n := reread.RemoveEntry(func(e *Entry) bool { return e.FriendlyName() == "extra" }); n != 1 {
+		t.Errorf("expected to remove one entry, removed %d", n)
+	}
+	if !EqualContents(original, reread.Contents) {
+		t.Error("contents changed by adding and removing an entry")
+	}
+}
+
+func TestPFXAddEntry(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	p := NewPFX("password")
+	p.AddEntry(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{LocalKeyIDAttribute([]byte{1})}})
+	p.AddEntry(Entry{BagType: CertBag, Certificate: cert, Attributes: []Attribute{LocalKeyIDAttribute([]byte{1})}})
+	if len(p.Contents) != 2 || p.Contents[0].Encrypted || !p.Contents[1].Encrypted {
+		t.Fatalf("unexpected layout %+v", p.Contents)
+	}
+
+	p.Encoder = LegacyDES.AllowWeakAlgorithms()
+	pfxData, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, certificate, caCerts, err := DecodeChain(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !certificate.Equal(cert) || len(caCerts) != 0 {
+		t.Error("unexpected certificates")
+	}
+	if err := MatchKeyToCert(privateKey, certificate); err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(p.Contents[1].Entries[0].LocalKeyID(), []byte{1}) {
+		t.Error("attributes lost")
+	}
+}
+
+func TestPFXAliases(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	pfxData, err := Modern.WithGeneratedAliases(true).Encode(key, cert, []*x509.Certificate{caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPFX("password")
+	if err := p.Unmarshal(pfxData); err != nil {
+		t.Fatal(err)
+	}
+
+	if aliases := p.Aliases(); len(aliases) != 2 || aliases[0] != "test ca" || aliases[1] != "leaf.example.com" {
+		t.Errorf("unexpected aliases %q", aliases)
+	}
+	if privateKey := p.Key("Leaf.Example.com"); privateKey == nil || MatchKeyToCert(privateKey, cert) != nil {
+		t.Error("key entry not found")
+	}
+	if certificate := p.Certificate("leaf.example.com"); certificate == nil || !certificate.Equal(cert) {
+		t.Errorf("expected the certificate of the key, got %v", certificate)
+	}
+	if certificate := p.Certificate("TEST CA"); certificate == nil || !certificate.Equal(caCert) {
+		t.Errorf("expected the CA certificate, got %v", certificate)
+	}
+	if p.Key("test ca") != nil {
+		t.Error("certificate entry returned as a key")
+	}
+	if p.Key("missing") != nil || p.Certificate("missing") != nil {
+		t.Error("unknown alias found")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A DuplicateNames policy selects what DecodeTrustStore and
+// DecodeTrustStoreCerts do with certificates which share a friendly name.
+// The java keytool refuses to load a keystore with duplicate aliases, and
+// compares them ignoring case, so the policies do too.
+type DuplicateNames int
+
+const (
+	// DuplicateNamesKeepLast is the default: DecodeTrustStore keeps the last
+	// certificate with a given name, and DecodeTrustStoreCerts returns the
+	// names as they are.
+	DuplicateNamesKeepLast DuplicateNames = iota
+	// DuplicateNamesRename appends "-1", "-2" and so on to the names of
+	// the second and later certificates with a given name, skipping
+	// names already in use, so that no certificate is lost and the
+	// result can be encoded into a keystore the java keytool loads.
+	DuplicateNamesRename
+	// DuplicateNamesError makes decoding fail if two certificates share a
+	// name.
+	DuplicateNamesError
+)
+
+// WithDuplicateNames creates a new Decoder identical to dec except that
+// certificates of a trust store sharing a friendly name are handled as
+// selected by policy.  Validate reports duplicate names whatever the
+// policy.
+func (dec Decoder) WithDuplicateNames(policy DuplicateNames) *Decoder {
+	dec.duplicateNames = policy
+	return &dec
+}
+
+// applyDuplicateNames applies the DuplicateNames policy of dec to names,
+// renaming in place.  Empty names are left alone.
+func (dec *Decoder) applyDuplicateNames(names []string) error {
+	if dec.duplicateNames == DuplicateNamesKeepLast {
+		return nil
+	}
+
+	used := make(map[string]bool, len(names))
+	for _, name := range names {
+		used[strings.ToLower(name)] = true
+	}
+	seen := make(map[string]bool, len(names))
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		if !seen[strings.ToLower(name)] {
+			seen[strings.ToLower(name)] = true
+			continue
+		}
+		if dec.duplicateNames == DuplicateNamesError {
+			return errors.New("pkcs12: friendly name " + strconv.Quote(name) + " is used by more than one certificate")
+		}
+		unique := name
+		for n := 1; used[strings.ToLower(unique)]; n++ {
+			unique = name + "-" + strconv.Itoa(n)
+		}
+		used[strings.ToLower(unique)] = true
+		seen[strings.ToLower(unique)] = true
+		names[i] = unique
+	}
+	return nil
+}
+
+// duplicateNames returns the friendly names used by more than one keystore
+// entry of entries, ignoring case, in order of appearance.  A certificate
+// which carries the localKeyId of a private key belongs to the entry of
+// the key and is not counted.
+func duplicateNames(entries []Entry) []string {
+	var keyIDs [][]byte
+	for i := range entries {
+		if entries[i].PrivateKey != nil {
+			keyIDs = append(keyIDs, entries[i].LocalKeyID())
+		}
+	}
+
+	count := map[string]int{}
+	var names []string
+	for i := range entries {
+		entry := &entries[i]
+		name := entry.FriendlyName()
+		if name == "" || (entry.PrivateKey == nil && entry.Certificate == nil) {
+			continue
+		}
+		if entry.Certificate != nil && hasID(keyIDs, entry.LocalKeyID()) {
+			continue
+		}
+		key := strings.ToLower(name)
+		if count[key]++; count[key] == 2 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func hasID(ids [][]byte, id []byte) bool {
+	if len(id) == 0 {
+		return false
+	}
+	for _, other := range ids {
+		if bytes.Equal(other, id) {
+			return true
+		}
+	}
+	return false
+}
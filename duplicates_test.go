@@ -0,0 +1,71 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestDuplicateNames(t *testing.T) {
+	var entries []Entry
+	var certs []*x509.Certificate
+	for _, name := range []string{"root", "Root", "root-1", "root"} {
+		_, cert := makeTestCertificate(t, name, true, nil, nil)
+		friendlyName, err := FriendlyNameAttribute(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, Entry{BagType: CertBag, Certificate: cert, Attributes: []Attribute{friendlyName}})
+		certs = append(certs, cert)
+	}
+	pfxData, err := Modern.EncodeContents([]SafeContents{{Encrypted: true, Entries: entries}}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(what string, got map[string]*x509.Certificate, want map[string]*x509.Certificate) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Errorf("%s: expected %d certificates, got %d", what, len(want), len(got))
+		}
+		for name, cert := range want {
+			if got[name] == nil || !got[name].Equal(cert) {
+				t.Errorf("%s: expected %s for %q, got %v", what, cert.Subject, name, got[name])
+			}
+		}
+	}
+
+	got, err := DecodeTrustStore(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("default", got, map[string]*x509.Certificate{"root": certs[3], "Root": certs[1], "root-1": certs[2]})
+
+	renamed := map[string]*x509.Certificate{"root": certs[0], "Root-2": certs[1], "root-1": certs[2], "root-3": certs[3]}
+	got, err = DefaultDecoder.WithDuplicateNames(DuplicateNamesRename).DecodeTrustStore(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("rename", got, renamed)
+
+	trustStoreCerts, err := DefaultDecoder.WithDuplicateNames(DuplicateNamesRename).DecodeTrustStoreCerts(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = map[string]*x509.Certificate{}
+	for _, cert := range trustStoreCerts {
+		got[cert.FriendlyName] = cert.Certificate
+	}
+	check("rename certs", got, renamed)
+
+	if _, err := DefaultDecoder.WithDuplicateNames(DuplicateNamesError).DecodeTrustStore(pfxData, "password"); err == nil {
+		t.Error("expected an error for duplicate names")
+	}
+	if _, err := DefaultDecoder.WithDuplicateNames(DuplicateNamesError).DecodeTrustStoreCerts(pfxData, "password"); err == nil {
+		t.Error("expected an error for duplicate names")
+	}
+}
@@ -0,0 +1,670 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// An Encoder contains methods for encoding PKCS#12 files.  This package
+// defines several different Encoders with different parameters.
+//
+// Encoders are immutable: the With* methods return a modified copy and
+// leave the receiver untouched.  An Encoder is therefore safe for
+// concurrent use by multiple goroutines, and one configured Encoder can be
+// shared by a pool of workers, provided the io.Reader given to WithRand is
+// safe for concurrent use too, as crypto/rand.Reader is.
+type Encoder struct {
+	macAlgorithm         asn1.ObjectIdentifier
+	certAlgorithm        asn1.ObjectIdentifier
+	keyAlgorithm         asn1.ObjectIdentifier
+	macIterations        int
+	encryptionIterations int
+	macSaltLen           int
+	saltLen              int
+	rand                 io.Reader
+
+	// pbes2Cipher and pbes2PRF select the encryption scheme and PBKDF2
+	// PRF used when certAlgorithm or keyAlgorithm is PBES2.
+	pbes2Cipher asn1.ObjectIdentifier
+	pbes2PRF    asn1.ObjectIdentifier
+
+	fips      bool
+	allowWeak bool
+
+	// deterministic is set by WithDeterministicEncoding, which derives
+	// salts and IVs from seed instead of reading them from rand.
+	deterministic bool
+	seed          []byte
+
+	// plaintextCerts is set by WithEncryptedCerts(false).
+	plaintextCerts bool
+	keyUsage       x509.KeyUsage
+
+	// bagOrder, singleSafeContents and plainKeyBag select the layout of
+	// Encode.
+	bagOrder           BagOrder
+	singleSafeContents bool
+	plainKeyBag        bool
+
+	armor           Armor
+	nameEncoding    NameEncoding
+	generateAliases bool
+	creationDate    time.Time
+	kdf             KDF
+	hsm             HSM
+	// localKeyIDHash is set by WithLocalKeyIDHash.
+	localKeyIDHash crypto.Hash
+	// authSafeEncoding is set by WithAuthSafeEncoding.
+	authSafeEncoding AuthSafeEncoding
+	// embedPublicKey is set by WithEmbeddedPublicKey.
+	embedPublicKey bool
+
+	// ctx is set by EncodeContext on the copy of the Encoder it uses, and
+	// bounds the key derivations.
+	ctx context.Context
+
+	// err is the first invalid option given to a With method, returned
+	// by the Encode methods.
+	err error
+}
+
+// Modern encodes PKCS#12 files with the same algorithms and iteration
+// counts as OpenSSL 3's "openssl pkcs12 -export" by default: certificates
+// and keys are encrypted using PBES2 with AES-256-CBC, using keys derived
+// with 2048 iterations of PBKDF2 with HMAC-SHA-256.  The MAC uses
+// HMAC-SHA-256 with a key derived with 2048 iterations of the SHA-256
+// PKCS#12 KDF.  The MAC and encryption salts are 16 bytes long, as FIPS
+// mode requires, where OpenSSL writes 8 bytes.
+//
+// Files produced by Modern cannot be read by OpenSSL before 1.1.0, by Java
+// before 8u301, or by Windows before Windows Server 2019.
+var Modern = &Encoder{
+	macAlgorithm:         oidSHA256,
+	certAlgorithm:        oidPBES2,
+	keyAlgorithm:         oidPBES2,
+	macIterations:        2048,
+	encryptionIterations: 2048,
+	macSaltLen:           16,
+	saltLen:              16,
+	rand:                 rand.Reader,
+	pbes2Cipher:          oidAES256CBC,
+	pbes2PRF:             oidHmacWithSHA256,
+}
+
+// LegacyRC2 encodes PKCS#12 files the same way OpenSSL 1.1.1's
+// "openssl pkcs12 -export" does by default: certificates are encrypted using
+// PBE with 40-bit RC2, keys are encrypted using PBE with 3DES, and the MAC
+// uses HMAC-SHA-1.  Both the encryption keys and the MAC key are derived with
+// 2048 iterations of the PKCS#12 KDF.
+//
+// Due to the weak encryption, it is STRONGLY RECOMMENDED that you use
+// DefaultPassword when encoding PKCS#12 files using this encoder, and protect
+// the PKCS#12 files using other means.  LegacyRC2 must be combined with
+// AllowWeakAlgorithms to be usable.
+var LegacyRC2 = &Encoder{
+	macAlgorithm:         oidSHA1,
+	certAlgorithm:        oidPBEWithSHAAnd40BitRC2CBC,
+	keyAlgorithm:         oidPBEWithSHAAnd3KeyTripleDESCBC,
+	macIterations:        2048,
+	encryptionIterations: 2048,
+	macSaltLen:           8,
+	saltLen:              8,
+	rand:                 rand.Reader,
+}
+
+// LegacyDES is like LegacyRC2 except that certificates are encrypted using
+// PBE with 3DES instead of RC2, matching "openssl pkcs12 -export -descert".
+// It is useful for consumers which do not support RC2.
+//
+// Due to the weak encryption, it is STRONGLY RECOMMENDED that you use
+// DefaultPassword when encoding PKCS#12 files using this encoder, and protect
+// the PKCS#12 files using other means.  LegacyDES must be combined with
+// AllowWeakAlgorithms to be usable.
+var LegacyDES = &Encoder{
+	macAlgorithm:         oidSHA1,
+	certAlgorithm:        oidPBEWithSHAAnd3KeyTripleDESCBC,
+	keyAlgorithm:         oidPBEWithSHAAnd3KeyTripleDESCBC,
+	macIterations:        2048,
+	encryptionIterations: 2048,
+	macSaltLen:           8,
+	saltLen:              8,
+	rand:                 rand.Reader,
+}
+
+// WithIterations creates a new Encoder identical to enc except that
+// it will use the given number of KDF iterations for deriving the MAC
+// and encryption keys.
+func (enc Encoder) WithIterations(iterations int) *Encoder {
+	enc.macIterations = iterations
+	enc.encryptionIterations = iterations
+	return &enc
+}
+
+// WithHashes creates a new Encoder identical to enc except that the MAC
+// will use macHash, and PBES2 encryption will derive its keys with PBKDF2
+// using HMAC with prfHash.  SHA-1 and the SHA-2 family, including
+// SHA-512/224 and SHA-512/256, are supported; with any other hash, the
+// Encode methods of the result return an error.  The PRF is ignored by
+// encoders that do not use PBES2.
+func (enc Encoder) WithHashes(macHash, prfHash crypto.Hash) *Encoder {
+	macAlgorithm, ok := macAlgorithms[macHash]
+	if !ok {
+		enc.setErr(errors.New("pkcs12: unsupported MAC hash " + macHash.String()))
+		return &enc
+	}
+	prf, ok := prfAlgorithms[prfHash]
+	if !ok {
+		enc.setErr(errors.New("pkcs12: unsupported PBKDF2 PRF hash " + prfHash.String()))
+		return &enc
+	}
+	enc.macAlgorithm, enc.pbes2PRF = macAlgorithm, prf
+	return &enc
+}
+
+// setErr records err as the error of an invalid option, unless an earlier
+// option was invalid.
+func (enc *Encoder) setErr(err error) {
+	if enc.err == nil {
+		enc.err = err
+	}
+}
+
+// checkOptions returns the error of an invalid option, or refuses the
+// algorithms of enc in FIPS mode or if they are weak.
+func (enc *Encoder) checkOptions() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	if err := enc.checkFIPS(); err != nil {
+		return err
+	}
+	return enc.checkWeak()
+}
+
+// WithGCM creates a new Encoder identical to enc except that PBES2
+// encrypts with AES-GCM instead of AES-CBC, keeping the key size.  The
+// authentication tag lets a wrong password or a corrupted file be told
+// apart from a bad padding, but AES-GCM in PBES2 is not part of any
+// published PKCS#12 profile and OpenSSL cannot read such files, so WithGCM
+// is experimental.  Encoders that do not use PBES2 are unaffected.
+func (enc Encoder) WithGCM() *Encoder {
+	enc.pbes2Cipher = gcmFor(enc.pbes2Cipher)
+	return &enc
+}
+
+// WithCamellia creates a new Encoder identical to enc except that PBES2
+// encrypts with Camellia-CBC instead of AES-CBC, keeping the key size.
+// Camellia is used by some Japanese CAs and government systems, and
+// OpenSSL reads the result; most other consumers, including Java and
+// Windows, do not.  Encoders that do not use PBES2 are unaffected, and FIPS
+// mode refuses Camellia.
+func (enc Encoder) WithCamellia() *Encoder {
+	enc.pbes2Cipher = camelliaFor(enc.pbes2Cipher)
+	return &enc
+}
+
+// minSaltLen is the shortest salt an Encoder accepts: the 64 bits
+// recommended by https://tools.ietf.org/html/rfc8018#section-4.1.
+const minSaltLen = 8
+
+func saltLenError(n int) error {
+	return errors.New("pkcs12: salt length " + strconv.Itoa(n) + " is shorter than the minimum of " + strconv.Itoa(minSaltLen) + " bytes")
+}
+
+// WithSaltLength creates a new Encoder identical to enc except that it
+// will use salts of macSaltLen bytes for deriving the MAC key and of
+// encryptionSaltLen bytes for deriving the encryption keys.  Salts shorter
+// than 8 bytes make encoding fail, and in FIPS mode salts must be at least
+// 16 bytes long.  Decoding accepts salts of any length.
+func (enc Encoder) WithSaltLength(macSaltLen, encryptionSaltLen int) *Encoder {
+	enc.macSaltLen = macSaltLen
+	enc.saltLen = encryptionSaltLen
+	return &enc
+}
+
+// WithEncryptedCerts creates a new Encoder identical to enc except that, if
+// encrypted is false, Encode, EncodeKeyReference and EncodeTrustStore store
+// the certificates as plain data instead of encrypting them with the
+// encoder's certificate algorithm.  Plaintext certificates can be listed
+// without the password, which some importers rely on; encrypted ones hide
+// the subject names from anyone who does not know it.  EncodeContents
+// follows the Encrypted field of each SafeContents instead.
+func (enc Encoder) WithEncryptedCerts(encrypted bool) *Encoder {
+	enc.plaintextCerts = !encrypted
+	return &enc
+}
+
+// certsAlgorithm returns the algorithm encrypting the certificates, or nil
+// if they are stored as plain data.
+func (enc *Encoder) certsAlgorithm() asn1.ObjectIdentifier {
+	if enc.plaintextCerts {
+		return nil
+	}
+	return enc.certAlgorithm
+}
+
+// WithRand creates a new Encoder identical to enc except that
+// it will use the given io.Reader for its random number generator
+// instead of crypto/rand.Reader.
+func (enc Encoder) WithRand(rand io.Reader) *Encoder {
+	enc.rand = rand
+	return &enc
+}
+
+// WithFIPSMode creates a new Encoder identical to enc except that encoding
+// fails with an error wrapping ErrNonFIPSAlgorithm if enc is configured with
+// an algorithm that is not approved by FIPS 140-3, such as RC2, 3DES or
+// anything based on SHA-1.  Of the encoders defined by this package, only
+// Modern is usable in FIPS mode.
+func (enc Encoder) WithFIPSMode() *Encoder {
+	enc.fips = true
+	return &enc
+}
+
+// Encode produces pfxData containing one private key (privateKey), an
+// end-entity certificate (certificate), and any number of CA certificates
+// (caCerts).
+//
+// The private key is encrypted with the provided password, but due to the
+// weak encryption primitives used by PKCS#12, it is RECOMMENDED that you
+// specify a hard-coded password (such as pkcs12.DefaultPassword) and protect
+// the resulting pfxData using other means.
+//
+// Encode creates two SafeContents: one that's encrypted with the encoder's
+// certificate algorithm and contains the certificates, and another that is
+// unencrypted and contains the private key shrouded with the encoder's key
+// algorithm.  The certificates come first unless WithBagOrder selects
+// KeyFirst, WithSingleSafeContents puts all bags into the encrypted
+// SafeContents, and WithPlainKeyBag encrypts the SafeContents of the key
+// instead of the key itself.  The private key bag and the end-entity certificate bag
+// have the LocalKeyId attribute set to the SHA-1 fingerprint of the
+// end-entity certificate.  The bags have no friendlyName attribute unless
+// WithGeneratedAliases is set.
+func (enc *Encoder) Encode(privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) (pfxData []byte, err error) {
+	if err = enc.checkOptions(); err != nil {
+		return nil, err
+	}
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	certBags, localKeyIdAttr, err := enc.makeChainBags(certificate, caCerts)
+	if err != nil {
+		return nil, err
+	}
+
+	keyBag, err := enc.makeKeyBag(privateKey, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyBag.Attributes = append(keyBag.Attributes, localKeyIdAttr)
+	if err = enc.addGeneratedAliases(certBags, append([]*x509.Certificate{certificate}, caCerts...), &keyBag); err != nil {
+		return nil, err
+	}
+	if err = enc.addCreationDate(&certBags[0], &keyBag); err != nil {
+		return nil, err
+	}
+
+	authenticatedSafe, err := enc.layoutSafeContents(certBags, keyBag, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return enc.marshalPFX(authenticatedSafe, encodedPassword)
+}
+
+// EncodeKeyReference is like Encode for a private key that cannot be
+// exported, such as one held by an HSM or a platform key store: it
+// produces pfxData containing the certificate chain without any key
+// material.
+//
+// PKCS#12 has no bag which refers to a key without containing it, so the
+// reference is the LocalKeyId attribute of the end-entity certificate bag,
+// set to the SHA-1 fingerprint of the certificate like Encode does, or as
+// selected by WithLocalKeyIDHash.  An
+// importer that already holds the key associates it with the certificate by
+// that attribute or by the public key, as "certutil -repairstore" does on
+// Windows.  Decoding such a file with Decode or DecodeChain fails because
+// the private key is missing; use DecodeContents instead.
+//
+// EncodeKeyReference checks that key is the private key of certificate, so
+// that a file referring to the wrong key cannot be produced.
+func (enc *Encoder) EncodeKeyReference(key crypto.Signer, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) (pfxData []byte, err error) {
+	if err = enc.checkOptions(); err != nil {
+		return nil, err
+	}
+
+	if err = MatchKeyToCert(key, certificate); err != nil {
+		return nil, err
+	}
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	certBags, _, err := enc.makeChainBags(certificate, caCerts)
+	if err != nil {
+		return nil, err
+	}
+	if err = enc.addGeneratedAliases(certBags, append([]*x509.Certificate{certificate}, caCerts...), nil); err != nil {
+		return nil, err
+	}
+	if err = enc.addCreationDate(&certBags[0]); err != nil {
+		return nil, err
+	}
+
+	var authenticatedSafe [1]contentInfo
+	if authenticatedSafe[0], err = enc.makeSafeContents(certBags, enc.certsAlgorithm(), encodedPassword); err != nil {
+		return nil, err
+	}
+
+	return enc.marshalPFX(authenticatedSafe[:], encodedPassword)
+}
+
+// EncodeKeyOnly produces pfxData containing privateKey and no
+// certificate, like "openssl pkcs12 -export -nocerts" does, for importing
+// the key into an HSM or escrowing it.  The key is shrouded with the
+// encoder's key algorithm and stored in an unencrypted SafeContents of its
+// own, whatever the layout selected for Encode.  Without a certificate
+// there is no LocalKeyId or alias to give the bag; its only attribute is
+// the one added by WithCreationDate, if any.  Decode and DecodeChain
+// fail on such a file because the certificate is missing; use
+// DecodeContents instead.
+func (enc *Encoder) EncodeKeyOnly(privateKey interface{}, password string) (pfxData []byte, err error) {
+	if err = enc.checkOptions(); err != nil {
+		return nil, err
+	}
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	var keyBag safeBag
+	keyBag.Id = oidPKCS8ShroundedKeyBag
+	keyBag.Value.Class = 2
+	keyBag.Value.Tag = 0
+	keyBag.Value.IsCompound = true
+	if keyBag.Value.Bytes, err = enc.encodePkcs8ShroudedKeyBag(privateKey, encodedPassword); err != nil {
+		return nil, err
+	}
+	if err = enc.addCreationDate(&keyBag); err != nil {
+		return nil, err
+	}
+
+	var authenticatedSafe [1]contentInfo
+	if authenticatedSafe[0], err = enc.makeSafeContents([]safeBag{keyBag}, nil, nil); err != nil {
+		return nil, err
+	}
+
+	return enc.marshalPFX(authenticatedSafe[:], encodedPassword)
+}
+
+// makeChainBags returns the cert bags for certificate and caCerts, in that
+// order, and the LocalKeyId attribute set on the first of them.
+func (enc *Encoder) makeChainBags(certificate *x509.Certificate, caCerts []*x509.Certificate) (certBags []safeBag, localKeyIdAttr pkcs12Attribute, err error) {
+	keyID, err := enc.localKeyID(certificate)
+	if err != nil {
+		return nil, localKeyIdAttr, err
+	}
+	localKeyIdAttr.Id = oidLocalKeyID
+	localKeyIdAttr.Value.Class = 0
+	localKeyIdAttr.Value.Tag = 17
+	localKeyIdAttr.Value.IsCompound = true
+	if localKeyIdAttr.Value.Bytes, err = asn1.Marshal(keyID); err != nil {
+		return nil, localKeyIdAttr, err
+	}
+
+	var certBag *safeBag
+	if certBag, err = makeCertBag(certificate.Raw, []pkcs12Attribute{localKeyIdAttr}); err != nil {
+		return nil, localKeyIdAttr, err
+	}
+	certBags = append(certBags, *certBag)
+
+	for _, cert := range caCerts {
+		if certBag, err = makeCertBag(cert.Raw, []pkcs12Attribute{}); err != nil {
+			return nil, localKeyIdAttr, err
+		}
+		certBags = append(certBags, *certBag)
+	}
+	return certBags, localKeyIdAttr, nil
+}
+
+// EncodeTrustStore produces pfxData containing any number of CA certificates
+// (certs), keyed by their alias.
+//
+// EncodeTrustStore creates one SafeContents that's encrypted with the
+// encoder's certificate algorithm and contains the certificates.  Each
+// certificate bag carries the alias as its friendlyName attribute, and the
+// attribute which marks it as a trustedCertEntry for the java keytool.  The
+// bags are sorted by alias, so the same certs always produce the same
+// layout.  A certificate stored under the empty alias gets a generated one
+// if WithGeneratedAliases is set.
+func (enc *Encoder) EncodeTrustStore(certs map[string]*x509.Certificate, password string) (pfxData []byte, err error) {
+	if err = enc.checkOptions(); err != nil {
+		return nil, err
+	}
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	var certBags []safeBag
+	var certBag *safeBag
+
+	aliases := make([]string, 0, len(certs))
+	for alias := range certs {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		cert := certs[alias]
+		if alias == "" {
+			alias = enc.trustStoreAlias(aliases, cert)
+		}
+		var attributes []pkcs12Attribute
+		if attributes, err = certBagAttributes(alias, enc.nameEncoding); err != nil {
+			return nil, err
+		}
+		if certBag, err = makeCertBag(cert.Raw, attributes); err != nil {
+			return nil, err
+		}
+		if err = enc.addCreationDate(certBag); err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, *certBag)
+	}
+
+	// Construct an authenticated safe with one SafeContents, which is
+	// encrypted and contains the cert bags.
+	var authenticatedSafe [1]contentInfo
+	if authenticatedSafe[0], err = enc.makeSafeContents(certBags, enc.certsAlgorithm(), encodedPassword); err != nil {
+		return nil, err
+	}
+
+	return enc.marshalPFX(authenticatedSafe[:], encodedPassword)
+}
+
+// marshalPFX computes the MAC over authenticatedSafe and wraps everything
+// into a DER-encoded PFX PDU.
+func (enc *Encoder) marshalPFX(authenticatedSafe []contentInfo, password []byte) (pfxData []byte, err error) {
+	var pfx pfxPdu
+	pfx.Version = 3
+
+	var authenticatedSafeBytes []byte
+	if authenticatedSafeBytes, err = asn1.Marshal(authenticatedSafe); err != nil {
+		return nil, err
+	}
+
+	// compute the MAC
+	pfx.MacData.Mac.Algorithm.Algorithm = enc.macAlgorithm
+	if enc.macSaltLen < minSaltLen {
+		return nil, saltLenError(enc.macSaltLen)
+	}
+	pfx.MacData.MacSalt = make([]byte, enc.macSaltLen)
+	if err = enc.saltFor(pfx.MacData.MacSalt, macSaltLabel, authenticatedSafeBytes); err != nil {
+		return nil, err
+	}
+	pfx.MacData.Iterations = enc.macIterations
+	if err = computeMac(enc.kdfContext(), &pfx.MacData, authenticatedSafeBytes, password); err != nil {
+		return nil, err
+	}
+
+	pfx.AuthSafe.ContentType = oidDataContentType
+	if pfx.AuthSafe.Content, err = enc.authSafeEncoding.content(authenticatedSafeBytes); err != nil {
+		return nil, err
+	}
+
+	if pfxData, err = asn1.Marshal(pfx); err != nil {
+		return nil, errors.New("pkcs12: error writing P12 data: " + err.Error())
+	}
+	return enc.applyArmor(pfxData), nil
+}
+
+// pbeAlgorithm returns a PBE AlgorithmIdentifier for the given algorithm
+// with a fresh salt, for encrypting content.
+func (enc *Encoder) pbeAlgorithm(algorithm asn1.ObjectIdentifier, content []byte) (algo pkix.AlgorithmIdentifier, err error) {
+	if enc.saltLen < minSaltLen {
+		return algo, saltLenError(enc.saltLen)
+	}
+	randomSalt := make([]byte, enc.saltLen)
+	if err = enc.saltFor(randomSalt, saltLabel, content); err != nil {
+		return algo, errors.New("pkcs12: error reading random salt: " + err.Error())
+	}
+
+	algo.Algorithm = algorithm
+	if algorithm.Equal(oidPBES2) {
+		iv := make([]byte, aes.BlockSize)
+		if isAEAD(enc.pbes2Cipher) {
+			iv = make([]byte, aeadNonceSize)
+		}
+		if err = enc.saltFor(iv, ivLabel, content); err != nil {
+			return algo, errors.New("pkcs12: error reading random IV: " + err.Error())
+		}
+		algo.Parameters.FullBytes, err = makePBES2Params(enc.pbes2Cipher, enc.pbes2PRF, randomSalt, iv, enc.encryptionIterations)
+	} else {
+		algo.Parameters.FullBytes, err = asn1.Marshal(pbeParams{Salt: randomSalt, Iterations: enc.encryptionIterations})
+	}
+	if err != nil {
+		return algo, errors.New("pkcs12: error encoding params: " + err.Error())
+	}
+	return algo, nil
+}
+
+// EncryptPrivateKey returns privateKey as a DER-encoded PKCS#8
+// EncryptedPrivateKeyInfo, encrypted with the key algorithm of enc the same
+// way the key of a PKCS#12 file is.  This is the content of an
+// "ENCRYPTED PRIVATE KEY" PEM block; the pkcs8 package provides a shorter
+// way to call it.
+func (enc *Encoder) EncryptPrivateKey(privateKey interface{}, password string) (der []byte, err error) {
+	if err = enc.checkOptions(); err != nil {
+		return nil, err
+	}
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return enc.encodePkcs8ShroudedKeyBag(privateKey, encodedPassword)
+}
+
+func (enc *Encoder) encodePkcs8ShroudedKeyBag(privateKey interface{}, password []byte) (asn1Data []byte, err error) {
+	var pkData []byte
+	if pkData, err = enc.marshalPKCS8(privateKey); err != nil {
+		return nil, err
+	}
+	defer wipe(pkData)
+
+	return enc.encryptPKCS8(pkData, password)
+}
+
+// encryptPKCS8 encrypts pkData, a DER-encoded PKCS#8 private key, into the
+// value of a PKCS#8 shrouded key bag.
+func (enc *Encoder) encryptPKCS8(pkData, password []byte) (asn1Data []byte, err error) {
+	var pkinfo encryptedPrivateKeyInfo
+	if pkinfo.AlgorithmIdentifier, err = enc.pbeAlgorithm(enc.keyAlgorithm, pkData); err != nil {
+		return nil, err
+	}
+
+	if err = pbEncrypt(enc.kdfContext(), &pkinfo, pkData, password); err != nil {
+		if ctxErr := enc.kdfContext().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("pkcs12: error encrypting PKCS#8 shrouded key bag: %w", err)
+	}
+
+	if asn1Data, err = asn1.Marshal(pkinfo); err != nil {
+		return nil, errors.New("pkcs12: error encoding PKCS#8 shrouded key bag: " + err.Error())
+	}
+
+	return asn1Data, nil
+}
+
+// makeSafeContents marshals bags into a SafeContents.  If algorithm is nil,
+// the SafeContents is stored as plain data, otherwise it is encrypted with
+// algorithm and password.
+func (enc *Encoder) makeSafeContents(bags []safeBag, algorithm asn1.ObjectIdentifier, password []byte) (ci contentInfo, err error) {
+	var data []byte
+	if data, err = asn1.Marshal(bags); err != nil {
+		return
+	}
+
+	if algorithm == nil {
+		ci.ContentType = oidDataContentType
+		ci.Content.Class = 2
+		ci.Content.Tag = 0
+		ci.Content.IsCompound = true
+		if ci.Content.Bytes, err = asn1.Marshal(data); err != nil {
+			return
+		}
+	} else {
+		var algo pkix.AlgorithmIdentifier
+		if algo, err = enc.pbeAlgorithm(algorithm, data); err != nil {
+			return
+		}
+
+		var encryptedData encryptedData
+		encryptedData.Version = 0
+		encryptedData.EncryptedContentInfo.ContentType = oidDataContentType
+		encryptedData.EncryptedContentInfo.ContentEncryptionAlgorithm = algo
+		if err = pbEncrypt(enc.kdfContext(), &encryptedData.EncryptedContentInfo, data, password); err != nil {
+			return
+		}
+
+		ci.ContentType = oidEncryptedDataContentType
+		ci.Content.Class = 2
+		ci.Content.Tag = 0
+		ci.Content.IsCompound = true
+		if ci.Content.Bytes, err = asn1.Marshal(encryptedData); err != nil {
+			return
+		}
+	}
+	return
+}
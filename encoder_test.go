@@ -0,0 +1,369 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// makeTestCertificate returns a fresh P-256 key and a certificate for it
+// with the given common name.  If parent is nil, the certificate is
+// self-signed, otherwise it is issued by parent/parentKey.
+func makeTestCertificate(t testing.TB, commonName string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// safeContentsAlgorithms returns the encryption algorithm of every
+// SafeContents in pfxData, or nil for plain data SafeContents.
+func safeContentsAlgorithms(t *testing.T, pfxData []byte) []asn1.ObjectIdentifier {
+	t.Helper()
+
+	pfx := new(pfxPdu)
+	if err := unmarshal(pfxData, pfx); err != nil {
+		t.Fatal(err)
+	}
+	var authSafeData []byte
+	if err := unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeData); err != nil {
+		t.Fatal(err)
+	}
+	var authenticatedSafe []contentInfo
+	if err := unmarshal(authSafeData, &authenticatedSafe); err != nil {
+		t.Fatal(err)
+	}
+
+	var algorithms []asn1.ObjectIdentifier
+	for _, ci := range authenticatedSafe {
+		if !ci.ContentType.Equal(oidEncryptedDataContentType) {
+			algorithms = append(algorithms, nil)
+			continue
+		}
+		var ed encryptedData
+		if err := unmarshal(ci.Content.Bytes, &ed); err != nil {
+			t.Fatal(err)
+		}
+		algorithms = append(algorithms, ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm)
+	}
+	return algorithms
+}
+
+func TestLegacyEncoders(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	for name, test := range map[string]struct {
+		enc           *Encoder
+		certAlgorithm asn1.ObjectIdentifier
+	}{
+		"LegacyRC2": {LegacyRC2, oidPBEWithSHAAnd40BitRC2CBC},
+		"LegacyDES": {LegacyDES, oidPBEWithSHAAnd3KeyTripleDESCBC},
+	} {
+		pfxData, err := test.enc.AllowWeakAlgorithms().Encode(key, cert, []*x509.Certificate{caCert}, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		algorithms := safeContentsAlgorithms(t, pfxData)
+		if len(algorithms) != 2 || !algorithms[0].Equal(test.certAlgorithm) || algorithms[1] != nil {
+			t.Errorf("%s: unexpected SafeContents algorithms %v", name, algorithms)
+		}
+
+		decodedKey, decodedCert, caCerts, err := DecodeChain(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !decodedKey.(*ecdsa.PrivateKey).Equal(key) {
+			t.Errorf("%s: private key does not match", name)
+		}
+		if !decodedCert.Equal(cert) {
+			t.Errorf("%s: certificate does not match", name)
+		}
+		if len(caCerts) != 1 || !caCerts[0].Equal(caCert) {
+			t.Errorf("%s: CA certificates do not match", name)
+		}
+	}
+}
+
+func TestWithEncryptedCerts(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	for _, encrypted := range []bool{true, false} {
+		enc := Modern.WithEncryptedCerts(encrypted)
+		pfxData, err := enc.Encode(key, cert, nil, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		algorithms := safeContentsAlgorithms(t, pfxData)
+		if len(algorithms) != 2 || (algorithms[0] != nil) != encrypted || algorithms[1] != nil {
+			t.Errorf("encrypted %v: unexpected SafeContents algorithms %v", encrypted, algorithms)
+		}
+		if _, _, err := Decode(pfxData, "password"); err != nil {
+			t.Errorf("encrypted %v: %v", encrypted, err)
+		}
+
+		trustStore, err := enc.EncodeTrustStore(map[string]*x509.Certificate{"leaf": cert}, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if algorithms := safeContentsAlgorithms(t, trustStore); len(algorithms) != 1 || (algorithms[0] != nil) != encrypted {
+			t.Errorf("encrypted %v: unexpected trust store algorithms %v", encrypted, algorithms)
+		}
+	}
+}
+
+func TestWithSaltLength(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	pfxData, err := LegacyDES.AllowWeakAlgorithms().WithSaltLength(20, 32).Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe.MAC.SaltLen != 20 {
+		t.Errorf("expected a MAC salt of 20 bytes, got %d", probe.MAC.SaltLen)
+	}
+	if saltLen := probe.SafeContents[0].Encryption.SaltLen; saltLen != 32 {
+		t.Errorf("expected a certificate salt of 32 bytes, got %d", saltLen)
+	}
+	if saltLen := probe.SafeContents[1].Bags[0].Encryption.SaltLen; saltLen != 32 {
+		t.Errorf("expected a key salt of 32 bytes, got %d", saltLen)
+	}
+	if _, _, err := Decode(pfxData, "password"); err != nil {
+		t.Error(err)
+	}
+
+	for _, lengths := range [][2]int{{4, 16}, {16, 4}, {0, 0}} {
+		if _, err := Modern.WithSaltLength(lengths[0], lengths[1]).Encode(key, cert, nil, "password"); err == nil {
+			t.Errorf("expected salt lengths %v to be refused", lengths)
+		}
+	}
+	if _, err := Modern.WithSaltLength(8, 16).WithFIPSMode().Encode(key, cert, nil, "password"); !errors.Is(err, ErrNonFIPSAlgorithm) {
+		t.Errorf("expected ErrNonFIPSAlgorithm for an 8-byte salt, got %v", err)
+	}
+}
+
+func TestWithHashes(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	for _, hash := range []crypto.Hash{crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512, crypto.SHA512_224, crypto.SHA512_256} {
+		pfxData, err := Modern.WithHashes(hash, hash).WithFIPSMode().Encode(key, cert, nil, "password")
+		if err != nil {
+			t.Errorf("%s: %v", hash, err)
+			continue
+		}
+		probe, err := Probe(pfxData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !probe.MAC.Algorithm.Equal(macAlgorithms[hash]) {
+			t.Errorf("%s: unexpected MAC %s", hash, probe.MAC.Name)
+		}
+		if prf := probe.SafeContents[0].Encryption.PRF; !prf.Equal(prfAlgorithms[hash]) {
+			t.Errorf("%s: unexpected PRF %s", hash, algorithmName(prf))
+		}
+		if _, _, err := DefaultDecoder.WithFIPSMode().Decode(pfxData, "password"); err != nil {
+			t.Errorf("%s: %v", hash, err)
+		}
+	}
+
+	if _, err := Modern.WithHashes(crypto.SHA256, crypto.SHA1).Encode(key, cert, nil, "password"); !errors.Is(err, ErrWeakAlgorithm) {
+		t.Errorf("expected ErrWeakAlgorithm for HMAC-SHA-1, got %v", err)
+	}
+	if _, err := Modern.WithHashes(crypto.SHA1, crypto.SHA256).AllowWeakAlgorithms().Encode(key, cert, nil, "password"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := Modern.WithHashes(crypto.MD5, crypto.SHA256).Encode(key, cert, nil, "password"); err == nil || !strings.Contains(err.Error(), "unsupported MAC hash") {
+		t.Errorf("expected an error for MD5, got %v", err)
+	}
+	// The first invalid option is reported, even after valid ones.
+	if _, err := Modern.WithHashes(crypto.SHA256, crypto.MD5).WithHashes(crypto.SHA256, crypto.SHA256).EncodeTrustStore(nil, "password"); err == nil || !strings.Contains(err.Error(), "unsupported PBKDF2 PRF hash") {
+		t.Errorf("expected an error for MD5, got %v", err)
+	}
+}
+
+func TestEncoderWithIsCopy(t *testing.T) {
+	enc := LegacyRC2.WithIterations(1)
+	if enc == LegacyRC2 || LegacyRC2.macIterations != 2048 || LegacyRC2.encryptionIterations != 2048 {
+		t.Fatal("WithIterations modified the receiver")
+	}
+	if enc.macIterations != 1 || enc.encryptionIterations != 1 {
+		t.Errorf("unexpected iterations %d/%d", enc.macIterations, enc.encryptionIterations)
+	}
+}
+
+// TestEncoderConcurrentUse shares one Encoder between goroutines which
+// encode with it and derive new Encoders from it; run it with -race.
+func TestEncoderConcurrentUse(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	enc := Modern.WithIterations(1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			derived := enc.WithIterations(i + 2).WithBagOrder(KeyFirst).WithGeneratedAliases(true)
+			for _, e := range []*Encoder{enc, derived} {
+				pfxData, err := e.Encode(key, cert, nil, DefaultPassword)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, _, err := Decode(pfxData, DefaultPassword); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if enc.macIterations != 1 || enc.bagOrder != CertsFirst || enc.generateAliases {
+		t.Error("the shared Encoder was modified")
+	}
+}
+
+func TestEncodeKeyReference(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	if _, err := Modern.EncodeKeyReference(caKey, cert, nil, DefaultPassword); err == nil {
+		t.Error("expected an error for a key not matching the certificate")
+	}
+
+	pfxData, err := Modern.EncodeKeyReference(key, cert, []*x509.Certificate{caCert}, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 1 || len(contents[0].Entries) != 2 {
+		t.Fatalf("unexpected structure %+v", contents)
+	}
+	for i, entry := range contents[0].Entries {
+		if entry.BagType != CertBag || entry.PrivateKey != nil {
+			t.Errorf("entry %d: expected a cert bag, got %s", i, entry.BagType)
+		}
+	}
+	fingerprint := sha1.Sum(cert.Raw)
+	if id := contents[0].Entries[0].LocalKeyID(); !bytes.Equal(id, fingerprint[:]) {
+		t.Errorf("expected LocalKeyId %x, got %x", fingerprint, id)
+	}
+
+	if _, _, _, err := DecodeChain(pfxData, DefaultPassword); err == nil {
+		t.Error("expected DecodeChain to fail without a private key")
+	}
+}
+
+func TestEncodeKeyOnly(t *testing.T) {
+	key, _ := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	pfxData, err := EncodeKeyOnly(rand.Reader, key, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 1 || contents[0].Encrypted || len(contents[0].Entries) != 1 {
+		t.Fatalf("unexpected structure %+v", contents)
+	}
+	entry := contents[0].Entries[0]
+	if entry.BagType != PKCS8ShroudedKeyBag || !key.Equal(entry.PrivateKey) || len(entry.Attributes) != 0 {
+		t.Errorf("expected the shrouded key only, got %+v", entry)
+	}
+
+	if _, _, _, err := DecodeChain(pfxData, DefaultPassword); err == nil {
+		t.Error("expected DecodeChain to fail without a certificate")
+	}
+}
+
+// makeBenchmarkChain returns a private key, its certificate and the two CA
+// certificates above it, the contents of a typical client PKCS#12 file.
+func makeBenchmarkChain(b *testing.B) (*ecdsa.PrivateKey, *x509.Certificate, []*x509.Certificate) {
+	rootKey, rootCert := makeTestCertificate(b, "Root CA", true, nil, nil)
+	caKey, caCert := makeTestCertificate(b, "Intermediate CA", true, rootCert, rootKey)
+	key, cert := makeTestCertificate(b, "client.example.com", false, caCert, caKey)
+	return key, cert, []*x509.Certificate{caCert, rootCert}
+}
+
+// benchmarkEncoders are the encoders the benchmarks use, with the iteration
+// count of OpenSSL 3's defaults raised to 100000.
+var benchmarkEncoders = []struct {
+	name string
+	enc  *Encoder
+}{
+	{"Modern", Modern.WithIterations(100000)},
+	{"LegacyDES", LegacyDES.AllowWeakAlgorithms().WithIterations(100000)},
+}
+
+func BenchmarkEncode(b *testing.B) {
+	key, cert, caCerts := makeBenchmarkChain(b)
+	for _, bench := range benchmarkEncoders {
+		enc := bench.enc
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := enc.Encode(key, cert, caCerts, "password"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,671 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A BagType identifies the type of a safe bag, using the names from
+// https://tools.ietf.org/html/rfc7292#section-4.2.  Bags of a type this
+// package does not know are identified by their dotted OID.
+type BagType string
+
+const (
+	KeyBag              BagType = "keyBag"
+	PKCS8ShroudedKeyBag BagType = "pkcs8ShroudedKeyBag"
+	CertBag             BagType = "certBag"
+	CRLBag              BagType = "crlBag"
+	SecretBag           BagType = "secretBag"
+	SafeContentsBag     BagType = "safeContentsBag"
+)
+
+var bagTypeOIDs = map[BagType]asn1.ObjectIdentifier{
+	KeyBag:              oidKeyBag,
+	PKCS8ShroudedKeyBag: oidPKCS8ShroundedKeyBag,
+	CertBag:             oidCertBag,
+	CRLBag:              oidCRLBag,
+	SecretBag:           oidSecretBag,
+	SafeContentsBag:     oidSafeContentsBag,
+}
+
+func bagTypeFor(oid asn1.ObjectIdentifier) BagType {
+	for bagType, bagTypeOID := range bagTypeOIDs {
+		if oid.Equal(bagTypeOID) {
+			return bagType
+		}
+	}
+	return BagType(oid.String())
+}
+
+func (t BagType) oid() (asn1.ObjectIdentifier, error) {
+	if oid, ok := bagTypeOIDs[t]; ok {
+		return oid, nil
+	}
+	var oid asn1.ObjectIdentifier
+	for _, s := range strings.Split(string(t), ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.New("pkcs12: unknown bag type " + strconv.Quote(string(t)))
+		}
+		oid = append(oid, n)
+	}
+	return oid, nil
+}
+
+// An Attribute is a PKCS#9 attribute of a safe bag.
+type Attribute struct {
+	Type asn1.ObjectIdentifier
+	// Values holds the DER encoding of each value of the attribute.
+	Values [][]byte
+}
+
+// An Entry is a safe bag of a PKCS#12 file in decoded form.
+type Entry struct {
+	BagType BagType
+	// PrivateKey is set for key bags and PKCS#8 shrouded key bags.
+	PrivateKey crypto.PrivateKey
+	// Certificate is set for certificate bags holding an X.509
+	// certificate.
+	Certificate *x509.Certificate
+	// CertType is the type of the certificate of a certificate bag, its
+	// certId, as set by DecodeContents.  EncodeContents takes nil for
+	// OIDX509Certificate.  A certificate of another type, such as
+	// OIDSDSICertificate, is kept in Value, and in OtherCertificate once
+	// parsed by a CertParser given to WithCertParser.
+	CertType         asn1.ObjectIdentifier
+	OtherCertificate interface{}
+	// CertificateError is set instead, by DecodeContents, for a
+	// certificate bag whose certificate crypto/x509 cannot parse, such as
+	// one with a negative serial number or an unknown critical extension,
+	// so that the rest of the file can still be decoded.  RawCertDER then
+	// holds the certificate, which EncodeContents stores as it is.
+	CertificateError error
+	// Value is the DER encoding of the value of bags of any other type,
+	// and of the certValue of certificate bags holding a certificate
+	// other than an X.509 one, which are kept as they are.
+	Value []byte
+	// Contents holds the entries of a safeContentsBag, which groups bags
+	// inside a SafeContents.  EncodeContents encodes them in turn unless
+	// Value is set.
+	Contents []Entry
+	// Attributes are the bag attributes, in the order they appear in the
+	// file.
+	Attributes []Attribute
+
+	// KeyUsage is the usage the private key of a key bag or PKCS#8
+	// shrouded key bag is restricted to by a keyUsage attribute, or 0 if
+	// there is none.  EncodeContents stores it with the key, see
+	// Encoder.WithKeyUsage.
+	KeyUsage x509.KeyUsage
+	// KeyAttributes are the attributes stored with the private key of a
+	// key bag or PKCS#8 shrouded key bag, inside the PKCS#8 structure,
+	// other than keyUsage.
+	KeyAttributes []Attribute
+	// PublicKey is the public key stored with the private key of a key
+	// bag or PKCS#8 shrouded key bag, as the version 2 OneAsymmetricKey of
+	// RFC 5958 allows and some Ed25519 keys do, or nil if there is none.
+	// EncodeContents writes a version 2 key when it is set.
+	PublicKey []byte
+
+	// RawBag is the DER encoding of the whole safe bag as stored in the
+	// decoded file, including its attributes.  For key bags it holds the
+	// unencrypted private key.
+	RawBag []byte
+	// RawCertDER is the DER encoding of the certificate of a certificate
+	// bag, byte for byte as stored, for signature checks and pinning.
+	RawCertDER []byte
+	// RawEncryptedKey is the DER encoding of the PKCS#8
+	// EncryptedPrivateKeyInfo of a PKCS#8 shrouded key bag, as stored.
+	//
+	// The raw fields are set by DecodeContents and not used by
+	// EncodeContents or EqualContents, except for RawCertDER when
+	// CertificateError is set.
+	RawEncryptedKey []byte
+}
+
+// FriendlyName returns the friendlyName attribute of e, or "" if there is
+// none.  Of an attribute with several values, the first one is returned;
+// see FriendlyNames.
+func (e *Entry) FriendlyName() string {
+	for _, attribute := range e.Attributes {
+		if attribute.Type.Equal(oidFriendlyName) && len(attribute.Values) != 0 {
+			name, err := unmarshalFriendlyName(attribute.Values[0])
+			if err != nil {
+				return ""
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+// FriendlyNames returns the distinct names held by the values of the
+// friendlyName attributes of e, in order.  PKCS#9 attributes are a SET OF
+// values, and some files give a friendlyName two of them, such as the same
+// name as a BMPString and as a UTF8String, see NameBMPAndUTF8String, or
+// two different names.  Values which do not decode are left out.
+func (e *Entry) FriendlyNames() []string {
+	var names []string
+	for _, attribute := range e.Attributes {
+		if !attribute.Type.Equal(oidFriendlyName) {
+			continue
+		}
+	values:
+		for _, value := range attribute.Values {
+			name, err := unmarshalFriendlyName(value)
+			if err != nil {
+				continue
+			}
+			for _, seen := range names {
+				if seen == name {
+					continue values
+				}
+			}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// LocalKeyID returns the localKeyId attribute of e, which links a key to
+// its certificate, or nil if there is none.
+func (e *Entry) LocalKeyID() []byte {
+	for _, attribute := range e.Attributes {
+		if attribute.Type.Equal(oidLocalKeyID) && len(attribute.Values) != 0 {
+			id, ok := parseOctetString(attribute.Values[0])
+			if !ok {
+				return nil
+			}
+			return append([]byte{}, id...)
+		}
+	}
+	return nil
+}
+
+// SafeContents is a group of entries stored together in a PKCS#12 file.
+type SafeContents struct {
+	// Encrypted reports whether the entries are encrypted with the
+	// password as a whole.  Keys in PKCS#8 shrouded key bags are encrypted
+	// individually in either case.
+	Encrypted bool
+	Entries   []Entry
+}
+
+// DecodeContents decodes every safe bag of pfxData, keeping the grouping of
+// the bags into SafeContents, their order and their attributes, so that the
+// result can be encoded again with Encoder.EncodeContents without losing
+// anything.  A certificate which does not parse does not fail the
+// decoding; its entry is returned with CertificateError set.  An encrypted
+// SafeContents without its encrypted content is left out, and
+// ErrDetachedContent is returned together with the other SafeContents.
+func (dec *Decoder) DecodeContents(pfxData []byte, password string) ([]SafeContents, error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeContents(pfxData, encodedPassword)
+}
+
+func (dec *Decoder) decodeContents(pfxData, encodedPassword []byte) ([]SafeContents, error) {
+	contents, _, err := dec.decodeAllContents(pfxData, encodedPassword)
+	return contents, err
+}
+
+// decodeAllContents is like decodeContents, but also returns the indexes
+// of the SafeContents which were left out because they did not decrypt,
+// see DecodeContentsPartial.
+func (dec *Decoder) decodeAllContents(pfxData, encodedPassword []byte) (contents []SafeContents, undecrypted []int, err error) {
+	// getAuthenticatedSafe adds the SafeContents to spent; they still
+	// count towards the limits for the bags decoded below.
+	var spent usage
+	decoded, encodedPassword, err := dec.getAuthenticatedSafe(pfxData, encodedPassword, nil, &spent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limits := dec.limits.withDefaults()
+	contents = make([]SafeContents, 0, len(decoded))
+	var detached bool
+	for i, sc := range decoded {
+		if sc.detached {
+			detached = true
+			continue
+		}
+		if sc.undecrypted {
+			undecrypted = append(undecrypted, i)
+			continue
+		}
+		entries := make([]Entry, 0, len(sc.bags))
+		for j := range sc.bags {
+			entry, err := dec.decodeEntry(&sc.bags[j], encodedPassword, 1, limits, &spent)
+			if err != nil {
+				return nil, nil, locateParseError(err, "authSafe["+strconv.Itoa(i)+"].safeContents["+strconv.Itoa(j)+"]", sc.der)
+			}
+			entries = append(entries, entry)
+		}
+		contents = append(contents, SafeContents{Encrypted: sc.encrypted, Entries: entries})
+	}
+	if detached {
+		return contents, undecrypted, ErrDetachedContent
+	}
+	return contents, undecrypted, nil
+}
+
+// DecodeContents decodes every safe bag of pfxData using the
+// DefaultDecoder.  See Decoder.DecodeContents.
+func DecodeContents(pfxData []byte, password string) ([]SafeContents, error) {
+	return DefaultDecoder.DecodeContents(pfxData, password)
+}
+
+// decodeAttributes splits the values of the bag attributes attributes.
+func decodeAttributes(attributes []pkcs12Attribute) (decoded []Attribute, err error) {
+	for _, attribute := range attributes {
+		values, ok := splitValues(attribute.Value.Bytes)
+		if !ok {
+			return nil, newParseError(".bagAttributes", attribute.Value.FullBytes, errors.New("malformed value of attribute "+attribute.Id.String()))
+		}
+		if len(values) == 0 {
+			values = nil
+		}
+		decoded = append(decoded, Attribute{Type: attribute.Id, Values: values})
+	}
+	return decoded, nil
+}
+
+// decodeEntry decodes bag, found at the given nesting depth of
+// safeContentsBags.  The bags nested in it and the key it decrypts are
+// added to spent and checked against limits.  A ParseError is returned with
+// a path relative to bag, and is left for the caller to locate.
+func (dec *Decoder) decodeEntry(bag *safeBag, password []byte, depth int, limits Limits, spent *usage) (entry Entry, err error) {
+	entry.BagType = bagTypeFor(bag.Id)
+	entry.RawBag = bag.Raw
+
+	if entry.Attributes, err = decodeAttributes(bag.Attributes); err != nil {
+		return entry, err
+	}
+
+	switch entry.BagType {
+	case CertBag:
+		certType, value, err := decodeAnyCertBag(bag.Value.Bytes)
+		if err != nil {
+			return entry, newParseError(".bagValue", bag.Value.FullBytes, err)
+		}
+		entry.CertType = certType
+		if !isX509CertType(certType) {
+			entry.Value = value
+			if parse := dec.certParserFor(certType); parse != nil {
+				if entry.OtherCertificate, err = parse(value); err != nil {
+					return entry, newParseError(".bagValue", bag.Value.FullBytes, err)
+				}
+			}
+			break
+		}
+		certData, err := decodeCertBag(bag.Value.Bytes)
+		if err != nil {
+			return entry, newParseError(".bagValue", bag.Value.FullBytes, err)
+		}
+		if entry.Certificate, err = x509.ParseCertificate(certData); err != nil {
+			entry.CertificateError = errors.New("pkcs12: error parsing certificate: " + err.Error())
+		}
+		entry.RawCertDER = certData
+	case PKCS8ShroudedKeyBag:
+		entry.RawEncryptedKey = bag.Value.Bytes
+		pkData, err := dec.decryptPkcs8ShroudedKeyBag(bag.Value.Bytes, password)
+		if err == ErrIncorrectPassword && dec.partial {
+			break
+		}
+		if err == nil {
+			err = limits.addDecrypted(spent, len(pkData))
+		}
+		if err != nil {
+			wipe(pkData)
+			return entry, err
+		}
+		entry.PrivateKey, entry.KeyUsage, err = parsePrivateKeyInfo(pkData)
+		if err == nil {
+			entry.KeyAttributes, entry.PublicKey, err = parseKeyExtras(pkData)
+		}
+		wipe(pkData)
+		if err != nil {
+			return entry, newParseError(".bagValue", bag.Value.FullBytes, err)
+		}
+	case KeyBag:
+		if entry.PrivateKey, entry.KeyUsage, err = parsePrivateKeyInfo(bag.Value.Bytes); err != nil {
+			return entry, newParseError(".bagValue", bag.Value.FullBytes, err)
+		}
+		if entry.KeyAttributes, entry.PublicKey, err = parseKeyExtras(bag.Value.Bytes); err != nil {
+			return entry, newParseError(".bagValue", bag.Value.FullBytes, err)
+		}
+	case SafeContentsBag:
+		if depth >= limits.MaxDepth {
+			return entry, &LimitError{"nesting depth", limits.MaxDepth}
+		}
+		nested, err := parseSafeContents(bag.Value.Bytes)
+		if err != nil {
+			return entry, newParseError(".bagValue", bag.Value.FullBytes, errors.New("error decoding safeContentsBag: "+err.Error()))
+		}
+		if err := limits.addBags(spent, len(nested)); err != nil {
+			return entry, err
+		}
+		entry.Contents = make([]Entry, 0, len(nested))
+		for i := range nested {
+			nestedEntry, err := dec.decodeEntry(&nested[i], password, depth+1, limits, spent)
+			if err != nil {
+				return entry, locateParseError(err, ".bagValue["+strconv.Itoa(i)+"]", nil)
+			}
+			entry.Contents = append(entry.Contents, nestedEntry)
+		}
+	default:
+		entry.Value = bag.Value.Bytes
+	}
+
+	if entry.PrivateKey != nil && entry.KeyUsage == 0 {
+		if a, ok := entry.Attribute(OIDKeyUsage); ok && len(a.Values) != 0 {
+			if entry.KeyUsage, err = parseKeyUsage(a.Values[0]); err != nil {
+				return entry, newParseError(".bagAttributes", a.Values[0], err)
+			}
+		}
+	}
+	return entry, nil
+}
+
+// EncodeContents produces pfxData holding contents, such as returned by
+// DecodeContents.  Encrypted SafeContents are encrypted with the encoder's
+// certificate algorithm, and PKCS#8 shrouded key bags with its key
+// algorithm.  Attributes are written as given.
+func (enc *Encoder) EncodeContents(contents []SafeContents, password string) (pfxData []byte, err error) {
+	if err = enc.checkOptions(); err != nil {
+		return nil, err
+	}
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	authenticatedSafe := make([]contentInfo, 0, len(contents))
+	for _, sc := range contents {
+		bags := make([]safeBag, 0, len(sc.Entries))
+		for i := range sc.Entries {
+			bag, err := enc.makeEntryBag(&sc.Entries[i], encodedPassword)
+			if err != nil {
+				return nil, err
+			}
+			bags = append(bags, *bag)
+		}
+
+		var algorithm asn1.ObjectIdentifier
+		if sc.Encrypted {
+			algorithm = enc.certAlgorithm
+		}
+		ci, err := enc.makeSafeContents(bags, algorithm, encodedPassword)
+		if err != nil {
+			return nil, err
+		}
+		authenticatedSafe = append(authenticatedSafe, ci)
+	}
+
+	return enc.marshalPFX(authenticatedSafe, encodedPassword)
+}
+
+// encodeAttributes joins the values of each of attributes into a SET.
+func encodeAttributes(attributes []Attribute) (encoded []pkcs12Attribute) {
+	for _, a := range attributes {
+		attribute := pkcs12Attribute{Id: a.Type}
+		attribute.Value.Class = 0
+		attribute.Value.Tag = 17
+		attribute.Value.IsCompound = true
+		attribute.Value.Bytes = bytes.Join(a.Values, nil)
+		encoded = append(encoded, attribute)
+	}
+	return encoded
+}
+
+func (enc *Encoder) makeEntryBag(entry *Entry, password []byte) (bag *safeBag, err error) {
+	attributes := encodeAttributes(entry.Attributes)
+
+	if entry.BagType == CertBag {
+		switch {
+		case !isX509CertType(entry.CertType):
+			if len(entry.Value) == 0 {
+				return nil, errors.New("pkcs12: value missing in cert bag entry of type " + entry.CertType.String())
+			}
+			return makeOtherCertBag(entry.CertType, entry.Value, attributes)
+		case entry.Certificate != nil:
+			return makeCertBag(entry.Certificate.Raw, attributes)
+		case entry.CertificateError != nil && len(entry.RawCertDER) != 0:
+			return makeCertBag(entry.RawCertDER, attributes)
+		}
+		return nil, errors.New("pkcs12: certificate missing in cert bag entry")
+	}
+
+	bag = new(safeBag)
+	if bag.Id, err = entry.BagType.oid(); err != nil {
+		return nil, err
+	}
+	bag.Value.Class = 2
+	bag.Value.Tag = 0
+	bag.Value.IsCompound = true
+	bag.Attributes = attributes
+
+	if entry.KeyUsage != 0 {
+		enc = enc.WithKeyUsage(entry.KeyUsage)
+	}
+
+	switch entry.BagType {
+	case PKCS8ShroudedKeyBag:
+		if entry.PrivateKey == nil {
+			return nil, errors.New("pkcs12: private key missing in key bag entry")
+		}
+		pkData, err := enc.marshalEntryKey(entry)
+		if err != nil {
+			return nil, err
+		}
+		bag.Value.Bytes, err = enc.encryptPKCS8(pkData, password)
+		wipe(pkData)
+		if err != nil {
+			return nil, err
+		}
+	case KeyBag:
+		if entry.PrivateKey == nil {
+			return nil, errors.New("pkcs12: private key missing in key bag entry")
+		}
+		if bag.Value.Bytes, err = enc.marshalEntryKey(entry); err != nil {
+			return nil, err
+		}
+	case SafeContentsBag:
+		if len(entry.Value) != 0 {
+			bag.Value.Bytes = entry.Value
+			break
+		}
+		nested := make([]safeBag, 0, len(entry.Contents))
+		for i := range entry.Contents {
+			nestedBag, err := enc.makeEntryBag(&entry.Contents[i], password)
+			if err != nil {
+				return nil, err
+			}
+			nested = append(nested, *nestedBag)
+		}
+		if bag.Value.Bytes, err = asn1.Marshal(nested); err != nil {
+			return nil, errors.New("pkcs12: error encoding safeContentsBag: " + err.Error())
+		}
+	default:
+		if len(entry.Value) == 0 {
+			return nil, errors.New("pkcs12: value missing in " + string(entry.BagType) + " entry")
+		}
+		bag.Value.Bytes = entry.Value
+	}
+	return bag, nil
+}
+
+// EqualContents reports whether a and b hold the same content: the same
+// grouping into SafeContents, encrypted or not, the same entries in the
+// same order, with equal keys, identical certificates and the same set of
+// attributes.  The algorithms, salts and iteration counts used to protect
+// the content are not compared, so EqualContents can check that decoding a
+// file and encoding it again, for example with a new password, lost
+// nothing.
+func EqualContents(a, b []SafeContents) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Encrypted != b[i].Encrypted || len(a[i].Entries) != len(b[i].Entries) {
+			return false
+		}
+		for j := range a[i].Entries {
+			if !equalEntries(&a[i].Entries[j], &b[i].Entries[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Equal decodes the PKCS#12 files a and b, protected by passA and passB,
+// and reports whether they hold the same keys, certificates and other bags
+// with the same attributes.  Unlike EqualContents it ignores the order of
+// the entries and how they are grouped and encrypted, as well as the
+// algorithms, salts and IVs, so that a reconciler can tell whether a file
+// it would write differs from the one it has.  Equal returns false if
+// either file fails to decode.
+func (dec *Decoder) Equal(a, b []byte, passA, passB string) bool {
+	contentsA, err := dec.DecodeContents(a, passA)
+	if err != nil {
+		return false
+	}
+	contentsB, err := dec.DecodeContents(b, passB)
+	if err != nil {
+		return false
+	}
+	entriesA, entriesB := flatEntries(contentsA), flatEntries(contentsB)
+	if len(entriesA) != len(entriesB) {
+		return false
+	}
+
+	matched := make([]bool, len(entriesB))
+next:
+	for i := range entriesA {
+		for j := range entriesB {
+			if !matched[j] && equalEntries(&entriesA[i], &entriesB[j]) {
+				matched[j] = true
+				continue next
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Equal is equivalent to DefaultDecoder.Equal.
+func Equal(a, b []byte, passA, passB string) bool {
+	return DefaultDecoder.Equal(a, b, passA, passB)
+}
+
+// flatEntries returns the entries of contents, with those nested in
+// safeContentsBags taken out of them in place of the safeContentsBags.
+func flatEntries(contents []SafeContents) []Entry {
+	var all, entries []Entry
+	for i := range contents {
+		all = appendEntries(all, contents[i].Entries)
+	}
+	for _, entry := range all {
+		if entry.BagType != SafeContentsBag {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func equalEntries(a, b *Entry) bool {
+	if a.BagType != b.BagType || !bytes.Equal(a.Value, b.Value) || a.KeyUsage != b.KeyUsage || !bytes.Equal(a.PublicKey, b.PublicKey) {
+		return false
+	}
+	if a.BagType == CertBag && isX509CertType(a.CertType) != isX509CertType(b.CertType) {
+		return false
+	}
+	if !isX509CertType(a.CertType) && !a.CertType.Equal(b.CertType) {
+		return false
+	}
+
+	if len(a.Contents) != len(b.Contents) {
+		return false
+	}
+	for i := range a.Contents {
+		if !equalEntries(&a.Contents[i], &b.Contents[i]) {
+			return false
+		}
+	}
+
+	if (a.Certificate == nil) != (b.Certificate == nil) {
+		return false
+	}
+	if a.Certificate != nil && !a.Certificate.Equal(b.Certificate) {
+		return false
+	}
+	if (a.CertificateError == nil) != (b.CertificateError == nil) {
+		return false
+	}
+	if a.CertificateError != nil && !bytes.Equal(a.RawCertDER, b.RawCertDER) {
+		return false
+	}
+
+	if (a.PrivateKey == nil) != (b.PrivateKey == nil) {
+		return false
+	}
+	if a.PrivateKey != nil {
+		key, ok := a.PrivateKey.(interface{ Equal(crypto.PrivateKey) bool })
+		if !ok || !key.Equal(b.PrivateKey) {
+			return false
+		}
+	}
+
+	return equalAttributes(a.Attributes, b.Attributes) && equalAttributes(a.KeyAttributes, b.KeyAttributes)
+}
+
+// equalAttributes reports whether a and b hold the same attributes, in any
+// order.
+func equalAttributes(a, b []Attribute) bool {
+	canonicalA, canonicalB := canonicalAttributes(a), canonicalAttributes(b)
+	if len(canonicalA) != len(canonicalB) {
+		return false
+	}
+	for i := range canonicalA {
+		if canonicalA[i] != canonicalB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// canonicalAttributes returns a sorted, comparable form of attributes.
+// Both the attributes of a bag and the values of an attribute are sets,
+// so their order is not significant.  As DER values are self-delimiting,
+// they can be concatenated without ambiguity.
+func canonicalAttributes(attributes []Attribute) []string {
+	canonical := make([]string, 0, len(attributes))
+	for _, attribute := range attributes {
+		values := make([]string, 0, len(attribute.Values))
+		for _, value := range attribute.Values {
+			values = append(values, string(value))
+		}
+		sort.Strings(values)
+		canonical = append(canonical, attribute.Type.String()+"\x00"+strings.Join(values, ""))
+	}
+	sort.Strings(canonical)
+	return canonical
+}
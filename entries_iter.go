@@ -0,0 +1,90 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package pkcs12
+
+import (
+	"iter"
+	"strconv"
+)
+
+// Entries returns an iterator over the entries of pfxData, in the order
+// DecodeContents returns them, with the entries of all SafeContents in
+// sequence.  The MAC is verified before the first entry is produced, but
+// each encrypted SafeContents and each private key is only decrypted when
+// the iteration reaches it, so a caller looking for one entry of a large
+// trust store can stop early and skip the rest of the work.
+//
+// An error ends the iteration: it is produced along with a zero Entry.
+func (dec *Decoder) Entries(pfxData []byte, password string) iter.Seq2[Entry, error] {
+	return func(yield func(Entry, error) bool) {
+		encodedPassword, err := bmpString(password)
+		if err != nil {
+			yield(Entry{}, err)
+			return
+		}
+		defer wipe(encodedPassword)
+
+		// Decrypting ahead of time would defeat the purpose.
+		lazy := dec.WithParallelism(1)
+		if pfxData, err = lazy.unarmor(pfxData); err != nil {
+			yield(Entry{}, err)
+			return
+		}
+		authenticatedSafe, _, macPassword, err := lazy.verifyAuthenticatedSafe(pfxData, encodedPassword, nil)
+		if err != nil {
+			yield(Entry{}, err)
+			return
+		}
+
+		limits := dec.limits.withDefaults()
+		var spent usage
+		for i := range authenticatedSafe {
+			sc, err := lazy.decodeSafeContents(&authenticatedSafe[i], nil, macPassword, limits, nil)
+			if err == nil {
+				err = limits.add(&spent, &sc)
+			}
+			if err != nil {
+				yield(Entry{}, locateParseError(err, "authSafe["+strconv.Itoa(i)+"]", pfxData))
+				return
+			}
+			if sc.der == nil {
+				sc.der = pfxData
+			}
+			for j := range sc.bags {
+				entry, err := lazy.decodeEntry(&sc.bags[j], macPassword, 1, limits, &spent)
+				if err != nil {
+					yield(Entry{}, locateParseError(err, "authSafe["+strconv.Itoa(i)+"].safeContents["+strconv.Itoa(j)+"]", sc.der))
+					return
+				}
+				if !yield(entry, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Entries returns an iterator over the entries of pfxData using the
+// DefaultDecoder.  See Decoder.Entries.
+func Entries(pfxData []byte, password string) iter.Seq2[Entry, error] {
+	return DefaultDecoder.Entries(pfxData, password)
+}
+
+// Entries returns an iterator over the entries of p, in order.  The
+// entries can be changed through the pointers it yields.
+func (p *PFX) Entries() iter.Seq[*Entry] {
+	return func(yield func(*Entry) bool) {
+		for i := range p.Contents {
+			for j := range p.Contents[i].Entries {
+				if !yield(&p.Contents[i].Entries[j]) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"strconv"
+	"testing"
+)
+
+func TestEntries(t *testing.T) {
+	certs := map[string]*x509.Certificate{}
+	for i := 0; i < 20; i++ {
+		_, cert := makeTestCertificate(t, "CA "+strconv.Itoa(i), true, nil, nil)
+		certs["ca-"+strconv.Itoa(i)] = cert
+	}
+	pfxData, err := Modern.EncodeTrustStore(certs, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	for entry, err := range Entries(pfxData, "password") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		n++
+		if !entry.Certificate.Equal(certs[entry.FriendlyName()]) {
+			t.Errorf("entry %q has the wrong certificate", entry.FriendlyName())
+		}
+	}
+	if n != len(certs) {
+		t.Errorf("expected %d entries, got %d", len(certs), n)
+	}
+
+	for _, err := range Entries(pfxData, "wrong") {
+		if err != ErrIncorrectPassword {
+			t.Errorf("expected ErrIncorrectPassword, got %v", err)
+		}
+	}
+}
+
+// TestEntriesLazy checks that a SafeContents is not decrypted before the
+// iteration reaches it: the second SafeContents of the file does not
+// decrypt with the password of the MAC, which only matters when iterating
+// past the first.
+func TestEntriesLazy(t *testing.T) {
+	_, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	macPassword, _ := bmpString("mac")
+	otherPassword, _ := bmpString("other")
+
+	certBags, _, err := Modern.makeChainBags(cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainCI, err := Modern.makeSafeContents(certBags, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedCI, err := Modern.makeSafeContents(certBags, Modern.certAlgorithm, otherPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := Modern.marshalPFX([]contentInfo{plainCI, encryptedCI}, macPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for entry, err := range Entries(pfxData, "mac") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !entry.Certificate.Equal(cert) {
+			t.Error("unexpected first entry")
+		}
+		break
+	}
+
+	var last error
+	for _, err := range Entries(pfxData, "mac") {
+		last = err
+	}
+	if last != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword from the second SafeContents, got %v", last)
+	}
+}
+
+func TestEntriesParseError(t *testing.T) {
+	pfxData := makeParseErrorPFX(t, nil)
+	_, expected := DecodeContents(pfxData, "password")
+
+	var err error
+	for _, err = range Entries(pfxData, "password") {
+		if err != nil {
+			break
+		}
+	}
+	if err == nil || expected == nil || err.Error() != expected.Error() {
+		t.Errorf("expected %v, got %v", expected, err)
+	}
+}
@@ -0,0 +1,373 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentsRoundTrip(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	_, otherCA := makeTestCertificate(t, "Other CA", true, nil, nil)
+
+	files := map[string][]byte{}
+	var err error
+	if files["Modern.Encode"], err = Modern.Encode(key, cert, []*x509.Certificate{caCert, otherCA}, "password"); err != nil {
+		t.Fatal(err)
+	}
+	if files["Modern.EncodeTrustStore"], err = Modern.EncodeTrustStore(map[string]*x509.Certificate{"ca": caCert}, "password"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := filepath.Glob(filepath.Join("testdata", "openssl-*.p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if files[name], err = os.ReadFile(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, pfxData := range files {
+		contents, err := DecodeContents(pfxData, "password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		reencoded, err := Modern.EncodeContents(contents, "new password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		roundTripped, err := DecodeContents(reencoded, "new password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !EqualContents(contents, roundTripped) {
+			t.Errorf("%s: content changed by re-encoding", name)
+		}
+	}
+
+	contents, err := DecodeContents(files["Modern.Encode"], "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 2 || !contents[0].Encrypted || len(contents[0].Entries) != 3 || contents[1].Encrypted || len(contents[1].Entries) != 1 {
+		t.Fatalf("unexpected structure %+v", contents)
+	}
+	for i, expected := range []*x509.Certificate{cert, caCert, otherCA} {
+		if entry := contents[0].Entries[i]; entry.BagType != CertBag || !entry.Certificate.Equal(expected) {
+			t.Errorf("entry %d: expected %s", i, expected.Subject)
+		}
+	}
+	keyEntry := contents[1].Entries[0]
+	if keyEntry.BagType != PKCS8ShroudedKeyBag || !bytes.Equal(keyEntry.LocalKeyID(), contents[0].Entries[0].LocalKeyID()) {
+		t.Errorf("key entry does not reference the leaf certificate")
+	}
+}
+
+func TestEqualContents(t *testing.T) {
+	_, certA := makeTestCertificate(t, "a", true, nil, nil)
+	_, certB := makeTestCertificate(t, "b", true, nil, nil)
+	friendlyName := func(name string) Attribute {
+		value, err := marshalBmpString(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Attribute{Type: oidFriendlyName, Values: [][]byte{value}}
+	}
+	localKeyID := Attribute{Type: oidLocalKeyID, Values: [][]byte{{0x04, 0x01, 0x2a}}}
+
+	base := []SafeContents{{Encrypted: true, Entries: []Entry{
+		{BagType: CertBag, Certificate: certA, Attributes: []Attribute{friendlyName("a"), localKeyID}},
+		{BagType: CertBag, Certificate: certB},
+	}}}
+
+	for name, test := range map[string]struct {
+		contents []SafeContents
+		equal    bool
+	}{
+		"attribute order": {[]SafeContents{{Encrypted: true, Entries: []Entry{
+			{BagType: CertBag, Certificate: certA, Attributes: []Attribute{localKeyID, friendlyName("a")}},
+			{BagType: CertBag, Certificate: certB},
+		}}}, true},
+		"entry order": {[]SafeContents{{Encrypted: true, Entries: []Entry{
+			{BagType: CertBag, Certificate: certB},
+			{BagType: CertBag, Certificate: certA, Attributes: []Attribute{friendlyName("a"), localKeyID}},
+		}}}, false},
+		"attribute value": {[]SafeContents{{Encrypted: true, Entries: []Entry{
+			{BagType: CertBag, Certificate: certA, Attributes: []Attribute{friendlyName("b"), localKeyID}},
+			{BagType: CertBag, Certificate: certB},
+		}}}, false},
+		"encryption": {[]SafeContents{{Encrypted: false, Entries: base[0].Entries}}, false},
+		"grouping": {[]SafeContents{
+			{Encrypted: true, Entries: base[0].Entries[:1]},
+			{Encrypted: true, Entries: base[0].Entries[1:]},
+		}, false},
+	} {
+		if EqualContents(base, test.contents) != test.equal {
+			t.Errorf("%s: expected EqualContents to be %v", name, test.equal)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	_, otherCA := makeTestCertificate(t, "Other CA", true, nil, nil)
+
+	pfxData, err := Modern.Encode(key, cert, []*x509.Certificate{caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same content with fresh salts and IVs, under another password.
+	reencoded, err := Modern.Encode(key, cert, []*x509.Certificate{caCert}, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(pfxData, reencoded, "password", "other") {
+		t.Error("files with the same content are not equal")
+	}
+
+	// The same entries in a different order and grouping.
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := contents[0].Entries
+	regrouped := []SafeContents{
+		{Entries: []Entry{contents[1].Entries[0], entries[1]}},
+		{Encrypted: true, Entries: []Entry{{BagType: SafeContentsBag, Contents: entries[:1]}}},
+	}
+	if reordered, err := Modern.EncodeContents(regrouped, "password"); err != nil {
+		t.Fatal(err)
+	} else if !Equal(pfxData, reordered, "password", "password") {
+		t.Error("reordering the entries changed the content")
+	}
+
+	different, err := Modern.Encode(key, cert, []*x509.Certificate{otherCA}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Equal(pfxData, different, "password", "password") {
+		t.Error("files with different CA certificates are equal")
+	}
+	if Equal(pfxData, reencoded, "password", "password") {
+		t.Error("a file with the wrong password is equal")
+	}
+}
+
+func TestDecodeContentsUnparsedCertificate(t *testing.T) {
+	_, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	password, _ := bmpString("password")
+	var bags []safeBag
+	for _, der := range [][]byte{cert.Raw, badCert} {
+		bag, err := makeCertBag(der, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bags = append(bags, *bag)
+	}
+	ci, err := Modern.makeSafeContents(bags, Modern.certAlgorithm, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := Modern.marshalPFX([]contentInfo{ci}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := contents[0].Entries
+	if len(entries) != 2 || !entries[0].Certificate.Equal(cert) || entries[0].CertificateError != nil {
+		t.Fatal("the certificate which parses was not decoded")
+	}
+	if unparsed := entries[1]; unparsed.Certificate != nil || unparsed.CertificateError == nil || !bytes.Equal(unparsed.RawCertDER, badCert) {
+		t.Errorf("expected the raw certificate with an error, got %v", unparsed.CertificateError)
+	}
+
+	reencoded, err := Modern.EncodeContents(contents, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if roundTripped, err := DecodeContents(reencoded, "password"); err != nil || !EqualContents(contents, roundTripped) {
+		t.Errorf("the certificate which does not parse was lost: %v", err)
+	}
+
+	if _, _, _, err := DecodeChain(pfxData, "password"); err == nil {
+		t.Error("DecodeChain accepted a certificate which does not parse")
+	}
+}
+
+func TestDecodeContentsRawFields(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certEntry, keyEntry := contents[0].Entries[0], contents[1].Entries[0]
+	if !bytes.Equal(certEntry.RawCertDER, cert.Raw) {
+		t.Error("RawCertDER differs from the encoded certificate")
+	}
+	if keyEntry.RawCertDER != nil || certEntry.RawEncryptedKey != nil {
+		t.Error("raw field set for the wrong bag type")
+	}
+	decrypted, err := DefaultDecoder.DecryptPrivateKey(keyEntry.RawEncryptedKey, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MatchKeyToCert(decrypted, cert); err != nil {
+		t.Error(err)
+	}
+
+	for _, entry := range []Entry{certEntry, keyEntry} {
+		var bag safeBag
+		if err := unmarshal(entry.RawBag, &bag); err != nil {
+			t.Fatalf("%s: %v", entry.BagType, err)
+		}
+		if bagTypeFor(bag.Id) != entry.BagType || len(bag.Attributes) != len(entry.Attributes) {
+			t.Errorf("%s: RawBag does not hold the bag", entry.BagType)
+		}
+	}
+}
+
+func TestNestedContents(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	secret := []byte{0x30, 0x03, 0x02, 0x01, 0x2a}
+
+	contents := []SafeContents{
+		{Encrypted: true, Entries: []Entry{
+			{BagType: SafeContentsBag, Attributes: []Attribute{LocalKeyIDAttribute([]byte{1})}, Contents: []Entry{
+				{BagType: CertBag, Certificate: cert},
+				{BagType: SafeContentsBag, Contents: []Entry{
+					{BagType: SecretBag, Value: secret},
+				}},
+			}},
+		}},
+		{Entries: []Entry{
+			{BagType: SafeContentsBag, Contents: []Entry{
+				{BagType: PKCS8ShroudedKeyBag, PrivateKey: key},
+			}},
+		}},
+	}
+	pfxData, err := Modern.EncodeContents(contents, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !EqualContents(contents, decoded) {
+		t.Error("nested contents changed by encoding")
+	}
+	if nested := decoded[0].Entries[0].Contents; len(nested) != 2 || !nested[0].Certificate.Equal(cert) || !bytes.Equal(nested[1].Contents[0].Value, secret) {
+		t.Errorf("unexpected nested entries %+v", nested)
+	}
+
+	// The nested key and certificate are found by DecodeChain too.
+	privateKey, certificate, _, err := DecodeChain(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MatchKeyToCert(privateKey, certificate); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := DefaultDecoder.WithLimits(Limits{MaxBags: 4}).DecodeContents(pfxData, "password"); err == nil {
+		t.Error("nested bags not counted against MaxBags")
+	}
+}
+
+func TestDecodeContentsDetached(t *testing.T) {
+	_, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	password, _ := bmpString(DefaultPassword)
+
+	certBags, _, err := Modern.makeChainBags(cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainCI, err := Modern.makeSafeContents(certBags, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detachedCI, err := Modern.makeSafeContents(certBags, Modern.certAlgorithm, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data encryptedData
+	if err := unmarshal(detachedCI.Content.Bytes, &data); err != nil {
+		t.Fatal(err)
+	}
+	data.EncryptedContentInfo.EncryptedContent = nil
+	content, err := asn1.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detachedCI.Content = asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: content}
+	pfxData, err := Modern.marshalPFX([]contentInfo{plainCI, detachedCI}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := DecodeContents(pfxData, DefaultPassword)
+	if err != ErrDetachedContent {
+		t.Errorf("expected ErrDetachedContent, got %v", err)
+	}
+	if len(contents) != 1 || contents[0].Encrypted || len(contents[0].Entries) != 1 || !contents[0].Entries[0].Certificate.Equal(cert) {
+		t.Errorf("unexpected contents %+v", contents)
+	}
+	if _, err := DecodeTrustStore(pfxData, DefaultPassword); err != ErrDetachedContent {
+		t.Errorf("DecodeTrustStore: expected ErrDetachedContent, got %v", err)
+	}
+	for _, dec := range []*Decoder{DefaultDecoder, DefaultDecoder.WithParallelism(1)} {
+		if _, _, err := dec.Decode(pfxData, DefaultPassword); err != ErrDetachedContent {
+			t.Errorf("Decode: expected ErrDetachedContent, got %v", err)
+		}
+	}
+}
+
+func TestFriendlyNames(t *testing.T) {
+	var values [][]byte
+	for _, name := range []string{"first", "second", "first"} {
+		value, err := friendlyNameValue(name, NameBMPString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	utf8, err := friendlyNameValue("third", NameUTF8String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := Entry{BagType: CertBag, Attributes: []Attribute{
+		{Type: oidFriendlyName, Values: values},
+		{Type: oidFriendlyName, Values: [][]byte{{0x02, 0x01, 0x00}, utf8}},
+	}}
+
+	if name := entry.FriendlyName(); name != "first" {
+		t.Errorf("FriendlyName returned %q, expected the first value", name)
+	}
+	if names := entry.FriendlyNames(); len(names) != 3 || names[0] != "first" || names[1] != "second" || names[2] != "third" {
+		t.Errorf("unexpected friendlyNames %q", names)
+	}
+	if names := (&Entry{}).FriendlyNames(); names != nil {
+		t.Errorf("unexpected friendlyNames %q of an entry without any", names)
+	}
+}
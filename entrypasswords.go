@@ -0,0 +1,106 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import "strconv"
+
+// An EntryPasswordError reports a private key of a file decoded by
+// DecodeContentsPartial which does not decrypt with the password of the
+// file, see PartialDecodeError.  The java keytool gives every entry of a PKCS#12 keystore a
+// password of its own in principle, but requires it to be the password of
+// the store, and cannot recover such a key.
+type EntryPasswordError struct {
+	// Entry is the PKCS#8 shrouded key bag in the contents returned by
+	// DecodeContentsPartial.  Its PrivateKey is nil and RawEncryptedKey
+	// holds the key as stored.
+	Entry *Entry
+	// FriendlyName is the friendlyName of the entry, or "" if it has none.
+	FriendlyName string
+}
+
+func (e *EntryPasswordError) Error() string {
+	if e.FriendlyName == "" {
+		return "pkcs12: private key is encrypted with another password than the file"
+	}
+	return "pkcs12: private key " + strconv.Quote(e.FriendlyName) + " is encrypted with another password than the file"
+}
+
+// Unwrap returns ErrIncorrectPassword.
+func (e *EntryPasswordError) Unwrap() error {
+	return ErrIncorrectPassword
+}
+
+// A PartialDecodeError is returned by DecodeContentsPartial, along with
+// the contents it could decode, for the parts of a file which do not
+// decrypt with the password of its MAC.
+type PartialDecodeError struct {
+	// SafeContents are the positions in the authenticated safe of the
+	// encrypted SafeContents which did not decrypt.  They are left out of
+	// the contents.
+	SafeContents []int
+	// Keys are the private keys which did not decrypt, in order.
+	Keys []*EntryPasswordError
+}
+
+func (e *PartialDecodeError) Error() string {
+	return "pkcs12: " + strconv.Itoa(len(e.SafeContents)) + " SafeContents and " + strconv.Itoa(len(e.Keys)) + " private keys are encrypted with another password than the file"
+}
+
+// Unwrap returns ErrIncorrectPassword.
+func (e *PartialDecodeError) Unwrap() error {
+	return ErrIncorrectPassword
+}
+
+// DecodeContentsPartial is like DecodeContents, but salvages what it can
+// of a file whose parts are protected by different passwords.  The MAC
+// must verify with password.  An encrypted SafeContents which does not
+// decrypt with it is left out, and a private key which does not decrypt is
+// kept without its PrivateKey, the way the java keytool loads a keystore;
+// both are then listed by a *PartialDecodeError, returned together with
+// the contents decoded.  EncodeContents refuses the listed keys until they
+// are removed or their PrivateKey set.  Any other error fails the whole
+// file, and the contents are nil.
+func (dec *Decoder) DecodeContentsPartial(pfxData []byte, password string) ([]SafeContents, error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	partial := *dec
+	partial.partial = true
+	contents, undecrypted, err := partial.decodeAllContents(pfxData, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*EntryPasswordError
+	for i := range contents {
+		keys = appendEntryPasswordErrors(keys, contents[i].Entries)
+	}
+	if len(undecrypted) != 0 || len(keys) != 0 {
+		return contents, &PartialDecodeError{SafeContents: undecrypted, Keys: keys}
+	}
+	return contents, nil
+}
+
+// DecodeContentsPartial is DefaultDecoder.DecodeContentsPartial.
+func DecodeContentsPartial(pfxData []byte, password string) ([]SafeContents, error) {
+	return DefaultDecoder.DecodeContentsPartial(pfxData, password)
+}
+
+// appendEntryPasswordErrors appends an EntryPasswordError for each private
+// key of entries, including nested ones, which could not be decrypted.
+func appendEntryPasswordErrors(errs []*EntryPasswordError, entries []Entry) []*EntryPasswordError {
+	for i := range entries {
+		entry := &entries[i]
+		if entry.BagType == PKCS8ShroudedKeyBag && entry.PrivateKey == nil {
+			errs = append(errs, &EntryPasswordError{Entry: entry, FriendlyName: entry.FriendlyName()})
+		}
+		errs = appendEntryPasswordErrors(errs, entry.Contents)
+	}
+	return errs
+}
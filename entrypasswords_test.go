@@ -0,0 +1,135 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// makeEntryPasswordPFX returns a PFX protected by "password" with two
+// private keys: "good", encrypted with "password" too, and "stray",
+// encrypted with "other".
+func makeEntryPasswordPFX(t *testing.T) []byte {
+	password, _ := bmpString("password")
+	other, _ := bmpString("other")
+
+	var certBags, keyBags []safeBag
+	for _, name := range []string{"good", "stray"} {
+		key, cert := makeTestCertificate(t, name, false, nil, nil)
+		bags, localKeyIdAttr, err := Modern.makeChainBags(cert, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nameAttr, err := friendlyNameBagAttribute(name, NameBMPString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPassword := password
+		if name == "stray" {
+			keyPassword = other
+		}
+
+		var keyBag safeBag
+		keyBag.Id = oidPKCS8ShroundedKeyBag
+		keyBag.Value.Class = 2
+		keyBag.Value.Tag = 0
+		keyBag.Value.IsCompound = true
+		if keyBag.Value.Bytes, err = Modern.encodePkcs8ShroudedKeyBag(key, keyPassword); err != nil {
+			t.Fatal(err)
+		}
+		keyBag.Attributes = append(keyBag.Attributes, localKeyIdAttr, nameAttr)
+		bags[0].Attributes = append(bags[0].Attributes, nameAttr)
+		certBags = append(certBags, bags...)
+		keyBags = append(keyBags, keyBag)
+	}
+
+	certsCI, err := Modern.makeSafeContents(certBags, Modern.certAlgorithm, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyCI, err := Modern.makeSafeContents(keyBags, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := Modern.marshalPFX([]contentInfo{certsCI, keyCI}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pfxData
+}
+
+func TestDecodeContentsPartial(t *testing.T) {
+	pfxData := makeEntryPasswordPFX(t)
+
+	if _, err := DecodeContents(pfxData, "password"); err != ErrIncorrectPassword {
+		t.Errorf("expected DecodeContents to fail with ErrIncorrectPassword, got %v", err)
+	}
+
+	contents, err := DecodeContentsPartial(pfxData, "password")
+	var partialErr *PartialDecodeError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("expected a PartialDecodeError, got %v", err)
+	}
+	if !errors.Is(err, ErrIncorrectPassword) || len(partialErr.SafeContents) != 0 || len(partialErr.Keys) != 1 {
+		t.Fatalf("expected one private key to fail, got %+v", partialErr)
+	}
+	keyErr := partialErr.Keys[0]
+	if keyErr.FriendlyName != "stray" || keyErr.Entry != &contents[1].Entries[1] {
+		t.Errorf("unexpected entry reported: %+v", keyErr)
+	}
+	if keyErr.Entry.PrivateKey != nil || len(keyErr.Entry.RawEncryptedKey) == 0 {
+		t.Error("expected the raw encrypted key only")
+	}
+	if !errors.Is(keyErr, ErrIncorrectPassword) || !strings.Contains(keyErr.Error(), `"stray"`) {
+		t.Errorf("unexpected error %v", keyErr)
+	}
+	if good := contents[1].Entries[0]; good.PrivateKey == nil || good.FriendlyName() != "good" {
+		t.Error("private key encrypted with the password of the file not decoded")
+	}
+
+	if contents, err := DecodeContentsPartial(pfxData, "other"); err != ErrIncorrectPassword || contents != nil {
+		t.Errorf("expected ErrIncorrectPassword for the MAC, got %v", err)
+	}
+
+	var critical []Finding
+	for _, f := range Validate(pfxData, "password") {
+		if f.Severity == SeverityCritical {
+			critical = append(critical, f)
+		}
+	}
+	if len(critical) != 1 || !strings.Contains(critical[0].Message, `"stray"`) {
+		t.Errorf("expected Validate to report the stray key only, got %+v", critical)
+	}
+}
+
+func TestDecodeContentsPartialSafeContents(t *testing.T) {
+	pfxData := makeMultiPasswordPFX(t)
+
+	contents, err := DecodeContentsPartial(pfxData, "mac")
+	var partialErr *PartialDecodeError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("expected a PartialDecodeError, got %v", err)
+	}
+	if len(partialErr.SafeContents) != 1 || partialErr.SafeContents[0] != 0 || len(partialErr.Keys) != 1 {
+		t.Errorf("expected the certificates and the key to fail, got %+v", partialErr)
+	}
+	if len(contents) != 1 || len(contents[0].Entries) != 1 || contents[0].Entries[0].BagType != PKCS8ShroudedKeyBag {
+		t.Errorf("expected the SafeContents of the key only, got %+v", contents)
+	}
+
+	if _, err := DecodeContents(pfxData, "mac"); err != ErrIncorrectPassword {
+		t.Errorf("expected DecodeContents to fail with ErrIncorrectPassword, got %v", err)
+	}
+	found := false
+	for _, f := range Validate(pfxData, "mac") {
+		found = found || (f.Severity == SeverityCritical && f.Message == "SafeContents 1 is encrypted with another password than the file")
+	}
+	if !found {
+		t.Error("Validate did not report the SafeContents")
+	}
+}
@@ -0,0 +1,288 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	oidEnvelopedDataContentType = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 7, 3})
+
+	oidRSAEncryption = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 1, 1})
+	oidRSAESOAEP     = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 1, 7})
+	oidMGF1          = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 1, 8})
+	oidPSpecified    = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 1, 9})
+
+	oidDESEDE3CBC = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 3, 7})
+)
+
+// A recipient is a certificate and private key given to WithRecipient.
+type recipient struct {
+	cert *x509.Certificate
+	key  crypto.Decrypter
+}
+
+// WithRecipient creates a new Decoder identical to dec except that it can
+// open SafeContents protected with public-key privacy: CMS EnvelopedData
+// (https://tools.ietf.org/html/rfc5652#section-6) addressed to cert, whose
+// content-encryption key is unwrapped with key.  key may live on a token
+// such as a smartcard.  Only RSA key transport, with PKCS#1 v1.5 or OAEP
+// padding, and AES-CBC or 3DES-CBC content encryption are supported.
+// WithRecipient may be called several times to try more than one
+// certificate.  The integrity of the file is still verified with the
+// password, which may be empty; files protected with a public-key
+// signature instead of a MAC are not supported.
+func (dec Decoder) WithRecipient(cert *x509.Certificate, key crypto.Decrypter) *Decoder {
+	dec.recipients = append(dec.recipients[:len(dec.recipients):len(dec.recipients)], recipient{cert, key})
+	return &dec
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	RecipientIdentifier    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// matches reports whether the RecipientIdentifier of ri designates cert,
+// either by issuer and serial number or by subject key identifier.
+func (ri *keyTransRecipientInfo) matches(cert *x509.Certificate) bool {
+	rid := ri.RecipientIdentifier
+	switch {
+	case rid.Class == asn1.ClassUniversal && rid.Tag == asn1.TagSequence:
+		var ias issuerAndSerialNumber
+		if err := unmarshal(rid.FullBytes, &ias); err != nil {
+			return false
+		}
+		return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+	case rid.Class == asn1.ClassContextSpecific && rid.Tag == 0:
+		return len(cert.SubjectKeyId) != 0 && bytes.Equal(rid.Bytes, cert.SubjectKeyId)
+	}
+	return false
+}
+
+type rsaOAEPParams struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:2"`
+}
+
+// oaepHashFor returns the hash function identified by a digest algorithm of
+// RSAES-OAEP, where an absent algorithm means SHA-1.
+func oaepHashFor(algorithm asn1.ObjectIdentifier) (crypto.Hash, error) {
+	if len(algorithm) == 0 {
+		return crypto.SHA1, nil
+	}
+	for hash, oid := range macAlgorithms {
+		if algorithm.Equal(oid) {
+			return hash, nil
+		}
+	}
+	return 0, NotImplementedError("unknown RSAES-OAEP digest algorithm: " + algorithm.String())
+}
+
+// decrypterOptsFor returns the options with which key transport algorithm
+// is decrypted by a crypto.Decrypter, for a content-encryption key of
+// keyLen bytes.
+func decrypterOptsFor(algorithm pkix.AlgorithmIdentifier, keyLen int) (crypto.DecrypterOpts, error) {
+	switch {
+	case algorithm.Algorithm.Equal(oidRSAEncryption):
+		// A wrong padding yields a random key, which then fails to decrypt
+		// the content, rather than an error a padding oracle could use.
+		return &rsa.PKCS1v15DecryptOptions{SessionKeyLen: keyLen}, nil
+	case algorithm.Algorithm.Equal(oidRSAESOAEP):
+		var params rsaOAEPParams
+		if len(algorithm.Parameters.FullBytes) != 0 {
+			if err := unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+				return nil, errors.New("pkcs12: error decoding RSAES-OAEP parameters: " + err.Error())
+			}
+		}
+		hash, err := oaepHashFor(params.HashAlgorithm.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		mgfHash := crypto.SHA1
+		if len(params.MaskGenAlgorithm.Algorithm) != 0 {
+			if !params.MaskGenAlgorithm.Algorithm.Equal(oidMGF1) {
+				return nil, NotImplementedError("RSAES-OAEP mask generation function " + params.MaskGenAlgorithm.Algorithm.String() + " is not supported")
+			}
+			var mgfDigest pkix.AlgorithmIdentifier
+			if err := unmarshal(params.MaskGenAlgorithm.Parameters.FullBytes, &mgfDigest); err != nil {
+				return nil, errors.New("pkcs12: error decoding RSAES-OAEP parameters: " + err.Error())
+			}
+			if mgfHash, err = oaepHashFor(mgfDigest.Algorithm); err != nil {
+				return nil, err
+			}
+		}
+		var label []byte
+		if len(params.PSourceAlgorithm.Algorithm) != 0 {
+			if !params.PSourceAlgorithm.Algorithm.Equal(oidPSpecified) {
+				return nil, NotImplementedError("RSAES-OAEP label source " + params.PSourceAlgorithm.Algorithm.String() + " is not supported")
+			}
+			if err := unmarshal(params.PSourceAlgorithm.Parameters.FullBytes, &label); err != nil {
+				return nil, errors.New("pkcs12: error decoding RSAES-OAEP parameters: " + err.Error())
+			}
+		}
+		return &rsa.OAEPOptions{Hash: hash, MGFHash: mgfHash, Label: label}, nil
+	}
+	return nil, NotImplementedError("key transport algorithm " + algorithm.Algorithm.String() + " is not supported")
+}
+
+// contentKeyLen returns the key length in bytes of a CMS content-encryption
+// algorithm.
+func contentKeyLen(algorithm asn1.ObjectIdentifier) (int, error) {
+	switch {
+	case algorithm.Equal(oidAES128CBC):
+		return 16, nil
+	case algorithm.Equal(oidAES192CBC):
+		return 24, nil
+	case algorithm.Equal(oidAES256CBC):
+		return 32, nil
+	case algorithm.Equal(oidDESEDE3CBC):
+		return 24, nil
+	}
+	return 0, NotImplementedError("content encryption algorithm " + algorithm.String() + " is not supported")
+}
+
+// contentDecrypt decrypts the content of info with the content-encryption
+// key.
+func contentDecrypt(info *encryptedContentInfo, key []byte) ([]byte, error) {
+	algorithm := info.ContentEncryptionAlgorithm
+	var block cipher.Block
+	var err error
+	if algorithm.Algorithm.Equal(oidDESEDE3CBC) {
+		block, err = des.NewTripleDESCipher(key)
+	} else {
+		block, err = aes.NewCipher(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var iv []byte
+	if err := unmarshal(algorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, errors.New("pkcs12: error decoding content encryption IV: " + err.Error())
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.New("pkcs12: invalid content encryption IV length")
+	}
+	return cbcDecrypt(cipher.NewCBCDecrypter(block, iv), block.BlockSize(), info.EncryptedContent)
+}
+
+// parseEnvelopedData decodes the EnvelopedData content of ci, returning its
+// KeyTransRecipientInfos and its encrypted content.  Other kinds of
+// RecipientInfo are skipped.
+func parseEnvelopedData(ci *contentInfo) ([]keyTransRecipientInfo, *encryptedContentInfo, error) {
+	// EnvelopedData is parsed element by element since encoding/asn1
+	// cannot match the optional IMPLICIT [0] originatorInfo to a RawValue
+	// field.
+	var envelopedData asn1.RawValue
+	if err := unmarshal(ci.Content.Bytes, &envelopedData); err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding enveloped data: " + err.Error())
+	}
+	var version int
+	rest, err := asn1.Unmarshal(envelopedData.Bytes, &version)
+	if err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding enveloped data: " + err.Error())
+	}
+	var recipientInfos asn1.RawValue
+	if rest, err = asn1.Unmarshal(rest, &recipientInfos); err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding enveloped data: " + err.Error())
+	}
+	if recipientInfos.Class == asn1.ClassContextSpecific && recipientInfos.Tag == 0 {
+		if rest, err = asn1.Unmarshal(rest, &recipientInfos); err != nil {
+			return nil, nil, errors.New("pkcs12: error decoding enveloped data: " + err.Error())
+		}
+	}
+	if recipientInfos.Class != asn1.ClassUniversal || recipientInfos.Tag != asn1.TagSet {
+		return nil, nil, errors.New("pkcs12: error decoding enveloped data: expected a set of recipient infos")
+	}
+	// Unprotected attributes may follow and are ignored.
+	info := new(encryptedContentInfo)
+	if _, err := asn1.Unmarshal(rest, info); err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding enveloped data: " + err.Error())
+	}
+
+	var recipients []keyTransRecipientInfo
+	for rest := recipientInfos.Bytes; len(rest) != 0; {
+		var ri asn1.RawValue
+		if rest, err = asn1.Unmarshal(rest, &ri); err != nil {
+			return nil, nil, errors.New("pkcs12: error decoding recipient info: " + err.Error())
+		}
+		if ri.Class != asn1.ClassUniversal || ri.Tag != asn1.TagSequence {
+			continue
+		}
+		var ktri keyTransRecipientInfo
+		if err := unmarshal(ri.FullBytes, &ktri); err != nil {
+			return nil, nil, errors.New("pkcs12: error decoding recipient info: " + err.Error())
+		}
+		recipients = append(recipients, ktri)
+	}
+	return recipients, info, nil
+}
+
+// openEnvelopedData decrypts the EnvelopedData content of ci with the first
+// recipient given to WithRecipient that it is addressed to.
+func (dec *Decoder) openEnvelopedData(ci *contentInfo) ([]byte, *encryptedContentInfo, error) {
+	if len(dec.recipients) == 0 {
+		return nil, nil, NotImplementedError("enveloped data is only decoded by a Decoder created by WithRecipient")
+	}
+	recipientInfos, info, err := parseEnvelopedData(ci)
+	if err != nil {
+		return nil, nil, err
+	}
+	algorithm := info.ContentEncryptionAlgorithm.Algorithm
+	if dec.fips && !algorithm.Equal(oidAES128CBC) && !algorithm.Equal(oidAES192CBC) && !algorithm.Equal(oidAES256CBC) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNonFIPSAlgorithm, algorithm)
+	}
+	keyLen, err := contentKeyLen(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, r := range dec.recipients {
+		for i := range recipientInfos {
+			ri := &recipientInfos[i]
+			if !ri.matches(r.cert) {
+				continue
+			}
+			// PKCS#1 v1.5 key transport is no longer approved.
+			if dec.fips && !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAESOAEP) {
+				return nil, nil, fmt.Errorf("%w: %s", ErrNonFIPSAlgorithm, ri.KeyEncryptionAlgorithm.Algorithm)
+			}
+			opts, err := decrypterOptsFor(ri.KeyEncryptionAlgorithm, keyLen)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, err := r.key.Decrypt(rand.Reader, ri.EncryptedKey, opts)
+			if err != nil || len(key) != keyLen {
+				return nil, nil, ErrDecryption
+			}
+			data, err := contentDecrypt(info, key)
+			wipe(key)
+			return data, info, err
+		}
+	}
+	return nil, nil, errors.New("pkcs12: enveloped data is not addressed to any certificate given to WithRecipient")
+}
@@ -0,0 +1,140 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"testing"
+)
+
+// The testdata/cms-*.p12 files hold a single SafeContents, with the
+// certificates and a plain key bag of the interop files, encrypted to the
+// certificate in testdata/cms-recipient.pem by OpenSSL 3.0 with
+//
+//	openssl cms -encrypt -binary -outform DER <options>
+//
+// and wrapped in a PFX whose MAC uses the password "password".
+var envelopedDataTests = []struct {
+	file    string
+	options string
+}{
+	{"cms-aes256.p12", "-aes256 -recip cms-recipient.pem"},
+	{"cms-3des-oaep.p12", "-des3 -keyid -recip cms-recipient.pem -keyopt rsa_padding_mode:oaep -keyopt rsa_oaep_md:sha256"},
+}
+
+func loadRecipient(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+
+	pemData, err := os.ReadFile("testdata/cms-recipient.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cert *x509.Certificate
+	var key *rsa.PrivateKey
+	for block, rest := pem.Decode(pemData); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err = x509.ParseCertificate(block.Bytes)
+		case "PRIVATE KEY":
+			var k interface{}
+			k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			key, _ = k.(*rsa.PrivateKey)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cert == nil || key == nil {
+		t.Fatal("cms-recipient.pem does not hold an RSA key and certificate")
+	}
+	return cert, key
+}
+
+func TestDecodeEnvelopedData(t *testing.T) {
+	cert, key := loadRecipient(t)
+	dec := DefaultDecoder.WithRecipient(cert, key)
+
+	for _, test := range envelopedDataTests {
+		pfxData, err := os.ReadFile("testdata/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := test.file + " (" + test.options + ")"
+
+		privateKey, leaf, caCerts, err := dec.DecodeChain(pfxData, "password")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if _, ok := privateKey.(*rsa.PrivateKey); !ok {
+			t.Errorf("%s: expected an RSA key, got %T", name, privateKey)
+		}
+		if leaf.Subject.CommonName != "interop.example.com" {
+			t.Errorf("%s: unexpected leaf %s", name, leaf.Subject)
+		}
+		if len(caCerts) != 1 || caCerts[0].Subject.CommonName != "Interop CA" {
+			t.Errorf("%s: expected the interop CA", name)
+		}
+
+		contents, err := dec.DecodeContents(pfxData, "password")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if len(contents) != 1 || !contents[0].Encrypted || len(contents[0].Entries) != 3 {
+			t.Errorf("%s: unexpected structure %+v", name, contents)
+		}
+
+		if _, _, err := DefaultDecoder.Decode(pfxData, "password"); err == nil {
+			t.Errorf("%s: decoded without a recipient", name)
+		} else if _, ok := err.(NotImplementedError); !ok {
+			t.Errorf("%s: expected a NotImplementedError without a recipient, got %v", name, err)
+		}
+		if _, _, err := dec.Decode(pfxData, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected ErrIncorrectPassword for a wrong MAC password, got %v", name, err)
+		}
+	}
+}
+
+func TestDecodeEnvelopedDataRecipients(t *testing.T) {
+	cert, key := loadRecipient(t)
+	pfxData, err := os.ReadFile("testdata/cms-3des-oaep.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, other := makeTestCertificate(t, "other", false, nil, nil)
+	if _, _, _, err := DefaultDecoder.WithRecipient(other, key).DecodeChain(pfxData, "password"); err == nil {
+		t.Error("decoded with a certificate the data is not addressed to")
+	}
+
+	// Certificates are tried in order until one matches.
+	if _, _, _, err := DefaultDecoder.WithRecipient(other, key).WithRecipient(cert, key).DecodeChain(pfxData, "password"); err != nil {
+		t.Errorf("second recipient: %v", err)
+	}
+
+	wrongKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := DefaultDecoder.WithRecipient(cert, wrongKey).DecodeChain(pfxData, "password"); err != ErrDecryption {
+		t.Errorf("expected ErrDecryption with the wrong key, got %v", err)
+	}
+
+	fips := DefaultDecoder.WithFIPSMode().WithRecipient(cert, key)
+	for _, file := range []string{"cms-3des-oaep.p12", "cms-aes256.p12"} {
+		pfxData, err := os.ReadFile("testdata/" + file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, _, err := fips.DecodeChain(pfxData, "password"); !errors.Is(err, ErrNonFIPSAlgorithm) {
+			t.Errorf("%s: expected FIPS mode to refuse 3DES or PKCS#1 v1.5, got %v", file, err)
+		}
+	}
+}
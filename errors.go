@@ -4,15 +4,43 @@
 
 package pkcs12
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 var (
-	// ErrDecryption represents a failure to decrypt the input.
+	// ErrDecryption represents a failure to decrypt the input.  The decoding
+	// functions report it as ErrIncorrectPassword, so that a padding failure
+	// cannot be told apart from a MAC failure.
 	ErrDecryption = errors.New("pkcs12: decryption error, incorrect padding")
 
-	// ErrIncorrectPassword is returned when an incorrect password is detected.
-	// Usually, P12/PFX data is signed to be able to verify the password.
+	// ErrIncorrectPassword is returned when an incorrect password is detected,
+	// either because the MAC does not verify or because data encrypted with
+	// the password does not decrypt.  Usually, P12/PFX data is signed to be
+	// able to verify the password.
 	ErrIncorrectPassword = errors.New("pkcs12: decryption password incorrect")
+
+	// ErrNonFIPSAlgorithm is returned in FIPS mode when an algorithm that is
+	// not approved by FIPS 140-3 would have to be used.
+	ErrNonFIPSAlgorithm = errors.New("pkcs12: algorithm not approved in FIPS mode")
+
+	// ErrKeyMismatch is returned when a private key does not belong to the
+	// certificate it is paired with.
+	ErrKeyMismatch = errors.New("pkcs12: private key does not match the certificate")
+
+	// ErrWeakAlgorithm is returned when an Encoder would produce output using
+	// a weak algorithm without AllowWeakAlgorithms having been called.
+	ErrWeakAlgorithm = errors.New("pkcs12: weak algorithm not allowed")
+
+	// ErrDetachedContent is returned for an encrypted SafeContents whose
+	// EncryptedContentInfo leaves out the encryptedContent, as if it were
+	// stored elsewhere, which PKCS#12 does not provide for.  A few
+	// malformed files do so.  DecodeContents returns it together with the
+	// other SafeContents.
+	ErrDetachedContent = errors.New("pkcs12: encrypted content is detached")
 )
 
 // NotImplementedError indicates that the input is not currently supported.
@@ -21,3 +49,85 @@ type NotImplementedError string
 func (e NotImplementedError) Error() string {
 	return "pkcs12: " + string(e)
 }
+
+// A PasswordError reports a password which cannot be encoded as the
+// NUL-terminated UCS-2 BMPString PKCS#12 derives keys from, because it is
+// longer than MaxPasswordLength or because of the character at Index.
+type PasswordError struct {
+	// Length is the number of characters of a password longer than
+	// MaxPasswordLength, and zero otherwise.
+	Length int
+	// Rune is the first character which cannot be encoded: one outside
+	// the Basic Multilingual Plane, such as an emoji, or utf8.RuneError.
+	Rune rune
+	// Index is the position of Rune in the password, counting characters
+	// from zero, and Offset its position in bytes.
+	Index, Offset int
+	// InvalidUTF8 is set if the password, passed as bytes, is not valid
+	// UTF-8 at Offset.
+	InvalidUTF8 bool
+}
+
+func (e *PasswordError) Error() string {
+	switch {
+	case e.Length != 0:
+		return fmt.Sprintf("pkcs12: password of %d characters is longer than the maximum of %d", e.Length, MaxPasswordLength)
+	case e.InvalidUTF8:
+		return fmt.Sprintf("pkcs12: password is not valid UTF-8 at byte %d", e.Offset)
+	}
+	return fmt.Sprintf("pkcs12: password character %d (%U at byte %d) is outside the Basic Multilingual Plane and cannot be encoded in UCS-2", e.Index, e.Rune, e.Offset)
+}
+
+// A ParseError reports an element of a PKCS#12 file which is not valid DER
+// or does not have the expected structure.
+type ParseError struct {
+	// Path names the element, such as
+	// "authSafe[1].safeContents[3].bagValue": the third bag, counting from
+	// zero, of the second ContentInfo of the authenticated safe.  The bags
+	// of a safeContentsBag are named like "bagValue[0]".
+	Path string
+	// Offset is the position of the element in the DER encoding of the
+	// file or, for the bags of an encrypted SafeContents, in its decrypted
+	// content.  It is zero for an element within BER-encoded input, which
+	// is parsed once converted to DER.
+	Offset int
+	Err    error
+
+	// element is the encoding of the element, from which Offset is
+	// computed by locateParseError once the buffer holding it is known.
+	element []byte
+}
+
+func (e *ParseError) Error() string {
+	return "pkcs12: " + e.Path + " at offset " + strconv.Itoa(e.Offset) + ": " + strings.TrimPrefix(e.Err.Error(), "pkcs12: ")
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError returns a *ParseError for err, which occurred decoding
+// element at path.
+func newParseError(path string, element []byte, err error) error {
+	return &ParseError{Path: path, Err: err, element: element}
+}
+
+// locateParseError prepends prefix to the path of err, if it is a
+// *ParseError, and computes its offset if base, the buffer holding the
+// element, is not nil.
+func locateParseError(err error, prefix string, base []byte) error {
+	var e *ParseError
+	if !errors.As(err, &e) {
+		return err
+	}
+	e.Path = prefix + e.Path
+	if e.element != nil && base != nil {
+		// The element is a subslice of base, as produced by
+		// asn1.Unmarshal, unless it was converted from BER.
+		if offset := cap(base) - cap(e.element); offset >= 0 && offset < len(base) && len(e.element) > 0 && &base[offset] == &e.element[0] {
+			e.Offset = offset
+		}
+		e.element = nil
+	}
+	return err
+}
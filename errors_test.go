@@ -0,0 +1,82 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"testing"
+)
+
+// badCert is a DER SEQUENCE, but not a certificate.
+var badCert = []byte{0x30, 0x03, 0x02, 0x01, 0x2a}
+
+// makeParseErrorPFX returns a PFX whose second SafeContents, encrypted with
+// algorithm unless it is nil, holds a certificate and a cert bag whose
+// value is badCert instead of a CertBag.
+func makeParseErrorPFX(t *testing.T, algorithm asn1.ObjectIdentifier) []byte {
+	_, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	password, _ := bmpString("password")
+
+	bag, err := makeCertBag(cert.Raw, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := *bag
+	malformed.Value.Bytes = badCert
+	bags := []safeBag{*bag, malformed}
+	plain, err := Modern.makeSafeContents(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci, err := Modern.makeSafeContents(bags, algorithm, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := Modern.marshalPFX([]contentInfo{plain, ci}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pfxData
+}
+
+func TestParseError(t *testing.T) {
+	check := func(what string, err error) *ParseError {
+		t.Helper()
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("%s: expected a ParseError, got %v", what, err)
+		}
+		if parseErr.Path != "authSafe[1].safeContents[1].bagValue" {
+			t.Errorf("%s: unexpected path in %v", what, err)
+		}
+		return parseErr
+	}
+
+	pfxData := makeParseErrorPFX(t, nil)
+	_, err := DecodeContents(pfxData, "password")
+	parseErr := check("plain", err)
+	// The offset is the one of the explicitly tagged bagValue, which holds
+	// badCert.
+	if offset := parseErr.Offset; offset <= 0 || offset >= len(pfxData) || pfxData[offset] != 0xa0 {
+		t.Errorf("offset %d is not the one of the bag value", offset)
+	} else if i := bytes.Index(pfxData[offset:], badCert); i < 0 || i > 24 {
+		t.Errorf("offset %d is not the one of the bag value", offset)
+	}
+
+	// In an encrypted SafeContents, the offset is relative to the
+	// decrypted content.
+	_, err = DecodeContents(makeParseErrorPFX(t, Modern.certAlgorithm), "password")
+	if parseErr := check("encrypted", err); parseErr.Offset <= 0 || parseErr.Offset > 2048 {
+		t.Errorf("unexpected offset %d in the decrypted content", parseErr.Offset)
+	}
+
+	_, err = DecodeContents(pfxData[:len(pfxData)-1], "password")
+	if !errors.As(err, &parseErr) || parseErr.Path != "pfx" || parseErr.Offset != 0 {
+		t.Errorf("expected a ParseError for the pfx, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// ExpiryInfo is the result of LeafExpiry.
+type ExpiryInfo struct {
+	// Certificate is the leaf certificate that expires first, or nil if
+	// no leaf certificate could be seen.
+	Certificate *x509.Certificate
+	// NotAfter is the NotAfter of Certificate, or zero if Certificate is
+	// nil.
+	NotAfter time.Time
+	// Hidden is the number of encrypted SafeContents, whose certificates
+	// could not be examined.  If it is not zero, a leaf certificate that
+	// expires earlier may be among them.
+	Hidden int
+}
+
+// LeafExpiry reports when the first of the leaf certificates of pfxData
+// expires, without needing the password, so that files nearing expiry can
+// be found by monitoring.  Only certificates stored in plain SafeContents,
+// as written by Encoder.WithEncryptedCerts(false), can be seen, see
+// Probe.  Certificates that are marked as CAs by their basic constraints
+// are not leaf certificates.
+func LeafExpiry(pfxData []byte) (*ExpiryInfo, error) {
+	probe, err := Probe(pfxData)
+	if err != nil {
+		return nil, err
+	}
+
+	info := new(ExpiryInfo)
+	for _, sc := range probe.SafeContents {
+		if sc.Encryption != nil {
+			info.Hidden++
+			continue
+		}
+		for _, bag := range sc.Bags {
+			cert := bag.Certificate
+			if cert == nil || cert.IsCA {
+				continue
+			}
+			if info.Certificate == nil || cert.NotAfter.Before(info.NotAfter) {
+				info.Certificate = cert
+				info.NotAfter = cert.NotAfter
+			}
+		}
+	}
+	return info, nil
+}
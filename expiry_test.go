@@ -0,0 +1,44 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestLeafExpiry(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	plain, err := Modern.WithEncryptedCerts(false).Encode(key, cert, []*x509.Certificate{caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := LeafExpiry(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Certificate == nil || !info.Certificate.Equal(cert) || !info.NotAfter.Equal(cert.NotAfter) || info.Hidden != 0 {
+		t.Errorf("unexpected expiry info %+v", info)
+	}
+
+	encrypted, err := Modern.Encode(key, cert, []*x509.Certificate{caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = LeafExpiry(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Certificate != nil || !info.NotAfter.IsZero() || info.Hidden != 1 {
+		t.Errorf("unexpected expiry info %+v", info)
+	}
+
+	if _, err := LeafExpiry([]byte("garbage")); err == nil {
+		t.Error("expected an error for garbage")
+	}
+}
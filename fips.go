@@ -0,0 +1,113 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// fipsApprovedMac reports whether the MAC digest algorithm is approved in
+// FIPS mode.  SHA-1 is refused even though FIPS 140-3 still permits it for
+// HMAC, since the point of FIPS mode is to keep SHA-1 out of new files.
+func fipsApprovedMac(algorithm asn1.ObjectIdentifier) bool {
+	for hash, oid := range macAlgorithms {
+		if hash != crypto.SHA1 && algorithm.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// fipsApprovedPRF reports whether the PBKDF2 PRF is approved in FIPS mode.
+func fipsApprovedPRF(algorithm asn1.ObjectIdentifier) bool {
+	for hash, oid := range prfAlgorithms {
+		if hash != crypto.SHA1 && algorithm.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// fipsApprovedCipher reports whether the PBES2 encryption scheme is
+// approved in FIPS mode.
+func fipsApprovedCipher(algorithm asn1.ObjectIdentifier) bool {
+	return algorithm.Equal(oidAES128CBC) || algorithm.Equal(oidAES192CBC) || algorithm.Equal(oidAES256CBC) || isGCM(algorithm)
+}
+
+// checkFIPSEncryption returns an error wrapping ErrNonFIPSAlgorithm unless
+// algorithm is PBES2 with an approved PRF and encryption scheme.  The
+// PKCS#12 PBE schemes (RC2, 3DES, all keyed via the SHA-1 PKCS#12 KDF) are
+// never approved.
+func checkFIPSEncryption(algorithm pkix.AlgorithmIdentifier) error {
+	if !algorithm.Algorithm.Equal(oidPBES2) {
+		return fmt.Errorf("%w: %s", ErrNonFIPSAlgorithm, algorithm.Algorithm)
+	}
+	params, kdfParams, err := parsePBES2Params(algorithm)
+	if err != nil {
+		return err
+	}
+	if !fipsApprovedPRF(kdfParams.Prf.Algorithm) {
+		return fmt.Errorf("%w: pbkdf2 prf %s", ErrNonFIPSAlgorithm, kdfParams.Prf.Algorithm)
+	}
+	if !fipsApprovedCipher(params.EncryptionScheme.Algorithm) {
+		return fmt.Errorf("%w: %s", ErrNonFIPSAlgorithm, params.EncryptionScheme.Algorithm)
+	}
+	return nil
+}
+
+// checkMacAlgorithm enforces FIPS mode on the MAC digest algorithm of a
+// file being decoded.
+func (dec *Decoder) checkMacAlgorithm(algorithm asn1.ObjectIdentifier) error {
+	if dec.fips && !fipsApprovedMac(algorithm) {
+		return fmt.Errorf("%w: mac digest %s", ErrNonFIPSAlgorithm, algorithm)
+	}
+	return nil
+}
+
+// checkEncryptionAlgorithm enforces FIPS mode and AllowChaCha20Poly1305 on
+// an encryption algorithm of a file being decoded.
+func (dec *Decoder) checkEncryptionAlgorithm(algorithm pkix.AlgorithmIdentifier) error {
+	if err := dec.checkChaCha20Poly1305(algorithm); err != nil {
+		return err
+	}
+	if dec.fips {
+		return checkFIPSEncryption(algorithm)
+	}
+	return nil
+}
+
+// checkFIPS enforces FIPS mode on the algorithms the encoder is configured
+// with.
+func (enc *Encoder) checkFIPS() error {
+	if !enc.fips {
+		return nil
+	}
+	if !fipsApprovedMac(enc.macAlgorithm) {
+		return fmt.Errorf("%w: mac digest %s", ErrNonFIPSAlgorithm, enc.macAlgorithm)
+	}
+	// SP 800-132 asks for salts of at least 128 bits.
+	if enc.macSaltLen < 16 || enc.saltLen < 16 {
+		return fmt.Errorf("%w: salts shorter than 16 bytes", ErrNonFIPSAlgorithm)
+	}
+	for _, algorithm := range []asn1.ObjectIdentifier{enc.certsAlgorithm(), enc.keyAlgorithm} {
+		if algorithm == nil {
+			continue
+		}
+		if !algorithm.Equal(oidPBES2) {
+			return fmt.Errorf("%w: %s", ErrNonFIPSAlgorithm, algorithm)
+		}
+		if !fipsApprovedPRF(enc.pbes2PRF) {
+			return fmt.Errorf("%w: pbkdf2 prf %s", ErrNonFIPSAlgorithm, enc.pbes2PRF)
+		}
+		if !fipsApprovedCipher(enc.pbes2Cipher) {
+			return fmt.Errorf("%w: %s", ErrNonFIPSAlgorithm, enc.pbes2Cipher)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestFIPSModeEncode(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	for name, enc := range map[string]*Encoder{"LegacyRC2": LegacyRC2, "LegacyDES": LegacyDES} {
+		if _, err := enc.WithFIPSMode().Encode(key, cert, nil, DefaultPassword); !errors.Is(err, ErrNonFIPSAlgorithm) {
+			t.Errorf("%s: expected ErrNonFIPSAlgorithm, got %v", name, err)
+		}
+		if _, err := enc.WithFIPSMode().EncodeTrustStore(nil, DefaultPassword); !errors.Is(err, ErrNonFIPSAlgorithm) {
+			t.Errorf("%s: expected ErrNonFIPSAlgorithm from EncodeTrustStore, got %v", name, err)
+		}
+	}
+
+	if _, err := Modern.WithFIPSMode().Encode(key, cert, nil, DefaultPassword); err != nil {
+		t.Errorf("Modern: %v", err)
+	}
+}
+
+func TestFIPSModeDecode(t *testing.T) {
+	dec := DefaultDecoder.WithFIPSMode()
+
+	for commonName, base64P12 := range testdata {
+		p12, _ := base64.StdEncoding.DecodeString(base64P12)
+		if _, _, err := dec.Decode(p12, ""); !errors.Is(err, ErrNonFIPSAlgorithm) {
+			t.Errorf("%s: expected ErrNonFIPSAlgorithm, got %v", commonName, err)
+		}
+	}
+
+	p12, _ := base64.StdEncoding.DecodeString(openSSL3DefaultP12)
+	if _, _, err := dec.Decode(p12, "password"); err != nil {
+		t.Errorf("OpenSSL 3 default file: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fuzzMaxIterations bounds the MAC iteration count of fuzz inputs, since
+// the MAC is verified before anything else and a mutated count would
+// otherwise make single inputs run for minutes.
+const fuzzMaxIterations = 4096
+
+// addFuzzSeeds adds the test fixtures to the seed corpus of f: the files
+// in testdata, which were generated by OpenSSL 3.0 and NSS, most with the
+// password "password", and the base64 fixtures of the other tests, which include
+// files written by Windows.
+func addFuzzSeeds(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.p12"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data, "password")
+	}
+
+	for _, base64P12 := range testdata {
+		data, _ := base64.StdEncoding.DecodeString(base64P12)
+		f.Add(data, "")
+	}
+	data, _ := base64.StdEncoding.DecodeString(openSSL3DefaultP12)
+	f.Add(data, "password")
+}
+
+// fuzzTooExpensive reports whether decoding pfxData would spend too long in
+// the MAC key derivation to be worth fuzzing.
+func fuzzTooExpensive(pfxData []byte) bool {
+	pfxData, err := DefaultLimits.berToDER(pfxData)
+	if err != nil {
+		return false
+	}
+	pfx, err := parsePFX(pfxData, DefaultLimits)
+	return err == nil && pfx.MacData.Iterations > fuzzMaxIterations
+}
+
+func FuzzDecode(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, pfxData []byte, password string) {
+		if fuzzTooExpensive(pfxData) {
+			t.Skip()
+		}
+		Decode(pfxData, password)
+		DecodeChain(pfxData, password)
+		ToPEM(pfxData, password)
+		Assess(pfxData)
+	})
+}
+
+func FuzzDecodeTrustStore(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, pfxData []byte, password string) {
+		if fuzzTooExpensive(pfxData) {
+			t.Skip()
+		}
+		DecodeTrustStore(pfxData, password)
+	})
+}
+
+// FuzzRoundTrip checks that whatever DecodeChain accepts can be encoded
+// again and decodes to the same key and certificates.
+func FuzzRoundTrip(f *testing.F) {
+	addFuzzSeeds(f)
+	enc := Modern.WithIterations(1)
+	f.Fuzz(func(t *testing.T, pfxData []byte, password string) {
+		if fuzzTooExpensive(pfxData) {
+			t.Skip()
+		}
+		key, cert, caCerts, err := DecodeChain(pfxData, password)
+		if err != nil {
+			t.Skip()
+		}
+
+		encoded, err := enc.Encode(key, cert, caCerts, password)
+		if err != nil {
+			t.Fatalf("cannot encode decoded content: %v", err)
+		}
+		key2, cert2, caCerts2, err := DecodeChain(encoded, password)
+		if err != nil {
+			t.Fatalf("cannot decode re-encoded content: %v", err)
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der2, err := x509.MarshalPKCS8PrivateKey(key2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(der, der2) {
+			t.Error("private key changed")
+		}
+		if !cert.Equal(cert2) {
+			t.Error("certificate changed")
+		}
+		if len(caCerts) != len(caCerts2) {
+			t.Fatalf("expected %d CA certificates, got %d", len(caCerts), len(caCerts2))
+		}
+		for i := range caCerts {
+			if !caCerts[i].Equal(caCerts2[i]) {
+				t.Errorf("CA certificate %d changed", i)
+			}
+		}
+	})
+}
@@ -0,0 +1,5 @@
+module github.com/nevissecurity/go-pkcs12
+
+go 1.21
+
+require golang.org/x/crypto v0.32.0
@@ -0,0 +1,80 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"crypto"
+	"crypto/cipher"
+	"hash"
+)
+
+// An HSM derives the keys protecting a PKCS#12 file from its password and
+// keeps them: rather than returning a key, it returns an HMAC or a block
+// cipher keyed with it, which do their work inside its boundary, such as
+// the one of a hardware security module.  WithHSM makes an Encoder or
+// Decoder use one, so that the keys derived from the password of a store
+// never enter the memory of the process.
+//
+// Only the MAC and PBES2 with a block cipher, in CBC mode or AES-GCM, can
+// be computed this way.  The PKCS#12 PBE schemes of the legacy Encoders and
+// ChaCha20-Poly1305 need the key itself, and are refused with a
+// NotImplementedError while an HSM is set.  Like a KDF, an HSM is called
+// from several goroutines at once when keys are derived in parallel.
+type HSM interface {
+	// NewMAC returns an HMAC using h, keyed with h.Size() bytes derived
+	// for pbe.MACKey from password, salt and iterations by the PKCS#12
+	// key derivation function, see
+	// https://tools.ietf.org/html/rfc7292#appendix-B.  password is
+	// encoded as a BMPString with a zero terminator, or empty.
+	NewMAC(ctx context.Context, h crypto.Hash, password, salt []byte, iterations int) (hash.Hash, error)
+	// NewCipher returns the block cipher called name, which is "AES",
+	// "Camellia" or "SEED", keyed with keyLen bytes derived from
+	// password, salt and iterations by PBKDF2 using HMAC with prf, see
+	// https://tools.ietf.org/html/rfc8018#section-5.2.  password is
+	// UTF-8 encoded.
+	NewCipher(ctx context.Context, name string, keyLen int, prf crypto.Hash, password, salt []byte, iterations int) (cipher.Block, error)
+}
+
+// WithHSM creates a new Encoder identical to enc except that the keys
+// of the MAC and of PBES2 are derived and used by hsm, see HSM.  hsm takes
+// precedence over the KDF selected by WithKDF.
+func (enc Encoder) WithHSM(hsm HSM) *Encoder {
+	enc.hsm = hsm
+	return &enc
+}
+
+// WithHSM creates a new Decoder identical to dec except that the keys of
+// the MAC and of PBES2 are derived and used by hsm, see HSM.  Files
+// protected with other algorithms fail to decode.
+func (dec Decoder) WithHSM(hsm HSM) *Decoder {
+	dec.hsm = hsm
+	return &dec
+}
+
+// hsmKey is the key of the HSM in the contexts returned by kdfContext.
+type hsmKey struct{}
+
+// withHSM returns ctx carrying hsm to the key derivations, unless hsm is
+// nil.
+func withHSM(ctx context.Context, hsm HSM) context.Context {
+	if hsm == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, hsmKey{}, hsm)
+}
+
+// hsmFor returns the HSM carried by ctx, or nil.
+func hsmFor(ctx context.Context) HSM {
+	hsm, _ := ctx.Value(hsmKey{}).(HSM)
+	return hsm
+}
+
+// hsmUnsupported returns the error for an algorithm whose key an HSM
+// cannot keep.
+func hsmUnsupported(algorithm string) error {
+	return NotImplementedError(algorithm + " cannot be used with an HSM, which does not hand out its keys")
+}
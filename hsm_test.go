@@ -0,0 +1,107 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"sync"
+	"testing"
+
+	"github.com/nevissecurity/go-pkcs12/internal/camellia"
+	"github.com/nevissecurity/go-pkcs12/pbe"
+)
+
+// softwareHSM is an HSM keeping its keys in memory, which counts the MACs
+// and ciphers it hands out.
+type softwareHSM struct {
+	mu           sync.Mutex
+	macs, blocks int
+	names        map[string]bool
+}
+
+func (s *softwareHSM) NewMAC(ctx context.Context, h crypto.Hash, password, salt []byte, iterations int) (hash.Hash, error) {
+	key, err := DefaultKDF.PKCS12(ctx, h, pbe.MACKey, password, salt, iterations, h.Size())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.macs++
+	s.mu.Unlock()
+	return hmac.New(h.New, key), nil
+}
+
+func (s *softwareHSM) NewCipher(ctx context.Context, name string, keyLen int, prf crypto.Hash, password, salt []byte, iterations int) (cipher.Block, error) {
+	key, err := DefaultKDF.PBKDF2(ctx, prf, password, salt, iterations, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.blocks++
+	if s.names == nil {
+		s.names = make(map[string]bool)
+	}
+	s.names[name] = true
+	s.mu.Unlock()
+	if name == "Camellia" {
+		return camellia.New(key)
+	}
+	return aes.NewCipher(key)
+}
+
+func TestWithHSM(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	for _, test := range []struct {
+		name   string
+		enc    *Encoder
+		cipher string
+	}{
+		{"Modern", Modern, "AES"},
+		{"GCM", Modern.WithGCM(), "AES"},
+		{"Camellia", Modern.WithCamellia(), "Camellia"},
+	} {
+		hsm := &softwareHSM{}
+		pfxData, err := test.enc.WithHSM(hsm).Encode(key, cert, nil, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if hsm.macs != 1 || hsm.blocks != 2 || !hsm.names[test.cipher] || len(hsm.names) != 1 {
+			t.Errorf("%s: encoding used %d MACs and %d %v ciphers of the HSM", test.name, hsm.macs, hsm.blocks, hsm.names)
+		}
+
+		// The file is the same as one encoded without an HSM.
+		if _, _, err := Decode(pfxData, DefaultPassword); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		hsm = &softwareHSM{}
+		decodedKey, _, err := DefaultDecoder.WithHSM(hsm).Decode(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !key.Equal(decodedKey) || hsm.macs != 1 || hsm.blocks != 2 {
+			t.Errorf("%s: decoding used %d MACs and %d ciphers of the HSM", test.name, hsm.macs, hsm.blocks)
+		}
+	}
+
+	var notImplemented NotImplementedError
+	pfxData, err := LegacyDES.AllowWeakAlgorithms().Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DefaultDecoder.WithHSM(&softwareHSM{}).Decode(pfxData, DefaultPassword); !errors.As(err, &notImplemented) {
+		t.Errorf("expected a NotImplementedError for the PKCS#12 PBE schemes, got %v", err)
+	}
+	if _, err := LegacyDES.AllowWeakAlgorithms().WithHSM(&softwareHSM{}).Encode(key, cert, nil, DefaultPassword); err == nil {
+		t.Error("encoded with the PKCS#12 PBE schemes and an HSM")
+	}
+}
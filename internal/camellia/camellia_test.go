@@ -0,0 +1,65 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package camellia
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	// Test vectors from https://tools.ietf.org/html/rfc3713#appendix-A
+	var tests = []struct {
+		key    string
+		plain  string
+		cipher string
+	}{
+		{
+			"0123456789abcdeffedcba9876543210",
+			"0123456789abcdeffedcba9876543210",
+			"67673138549669730857065648eabe43",
+		},
+		{
+			"0123456789abcdeffedcba98765432100011223344556677",
+			"0123456789abcdeffedcba9876543210",
+			"b4993401b3e996f84ee5cee7d79b09b9",
+		},
+		{
+			"0123456789abcdeffedcba987654321000112233445566778899aabbccddeeff",
+			"0123456789abcdeffedcba9876543210",
+			"9acc237dff16d76c20ef7c919e3a7509",
+		},
+	}
+
+	for _, tt := range tests {
+		k, _ := hex.DecodeString(tt.key)
+		p, _ := hex.DecodeString(tt.plain)
+		c, _ := hex.DecodeString(tt.cipher)
+
+		b, err := New(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var dst [BlockSize]byte
+		b.Encrypt(dst[:], p)
+		if !bytes.Equal(dst[:], c) {
+			t.Errorf("encrypt failed with %d-bit key: got %x, wanted %x", len(k)*8, dst, c)
+		}
+
+		b.Decrypt(dst[:], c)
+		if !bytes.Equal(dst[:], p) {
+			t.Errorf("decrypt failed with %d-bit key: got %x, wanted %x", len(k)*8, dst, p)
+		}
+	}
+}
+
+func TestNewKeySize(t *testing.T) {
+	if _, err := New(make([]byte, 8)); err == nil {
+		t.Error("expected a 64-bit key to be refused")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package chacha20poly1305 implements the ChaCha20-Poly1305 AEAD
+/*
+https://tools.ietf.org/html/rfc8439
+
+The standard library only ships it as an internal package.  This is a
+straightforward implementation of the RFC, without assembly.
+*/
+package chacha20poly1305
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+const (
+	// KeySize is the size of the key in bytes.
+	KeySize = 32
+	// NonceSize is the size of the nonce in bytes.
+	NonceSize = 12
+	// Overhead is the size of the Poly1305 tag in bytes.
+	Overhead = 16
+)
+
+type chacha20poly1305 struct {
+	key [8]uint32
+}
+
+// New returns a ChaCha20-Poly1305 AEAD that uses the given 256-bit key.
+func New(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("chacha20poly1305: bad key length")
+	}
+	c := new(chacha20poly1305)
+	for i := range c.key {
+		c.key[i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	return c, nil
+}
+
+func (*chacha20poly1305) NonceSize() int { return NonceSize }
+
+func (*chacha20poly1305) Overhead() int { return Overhead }
+
+func (c *chacha20poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != NonceSize {
+		panic("chacha20poly1305: bad nonce length passed to Seal")
+	}
+	ret, out := sliceForAppend(dst, len(plaintext)+Overhead)
+	ciphertext, tag := out[:len(plaintext)], out[len(plaintext):]
+
+	var polyKey [64]byte
+	c.block(&polyKey, 0, nonce)
+	c.xorKeyStream(ciphertext, plaintext, nonce)
+	sum := authenticate(polyKey[:32], additionalData, ciphertext)
+	copy(tag, sum[:])
+	return ret
+}
+
+func (c *chacha20poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != NonceSize {
+		panic("chacha20poly1305: bad nonce length passed to Open")
+	}
+	if len(ciphertext) < Overhead {
+		return nil, errOpen
+	}
+	tag := ciphertext[len(ciphertext)-Overhead:]
+	ciphertext = ciphertext[:len(ciphertext)-Overhead]
+
+	var polyKey [64]byte
+	c.block(&polyKey, 0, nonce)
+	sum := authenticate(polyKey[:32], additionalData, ciphertext)
+	if subtle.ConstantTimeCompare(sum[:], tag) != 1 {
+		return nil, errOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	c.xorKeyStream(out, ciphertext, nonce)
+	return ret, nil
+}
+
+var errOpen = errors.New("chacha20poly1305: message authentication failed")
+
+// sliceForAppend extends in by n bytes, returning the whole slice and the
+// extension.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
+
+// xorKeyStream encrypts src into dst with the ChaCha20 key stream starting
+// at block counter 1, see https://tools.ietf.org/html/rfc8439#section-2.4
+func (c *chacha20poly1305) xorKeyStream(dst, src, nonce []byte) {
+	var keyStream [64]byte
+	for counter := uint32(1); len(src) > 0; counter++ {
+		c.block(&keyStream, counter, nonce)
+		n := subtle.XORBytes(dst, src, keyStream[:])
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+// block computes the ChaCha20 block function, see
+// https://tools.ietf.org/html/rfc8439#section-2.3
+func (c *chacha20poly1305) block(out *[64]byte, counter uint32, nonce []byte) {
+	state := [16]uint32{
+		0x61707865, 0x3320646e, 0x79622d32, 0x6b206574,
+		c.key[0], c.key[1], c.key[2], c.key[3],
+		c.key[4], c.key[5], c.key[6], c.key[7],
+		counter,
+		binary.LittleEndian.Uint32(nonce[0:]),
+		binary.LittleEndian.Uint32(nonce[4:]),
+		binary.LittleEndian.Uint32(nonce[8:]),
+	}
+
+	x := state
+	for i := 0; i < 10; i++ {
+		quarterRound(&x, 0, 4, 8, 12)
+		quarterRound(&x, 1, 5, 9, 13)
+		quarterRound(&x, 2, 6, 10, 14)
+		quarterRound(&x, 3, 7, 11, 15)
+		quarterRound(&x, 0, 5, 10, 15)
+		quarterRound(&x, 1, 6, 11, 12)
+		quarterRound(&x, 2, 7, 8, 13)
+		quarterRound(&x, 3, 4, 9, 14)
+	}
+
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+state[i])
+	}
+}
+
+func quarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
+
+// authenticate computes the Poly1305 tag over the additional data and the
+// ciphertext as laid out in https://tools.ietf.org/html/rfc8439#section-2.8
+func authenticate(key, additionalData, ciphertext []byte) [16]byte {
+	p := newPoly1305(key)
+	p.writePadded(additionalData)
+	p.writePadded(ciphertext)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	p.writePadded(lengths[:])
+	return p.sum()
+}
+
+// poly1305 is the one-time authenticator of
+// https://tools.ietf.org/html/rfc8439#section-2.5.  The accumulator h is
+// kept in three 64-bit limbs, of which h2 only holds a few bits, and is
+// reduced modulo 2^130 - 5 after every block.
+type poly1305 struct {
+	r0, r1     uint64
+	s0, s1     uint64
+	h0, h1, h2 uint64
+}
+
+func newPoly1305(key []byte) *poly1305 {
+	return &poly1305{
+		r0: binary.LittleEndian.Uint64(key[0:]) & 0x0ffffffc0fffffff,
+		r1: binary.LittleEndian.Uint64(key[8:]) & 0x0ffffffc0ffffffc,
+		s0: binary.LittleEndian.Uint64(key[16:]),
+		s1: binary.LittleEndian.Uint64(key[24:]),
+	}
+}
+
+// writePadded processes msg, zero padding the last block to 16 bytes.  The
+// AEAD construction pads each of its parts this way, so the padding bytes
+// count as message bytes with the 2^128 bit set like any full block.
+func (p *poly1305) writePadded(msg []byte) {
+	for len(msg) > 0 {
+		var block [16]byte
+		n := copy(block[:], msg)
+		msg = msg[n:]
+		p.block(&block)
+	}
+}
+
+func (p *poly1305) block(block *[16]byte) {
+	var c uint64
+	p.h0, c = bits.Add64(p.h0, binary.LittleEndian.Uint64(block[0:]), 0)
+	p.h1, c = bits.Add64(p.h1, binary.LittleEndian.Uint64(block[8:]), c)
+	p.h2 += c + 1
+
+	// h * r, where r0 and r1 are below 2^60 and h2 is below 8.
+	h0r0Hi, h0r0Lo := bits.Mul64(p.h0, p.r0)
+	h1r0Hi, h1r0Lo := bits.Mul64(p.h1, p.r0)
+	h0r1Hi, h0r1Lo := bits.Mul64(p.h0, p.r1)
+	h1r1Hi, h1r1Lo := bits.Mul64(p.h1, p.r1)
+	h2r0 := p.h2 * p.r0
+	h2r1 := p.h2 * p.r1
+
+	m1Lo, c := bits.Add64(h1r0Lo, h0r1Lo, 0)
+	m1Hi, _ := bits.Add64(h1r0Hi, h0r1Hi, c)
+	m2Lo, c := bits.Add64(h1r1Lo, h2r0, 0)
+	m2Hi, _ := bits.Add64(h1r1Hi, 0, c)
+
+	t0 := h0r0Lo
+	t1, c := bits.Add64(h0r0Hi, m1Lo, 0)
+	t2, c := bits.Add64(m1Hi, m2Lo, c)
+	t3, _ := bits.Add64(m2Hi, h2r1, c)
+
+	// Everything above 2^130 is multiplied by 5 and added back, since
+	// 2^130 = 5 mod 2^130 - 5: once as 4 times the excess, which is just
+	// the upper bits in place, and once as the excess itself.
+	p.h0, p.h1, p.h2 = t0, t1, t2&3
+	ccLo, ccHi := t2&^3, t3
+	p.h0, c = bits.Add64(p.h0, ccLo, 0)
+	p.h1, c = bits.Add64(p.h1, ccHi, c)
+	p.h2 += c
+	ccLo, ccHi = ccLo>>2|ccHi<<62, ccHi>>2
+	p.h0, c = bits.Add64(p.h0, ccLo, 0)
+	p.h1, c = bits.Add64(p.h1, ccHi, c)
+	p.h2 += c
+}
+
+func (p *poly1305) sum() [16]byte {
+	// Subtract 2^130 - 5 if h is not below it, in constant time.
+	g0, b := bits.Sub64(p.h0, 0xfffffffffffffffb, 0)
+	g1, b := bits.Sub64(p.h1, 0xffffffffffffffff, b)
+	_, b = bits.Sub64(p.h2, 3, b)
+	mask := b - 1 // all ones if h >= 2^130 - 5
+	h0 := p.h0&^mask | g0&mask
+	h1 := p.h1&^mask | g1&mask
+
+	var tag [16]byte
+	h0, c := bits.Add64(h0, p.s0, 0)
+	h1, _ = bits.Add64(h1, p.s1, c)
+	binary.LittleEndian.PutUint64(tag[0:], h0)
+	binary.LittleEndian.PutUint64(tag[8:], h1)
+	return tag
+}
@@ -0,0 +1,60 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chacha20poly1305
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	// Test vector from https://tools.ietf.org/html/rfc8439#section-2.8.2
+	key, _ := hex.DecodeString("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce, _ := hex.DecodeString("070000004041424344454647")
+	additionalData, _ := hex.DecodeString("50515253c0c1c2c3c4c5c6c7")
+	plaintext := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	expected, _ := hex.DecodeString("" +
+		"d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6" +
+		"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36" +
+		"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc" +
+		"3ff4def08e4b7a9de576d26586cec64b6116" +
+		"1ae10b594f09e26a7e902ecbd0600691")
+
+	aead, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := aead.Seal(nil, nonce, plaintext, additionalData)
+	if !bytes.Equal(ciphertext, expected) {
+		t.Fatalf("expected %x, got %x", expected, ciphertext)
+	}
+
+	decrypted, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected %q, got %q", plaintext, decrypted)
+	}
+
+	for i := range ciphertext {
+		ciphertext[i] ^= 0x80
+		if _, err := aead.Open(nil, nonce, ciphertext, additionalData); err == nil {
+			t.Fatalf("flipping a bit of byte %d was not detected", i)
+		}
+		ciphertext[i] ^= 0x80
+	}
+	if _, err := aead.Open(nil, nonce, ciphertext, nil); err == nil {
+		t.Error("missing additional data was not detected")
+	}
+}
+
+func TestNewKeySize(t *testing.T) {
+	if _, err := New(make([]byte, 16)); err == nil {
+		t.Error("expected a 128-bit key to be refused")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package seed
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	// Test vectors from https://tools.ietf.org/html/rfc4269#appendix-B
+	var tests = []struct {
+		key    string
+		plain  string
+		cipher string
+	}{
+		{
+			"00000000000000000000000000000000",
+			"000102030405060708090a0b0c0d0e0f",
+			"5ebac6e0054e166819aff1cc6d346cdb",
+		},
+		{
+			"000102030405060708090a0b0c0d0e0f",
+			"00000000000000000000000000000000",
+			"c11f22f20140505084483597e4370f43",
+		},
+	}
+
+	for _, tt := range tests {
+		k, _ := hex.DecodeString(tt.key)
+		p, _ := hex.DecodeString(tt.plain)
+		c, _ := hex.DecodeString(tt.cipher)
+
+		b, err := New(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var dst [BlockSize]byte
+		b.Encrypt(dst[:], p)
+		if !bytes.Equal(dst[:], c) {
+			t.Errorf("encrypt failed: got %x, wanted %x", dst, c)
+		}
+
+		b.Decrypt(dst[:], c)
+		if !bytes.Equal(dst[:], p) {
+			t.Errorf("decrypt failed: got %x, wanted %x", dst, p)
+		}
+	}
+}
+
+func TestNewKeySize(t *testing.T) {
+	if _, err := New(make([]byte, 32)); err == nil {
+		t.Error("expected a 256-bit key to be refused")
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/rsa"
+	"encoding/asn1"
+	"os"
+	"testing"
+)
+
+// The testdata/openssl-<version>-*.p12 files were written by the OpenSSL
+// version they are named after, 3.0.17 for openssl-3.0, with
+//
+//	openssl pkcs12 -export -inkey leaf.key -in leaf.pem -certfile ca.pem \
+//		-name interop -passout pass:password <options>
+//
+// for an RSA key whose certificate is issued by a CA.  SEED needs
+// "-provider legacy -provider default" as well.  The -legacy files show
+// the algorithms OpenSSL 3.0 selects with that flag, not the layout OpenSSL
+// 1.0.2 or 1.1.1 write.
+var openSSLInteropTests = []struct {
+	file    string
+	options string
+	mac     asn1.ObjectIdentifier
+	// certs is the certificate encryption scheme, or nil for plain
+	// data; for PBES2, cipher is the encryption scheme.
+	certs, certsCipher asn1.ObjectIdentifier
+	// key is the key encryption scheme, or nil for a plain key bag.
+	key, keyCipher asn1.ObjectIdentifier
+	iterations     int
+}{
+	{"openssl-3.0-default.p12", "", oidSHA256, oidPBES2, oidAES256CBC, oidPBES2, oidAES256CBC, 2048},
+	{"openssl-3.0-legacy.p12", "-legacy", oidSHA1, oidPBEWithSHAAnd40BitRC2CBC, nil, oidPBEWithSHAAnd3KeyTripleDESCBC, nil, 2048},
+	{"openssl-3.0-descert.p12", "-legacy -descert", oidSHA1, oidPBEWithSHAAnd3KeyTripleDESCBC, nil, oidPBEWithSHAAnd3KeyTripleDESCBC, nil, 2048},
+	{"openssl-3.0-aes128.p12", "-keypbe AES-128-CBC -certpbe AES-128-CBC", oidSHA256, oidPBES2, oidAES128CBC, oidPBES2, oidAES128CBC, 2048},
+	{"openssl-3.0-aes192-sha384.p12", "-keypbe AES-192-CBC -certpbe AES-192-CBC -macalg sha384", oidSHA384, oidPBES2, oidAES192CBC, oidPBES2, oidAES192CBC, 2048},
+	{"openssl-3.0-aes256-sha512.p12", "-keypbe AES-256-CBC -certpbe AES-256-CBC -macalg sha512", oidSHA512, oidPBES2, oidAES256CBC, oidPBES2, oidAES256CBC, 2048},
+	{"openssl-3.0-aes256-sha1mac.p12", "-macalg sha1", oidSHA1, oidPBES2, oidAES256CBC, oidPBES2, oidAES256CBC, 2048},
+	{"openssl-3.0-sha224mac.p12", "-macalg sha224", oidSHA224, oidPBES2, oidAES256CBC, oidPBES2, oidAES256CBC, 2048},
+	{"openssl-3.0-sha512-224mac.p12", "-macalg sha512-224", oidSHA512_224, oidPBES2, oidAES256CBC, oidPBES2, oidAES256CBC, 2048},
+	{"openssl-3.0-sha512-256mac.p12", "-macalg sha512-256", oidSHA512_256, oidPBES2, oidAES256CBC, oidPBES2, oidAES256CBC, 2048},
+	{"openssl-3.0-3deskey-aescert.p12", "-keypbe PBE-SHA1-3DES", oidSHA256, oidPBES2, oidAES256CBC, oidPBEWithSHAAnd3KeyTripleDESCBC, nil, 2048},
+	{"openssl-3.0-nocertpbe.p12", "-certpbe NONE", oidSHA256, nil, nil, oidPBES2, oidAES256CBC, 2048},
+	{"openssl-3.0-nokeypbe.p12", "-keypbe NONE", oidSHA256, oidPBES2, oidAES256CBC, nil, nil, 2048},
+	{"openssl-3.0-camellia.p12", "-keypbe CAMELLIA-256-CBC -certpbe CAMELLIA-128-CBC", oidSHA256, oidPBES2, oidCamellia128CBC, oidPBES2, oidCamellia256CBC, 2048},
+	{"openssl-3.0-camellia192.p12", "-keypbe CAMELLIA-192-CBC -certpbe CAMELLIA-192-CBC", oidSHA256, oidPBES2, oidCamellia192CBC, oidPBES2, oidCamellia192CBC, 2048},
+	{"openssl-3.0-seed.p12", "-keypbe SEED-CBC -certpbe SEED-CBC", oidSHA256, oidPBES2, oidSEEDCBC, oidPBES2, oidSEEDCBC, 2048},
+	{"openssl-3.0-iter1.p12", "-iter 1 -maciter", oidSHA256, oidPBES2, oidAES256CBC, oidPBES2, oidAES256CBC, 1},
+	{"openssl-3.0-iter100000.p12", "-iter 100000", oidSHA256, oidPBES2, oidAES256CBC, oidPBES2, oidAES256CBC, 100000},
+}
+
+func TestOpenSSLInterop(t *testing.T) {
+	for _, test := range openSSLInteropTests {
+		pfxData, err := os.ReadFile("testdata/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := test.file + " (" + test.options + ")"
+
+		// Check that the fixture covers what it claims to.
+		probe, err := Probe(pfxData)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if probe.MAC == nil || !probe.MAC.Algorithm.Equal(test.mac) || probe.MAC.Iterations != test.iterations {
+			t.Errorf("%s: unexpected MAC %+v", name, probe.MAC)
+		}
+		if len(probe.SafeContents) != 2 {
+			t.Fatalf("%s: expected 2 SafeContents, got %d", name, len(probe.SafeContents))
+		}
+		if enc := probe.SafeContents[0].Encryption; !equalEncryption(enc, test.certs, test.certsCipher, test.iterations) {
+			t.Errorf("%s: unexpected certificate encryption %+v", name, enc)
+		}
+		if bags := probe.SafeContents[1].Bags; len(bags) != 1 || !equalEncryption(bags[0].Encryption, test.key, test.keyCipher, test.iterations) {
+			t.Errorf("%s: unexpected key bags %+v", name, bags)
+		}
+
+		privateKey, certificate, caCerts, err := DecodeChain(pfxData, "password")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if _, ok := privateKey.(*rsa.PrivateKey); !ok {
+			t.Errorf("%s: unexpected key type %T", name, privateKey)
+		}
+		if certificate.Subject.CommonName != "interop.example.com" || len(caCerts) != 1 || caCerts[0].Subject.CommonName != "Interop CA" {
+			t.Errorf("%s: unexpected certificates", name)
+		}
+		if err := MatchKeyToCert(privateKey, certificate); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		contents, err := DecodeContents(pfxData, "password")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		for _, sc := range contents {
+			if sc.Entries[0].FriendlyName() != "interop" {
+				t.Errorf("%s: unexpected friendlyName %q", name, sc.Entries[0].FriendlyName())
+			}
+		}
+	}
+}
+
+func equalEncryption(info *EncryptionInfo, scheme, cipher asn1.ObjectIdentifier, iterations int) bool {
+	if info == nil || scheme == nil {
+		return info == nil && scheme == nil
+	}
+	return info.Algorithm.Equal(scheme) && info.Cipher.Equal(cipher) && info.Iterations == iterations
+}
@@ -0,0 +1,272 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jks
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/asn1"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	pkcs12 "github.com/nevissecurity/go-pkcs12"
+)
+
+var (
+	oidFriendlyName = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 9, 20})
+	oidLocalKeyID   = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 9, 21})
+
+	// oidTrustedKeyUsage is the attribute with which Java marks the cert
+	// bags of trusted certificate entries, see
+	// sun.security.pkcs12.PKCS12KeyStore.
+	oidTrustedKeyUsage       = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 113894, 746875, 1, 1})
+	oidAnyExtendedKeyUsage   = asn1.ObjectIdentifier([]int{2, 5, 29, 37, 0})
+	anyExtendedKeyUsageValue = mustMarshal(oidAnyExtendedKeyUsage)
+)
+
+func mustMarshal(v interface{}) []byte {
+	b, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// ToPKCS12 converts the entries of ks to PKCS#12 contents, laid out the way
+// keytool does when converting a keystore to PKCS#12: the certificates in
+// an encrypted SafeContents, followed by the private keys in a plain one.
+// The result can be encoded with pkcs12.Encoder.EncodeContents.
+//
+// Every private key and the first certificate of its chain carry the alias
+// as friendlyName and the SHA-1 fingerprint of the certificate as
+// localKeyId.  Trusted certificates carry the alias and the attribute that
+// marks them as trusted for Java.  The creation date of an entry, if set,
+// is stored with them as a signingTime attribute.
+func (ks *KeyStore) ToPKCS12() []pkcs12.SafeContents {
+	var certs, keys []pkcs12.Entry
+	for _, entry := range ks.Entries {
+		name := friendlyName(entry.Alias)
+		date, hasDate := creationDate(entry.Date)
+
+		switch {
+		case entry.PrivateKey != nil:
+			var localKeyID pkcs12.Attribute
+			if len(entry.CertificateChain) != 0 {
+				fingerprint := sha1.Sum(entry.CertificateChain[0].Raw)
+				localKeyID = pkcs12.Attribute{Type: oidLocalKeyID, Values: [][]byte{mustMarshal(fingerprint[:])}}
+			}
+			for i, cert := range entry.CertificateChain {
+				certEntry := pkcs12.Entry{BagType: pkcs12.CertBag, Certificate: cert}
+				if i == 0 {
+					certEntry.Attributes = []pkcs12.Attribute{name, localKeyID}
+					if hasDate {
+						certEntry.Attributes = append(certEntry.Attributes, date)
+					}
+				}
+				certs = append(certs, certEntry)
+			}
+			keyEntry := pkcs12.Entry{BagType: pkcs12.PKCS8ShroudedKeyBag, PrivateKey: entry.PrivateKey, Attributes: []pkcs12.Attribute{name}}
+			if localKeyID.Type != nil {
+				keyEntry.Attributes = append(keyEntry.Attributes, localKeyID)
+			}
+			if hasDate {
+				keyEntry.Attributes = append(keyEntry.Attributes, date)
+			}
+			keys = append(keys, keyEntry)
+		case entry.Certificate != nil:
+			certEntry := pkcs12.Entry{
+				BagType:     pkcs12.CertBag,
+				Certificate: entry.Certificate,
+				Attributes: []pkcs12.Attribute{
+					name,
+					{Type: oidTrustedKeyUsage, Values: [][]byte{anyExtendedKeyUsageValue}},
+				},
+			}
+			if hasDate {
+				certEntry.Attributes = append(certEntry.Attributes, date)
+			}
+			certs = append(certs, certEntry)
+		}
+	}
+
+	var contents []pkcs12.SafeContents
+	if len(certs) != 0 {
+		contents = append(contents, pkcs12.SafeContents{Encrypted: true, Entries: certs})
+	}
+	if len(keys) != 0 {
+		contents = append(contents, pkcs12.SafeContents{Entries: keys})
+	}
+	return contents
+}
+
+// FromPKCS12 converts PKCS#12 contents, such as returned by
+// pkcs12.DecodeContents, to a KeyStore.
+//
+// Every private key becomes a private key entry, paired with its
+// certificate by localKeyId or public key, and with the chain built from
+// the other certificates by issuer.  Certificates marked as trusted for
+// Java, and certificates that are in no chain, become trusted certificate
+// entries.  Aliases are taken from the friendlyName attributes; entries
+// without one are named after their position.  Creation dates are taken
+// from the signingTime attributes, and left zero without one.  Bags of
+// other types are dropped, since JKS cannot hold them.
+func FromPKCS12(contents []pkcs12.SafeContents) (*KeyStore, error) {
+	var certs, keys []*pkcs12.Entry
+	for i := range contents {
+		for j := range contents[i].Entries {
+			entry := &contents[i].Entries[j]
+			switch {
+			case entry.Certificate != nil:
+				certs = append(certs, entry)
+			case entry.PrivateKey != nil:
+				keys = append(keys, entry)
+			}
+		}
+	}
+
+	ks := new(KeyStore)
+	aliases := make(map[string]bool)
+	inChain := make([]bool, len(certs))
+
+	for i, key := range keys {
+		leaf := -1
+		if id := key.LocalKeyID(); len(id) != 0 {
+			for j, cert := range certs {
+				if bytes.Equal(cert.LocalKeyID(), id) {
+					leaf = j
+					break
+				}
+			}
+		}
+		if leaf < 0 {
+			for j, cert := range certs {
+				if pkcs12.MatchKeyToCert(key.PrivateKey, cert.Certificate) == nil {
+					leaf = j
+					break
+				}
+			}
+		}
+		if leaf < 0 {
+			return nil, &MissingCertificateError{Alias: key.FriendlyName()}
+		}
+
+		alias := key.FriendlyName()
+		if alias == "" {
+			alias = certs[leaf].FriendlyName()
+		}
+		date, ok := key.CreationDate()
+		if !ok {
+			date, _ = certs[leaf].CreationDate()
+		}
+		entry := Entry{
+			Alias:      uniqueAlias(aliases, alias, "key", i),
+			Date:       date,
+			PrivateKey: key.PrivateKey,
+		}
+		for _, j := range buildChain(certs, leaf) {
+			inChain[j] = true
+			entry.CertificateChain = append(entry.CertificateChain, certs[j].Certificate)
+		}
+		ks.Entries = append(ks.Entries, entry)
+	}
+
+	for i, cert := range certs {
+		if inChain[i] && !isTrusted(cert) {
+			continue
+		}
+		date, _ := cert.CreationDate()
+		ks.Entries = append(ks.Entries, Entry{
+			Alias:       uniqueAlias(aliases, cert.FriendlyName(), "cert", i),
+			Date:        date,
+			Certificate: cert.Certificate,
+		})
+	}
+
+	return ks, nil
+}
+
+// A MissingCertificateError is returned by FromPKCS12 for a private key
+// without a certificate, which JKS cannot store.
+type MissingCertificateError struct {
+	// Alias is the friendlyName of the key, if any.
+	Alias string
+}
+
+func (e *MissingCertificateError) Error() string {
+	if e.Alias == "" {
+		return "jks: no certificate found for private key"
+	}
+	return "jks: no certificate found for private key " + strconv.Quote(e.Alias)
+}
+
+// buildChain returns the indexes of the certificates from certs[leaf] up to
+// a self-signed certificate, following issuers as far as they are present.
+func buildChain(certs []*pkcs12.Entry, leaf int) []int {
+	chain := []int{leaf}
+	used := map[int]bool{leaf: true}
+	for current := certs[leaf].Certificate; !bytes.Equal(current.RawIssuer, current.RawSubject); {
+		next := -1
+		for j, candidate := range certs {
+			if !used[j] && bytes.Equal(candidate.Certificate.RawSubject, current.RawIssuer) && current.CheckSignatureFrom(candidate.Certificate) == nil {
+				next = j
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		chain = append(chain, next)
+		used[next] = true
+		current = certs[next].Certificate
+	}
+	return chain
+}
+
+func isTrusted(entry *pkcs12.Entry) bool {
+	for _, attribute := range entry.Attributes {
+		if attribute.Type.Equal(oidTrustedKeyUsage) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueAlias returns alias, or a name made of prefix and index if alias is
+// empty, made unique among aliases.  JKS aliases are case-insensitive.
+func uniqueAlias(aliases map[string]bool, alias, prefix string, index int) string {
+	if alias == "" {
+		alias = prefix + "-" + strconv.Itoa(index)
+	}
+	unique := alias
+	for n := 2; aliases[strings.ToLower(unique)]; n++ {
+		unique = alias + "-" + strconv.Itoa(n)
+	}
+	aliases[strings.ToLower(unique)] = true
+	return unique
+}
+
+// creationDate returns the signingTime attribute holding the creation date
+// of an entry, or false if there is none or it cannot be encoded.
+func creationDate(date time.Time) (pkcs12.Attribute, bool) {
+	if date.IsZero() {
+		return pkcs12.Attribute{}, false
+	}
+	a, err := pkcs12.SigningTimeAttribute(date.UTC().Truncate(time.Second))
+	return a, err == nil
+}
+
+func friendlyName(alias string) pkcs12.Attribute {
+	var value []byte
+	for _, u := range utf16.Encode([]rune(alias)) {
+		value = append(value, byte(u>>8), byte(u))
+	}
+	return pkcs12.Attribute{
+		Type:   oidFriendlyName,
+		Values: [][]byte{mustMarshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: value})},
+	}
+}
@@ -0,0 +1,415 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package jks reads and writes Java KeyStore (JKS) files, the keystore
+// format of the Java keytool before Java 9, and converts their entries to
+// and from PKCS#12 so that keystores can be migrated without keytool.
+//
+// The format is undocumented; the implementation follows the behavior of
+// sun.security.provider.JavaKeyStore and KeyProtector.  JCEKS keystores
+// are not supported.
+package jks
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	magic   = 0xfeedfeed
+	version = 2
+
+	tagPrivateKey  = 1
+	tagTrustedCert = 2
+
+	certType = "X.509"
+
+	// integritySalt is mixed into the keystore digest, see
+	// JavaKeyStore.getPreKeyedHash.
+	integritySalt = "Mighty Aphrodite"
+)
+
+// oidKeyProtector identifies the proprietary key protection algorithm of
+// the Sun provider.
+var oidKeyProtector = asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1})
+
+var (
+	// ErrIncorrectPassword is returned when the keystore digest or the
+	// check value of a protected key does not match the password.
+	ErrIncorrectPassword = errors.New("jks: password incorrect or keystore tampered with")
+
+	errFormat = errors.New("jks: malformed keystore")
+)
+
+// A KeyStore is the content of a JKS file.
+type KeyStore struct {
+	Entries []Entry
+}
+
+// An Entry is a private key entry or a trusted certificate entry of a
+// KeyStore.
+type Entry struct {
+	Alias string
+	// Date is the creation date of the entry.  Encode uses the current
+	// time if it is zero.
+	Date time.Time
+
+	// PrivateKey and CertificateChain are set for private key entries.
+	// The first certificate of the chain is the one of the private key.
+	PrivateKey       crypto.PrivateKey
+	CertificateChain []*x509.Certificate
+
+	// Certificate is set for trusted certificate entries.
+	Certificate *x509.Certificate
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// Decode parses a JKS file, checking its integrity with storePassword and
+// decrypting the private keys with keyPassword.  keytool protects keys with
+// the store password unless told otherwise.
+func Decode(data []byte, storePassword, keyPassword string) (*KeyStore, error) {
+	if len(data) < sha1.Size {
+		return nil, errFormat
+	}
+	content, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if !hmac.Equal(integrityDigest(content, storePassword), digest) {
+		return nil, ErrIncorrectPassword
+	}
+
+	r := &reader{data: content}
+	if r.uint32() != magic {
+		return nil, errors.New("jks: not a JKS keystore")
+	}
+	if v := r.uint32(); v != version && r.err == nil {
+		return nil, errors.New("jks: unsupported keystore version")
+	}
+
+	ks := new(KeyStore)
+	for n := r.uint32(); n > 0 && r.err == nil; n-- {
+		var entry Entry
+		tag := r.uint32()
+		entry.Alias = r.utf()
+		entry.Date = time.UnixMilli(int64(r.uint64()))
+
+		switch tag {
+		case tagPrivateKey:
+			protectedKey := r.bytes()
+			for n := r.uint32(); n > 0 && r.err == nil; n-- {
+				cert, err := r.certificate()
+				if err != nil {
+					return nil, err
+				}
+				entry.CertificateChain = append(entry.CertificateChain, cert)
+			}
+			if r.err != nil {
+				break
+			}
+			key, err := recoverKey(protectedKey, keyPassword)
+			if err != nil {
+				return nil, err
+			}
+			entry.PrivateKey = key
+		case tagTrustedCert:
+			cert, err := r.certificate()
+			if err != nil {
+				return nil, err
+			}
+			entry.Certificate = cert
+		default:
+			if r.err == nil {
+				return nil, errors.New("jks: unknown entry type")
+			}
+		}
+		ks.Entries = append(ks.Entries, entry)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) != 0 {
+		return nil, errors.New("jks: trailing data found")
+	}
+	return ks, nil
+}
+
+// Encode writes ks as a JKS file, protecting its integrity with
+// storePassword and its private keys with keyPassword.  The rand argument
+// provides the salts and can be set to rand.Reader from the crypto/rand
+// package.
+//
+// The key protection of JKS is weak, see CVE-2017-10356 and similar;
+// keystores written by Encode should only be used for compatibility with
+// software that cannot read PKCS#12.
+func Encode(rand io.Reader, ks *KeyStore, storePassword, keyPassword string) ([]byte, error) {
+	w := new(writer)
+	w.uint32(magic)
+	w.uint32(version)
+	w.uint32(uint32(len(ks.Entries)))
+
+	for _, entry := range ks.Entries {
+		date := entry.Date
+		if date.IsZero() {
+			date = time.Now()
+		}
+
+		switch {
+		case entry.PrivateKey != nil:
+			if len(entry.CertificateChain) == 0 {
+				return nil, errors.New("jks: private key entry " + entry.Alias + " has no certificate chain")
+			}
+			protectedKey, err := protectKey(rand, entry.PrivateKey, keyPassword)
+			if err != nil {
+				return nil, err
+			}
+			w.uint32(tagPrivateKey)
+			w.utf(entry.Alias)
+			w.uint64(uint64(date.UnixMilli()))
+			w.bytes(protectedKey)
+			w.uint32(uint32(len(entry.CertificateChain)))
+			for _, cert := range entry.CertificateChain {
+				w.certificate(cert)
+			}
+		case entry.Certificate != nil:
+			w.uint32(tagTrustedCert)
+			w.utf(entry.Alias)
+			w.uint64(uint64(date.UnixMilli()))
+			w.certificate(entry.Certificate)
+		default:
+			return nil, errors.New("jks: entry " + entry.Alias + " has neither a private key nor a certificate")
+		}
+	}
+
+	return append(w.buf, integrityDigest(w.buf, storePassword)...), nil
+}
+
+// passwordBytes returns password as Java stores a char[]: big-endian
+// UTF-16 code units without a terminator.
+func passwordBytes(password string) []byte {
+	units := utf16.Encode([]rune(password))
+	b := make([]byte, 0, 2*len(units))
+	for _, u := range units {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return b
+}
+
+func integrityDigest(content []byte, password string) []byte {
+	h := sha1.New()
+	h.Write(passwordBytes(password))
+	h.Write([]byte(integritySalt))
+	h.Write(content)
+	return h.Sum(nil)
+}
+
+// keyStream XORs data with the key protector keystream derived from
+// password and salt.
+func keyStream(data, password, salt []byte) {
+	digest := salt
+	for i := 0; i < len(data); i += sha1.Size {
+		h := sha1.New()
+		h.Write(password)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(data); j++ {
+			data[i+j] ^= digest[j]
+		}
+	}
+}
+
+func protectKey(rand io.Reader, privateKey crypto.PrivateKey, password string) ([]byte, error) {
+	plaintext, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.New("jks: error encoding PKCS#8 private key: " + err.Error())
+	}
+	pw := passwordBytes(password)
+
+	salt := make([]byte, sha1.Size)
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return nil, errors.New("jks: error reading random salt: " + err.Error())
+	}
+
+	check := sha1.New()
+	check.Write(pw)
+	check.Write(plaintext)
+
+	encrypted := make([]byte, 0, 2*sha1.Size+len(plaintext))
+	encrypted = append(encrypted, salt...)
+	encrypted = append(encrypted, plaintext...)
+	keyStream(encrypted[sha1.Size:], pw, salt)
+	encrypted = check.Sum(encrypted)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: encrypted,
+	})
+}
+
+func recoverKey(protectedKey []byte, password string) (crypto.PrivateKey, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(protectedKey, &info); err != nil || len(rest) != 0 {
+		return nil, errors.New("jks: error decoding protected key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidKeyProtector) {
+		return nil, errors.New("jks: unsupported key protection algorithm " + info.Algorithm.Algorithm.String())
+	}
+	if len(info.EncryptedData) < 2*sha1.Size {
+		return nil, errors.New("jks: protected key too short")
+	}
+	pw := passwordBytes(password)
+
+	salt := info.EncryptedData[:sha1.Size]
+	plaintext := append([]byte(nil), info.EncryptedData[sha1.Size:len(info.EncryptedData)-sha1.Size]...)
+	keyStream(plaintext, pw, salt)
+
+	check := sha1.New()
+	check.Write(pw)
+	check.Write(plaintext)
+	if !hmac.Equal(check.Sum(nil), info.EncryptedData[len(info.EncryptedData)-sha1.Size:]) {
+		return nil, ErrIncorrectPassword
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(plaintext)
+	if err != nil {
+		return nil, errors.New("jks: error parsing PKCS#8 private key: " + err.Error())
+	}
+	return key, nil
+}
+
+// reader decodes the big-endian primitives of java.io.DataInputStream.
+// The first error is sticky; later calls return zero values.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = errFormat
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) bytes() []byte {
+	return r.next(int(r.uint32()))
+}
+
+func (r *reader) utf() string {
+	s, err := decodeModifiedUTF8(r.next(int(r.uint16())))
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return s
+}
+
+func (r *reader) certificate() (*x509.Certificate, error) {
+	if t := r.utf(); t != certType && r.err == nil {
+		return nil, errors.New("jks: unsupported certificate type " + t)
+	}
+	der := r.bytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// writer encodes the big-endian primitives of java.io.DataOutputStream.
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) uint32(v uint32) { w.buf = binary.BigEndian.AppendUint32(w.buf, v) }
+func (w *writer) uint64(v uint64) { w.buf = binary.BigEndian.AppendUint64(w.buf, v) }
+
+func (w *writer) bytes(b []byte) {
+	w.uint32(uint32(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *writer) utf(s string) {
+	b := encodeModifiedUTF8(s)
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *writer) certificate(cert *x509.Certificate) {
+	w.utf(certType)
+	w.bytes(cert.Raw)
+}
+
+// encodeModifiedUTF8 encodes s as DataOutputStream.writeUTF does: UTF-16
+// code units are encoded individually, and U+0000 takes two bytes.
+func encodeModifiedUTF8(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		switch {
+		case u != 0 && u < 0x80:
+			b = append(b, byte(u))
+		case u < 0x800:
+			b = append(b, 0xc0|byte(u>>6), 0x80|byte(u&0x3f))
+		default:
+			b = append(b, 0xe0|byte(u>>12), 0x80|byte(u>>6&0x3f), 0x80|byte(u&0x3f))
+		}
+	}
+	return b
+}
+
+func decodeModifiedUTF8(b []byte) (string, error) {
+	var units []uint16
+	for len(b) > 0 {
+		switch {
+		case b[0] < 0x80:
+			units = append(units, uint16(b[0]))
+			b = b[1:]
+		case b[0]&0xe0 == 0xc0 && len(b) >= 2 && b[1]&0xc0 == 0x80:
+			units = append(units, uint16(b[0]&0x1f)<<6|uint16(b[1]&0x3f))
+			b = b[2:]
+		case b[0]&0xf0 == 0xe0 && len(b) >= 3 && b[1]&0xc0 == 0x80 && b[2]&0xc0 == 0x80:
+			units = append(units, uint16(b[0]&0x0f)<<12|uint16(b[1]&0x3f)<<6|uint16(b[2]&0x3f))
+			b = b[3:]
+		default:
+			return "", errors.New("jks: malformed alias")
+		}
+	}
+	return string(utf16.Decode(units)), nil
+}
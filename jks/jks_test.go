@@ -0,0 +1,240 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pkcs12 "github.com/nevissecurity/go-pkcs12"
+)
+
+func makeTestCertificate(t *testing.T, commonName string, isCA bool, parent *x509.Certificate, parentKey crypto.Signer) (crypto.Signer, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func makeTestKeyStore(t *testing.T) *KeyStore {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rsa.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	rsaDER, err := x509.CreateCertificate(rand.Reader, rsaTemplate, rsaTemplate, rsaKey.Public(), rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert, err := x509.ParseCertificate(rsaDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, trustedCert := makeTestCertificate(t, "Trusted CA", true, nil, nil)
+
+	date := time.UnixMilli(1500000000000)
+	return &KeyStore{Entries: []Entry{
+		{Alias: "leaf", Date: date, PrivateKey: key, CertificateChain: []*x509.Certificate{cert, caCert}},
+		{Alias: "rsa", Date: date, PrivateKey: rsaKey, CertificateChain: []*x509.Certificate{rsaCert}},
+		{Alias: "trusted", Date: date, Certificate: trustedCert},
+	}}
+}
+
+func checkKeyStore(t *testing.T, expected, actual *KeyStore, compareDates bool) {
+	t.Helper()
+	if len(actual.Entries) != len(expected.Entries) {
+		t.Fatalf("expected %d entries, got %d", len(expected.Entries), len(actual.Entries))
+	}
+	for i, e := range expected.Entries {
+		a := actual.Entries[i]
+		if a.Alias != e.Alias {
+			t.Errorf("entry %d: expected alias %q, got %q", i, e.Alias, a.Alias)
+		}
+		if compareDates && !a.Date.Equal(e.Date) {
+			t.Errorf("entry %d: expected date %v, got %v", i, e.Date, a.Date)
+		}
+		if e.PrivateKey != nil {
+			if a.PrivateKey == nil || !a.PrivateKey.(interface{ Equal(crypto.PrivateKey) bool }).Equal(e.PrivateKey) {
+				t.Errorf("entry %d: private key differs", i)
+			}
+		} else if a.PrivateKey != nil {
+			t.Errorf("entry %d: unexpected private key", i)
+		}
+		if len(a.CertificateChain) != len(e.CertificateChain) {
+			t.Errorf("entry %d: expected %d chain certificates, got %d", i, len(e.CertificateChain), len(a.CertificateChain))
+		} else {
+			for j := range e.CertificateChain {
+				if !a.CertificateChain[j].Equal(e.CertificateChain[j]) {
+					t.Errorf("entry %d: chain certificate %d differs", i, j)
+				}
+			}
+		}
+		if (e.Certificate == nil) != (a.Certificate == nil) || e.Certificate != nil && !a.Certificate.Equal(e.Certificate) {
+			t.Errorf("entry %d: trusted certificate differs", i)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ks := makeTestKeyStore(t)
+	data, err := Encode(rand.Reader, ks, "store password", "key password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data, "store password", "key password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeyStore(t, ks, decoded, true)
+
+	if _, err := Decode(data, "wrong", "key password"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong store password: expected ErrIncorrectPassword, got %v", err)
+	}
+	if _, err := Decode(data, "store password", "wrong"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("wrong key password: expected ErrIncorrectPassword, got %v", err)
+	}
+
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := Decode(tampered, "store password", "key password"); !errors.Is(err, ErrIncorrectPassword) {
+		t.Errorf("tampered keystore: expected ErrIncorrectPassword, got %v", err)
+	}
+}
+
+func TestModifiedUTF8(t *testing.T) {
+	for _, s := range []string{"", "alias", "a\x00b", "grüße", "€", "\U0001F511 key"} {
+		encoded := encodeModifiedUTF8(s)
+		for _, b := range encoded {
+			if b == 0 {
+				t.Errorf("%q: encoding contains a NUL byte", s)
+			}
+		}
+		decoded, err := decodeModifiedUTF8(encoded)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		} else if decoded != s {
+			t.Errorf("%q: decoded as %q", s, decoded)
+		}
+	}
+	// U+1F511 is written as a surrogate pair of three bytes each.
+	if n := len(encodeModifiedUTF8("\U0001F511")); n != 6 {
+		t.Errorf("expected 6 bytes for a supplementary character, got %d", n)
+	}
+}
+
+func TestPKCS12Conversion(t *testing.T) {
+	ks := makeTestKeyStore(t)
+
+	pfxData, err := pkcs12.Modern.EncodeContents(ks.ToPKCS12(), "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := pkcs12.DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := FromPKCS12(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeyStore(t, ks, converted, true)
+
+	// The files written by Encode pair keys and certificates the same
+	// way, and name their entries after the leaf certificates.
+	leaf := ks.Entries[0]
+	pfxData, err = pkcs12.Modern.Encode(leaf.PrivateKey, leaf.CertificateChain[0], leaf.CertificateChain[1:], "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents, err = pkcs12.DecodeContents(pfxData, "password"); err != nil {
+		t.Fatal(err)
+	}
+	if converted, err = FromPKCS12(contents); err != nil {
+		t.Fatal(err)
+	}
+	expected := &KeyStore{Entries: []Entry{{Alias: "key-0", PrivateKey: leaf.PrivateKey, CertificateChain: leaf.CertificateChain}}}
+	checkKeyStore(t, expected, converted, false)
+}
+
+func TestFromOpenSSL(t *testing.T) {
+	pfxData, err := os.ReadFile(filepath.Join("..", "testdata", "openssl-legacy-chain.p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := pkcs12.DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := FromPKCS12(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.Entries) != 1 || ks.Entries[0].PrivateKey == nil || len(ks.Entries[0].CertificateChain) != 2 {
+		t.Fatalf("expected one private key entry with a chain of 2, got %+v", ks.Entries)
+	}
+	chain := ks.Entries[0].CertificateChain
+	if chain[0].Subject.CommonName != "leaf.openssl.example.com" || chain[1].Subject.CommonName != "OpenSSL Test CA" {
+		t.Errorf("unexpected chain %s, %s", chain[0].Subject, chain[1].Subject)
+	}
+
+	data, err := Encode(rand.Reader, ks, "changeit", "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data, "changeit", "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkKeyStore(t, ks, decoded, false)
+}
+
+func TestFromPKCS12MissingCertificate(t *testing.T) {
+	key, _ := makeTestCertificate(t, "orphan", false, nil, nil)
+	contents := []pkcs12.SafeContents{{Entries: []pkcs12.Entry{{BagType: pkcs12.PKCS8ShroudedKeyBag, PrivateKey: key}}}}
+	var missing *MissingCertificateError
+	if _, err := FromPKCS12(contents); !errors.As(err, &missing) {
+		t.Errorf("expected MissingCertificateError, got %v", err)
+	}
+}
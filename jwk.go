@@ -0,0 +1,280 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// jwk is a JSON Web Key as defined in https://tools.ietf.org/html/rfc7517,
+// with the members for the key types of
+// https://tools.ietf.org/html/rfc7518#section-6 and
+// https://tools.ietf.org/html/rfc8037.
+type jwk struct {
+	Kty     string   `json:"kty"`
+	Kid     string   `json:"kid,omitempty"`
+	Crv     string   `json:"crv,omitempty"`
+	N       string   `json:"n,omitempty"`
+	E       string   `json:"e,omitempty"`
+	X       string   `json:"x,omitempty"`
+	Y       string   `json:"y,omitempty"`
+	D       string   `json:"d,omitempty"`
+	P       string   `json:"p,omitempty"`
+	Q       string   `json:"q,omitempty"`
+	DP      string   `json:"dp,omitempty"`
+	DQ      string   `json:"dq,omitempty"`
+	QI      string   `json:"qi,omitempty"`
+	X5c     []string `json:"x5c,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+}
+
+type jwkSet struct {
+	Keys []*jwk `json:"keys"`
+}
+
+// ToJWK returns entry as a JSON Web Key (RFC 7517).  A key entry becomes a
+// private JWK, and a certificate entry the public JWK of its certificate
+// with the certificate as x5c.  The kid is the friendlyName of the entry, or
+// the JWK thumbprint (RFC 7638) if it has none.
+//
+// The private JWK holds the key in the clear and must be protected like
+// the key itself.
+func ToJWK(entry Entry) ([]byte, error) {
+	key, err := entryJWK(&entry, nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(key)
+}
+
+// ToJWKS returns entries, such as the Entries of the SafeContents returned
+// by DecodeContents, as a JSON Web Key Set (RFC 7517).
+//
+// Every private key becomes a private JWK whose x5c holds its certificate,
+// paired by localKeyId or public key, followed by the chain built from the
+// other certificates by issuer.  Certificates that are in no such chain
+// become public JWKs of their own.  Entries of other bag types are skipped.
+func ToJWKS(entries []Entry) ([]byte, error) {
+	var certs []*x509.Certificate
+	var certIDs [][]byte
+	var certEntries []*Entry
+	for i := range entries {
+		if entries[i].Certificate != nil {
+			certs = append(certs, entries[i].Certificate)
+			certIDs = append(certIDs, entries[i].LocalKeyID())
+			certEntries = append(certEntries, &entries[i])
+		}
+	}
+
+	set := jwkSet{Keys: []*jwk{}}
+	inChain := make([]bool, len(certs))
+	for i := range entries {
+		entry := &entries[i]
+		if entry.PrivateKey == nil {
+			continue
+		}
+		var chain []*x509.Certificate
+		if leaf := findLeaf(entry.PrivateKey, entry.LocalKeyID(), certs, certIDs); leaf < len(certs) && MatchKeyToCert(entry.PrivateKey, certs[leaf]) == nil {
+			for _, j := range chainFrom(certs, leaf) {
+				inChain[j] = true
+				chain = append(chain, certs[j])
+			}
+		}
+		key, err := entryJWK(entry, chain)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	for i, entry := range certEntries {
+		if inChain[i] {
+			continue
+		}
+		key, err := entryJWK(entry, nil)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, key)
+	}
+
+	return json.Marshal(set)
+}
+
+// chainFrom returns the indexes of the certificates from certs[leaf] up to
+// a self-signed certificate, following issuers as far as they are present.
+func chainFrom(certs []*x509.Certificate, leaf int) []int {
+	chain := []int{leaf}
+	used := map[int]bool{leaf: true}
+	for current := certs[leaf]; !bytes.Equal(current.RawIssuer, current.RawSubject); {
+		next := -1
+		for i, candidate := range certs {
+			if !used[i] && bytes.Equal(candidate.RawSubject, current.RawIssuer) && current.CheckSignatureFrom(candidate) == nil {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		chain = append(chain, next)
+		used[next] = true
+		current = certs[next]
+	}
+	return chain
+}
+
+// entryJWK returns the JWK of a key or certificate entry.  The x5c of a key
+// is set to chain; that of a certificate to the certificate itself.
+func entryJWK(entry *Entry, chain []*x509.Certificate) (*jwk, error) {
+	var key *jwk
+	var err error
+	switch {
+	case entry.PrivateKey != nil:
+		key, err = privateJWK(entry.PrivateKey)
+	case entry.Certificate != nil:
+		key, err = publicJWK(entry.Certificate.PublicKey)
+		chain = []*x509.Certificate{entry.Certificate}
+	default:
+		return nil, NotImplementedError("bags of type " + string(entry.BagType) + " cannot be represented as a JWK")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cert := range chain {
+		key.X5c = append(key.X5c, base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	if len(chain) != 0 {
+		fingerprint := sha256.Sum256(chain[0].Raw)
+		key.X5tS256 = base64URL(fingerprint[:])
+	}
+
+	if key.Kid = entry.FriendlyName(); key.Kid == "" {
+		key.Kid = key.thumbprint()
+	}
+	return key, nil
+}
+
+func publicJWK(publicKey crypto.PublicKey) (*jwk, error) {
+	switch publicKey := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &jwk{Kty: "RSA", N: base64URL(publicKey.N.Bytes()), E: base64URL(big.NewInt(int64(publicKey.E)).Bytes())}, nil
+	case *ecdsa.PublicKey:
+		crv, size, err := jwkCurve(publicKey.Curve)
+		if err != nil {
+			return nil, err
+		}
+		return &jwk{
+			Kty: "EC",
+			Crv: crv,
+			X:   base64URL(publicKey.X.FillBytes(make([]byte, size))),
+			Y:   base64URL(publicKey.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return &jwk{Kty: "OKP", Crv: "Ed25519", X: base64URL(publicKey)}, nil
+	case *ecdh.PublicKey:
+		if publicKey.Curve() != ecdh.X25519() {
+			return nil, NotImplementedError("ECDH public keys are only supported on X25519")
+		}
+		return &jwk{Kty: "OKP", Crv: "X25519", X: base64URL(publicKey.Bytes())}, nil
+	default:
+		return nil, NotImplementedError(fmt.Sprintf("public keys of type %T cannot be represented as a JWK", publicKey))
+	}
+}
+
+func privateJWK(privateKey crypto.PrivateKey) (*jwk, error) {
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if len(privateKey.Primes) != 2 {
+			return nil, NotImplementedError("multi-prime RSA keys cannot be represented as a JWK")
+		}
+		if privateKey.Precomputed.Dp == nil {
+			privateKey.Precompute()
+		}
+		key, err := publicJWK(&privateKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		key.D = base64URL(privateKey.D.Bytes())
+		key.P = base64URL(privateKey.Primes[0].Bytes())
+		key.Q = base64URL(privateKey.Primes[1].Bytes())
+		key.DP = base64URL(privateKey.Precomputed.Dp.Bytes())
+		key.DQ = base64URL(privateKey.Precomputed.Dq.Bytes())
+		key.QI = base64URL(privateKey.Precomputed.Qinv.Bytes())
+		return key, nil
+	case *ecdsa.PrivateKey:
+		key, err := publicJWK(&privateKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		_, size, _ := jwkCurve(privateKey.Curve)
+		key.D = base64URL(privateKey.D.FillBytes(make([]byte, size)))
+		return key, nil
+	case ed25519.PrivateKey:
+		key, err := publicJWK(privateKey.Public())
+		if err != nil {
+			return nil, err
+		}
+		key.D = base64URL(privateKey.Seed())
+		return key, nil
+	case *ecdh.PrivateKey:
+		key, err := publicJWK(privateKey.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		key.D = base64URL(privateKey.Bytes())
+		return key, nil
+	default:
+		return nil, NotImplementedError(fmt.Sprintf("private keys of type %T cannot be represented as a JWK", privateKey))
+	}
+}
+
+// jwkCurve returns the JWK name and the coordinate size of curve.
+func jwkCurve(curve elliptic.Curve) (string, int, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", 32, nil
+	case elliptic.P384():
+		return "P-384", 48, nil
+	case elliptic.P521():
+		return "P-521", 66, nil
+	default:
+		return "", 0, NotImplementedError("elliptic curve " + curve.Params().Name + " cannot be represented as a JWK")
+	}
+}
+
+// thumbprint returns the JWK thumbprint of key as defined in
+// https://tools.ietf.org/html/rfc7638: the SHA-256 hash of the required
+// public members, in lexicographic order and without whitespace.
+func (key *jwk) thumbprint() string {
+	var members []byte
+	switch key.Kty {
+	case "RSA":
+		members = fmt.Appendf(nil, `{"e":%q,"kty":%q,"n":%q}`, key.E, key.Kty, key.N)
+	case "EC":
+		members = fmt.Appendf(nil, `{"crv":%q,"kty":%q,"x":%q,"y":%q}`, key.Crv, key.Kty, key.X, key.Y)
+	default:
+		members = fmt.Appendf(nil, `{"crv":%q,"kty":%q,"x":%q}`, key.Crv, key.Kty, key.X)
+	}
+	digest := sha256.Sum256(members)
+	return base64URL(digest[:])
+}
+
+func base64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,159 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// The example of https://tools.ietf.org/html/rfc7638#section-3.1.
+	key := &jwk{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+	if thumbprint := key.thumbprint(); thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("unexpected thumbprint %s", thumbprint)
+	}
+}
+
+func decodeJWKInt(t *testing.T, s string) *big.Int {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return new(big.Int).SetBytes(b)
+}
+
+func TestToJWKS(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	_, otherCA := makeTestCertificate(t, "Other CA", true, nil, nil)
+
+	pfxData, err := Modern.Encode(key, cert, []*x509.Certificate{otherCA, caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	for _, c := range contents {
+		entries = append(entries, c.Entries...)
+	}
+
+	data, err := ToJWKS(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set jwkSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(set.Keys))
+	}
+
+	private := set.Keys[0]
+	if private.Kty != "EC" || private.Crv != "P-256" || private.D == "" {
+		t.Fatalf("expected a private P-256 key, got %s", data)
+	}
+	if decodeJWKInt(t, private.D).Cmp(key.D) != 0 || decodeJWKInt(t, private.X).Cmp(key.X) != 0 || decodeJWKInt(t, private.Y).Cmp(key.Y) != 0 {
+		t.Error("private key members differ")
+	}
+	if len(private.X5c) != 2 || private.X5c[0] != base64.StdEncoding.EncodeToString(cert.Raw) || private.X5c[1] != base64.StdEncoding.EncodeToString(caCert.Raw) {
+		t.Errorf("expected x5c to be the leaf and its CA, got %v", private.X5c)
+	}
+	if private.Kid != private.thumbprint() {
+		t.Errorf("expected the thumbprint as kid, got %q", private.Kid)
+	}
+
+	public := set.Keys[1]
+	if public.D != "" || len(public.X5c) != 1 || public.X5c[0] != base64.StdEncoding.EncodeToString(otherCA.Raw) {
+		t.Errorf("expected the public key of the other CA, got %+v", public)
+	}
+}
+
+func TestToJWK(t *testing.T) {
+	pfxData, err := os.ReadFile(filepath.Join("testdata", "openssl-legacy-chain.p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rsaEntry *Entry
+	for i := range contents {
+		for j := range contents[i].Entries {
+			if contents[i].Entries[j].PrivateKey != nil {
+				rsaEntry = &contents[i].Entries[j]
+			}
+		}
+	}
+	if rsaEntry == nil {
+		t.Fatal("no key found")
+	}
+
+	data, err := ToJWK(*rsaEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key jwk
+	if err := json.Unmarshal(data, &key); err != nil {
+		t.Fatal(err)
+	}
+	rsaKey := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: decodeJWKInt(t, key.N), E: int(decodeJWKInt(t, key.E).Int64())},
+		D:         decodeJWKInt(t, key.D),
+		Primes:    []*big.Int{decodeJWKInt(t, key.P), decodeJWKInt(t, key.Q)},
+	}
+	if err := rsaKey.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !rsaKey.Equal(rsaEntry.PrivateKey) {
+		t.Error("RSA key differs")
+	}
+	if len(key.X5c) != 0 {
+		t.Error("expected no x5c for a key entry on its own")
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := marshalBmpString("signing key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ToJWK(Entry{BagType: KeyBag, PrivateKey: edKey, Attributes: []Attribute{{Type: oidFriendlyName, Values: [][]byte{name}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var edJWK jwk
+	if err := json.Unmarshal(data, &edJWK); err != nil {
+		t.Fatal(err)
+	}
+	if edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.Kid != "signing key" || edJWK.D != base64.RawURLEncoding.EncodeToString(edKey.Seed()) {
+		t.Errorf("unexpected Ed25519 JWK %s", data)
+	}
+
+	if _, err := ToJWK(Entry{BagType: SecretBag, Value: []byte{0x04, 0x00}}); err == nil {
+		t.Error("expected an error for a secret bag")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"crypto"
+	_ "crypto/sha1" // the hash functions of macHashFor and prfFor
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/nevissecurity/go-pkcs12/pbe"
+)
+
+// A KDF derives the keys protecting a PKCS#12 file from its password.
+// WithKDF substitutes one for DefaultKDF, so that tests can record or
+// replace the derivations and so that they can be handed to hardware or a
+// FIPS module.  A KDF is called from several goroutines at once when keys
+// are derived in parallel, see WithParallelism, and should stop with the
+// error of ctx if ctx is cancelled.  The keys it returns are wiped after
+// use.
+type KDF interface {
+	// PKCS12 returns size bytes derived for purpose with the PKCS#12 key
+	// derivation function, see
+	// https://tools.ietf.org/html/rfc7292#appendix-B.2, which the MAC and
+	// the legacy PBE schemes use.  password is encoded as a BMPString
+	// with a zero terminator, or empty, see pbe.EncodePassword.
+	PKCS12(ctx context.Context, h crypto.Hash, purpose pbe.Purpose, password, salt []byte, iterations, size int) ([]byte, error)
+	// PBKDF2 returns keyLen bytes derived with PBKDF2 using HMAC with h,
+	// see https://tools.ietf.org/html/rfc8018#section-5.2, which PBES2
+	// uses.  password is UTF-8 encoded.
+	PBKDF2(ctx context.Context, h crypto.Hash, password, salt []byte, iterations, keyLen int) ([]byte, error)
+}
+
+// DefaultKDF is the KDF of Encoders and Decoders without WithKDF, which
+// derives the keys in software.  A KDF passing only some of the
+// derivations elsewhere can use it for the others.
+var DefaultKDF KDF = defaultKDF{}
+
+type defaultKDF struct{}
+
+func (defaultKDF) PKCS12(ctx context.Context, h crypto.Hash, purpose pbe.Purpose, password, salt []byte, iterations, size int) ([]byte, error) {
+	return pbe.Derive(ctx, h.New, purpose, password, salt, iterations, size)
+}
+
+func (defaultKDF) PBKDF2(ctx context.Context, h crypto.Hash, password, salt []byte, iterations, keyLen int) ([]byte, error) {
+	return pbkdf2(ctx, h.New, password, salt, iterations, keyLen)
+}
+
+// DeriveKey returns size bytes derived for purpose from password, salt and
+// iterations by the PKCS#12 key derivation function with h, see
+// https://tools.ietf.org/html/rfc7292#appendix-B.2, such as the key of a
+// MAC computed by another implementation.  password is encoded as the KDF
+// expects, as a BMPString with a zero terminator; a *PasswordError is
+// returned if it cannot be.  pbe.Derive takes the encoded password
+// instead.
+func DeriveKey(h crypto.Hash, password string, salt []byte, iterations int, purpose pbe.Purpose, size int) ([]byte, error) {
+	if !h.Available() {
+		return nil, NotImplementedError("hash function " + h.String() + " is not available")
+	}
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return pbe.Derive(context.Background(), h.New, purpose, encodedPassword, salt, iterations, size)
+}
+
+// WithKDF creates a new Encoder identical to enc except that it derives
+// its keys with kdf instead of DefaultKDF.  Together with WithRand or
+// WithDeterministicEncoding, this makes the whole encoding reproducible.
+func (enc Encoder) WithKDF(kdf KDF) *Encoder {
+	enc.kdf = kdf
+	return &enc
+}
+
+// WithKDF creates a new Decoder identical to dec except that it derives
+// the keys of the files it decodes with kdf instead of DefaultKDF.
+func (dec Decoder) WithKDF(kdf KDF) *Decoder {
+	dec.kdf = kdf
+	return &dec
+}
+
+// kdfKey is the key of the KDF in the contexts returned by kdfContext.
+type kdfKey struct{}
+
+// withKDF returns ctx carrying kdf to the key derivations, unless kdf is
+// nil.
+func withKDF(ctx context.Context, kdf KDF) context.Context {
+	if kdf == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, kdfKey{}, kdf)
+}
+
+// kdfFor returns the KDF carried by ctx, or DefaultKDF.
+func kdfFor(ctx context.Context) KDF {
+	if kdf, ok := ctx.Value(kdfKey{}).(KDF); ok {
+		return kdf
+	}
+	return DefaultKDF
+}
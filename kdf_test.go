@@ -0,0 +1,108 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/nevissecurity/go-pkcs12/pbe"
+)
+
+// countingKDF counts the derivations it passes on to DefaultKDF, and fails
+// them with err if it is set.
+type countingKDF struct {
+	mu             sync.Mutex
+	pkcs12, pbkdf2 int
+	err            error
+}
+
+func (k *countingKDF) PKCS12(ctx context.Context, h crypto.Hash, purpose pbe.Purpose, password, salt []byte, iterations, size int) ([]byte, error) {
+	k.mu.Lock()
+	k.pkcs12++
+	k.mu.Unlock()
+	if k.err != nil {
+		return nil, k.err
+	}
+	return DefaultKDF.PKCS12(ctx, h, purpose, password, salt, iterations, size)
+}
+
+func (k *countingKDF) PBKDF2(ctx context.Context, h crypto.Hash, password, salt []byte, iterations, keyLen int) ([]byte, error) {
+	k.mu.Lock()
+	k.pbkdf2++
+	k.mu.Unlock()
+	if k.err != nil {
+		return nil, k.err
+	}
+	return DefaultKDF.PBKDF2(ctx, h, password, salt, iterations, keyLen)
+}
+
+func TestWithKDF(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	for _, test := range []struct {
+		name           string
+		enc            *Encoder
+		pkcs12, pbkdf2 int
+	}{
+		// The MAC, and for the legacy encoder the key and IV of both
+		// the certificates and the private key.
+		{"Modern", Modern, 1, 2},
+		{"LegacyDES", LegacyDES.AllowWeakAlgorithms(), 5, 0},
+	} {
+		encKDF := &countingKDF{}
+		pfxData, err := test.enc.WithKDF(encKDF).Encode(key, cert, nil, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if encKDF.pkcs12 != test.pkcs12 || encKDF.pbkdf2 != test.pbkdf2 {
+			t.Errorf("%s: encoding made %d PKCS#12 and %d PBKDF2 derivations, expected %d and %d", test.name, encKDF.pkcs12, encKDF.pbkdf2, test.pkcs12, test.pbkdf2)
+		}
+
+		decKDF := &countingKDF{}
+		if _, _, err := DefaultDecoder.WithKDF(decKDF).Decode(pfxData, DefaultPassword); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if decKDF.pkcs12 != test.pkcs12 || decKDF.pbkdf2 != test.pbkdf2 {
+			t.Errorf("%s: decoding made %d PKCS#12 and %d PBKDF2 derivations, expected %d and %d", test.name, decKDF.pkcs12, decKDF.pbkdf2, test.pkcs12, test.pbkdf2)
+		}
+	}
+
+	failing := &countingKDF{err: errors.New("HSM unavailable")}
+	if _, err := Modern.WithKDF(failing).Encode(key, cert, nil, DefaultPassword); !errors.Is(err, failing.err) {
+		t.Errorf("expected the error of the KDF when encoding, got %v", err)
+	}
+	pfxData, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := DefaultDecoder.WithKDF(failing).Decode(pfxData, DefaultPassword); !errors.Is(err, failing.err) {
+		t.Errorf("expected the error of the KDF when decoding, got %v", err)
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	// The published vector of the MAC key for "smeg", see pbe.
+	key, err := DeriveKey(crypto.SHA1, "smeg", []byte{0x3d, 0x83, 0xc0, 0xe4, 0x54, 0x6a, 0xc1, 0x40}, 1, pbe.MACKey, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "8d967d88f6caa9d714800ab3d48051d63f73a312"; hex.EncodeToString(key) != expected {
+		t.Errorf("expected %s, got %x", expected, key)
+	}
+
+	var passwordErr *PasswordError
+	if _, err := DeriveKey(crypto.SHA1, "\U0001f000", nil, 1, pbe.MACKey, 20); !errors.As(err, &passwordErr) {
+		t.Errorf("expected a PasswordError, got %v", err)
+	}
+	if _, err := DeriveKey(crypto.MD4, "smeg", nil, 1, pbe.MACKey, 20); err == nil {
+		t.Error("derived a key with an unavailable hash")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"container/list"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/nevissecurity/go-pkcs12/pbe"
+)
+
+// A KDFCache is a KDF which remembers the keys derived by another KDF, so
+// that decoding the same file again with the same password, as an agent
+// reloading its key for every TLS renewal does, skips the key derivations.
+// Keys are looked up by the derivation function, its hash, purpose, salt,
+// iteration count and key size, and by an HMAC of the password under a key
+// drawn when the cache is created, so that the cache holds neither the
+// passwords nor hashes which could be guessed from.  It does hold the
+// derived keys, which protect the files as well as the passwords do; Purge
+// wipes them.
+//
+// A KDFCache is safe for concurrent use, and can be shared by Decoders and
+// Encoders with WithKDF.  Failed derivations are not remembered.
+type KDFCache struct {
+	kdf    KDF
+	size   int
+	secret [sha256.Size]byte
+
+	mu      sync.Mutex
+	entries map[kdfCacheKey]*list.Element
+	// order holds the *kdfCacheEntry values, most recently used first.
+	order *list.List
+}
+
+type kdfCacheKey struct {
+	pbkdf2     bool
+	h          crypto.Hash
+	purpose    pbe.Purpose
+	password   [sha256.Size]byte
+	salt       string
+	iterations int
+	size       int
+}
+
+type kdfCacheEntry struct {
+	key     kdfCacheKey
+	derived []byte
+}
+
+// NewKDFCache returns a KDFCache keeping up to size keys derived by kdf,
+// or by DefaultKDF if kdf is nil.  The least recently used key is dropped
+// to make room.  NewKDFCache panics if size is not positive.
+func NewKDFCache(kdf KDF, size int) *KDFCache {
+	if size <= 0 {
+		panic("pkcs12: KDFCache size must be positive")
+	}
+	if kdf == nil {
+		kdf = DefaultKDF
+	}
+	c := &KDFCache{kdf: kdf, size: size, entries: make(map[kdfCacheKey]*list.Element), order: list.New()}
+	if _, err := rand.Read(c.secret[:]); err != nil {
+		panic("pkcs12: error generating KDFCache key: " + err.Error())
+	}
+	return c
+}
+
+// PKCS12 implements KDF.
+func (c *KDFCache) PKCS12(ctx context.Context, h crypto.Hash, purpose pbe.Purpose, password, salt []byte, iterations, size int) ([]byte, error) {
+	key := c.key(false, h, purpose, password, salt, iterations, size)
+	return c.derive(key, func() ([]byte, error) {
+		return c.kdf.PKCS12(ctx, h, purpose, password, salt, iterations, size)
+	})
+}
+
+// PBKDF2 implements KDF.
+func (c *KDFCache) PBKDF2(ctx context.Context, h crypto.Hash, password, salt []byte, iterations, keyLen int) ([]byte, error) {
+	key := c.key(true, h, 0, password, salt, iterations, keyLen)
+	return c.derive(key, func() ([]byte, error) {
+		return c.kdf.PBKDF2(ctx, h, password, salt, iterations, keyLen)
+	})
+}
+
+// Len returns the number of keys in c.
+func (c *KDFCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Purge wipes and drops every key in c.
+func (c *KDFCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		wipe(e.Value.(*kdfCacheEntry).derived)
+	}
+	c.entries = make(map[kdfCacheKey]*list.Element)
+	c.order.Init()
+}
+
+func (c *KDFCache) key(pbkdf2 bool, h crypto.Hash, purpose pbe.Purpose, password, salt []byte, iterations, size int) kdfCacheKey {
+	key := kdfCacheKey{pbkdf2: pbkdf2, h: h, purpose: purpose, salt: string(salt), iterations: iterations, size: size}
+	mac := hmac.New(sha256.New, c.secret[:])
+	mac.Write(password)
+	mac.Sum(key.password[:0])
+	return key
+}
+
+// derive returns a copy of the key stored under key, deriving and storing
+// it first if there is none.  The copy is the caller's to wipe.
+func (c *KDFCache) derive(key kdfCacheKey, derive func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		derived := append([]byte(nil), e.Value.(*kdfCacheEntry).derived...)
+		c.mu.Unlock()
+		return derived, nil
+	}
+	c.mu.Unlock()
+
+	// Concurrent misses for the same key each derive it; the first key
+	// stored is kept.
+	derived, err := derive()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+	} else {
+		c.entries[key] = c.order.PushFront(&kdfCacheEntry{key: key, derived: append([]byte(nil), derived...)})
+		for c.order.Len() > c.size {
+			oldest := c.order.Remove(c.order.Back()).(*kdfCacheEntry)
+			wipe(oldest.derived)
+			delete(c.entries, oldest.key)
+		}
+	}
+	return derived, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKDFCache(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counting := &countingKDF{}
+	cache := NewKDFCache(counting, 16)
+	dec := DefaultDecoder.WithKDF(cache)
+	for i := 0; i < 3; i++ {
+		if _, _, err := dec.Decode(pfxData, DefaultPassword); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The MAC, and the certificates and the private key, once.
+	if counting.pkcs12 != 1 || counting.pbkdf2 != 2 || cache.Len() != 3 {
+		t.Errorf("made %d PKCS#12 and %d PBKDF2 derivations for %d keys, expected 1, 2 and 3", counting.pkcs12, counting.pbkdf2, cache.Len())
+	}
+
+	if _, _, err := dec.Decode(pfxData, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+	if counting.pkcs12 != 2 {
+		t.Errorf("a different password made %d PKCS#12 derivations, expected 2", counting.pkcs12)
+	}
+
+	cache.Purge()
+	if _, _, err := dec.Decode(pfxData, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if counting.pkcs12 != 3 || counting.pbkdf2 != 4 {
+		t.Errorf("after Purge, made %d PKCS#12 and %d PBKDF2 derivations, expected 3 and 4", counting.pkcs12, counting.pbkdf2)
+	}
+
+	small := NewKDFCache(nil, 2)
+	if _, _, err := DefaultDecoder.WithKDF(small).Decode(pfxData, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if small.Len() != 2 {
+		t.Errorf("cache of size 2 holds %d keys", small.Len())
+	}
+
+	failing := &countingKDF{err: errors.New("HSM unavailable")}
+	cache = NewKDFCache(failing, 16)
+	if _, _, err := DefaultDecoder.WithKDF(cache).Decode(pfxData, DefaultPassword); !errors.Is(err, failing.err) {
+		t.Errorf("expected the error of the KDF, got %v", err)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("remembered %d failed derivations", cache.Len())
+	}
+}
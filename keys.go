@@ -0,0 +1,27 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509"
+)
+
+// MatchKeyToCert returns ErrKeyMismatch unless privateKey is the private key
+// for the public key of certificate.  It catches files whose key and leaf
+// certificate were mixed up, which are otherwise only noticed when the first
+// TLS handshake fails.
+func MatchKeyToCert(privateKey crypto.PrivateKey, certificate *x509.Certificate) error {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return ErrKeyMismatch
+	}
+	publicKey, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(certificate.PublicKey) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
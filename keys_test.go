@@ -0,0 +1,88 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDecodeSigner(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ed25519.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, edKey.Public(), edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edP12, err := Modern.Encode(edKey, edCert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, ecCert := makeTestCertificate(t, "ec.example.com", false, nil, nil)
+	ecP12, err := Modern.Encode(ecKey, ecCert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaP12, err := os.ReadFile("testdata/openssl-legacy-chain.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, pfxData := range map[string][]byte{"Ed25519": edP12, "ECDSA": ecP12, "RSA": rsaP12} {
+		key, cert, caCerts, err := DecodeChain(pfxData, "password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			t.Fatalf("%s: %T does not implement crypto.Signer", name, key)
+		}
+
+		opts := crypto.Hash(0)
+		digest := []byte("message")
+		if _, isEd := key.(ed25519.PrivateKey); !isEd {
+			opts = crypto.SHA256
+			digest = make([]byte, 32)
+		}
+		if _, err := signer.Sign(rand.Reader, digest, opts); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+
+		if err := MatchKeyToCert(key, cert); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if len(caCerts) != 0 {
+			if err := MatchKeyToCert(key, caCerts[0]); err != ErrKeyMismatch {
+				t.Errorf("%s: expected ErrKeyMismatch for the CA certificate, got %v", name, err)
+			}
+		}
+	}
+
+	if err := MatchKeyToCert(ecKey, edCert); err != ErrKeyMismatch {
+		t.Errorf("expected ErrKeyMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A KeyStore is an in-memory keystore over a PKCS#12 file, like a
+// java.security.KeyStore: entries are stored, looked up and deleted by
+// alias, ignoring case, and a certificate entry cannot replace a private
+// key.  The file itself is a PFX, which KeyStore edits through those rules;
+// use the PFX directly to edit its bags.
+type KeyStore struct {
+	pfx *PFX
+}
+
+// NewKeyStore returns a KeyStore over p, or over an empty PFX if p is nil.
+// Load uses the Decoder of p, and Store its Encoder by default.
+func NewKeyStore(p *PFX) *KeyStore {
+	if p == nil {
+		p = NewPFX("")
+	}
+	return &KeyStore{pfx: p}
+}
+
+// PFX returns the document ks stores its entries in.
+func (ks *KeyStore) PFX() *PFX {
+	return ks.pfx
+}
+
+// Load replaces the contents and the password of ks with those of pfxData,
+// protected by password, like KeyStore.load.
+func (ks *KeyStore) Load(pfxData []byte, password string) error {
+	p := ks.pfx
+	loaded := PFX{Decoder: p.Decoder, password: password}
+	if err := loaded.Unmarshal(pfxData); err != nil {
+		return err
+	}
+	p.Contents, p.password = loaded.Contents, password
+	return nil
+}
+
+// Aliases returns the aliases of ks, like KeyStore.aliases, see
+// PFX.Aliases.
+func (ks *KeyStore) Aliases() []string {
+	return ks.pfx.Aliases()
+}
+
+// Key returns the private key stored under alias, like KeyStore.getKey,
+// see PFX.Key.
+func (ks *KeyStore) Key(alias string) crypto.PrivateKey {
+	return ks.pfx.Key(alias)
+}
+
+// Certificate returns the certificate stored under alias, like
+// KeyStore.getCertificate, see PFX.Certificate.
+func (ks *KeyStore) Certificate(alias string) *x509.Certificate {
+	return ks.pfx.Certificate(alias)
+}
+
+// SetKeyEntry stores privateKey and its certificate chain, leaf first,
+// under alias, like KeyStore.setKeyEntry, replacing any entry with that
+// alias.  The key and the leaf carry alias as friendlyName and the
+// localKeyId the Encoder of the PFX gives them, by default the SHA-1 fingerprint
+// of the leaf, as Encode writes them.
+func (ks *KeyStore) SetKeyEntry(alias string, privateKey crypto.PrivateKey, chain []*x509.Certificate) error {
+	if len(chain) == 0 {
+		return errors.New("pkcs12: certificate chain of " + strconv.Quote(alias) + " missing")
+	}
+	if err := MatchKeyToCert(privateKey, chain[0]); err != nil {
+		return err
+	}
+	name, err := FriendlyNameAttribute(alias)
+	if err != nil {
+		return err
+	}
+
+	p := ks.pfx
+	enc := p.Encoder
+	if enc == nil {
+		enc = Modern
+	}
+	keyID, err := enc.localKeyID(chain[0])
+	if err != nil {
+		return err
+	}
+
+	ks.DeleteEntry(alias)
+	localKeyID := LocalKeyIDAttribute(keyID)
+	p.AddEntry(Entry{BagType: CertBag, Certificate: chain[0], Attributes: []Attribute{name, localKeyID}})
+	for _, cert := range chain[1:] {
+		p.AddEntry(Entry{BagType: CertBag, Certificate: cert})
+	}
+	p.AddEntry(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: privateKey, Attributes: []Attribute{name, localKeyID}})
+	return nil
+}
+
+// SetCertEntry stores cert under alias as a trusted certificate, like
+// KeyStore.setCertificateEntry, replacing the certificate with that alias.
+// The certificate carries alias as friendlyName and the attribute which
+// marks it as a trustedCertEntry for the java keytool.  Like Java,
+// SetCertEntry refuses to replace a private key.
+func (ks *KeyStore) SetCertEntry(alias string, cert *x509.Certificate) error {
+	p := ks.pfx
+	if p.keyEntry(alias) != nil {
+		return errors.New("pkcs12: " + strconv.Quote(alias) + " names a private key, not a certificate")
+	}
+	name, err := FriendlyNameAttribute(alias)
+	if err != nil {
+		return err
+	}
+	trusted, err := NewAttribute(OIDJavaTrustedKeyUsage, oidExtendedKeyUsage)
+	if err != nil {
+		return err
+	}
+
+	ks.DeleteEntry(alias)
+	p.AddEntry(Entry{BagType: CertBag, Certificate: cert, Attributes: []Attribute{name, trusted}})
+	return nil
+}
+
+// DeleteEntry removes the entry stored under alias, ignoring case, like
+// KeyStore.deleteEntry, and reports whether there was one.  For a private
+// key, the certificate carrying its localKeyId is removed too; the rest of
+// its chain is kept, as the certificates may belong to other chains.
+func (ks *KeyStore) DeleteEntry(alias string) bool {
+	p := ks.pfx
+	var keyIDs [][]byte
+	if key := p.keyEntry(alias); key != nil {
+		keyIDs = append(keyIDs, key.LocalKeyID())
+	}
+	otherIDs := p.keyIDs()
+	removed := p.RemoveEntry(func(entry *Entry) bool {
+		switch {
+		case entry.PrivateKey != nil:
+			return strings.EqualFold(entry.FriendlyName(), alias)
+		case entry.Certificate != nil && hasID(keyIDs, entry.LocalKeyID()):
+			return true
+		case entry.Certificate != nil && !hasID(otherIDs, entry.LocalKeyID()):
+			return entry.FriendlyName() != "" && strings.EqualFold(entry.FriendlyName(), alias)
+		}
+		return false
+	})
+	return removed != 0
+}
+
+// Store encodes ks with profile and protects it with password, like
+// KeyStore.store, without changing the password of ks.  If profile is nil,
+// the Encoder of the PFX is used, or Modern.
+func (ks *KeyStore) Store(password string, profile *Encoder) (pfxData []byte, err error) {
+	p := ks.pfx
+	stored := PFX{Contents: p.Contents, Encoder: profile, password: password}
+	if profile == nil {
+		stored.Encoder = p.Encoder
+	}
+	return stored.Marshal()
+}
@@ -0,0 +1,93 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"reflect"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	otherKey, otherCert := makeTestCertificate(t, "other.example.com", false, nil, nil)
+	_, trusted := makeTestCertificate(t, "Trusted CA", true, nil, nil)
+
+	ks := NewKeyStore(nil)
+	if err := ks.SetKeyEntry("server", key, []*x509.Certificate{cert, caCert}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetCertEntry("ca", trusted); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.SetKeyEntry("wrong", otherKey, []*x509.Certificate{cert}); err != ErrKeyMismatch {
+		t.Errorf("expected ErrKeyMismatch, got %v", err)
+	}
+	if err := ks.SetCertEntry("Server", trusted); err == nil {
+		t.Error("replaced a private key with a certificate")
+	}
+
+	pfxData, err := ks.Store("changeit", LegacyDES.AllowWeakAlgorithms())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewKeyStore(nil)
+	if err := loaded.Load(pfxData, "changeit"); err != nil {
+		t.Fatal(err)
+	}
+	if aliases := loaded.Aliases(); !reflect.DeepEqual(aliases, []string{"ca", "server"}) {
+		t.Errorf("unexpected aliases %q", aliases)
+	}
+	if !key.Equal(loaded.Key("server")) || !loaded.Certificate("server").Equal(cert) || !loaded.Certificate("ca").Equal(trusted) {
+		t.Error("entries differ after Store and Load")
+	}
+	if certs, err := DecodeTrustStoreCerts(pfxData, "changeit"); err != nil {
+		t.Fatal(err)
+	} else {
+		for _, c := range certs {
+			if c.JavaTrusted != (c.FriendlyName == "ca") {
+				t.Errorf("certificate %q: JavaTrusted is %v", c.FriendlyName, c.JavaTrusted)
+			}
+		}
+	}
+
+	// Replacing the key of an alias drops the old leaf, and deleting an
+	// entry drops the leaf with it.
+	if err := loaded.SetKeyEntry("SERVER", otherKey, []*x509.Certificate{otherCert}); err != nil {
+		t.Fatal(err)
+	}
+	if !otherKey.Equal(loaded.Key("server")) || !loaded.Certificate("server").Equal(otherCert) {
+		t.Error("SetKeyEntry did not replace the entry")
+	}
+	if !loaded.DeleteEntry("server") || !loaded.DeleteEntry("ca") {
+		t.Error("DeleteEntry did not find the entries")
+	}
+	if loaded.DeleteEntry("ca") {
+		t.Error("deleted an entry twice")
+	}
+	for _, entry := range loaded.PFX().allEntries() {
+		if entry.PrivateKey != nil || !entry.Certificate.Equal(caCert) {
+			t.Errorf("unexpected entry %v left after deleting all aliases", entry.BagType)
+		}
+	}
+
+	// A KeyStore edits the PFX it wraps.
+	p := NewPFX("changeit")
+	if err := NewKeyStore(p).SetCertEntry("ca", trusted); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Certificate("ca").Equal(trusted) {
+		t.Error("SetCertEntry did not edit the wrapped PFX")
+	}
+
+	if _, err := loaded.Store("changeit", nil); err != nil {
+		t.Error(err)
+	}
+	if err := loaded.Load(pfxData, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/bits"
+)
+
+// privateKeyInfo is the PKCS#8 PrivateKeyInfo, see
+// https://tools.ietf.org/html/rfc5208#section-5, with its attributes and
+// the public key of its OneAsymmetricKey revision, see
+// https://tools.ietf.org/html/rfc5958#section-2.
+type privateKeyInfo struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+	Attributes []pkcs12Attribute `asn1:"optional,tag:0,set"`
+	PublicKey  asn1.BitString    `asn1:"optional,tag:1"`
+}
+
+// WithKeyUsage creates a new Encoder identical to enc except that the
+// private keys it encodes carry a keyUsage attribute restricting them to
+// usage, as "openssl pkcs12 -export -keysig" and "-keyex" do.  Windows
+// honors the attribute on import: a key restricted to
+// x509.KeyUsageDigitalSignature can only be used for signing.  A usage of
+// 0, the default, adds no attribute.
+//
+// The attribute is stored with the other attributes of the PKCS#8
+// PrivateKeyInfo, inside the encrypted key, which is where OpenSSL and
+// Windows put and look for it.
+func (enc Encoder) WithKeyUsage(usage x509.KeyUsage) *Encoder {
+	enc.keyUsage = usage
+	return &enc
+}
+
+// marshalPKCS8 returns privateKey as a DER-encoded PKCS#8 PrivateKeyInfo,
+// with the key usage of enc, and its public key if enc embeds it.  The
+// caller should wipe the result once it is no longer needed.
+func (enc *Encoder) marshalPKCS8(privateKey interface{}) ([]byte, error) {
+	pkData, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, errors.New("pkcs12: error encoding PKCS#8 private key: " + err.Error())
+	}
+	var publicKey []byte
+	if enc.embedPublicKey {
+		if publicKey, err = embeddedPublicKey(privateKey); err != nil {
+			wipe(pkData)
+			return nil, err
+		}
+	}
+	if enc.keyUsage == 0 && publicKey == nil {
+		return pkData, nil
+	}
+	defer wipe(pkData)
+
+	var info privateKeyInfo
+	if err := unmarshal(pkData, &info); err != nil {
+		return nil, errors.New("pkcs12: error encoding PKCS#8 private key: " + err.Error())
+	}
+	if enc.keyUsage != 0 {
+		attribute, err := keyUsageAttribute(enc.keyUsage)
+		if err != nil {
+			return nil, err
+		}
+		info.Attributes = append(info.Attributes, attribute)
+	}
+	if publicKey != nil {
+		info.setPublicKey(publicKey)
+	}
+	return asn1.Marshal(info)
+}
+
+// keyUsageAttribute returns the keyUsage attribute for usage, in the
+// encoding of the X.509 extension.
+func keyUsageAttribute(usage x509.KeyUsage) (attribute pkcs12Attribute, err error) {
+	var bitString asn1.BitString
+	bitString.BitLength = bits.Len(uint(usage))
+	bitString.Bytes = make([]byte, (bitString.BitLength+7)/8)
+	for i := 0; i < bitString.BitLength; i++ {
+		if usage&(1<<i) != 0 {
+			bitString.Bytes[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	attribute.Id = OIDKeyUsage
+	attribute.Value.Class = 0
+	attribute.Value.Tag = 17
+	attribute.Value.IsCompound = true
+	if attribute.Value.Bytes, err = asn1.Marshal(bitString); err != nil {
+		return attribute, err
+	}
+	return attribute, nil
+}
+
+// parseKeyUsage decodes the value of a keyUsage attribute.
+func parseKeyUsage(der []byte) (usage x509.KeyUsage, err error) {
+	var bitString asn1.BitString
+	if err := unmarshal(der, &bitString); err != nil {
+		return 0, errors.New("pkcs12: error decoding key usage: " + err.Error())
+	}
+	for i := 0; i < bitString.BitLength; i++ {
+		if bitString.At(i) != 0 {
+			usage |= 1 << i
+		}
+	}
+	return usage, nil
+}
+
+// parsePrivateKeyInfo parses a DER-encoded PKCS#8 PrivateKeyInfo,
+// returning the key and the usage given by its keyUsage attribute, or 0 if
+// it has none.
+func parsePrivateKeyInfo(pkData []byte) (privateKey crypto.PrivateKey, usage x509.KeyUsage, err error) {
+	if privateKey, err = x509.ParsePKCS8PrivateKey(pkData); err != nil {
+		return nil, 0, errors.New("pkcs12: error parsing PKCS#8 private key: " + err.Error())
+	}
+
+	var info privateKeyInfo
+	if _, err := asn1.Unmarshal(pkData, &info); err != nil {
+		// The attributes are optional, a key which x509 can parse is
+		// good enough.
+		return privateKey, 0, nil
+	}
+	for _, attribute := range info.Attributes {
+		if attribute.Id.Equal(OIDKeyUsage) {
+			if usage, err = parseKeyUsage(attribute.Value.Bytes); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return privateKey, usage, nil
+}
+
+// KeyUsageAttribute returns a keyUsage bag attribute restricting the key
+// of the bag to usage.  Prefer Entry.KeyUsage, which stores the usage
+// where Windows looks for it; some tools look for it among the bag
+// attributes instead.
+func KeyUsageAttribute(usage x509.KeyUsage) (Attribute, error) {
+	attribute, err := keyUsageAttribute(usage)
+	if err != nil {
+		return Attribute{}, err
+	}
+	return Attribute{Type: attribute.Id, Values: [][]byte{attribute.Value.Bytes}}, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"testing"
+)
+
+func TestWithKeyUsage(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	for _, usage := range []x509.KeyUsage{x509.KeyUsageDigitalSignature, x509.KeyUsageDataEncipherment, x509.KeyUsageDigitalSignature | x509.KeyUsageDecipherOnly} {
+		pfxData, err := Modern.WithKeyUsage(usage).Encode(key, cert, nil, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := DecodeContents(pfxData, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded := contents[1].Entries[0].KeyUsage; decoded != usage {
+			t.Errorf("expected key usage %#x, got %#x", usage, decoded)
+		}
+		if _, _, err := Decode(pfxData, "password"); err != nil {
+			t.Error(err)
+		}
+
+		reencoded, err := Modern.EncodeContents(contents, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTripped, err := DecodeContents(reencoded, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !EqualContents(contents, roundTripped) {
+			t.Errorf("key usage %#x lost by EncodeContents", usage)
+		}
+	}
+
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage := contents[1].Entries[0].KeyUsage; usage != 0 {
+		t.Errorf("expected no key usage, got %#x", usage)
+	}
+}
+
+// TestKeyUsageOpenSSL decodes a file created with
+// "openssl pkcs12 -export -keysig", whose key attributes OpenSSL shows as
+// "X509v3 Key Usage: 80".
+func TestKeyUsageOpenSSL(t *testing.T) {
+	pfxData, err := os.ReadFile("testdata/openssl-keysig.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage := contents[1].Entries[0].KeyUsage; usage != x509.KeyUsageDigitalSignature {
+		t.Errorf("expected KeyUsageDigitalSignature, got %#x", usage)
+	}
+
+	attribute, err := keyUsageAttribute(x509.KeyUsageDigitalSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x03, 0x02, 0x07, 0x80}; !bytes.Equal(attribute.Value.Bytes, expected) {
+		t.Errorf("expected encoding %x, got %x", expected, attribute.Value.Bytes)
+	}
+}
+
+func TestKeyUsageBagAttribute(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	attribute, err := KeyUsageAttribute(x509.KeyUsageKeyAgreement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := Modern.EncodeContents([]SafeContents{
+		{Encrypted: true, Entries: []Entry{{BagType: CertBag, Certificate: cert}}},
+		{Entries: []Entry{{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{attribute}}}},
+	}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage := contents[1].Entries[0].KeyUsage; usage != x509.KeyUsageKeyAgreement {
+		t.Errorf("expected KeyUsageKeyAgreement from the bag attribute, got %#x", usage)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package kubesecret produces and consumes the data of the Kubernetes
+// secrets into which cert-manager writes PKCS#12 keystores: the identity
+// in keystore.p12 and the CA certificates in truststore.p12, both protected
+// by a password kept in another secret, so that operators can generate
+// secrets that applications configured for cert-manager read as they are.
+//
+// The data is returned and taken as the map of a secret's data, keyed by
+// the secret keys, without depending on the Kubernetes client libraries.
+package kubesecret
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"strconv"
+	"strings"
+
+	pkcs12 "github.com/nevissecurity/go-pkcs12"
+)
+
+const (
+	// KeystoreKey is the secret key of the keystore.
+	KeystoreKey = "keystore.p12"
+	// TruststoreKey is the secret key of the trust store.
+	TruststoreKey = "truststore.p12"
+	// DefaultPasswordKey is the secret key of the password used by the
+	// passwordSecretRef of the cert-manager documentation.
+	DefaultPasswordKey = "password-key"
+)
+
+// A Profile selects the algorithms protecting the keystores, named as the
+// profile of the pkcs12 keystore of a cert-manager Certificate.
+type Profile string
+
+const (
+	// LegacyRC2 encodes with pkcs12.LegacyRC2, readable by all
+	// applications.  It is the profile of cert-manager when none is
+	// configured.
+	LegacyRC2 Profile = "LegacyRC2"
+	// LegacyDES encodes with pkcs12.LegacyDES, for applications which do
+	// not support RC2.
+	LegacyDES Profile = "LegacyDES"
+	// Modern2023 encodes with pkcs12.Modern: AES-256-CBC with PBKDF2 and a
+	// SHA-256 MAC.
+	Modern2023 Profile = "Modern2023"
+)
+
+// Encoder returns the pkcs12.Encoder of p, or of LegacyRC2 if p is empty.
+// The weak algorithms of the legacy profiles are allowed.
+func (p Profile) Encoder() (*pkcs12.Encoder, error) {
+	switch p {
+	case LegacyRC2, "":
+		return pkcs12.LegacyRC2.AllowWeakAlgorithms(), nil
+	case LegacyDES:
+		return pkcs12.LegacyDES.AllowWeakAlgorithms(), nil
+	case Modern2023:
+		return pkcs12.Modern, nil
+	}
+	return nil, errors.New("kubesecret: unknown profile " + strconv.Quote(string(p)))
+}
+
+// A Keystore is the content of the keystores of a secret.
+type Keystore struct {
+	// PrivateKey, Certificate and CACerts are stored in keystore.p12:
+	// the private key, its certificate and the rest of its chain.
+	PrivateKey  crypto.PrivateKey
+	Certificate *x509.Certificate
+	CACerts     []*x509.Certificate
+	// TrustedCerts are stored in truststore.p12, as cert-manager stores
+	// the CA of the issuer.
+	TrustedCerts []*x509.Certificate
+}
+
+// Encode returns the data of a secret holding ks, encoded with profile and
+// protected by password: keystore.p12 if ks has a private key, and
+// truststore.p12 if it has trusted certificates.  The trusted certificates
+// are stored under their subject as alias, as cert-manager stores them.
+func Encode(profile Profile, ks *Keystore, password string) (map[string][]byte, error) {
+	enc, err := profile.Encoder()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]byte, 2)
+	if ks.PrivateKey != nil {
+		if data[KeystoreKey], err = enc.Encode(ks.PrivateKey, ks.Certificate, ks.CACerts, password); err != nil {
+			return nil, err
+		}
+	}
+	if len(ks.TrustedCerts) != 0 {
+		certs := make(map[string]*x509.Certificate, len(ks.TrustedCerts))
+		used := make(map[string]bool, len(ks.TrustedCerts))
+		for _, cert := range ks.TrustedCerts {
+			alias := cert.Subject.String()
+			unique := alias
+			for n := 2; used[strings.ToLower(unique)]; n++ {
+				unique = alias + "-" + strconv.Itoa(n)
+			}
+			used[strings.ToLower(unique)] = true
+			certs[unique] = cert
+		}
+		if data[TruststoreKey], err = enc.EncodeTrustStore(certs, password); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Decode returns the keystores in data, the data of a secret written by
+// Encode or by cert-manager, protected by password.  Either keystore may
+// be missing, but not both.
+func Decode(data map[string][]byte, password string) (*Keystore, error) {
+	keystore, hasKeystore := data[KeystoreKey]
+	truststore, hasTruststore := data[TruststoreKey]
+	if !hasKeystore && !hasTruststore {
+		return nil, errors.New("kubesecret: secret has neither " + KeystoreKey + " nor " + TruststoreKey)
+	}
+
+	ks := new(Keystore)
+	var err error
+	if hasKeystore {
+		if ks.PrivateKey, ks.Certificate, ks.CACerts, err = pkcs12.DecodeChain(keystore, password); err != nil {
+			return nil, err
+		}
+	}
+	if hasTruststore {
+		certs, err := pkcs12.DecodeTrustStoreCerts(truststore, password)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			ks.TrustedCerts = append(ks.TrustedCerts, cert.Certificate)
+		}
+	}
+	return ks, nil
+}
+
+// EncodePassword returns the data of the secret holding password under
+// key, or under DefaultPasswordKey if key is empty.
+func EncodePassword(key, password string) map[string][]byte {
+	if key == "" {
+		key = DefaultPasswordKey
+	}
+	return map[string][]byte{key: []byte(password)}
+}
+
+// DecodePassword returns the password stored in data under key, or under
+// DefaultPasswordKey if key is empty.
+func DecodePassword(data map[string][]byte, key string) (string, error) {
+	if key == "" {
+		key = DefaultPasswordKey
+	}
+	password, ok := data[key]
+	if !ok {
+		return "", errors.New("kubesecret: secret has no " + strconv.Quote(key))
+	}
+	return string(password), nil
+}
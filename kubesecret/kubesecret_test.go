@@ -0,0 +1,112 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kubesecret
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	pkcs12 "github.com/nevissecurity/go-pkcs12"
+)
+
+func makeTestCertificate(t *testing.T, commonName string, isCA bool, parent *x509.Certificate, parentKey crypto.Signer) (crypto.Signer, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func TestRoundTrip(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	ks := &Keystore{PrivateKey: key, Certificate: cert, CACerts: []*x509.Certificate{caCert}, TrustedCerts: []*x509.Certificate{caCert, caCert}}
+
+	for _, profile := range []Profile{"", LegacyRC2, LegacyDES, Modern2023} {
+		data, err := Encode(profile, ks, "changeit")
+		if err != nil {
+			t.Fatalf("%q: %v", profile, err)
+		}
+		if len(data) != 2 {
+			t.Errorf("%q: expected %s and %s, got %d keys", profile, KeystoreKey, TruststoreKey, len(data))
+		}
+
+		decoded, err := Decode(data, "changeit")
+		if err != nil {
+			t.Fatalf("%q: %v", profile, err)
+		}
+		if !key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(decoded.PrivateKey) || !decoded.Certificate.Equal(cert) ||
+			len(decoded.CACerts) != 1 || !decoded.CACerts[0].Equal(caCert) || len(decoded.TrustedCerts) != 2 || !decoded.TrustedCerts[0].Equal(caCert) {
+			t.Errorf("%q: keystore differs after a round trip", profile)
+		}
+
+		certs, err := pkcs12.DecodeTrustStore(data[TruststoreKey], "changeit")
+		if err != nil {
+			t.Fatalf("%q: %v", profile, err)
+		}
+		if certs["CN=Test CA"] == nil || certs["CN=Test CA-2"] == nil {
+			t.Errorf("%q: unexpected aliases in the trust store: %v", profile, certs)
+		}
+	}
+
+	data, err := Encode(Modern2023, ks, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := pkcs12.Probe(data[KeystoreKey]); err != nil || info.MAC == nil || info.MAC.Name != "sha256" {
+		t.Errorf("expected a SHA-256 MAC for Modern2023, got %+v, %v", info, err)
+	}
+
+	if _, err := Encode("Modern2019", ks, "changeit"); err == nil {
+		t.Error("encoded with an unknown profile")
+	}
+	if _, err := Decode(map[string][]byte{"tls.crt": nil}, "changeit"); err == nil {
+		t.Error("decoded a secret without keystores")
+	}
+}
+
+func TestPassword(t *testing.T) {
+	data := EncodePassword("", "changeit")
+	if string(data[DefaultPasswordKey]) != "changeit" {
+		t.Errorf("unexpected password secret %q", data)
+	}
+	if password, err := DecodePassword(data, ""); err != nil || password != "changeit" {
+		t.Errorf("expected the password, got %q, %v", password, err)
+	}
+	if password, err := DecodePassword(EncodePassword("pass", "secret"), "pass"); err != nil || password != "secret" {
+		t.Errorf("expected the password, got %q, %v", password, err)
+	}
+	if _, err := DecodePassword(data, "pass"); err == nil {
+		t.Error("decoded a missing password")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"errors"
+)
+
+// A BagOrder selects whether Encode writes the private key before or after
+// the certificates.
+type BagOrder int
+
+const (
+	// CertsFirst writes the certificates, then the private key.  This is
+	// the default and the order OpenSSL uses.
+	CertsFirst BagOrder = iota
+	// KeyFirst writes the private key, then the certificates, as some
+	// older importers, such as those of early IIS versions, require.
+	KeyFirst
+)
+
+// WithBagOrder creates a new Encoder identical to enc except that Encode
+// writes the bags in the given order.
+func (enc Encoder) WithBagOrder(order BagOrder) *Encoder {
+	enc.bagOrder = order
+	return &enc
+}
+
+// WithSingleSafeContents creates a new Encoder identical to enc except
+// that, if single is true, Encode puts the private key bag and the cert
+// bags into one SafeContents, protected like the certificates are, instead
+// of two.
+func (enc Encoder) WithSingleSafeContents(single bool) *Encoder {
+	enc.singleSafeContents = single
+	return &enc
+}
+
+// WithPlainKeyBag creates a new Encoder identical to enc except that, if
+// plain is true, Encode writes the private key as a keyBag, an unencrypted
+// PKCS#8 PrivateKeyInfo, and stores it in a SafeContents encrypted with the
+// encoder's certificate algorithm instead of shrouding it with the key
+// algorithm.  The key is then protected once, by the SafeContents, rather
+// than left in the clear as "openssl pkcs12 -export -keypbe NONE" does.
+// Encoding fails if the certificates are not encrypted, see
+// WithEncryptedCerts.
+func (enc Encoder) WithPlainKeyBag(plain bool) *Encoder {
+	enc.plainKeyBag = plain
+	return &enc
+}
+
+// makeKeyBag returns the bag Encode stores privateKey in, shrouded with the
+// key algorithm unless WithPlainKeyBag is set.
+func (enc *Encoder) makeKeyBag(privateKey interface{}, password []byte) (keyBag safeBag, err error) {
+	keyBag.Value.Class = 2
+	keyBag.Value.Tag = 0
+	keyBag.Value.IsCompound = true
+	if enc.plainKeyBag {
+		if enc.certsAlgorithm() == nil {
+			return keyBag, errors.New("pkcs12: a plain key bag needs encrypted certificates to be stored with")
+		}
+		keyBag.Id = oidKeyBag
+		keyBag.Value.Bytes, err = enc.marshalPKCS8(privateKey)
+	} else {
+		keyBag.Id = oidPKCS8ShroundedKeyBag
+		keyBag.Value.Bytes, err = enc.encodePkcs8ShroudedKeyBag(privateKey, password)
+	}
+	return keyBag, err
+}
+
+// DecodeKeyBag parses der, the bagValue of a keyBag: a DER-encoded PKCS#8
+// PrivateKeyInfo in the clear, as written by WithPlainKeyBag inside an
+// encrypted SafeContents, or by "openssl pkcs12 -export -keypbe NONE".
+// Unlike x509.ParsePKCS8PrivateKey, it checks the keyUsage attribute of the
+// key.
+func DecodeKeyBag(der []byte) (crypto.PrivateKey, error) {
+	privateKey, _, err := parsePrivateKeyInfo(der)
+	return privateKey, err
+}
+
+// EncodeKeyBag returns privateKey as the bagValue of a keyBag, the way
+// Encode writes it with WithPlainKeyBag: an unencrypted PKCS#8
+// PrivateKeyInfo with the key usage and embedded public key of enc, meant
+// to be stored in an encrypted SafeContents.  The caller should wipe the result once it is no
+// longer needed.
+func (enc *Encoder) EncodeKeyBag(privateKey interface{}) (der []byte, err error) {
+	if enc.err != nil {
+		return nil, enc.err
+	}
+	return enc.marshalPKCS8(privateKey)
+}
+
+// layoutSafeContents groups the bags written by Encode into SafeContents
+// in the layout selected for enc.
+func (enc *Encoder) layoutSafeContents(certBags []safeBag, keyBag safeBag, password []byte) (authenticatedSafe []contentInfo, err error) {
+	if enc.singleSafeContents {
+		var bags []safeBag
+		if enc.bagOrder == KeyFirst {
+			bags = append(append(bags, keyBag), certBags...)
+		} else {
+			bags = append(append(bags, certBags...), keyBag)
+		}
+		ci, err := enc.makeSafeContents(bags, enc.certsAlgorithm(), password)
+		if err != nil {
+			return nil, err
+		}
+		return []contentInfo{ci}, nil
+	}
+
+	certsCI, err := enc.makeSafeContents(certBags, enc.certsAlgorithm(), password)
+	if err != nil {
+		return nil, err
+	}
+	// A shrouded key is encrypted on its own and needs no further
+	// encryption; a plain one is encrypted like the certificates.
+	var keyCI contentInfo
+	if enc.plainKeyBag {
+		keyCI, err = enc.makeSafeContents([]safeBag{keyBag}, enc.certsAlgorithm(), password)
+	} else {
+		keyCI, err = enc.makeSafeContents([]safeBag{keyBag}, nil, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enc.bagOrder == KeyFirst {
+		return []contentInfo{keyCI, certsCI}, nil
+	}
+	return []contentInfo{certsCI, keyCI}, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"reflect"
+	"testing"
+)
+
+func TestEncoderLayout(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	type safeContents struct {
+		Encrypted bool
+		BagTypes  []BagType
+	}
+	for name, test := range map[string]struct {
+		enc      *Encoder
+		expected []safeContents
+	}{
+		"default": {Modern, []safeContents{
+			{true, []BagType{CertBag, CertBag}},
+			{false, []BagType{PKCS8ShroudedKeyBag}},
+		}},
+		"KeyFirst": {Modern.WithBagOrder(KeyFirst), []safeContents{
+			{false, []BagType{PKCS8ShroudedKeyBag}},
+			{true, []BagType{CertBag, CertBag}},
+		}},
+		"single": {Modern.WithSingleSafeContents(true), []safeContents{
+			{true, []BagType{CertBag, CertBag, PKCS8ShroudedKeyBag}},
+		}},
+		"single KeyFirst": {Modern.WithSingleSafeContents(true).WithBagOrder(KeyFirst), []safeContents{
+			{true, []BagType{PKCS8ShroudedKeyBag, CertBag, CertBag}},
+		}},
+		"plain key bag": {Modern.WithPlainKeyBag(true), []safeContents{
+			{true, []BagType{CertBag, CertBag}},
+			{true, []BagType{KeyBag}},
+		}},
+		"single plain key bag": {Modern.WithSingleSafeContents(true).WithPlainKeyBag(true), []safeContents{
+			{true, []BagType{CertBag, CertBag, KeyBag}},
+		}},
+	} {
+		pfxData, err := test.enc.Encode(key, cert, []*x509.Certificate{caCert}, "password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		contents, err := DecodeContents(pfxData, "password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var layout []safeContents
+		for _, sc := range contents {
+			l := safeContents{Encrypted: sc.Encrypted}
+			for _, entry := range sc.Entries {
+				l.BagTypes = append(l.BagTypes, entry.BagType)
+			}
+			layout = append(layout, l)
+		}
+		if !reflect.DeepEqual(layout, test.expected) {
+			t.Errorf("%s: expected layout %+v, got %+v", name, test.expected, layout)
+		}
+
+		privateKey, certificate, caCerts, err := DecodeChain(pfxData, "password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !certificate.Equal(cert) || len(caCerts) != 1 || !caCerts[0].Equal(caCert) {
+			t.Errorf("%s: wrong certificates decoded", name)
+		}
+		if err := MatchKeyToCert(privateKey, certificate); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestWithPlainKeyBag(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	enc := Modern.WithPlainKeyBag(true)
+
+	pfxData, err := enc.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := ToPEM(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, block := range blocks {
+		types = append(types, block.Type)
+	}
+	if !reflect.DeepEqual(types, []string{certificateType, privateKeyType}) {
+		t.Errorf("unexpected PEM blocks %v", types)
+	}
+
+	pkData := make([]byte, 1024)
+	n, err := DefaultDecoder.DecryptPrivateKeyInto(pkData, pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privateKey, err := x509.ParsePKCS8PrivateKey(pkData[:n]); err != nil {
+		t.Error(err)
+	} else if !key.Equal(privateKey) {
+		t.Error("unexpected private key")
+	}
+
+	if _, err := enc.WithEncryptedCerts(false).Encode(key, cert, nil, "password"); err == nil {
+		t.Error("expected a plain key bag without encrypted certificates to be refused")
+	}
+}
+
+func TestKeyBag(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	enc := Modern.WithPlainKeyBag(true).WithKeyUsage(x509.KeyUsageDigitalSignature)
+
+	der, err := enc.EncodeKeyBag(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privateKey, err := DecodeKeyBag(der); err != nil {
+		t.Fatal(err)
+	} else if !key.Equal(privateKey) {
+		t.Error("unexpected private key")
+	}
+
+	// The bag Encode writes holds the same value.
+	pfxData, err := enc.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bag safeBag
+	if err := unmarshal(contents[1].Entries[0].RawBag, &bag); err != nil {
+		t.Fatal(err)
+	}
+	if !bag.Id.Equal(oidKeyBag) || !contents[1].Encrypted {
+		t.Fatalf("unexpected %v bag", bag.Id)
+	}
+	if !bytes.Equal(bag.Value.Bytes, der) {
+		t.Error("EncodeKeyBag and Encode disagree")
+	}
+
+	if _, err := DecodeKeyBag(der[:len(der)-1]); err == nil {
+		t.Error("decoded a truncated key bag")
+	}
+}
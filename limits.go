@@ -0,0 +1,209 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import "strconv"
+
+// Limits bounds the resources spent on decoding a single PKCS#12 file, so
+// that crafted input is rejected with a LimitError rather than exhausting
+// memory or stack.  A zero field is replaced by the value from
+// DefaultLimits.
+type Limits struct {
+	// MaxElementSize is the largest length, in bytes, that an ASN.1
+	// element of the file, or of any decrypted SafeContents, may declare.
+	MaxElementSize int
+	// MaxBags is the largest number of safe bags the file may contain,
+	// across all of its SafeContents.
+	MaxBags int
+	// MaxDepth is the deepest nesting of constructed ASN.1 elements
+	// accepted.
+	MaxDepth int
+	// MaxDecryptedSize is the largest total size, in bytes, of the
+	// plaintext decrypted from the file: its encrypted SafeContents and
+	// its shrouded key bags.  It bounds the memory a service decoding
+	// untrusted uploads spends on a single file.  The default is that of
+	// MaxElementSize, so that a file cannot decrypt more than a single
+	// SafeContents of the largest size.
+	MaxDecryptedSize int
+}
+
+// DefaultLimits are the limits applied by a Decoder on which WithLimits has
+// not been called, and by Probe.  They accommodate trust stores with
+// thousands of certificates.
+var DefaultLimits = Limits{
+	MaxElementSize:   32 << 20,
+	MaxBags:          4096,
+	MaxDepth:         32,
+	MaxDecryptedSize: 32 << 20,
+}
+
+// WithLimits creates a new Decoder identical to dec except that it applies
+// limits when decoding.
+func (dec Decoder) WithLimits(limits Limits) *Decoder {
+	dec.limits = limits
+	return &dec
+}
+
+// withDefaults returns l with every zero or negative field set from
+// DefaultLimits.
+func (l Limits) withDefaults() Limits {
+	if l.MaxElementSize <= 0 {
+		l.MaxElementSize = DefaultLimits.MaxElementSize
+	}
+	if l.MaxBags <= 0 {
+		l.MaxBags = DefaultLimits.MaxBags
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultLimits.MaxDepth
+	}
+	if l.MaxDecryptedSize <= 0 {
+		l.MaxDecryptedSize = DefaultLimits.MaxDecryptedSize
+	}
+	return l
+}
+
+// A LimitError is returned when decoding input that exceeds one of the
+// Limits of a Decoder.
+type LimitError struct {
+	// Limit names the limit that was exceeded: "element size", "bag
+	// count", "nesting depth" or "decrypted size".
+	Limit string
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return "pkcs12: " + e.Limit + " exceeds limit of " + strconv.Itoa(e.Max)
+}
+
+// checkDER walks the DER encoding in data, without allocating, and checks
+// every element against the element size and nesting depth limits.  The
+// contents of primitive elements, such as OCTET STRINGs holding further DER,
+// are not descended into; they are checked when they are decoded.
+//
+// Malformed encodings are not reported, the walk simply stops; they are
+// left to the subsequent unmarshal, which gives a more useful error.
+func (l Limits) checkDER(data []byte) error {
+	return l.checkDERDepth(data, 1)
+}
+
+func (l Limits) checkDERDepth(data []byte, depth int) error {
+	if depth > l.MaxDepth {
+		return &LimitError{"nesting depth", l.MaxDepth}
+	}
+
+	for len(data) > 0 {
+		constructed, content, rest, ok := parseDERElement(data)
+		if !ok {
+			return nil
+		}
+		if len(content) > l.MaxElementSize {
+			return &LimitError{"element size", l.MaxElementSize}
+		}
+		if constructed {
+			if err := l.checkDERDepth(content, depth+1); err != nil {
+				return err
+			}
+		}
+		data = rest
+	}
+	return nil
+}
+
+// checkBagCount returns a LimitError if n bags exceed the bag count limit.
+func (l Limits) checkBagCount(n int) error {
+	if n > l.MaxBags {
+		return &LimitError{"bag count", l.MaxBags}
+	}
+	return nil
+}
+
+// usage is what decoding a file has spent so far of the limits which apply
+// to the whole file.
+type usage struct {
+	bags      int
+	decrypted int
+}
+
+// addBags adds n bags to u and returns a LimitError if they exceed the bag
+// count limit.
+func (l Limits) addBags(u *usage, n int) error {
+	u.bags += n
+	return l.checkBagCount(u.bags)
+}
+
+// addDecrypted adds n bytes of plaintext to u and returns a LimitError if
+// they exceed the decrypted size limit.
+func (l Limits) addDecrypted(u *usage, n int) error {
+	u.decrypted += n
+	if u.decrypted > l.MaxDecryptedSize {
+		return &LimitError{"decrypted size", l.MaxDecryptedSize}
+	}
+	return nil
+}
+
+// add adds the bags of sc, and its plaintext if it was decrypted, to u and
+// checks them against the limits.
+func (l Limits) add(u *usage, sc *decodedSafeContents) error {
+	if err := l.addBags(u, len(sc.bags)); err != nil {
+		return err
+	}
+	if sc.decrypted() {
+		return l.addDecrypted(u, len(sc.der))
+	}
+	return nil
+}
+
+// parseDERElement splits the first element off data, returning whether it
+// is constructed, its contents and the remaining bytes.  The declared
+// length is checked against the input before it is used; ok is false if
+// the element is malformed or truncated.
+func parseDERElement(data []byte) (constructed bool, content, rest []byte, ok bool) {
+	if len(data) < 2 {
+		return false, nil, nil, false
+	}
+	constructed = data[0]&0x20 != 0
+
+	offset := 1
+	if data[0]&0x1f == 0x1f {
+		// high tag number form
+		for {
+			if offset >= len(data) {
+				return false, nil, nil, false
+			}
+			b := data[offset]
+			offset++
+			if b&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	if offset >= len(data) {
+		return false, nil, nil, false
+	}
+	length := int(data[offset])
+	offset++
+	if length&0x80 != 0 {
+		numBytes := length & 0x7f
+		if numBytes == 0 || numBytes > 4 {
+			// indefinite length, or longer than any input we would accept
+			return false, nil, nil, false
+		}
+		length = 0
+		for i := 0; i < numBytes; i++ {
+			if offset >= len(data) {
+				return false, nil, nil, false
+			}
+			length = length<<8 | int(data[offset])
+			offset++
+		}
+	}
+
+	if length > len(data)-offset {
+		return false, nil, nil, false
+	}
+	return constructed, data[offset : offset+length], data[offset+length:], true
+}
@@ -0,0 +1,102 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	certs := make(map[string]*x509.Certificate)
+	for _, name := range []string{"a", "b", "c"} {
+		_, certs[name] = makeTestCertificate(t, name, true, nil, nil)
+	}
+	pfxData, err := Modern.EncodeTrustStore(certs, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeTrustStore(pfxData, DefaultPassword); err != nil {
+		t.Fatalf("default limits: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		limits Limits
+		limit  string
+	}{
+		"MaxElementSize": {Limits{MaxElementSize: 256}, "element size"},
+		"MaxBags":        {Limits{MaxBags: 2}, "bag count"},
+		"MaxDepth":       {Limits{MaxDepth: 3}, "nesting depth"},
+		"MaxDecrypted":   {Limits{MaxDecryptedSize: 256}, "decrypted size"},
+	} {
+		_, err := DefaultDecoder.WithLimits(test.limits).DecodeTrustStore(pfxData, DefaultPassword)
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != test.limit {
+			t.Errorf("%s: expected %s LimitError, got %v", name, test.limit, err)
+		}
+	}
+}
+
+func TestLimitsDecryptedKeys(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enough for the encrypted certificates, but not for the key too.
+	limits := Limits{MaxDecryptedSize: len(contents[0].Entries[0].RawBag) + 16}
+	dec := DefaultDecoder.WithLimits(limits)
+	var limitErr *LimitError
+	if _, err := dec.DecodeContents(pfxData, DefaultPassword); !errors.As(err, &limitErr) || limitErr.Limit != "decrypted size" {
+		t.Errorf("DecodeContents: expected decrypted size LimitError, got %v", err)
+	}
+	if _, _, _, err := dec.DecodeChain(pfxData, DefaultPassword); !errors.As(err, &limitErr) || limitErr.Limit != "decrypted size" {
+		t.Errorf("DecodeChain: expected decrypted size LimitError, got %v", err)
+	}
+	if _, _, err := dec.Decode(pfxData, DefaultPassword); !errors.As(err, &limitErr) || limitErr.Limit != "decrypted size" {
+		t.Errorf("Decode: expected decrypted size LimitError, got %v", err)
+	}
+	if _, err := dec.ToPEM(pfxData, DefaultPassword); !errors.As(err, &limitErr) || limitErr.Limit != "decrypted size" {
+		t.Errorf("ToPEM: expected decrypted size LimitError, got %v", err)
+	}
+	if _, err := dec.DecryptPrivateKeyInto(make([]byte, 4096), pfxData, DefaultPassword); !errors.As(err, &limitErr) || limitErr.Limit != "decrypted size" {
+		t.Errorf("DecryptPrivateKeyInto: expected decrypted size LimitError, got %v", err)
+	}
+	if _, _, _, err := DefaultDecoder.DecodeChain(pfxData, DefaultPassword); err != nil {
+		t.Errorf("DecodeChain with the default limits: %v", err)
+	}
+
+	// A key decrypted on its own counts towards the limit too.
+	der, err := Modern.EncryptPrivateKey(key, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DefaultDecoder.WithLimits(Limits{MaxDecryptedSize: 64}).DecryptPrivateKey(der, DefaultPassword); !errors.As(err, &limitErr) || limitErr.Limit != "decrypted size" {
+		t.Errorf("DecryptPrivateKey: expected decrypted size LimitError, got %v", err)
+	}
+}
+
+func TestLimitsDeepNesting(t *testing.T) {
+	// 100000 nested SEQUENCEs, each of them declaring the remaining length.
+	const depth = 100000
+	data := make([]byte, 0, 6*depth)
+	for i := 0; i < depth; i++ {
+		n := 6 * (depth - i - 1)
+		data = append(data, 0x30, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	var limitErr *LimitError
+	if _, err := Probe(data); !errors.As(err, &limitErr) || limitErr.Limit != "nesting depth" {
+		t.Errorf("expected nesting depth LimitError, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"errors"
+)
+
+// WithLocalKeyIDHash creates a new Encoder identical to enc except that
+// the localKeyId linking the private key to its certificate is the hash h
+// of the SubjectPublicKeyInfo of the certificate, instead of the SHA-1
+// fingerprint of the whole certificate.  The ID then depends only on the
+// key, so that it stays the same when the file is rebuilt with a renewed
+// certificate for the same key, and tooling which tracks keys by their
+// ID is not disturbed.  A zero h restores the fingerprint.  If h is not
+// available, the Encode methods of the result return an error.
+func (enc Encoder) WithLocalKeyIDHash(h crypto.Hash) *Encoder {
+	if h != 0 && !h.Available() {
+		enc.setErr(errors.New("pkcs12: unavailable localKeyId hash " + h.String()))
+		return &enc
+	}
+	enc.localKeyIDHash = h
+	return &enc
+}
+
+// localKeyID returns the localKeyId of the key of cert, as selected by
+// WithLocalKeyIDHash.
+func (enc *Encoder) localKeyID(cert *x509.Certificate) ([]byte, error) {
+	if enc.localKeyIDHash == 0 {
+		fingerprint := sha1.Sum(cert.Raw)
+		return fingerprint[:], nil
+	}
+
+	spki := cert.RawSubjectPublicKeyInfo
+	if len(spki) == 0 {
+		// A certificate given only in DER, see EncodeDER.
+		parsed, err := x509.ParseCertificate(cert.Raw)
+		if err != nil {
+			return nil, errors.New("pkcs12: error parsing certificate for its localKeyId: " + err.Error())
+		}
+		spki = parsed.RawSubjectPublicKeyInfo
+	}
+	h := enc.localKeyIDHash.New()
+	h.Write(spki)
+	return h.Sum(nil), nil
+}
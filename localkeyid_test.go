@@ -0,0 +1,71 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+)
+
+func TestWithLocalKeyIDHash(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	fingerprint := sha1.Sum(cert.Raw)
+
+	for _, test := range []struct {
+		name string
+		enc  *Encoder
+		id   []byte
+	}{
+		{"default", Modern, fingerprint[:]},
+		{"SHA-256", Modern.WithLocalKeyIDHash(crypto.SHA256), spki[:]},
+		{"reset", Modern.WithLocalKeyIDHash(crypto.SHA256).WithLocalKeyIDHash(0), fingerprint[:]},
+	} {
+		pfxData, err := test.enc.Encode(key, cert, []*x509.Certificate{caCert}, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		contents, err := DecodeContents(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if id := contents[0].Entries[0].LocalKeyID(); !bytes.Equal(id, test.id) {
+			t.Errorf("%s: certificate has localKeyId %x, expected %x", test.name, id, test.id)
+		}
+		if id := contents[1].Entries[0].LocalKeyID(); !bytes.Equal(id, test.id) {
+			t.Errorf("%s: key has localKeyId %x, expected %x", test.name, id, test.id)
+		}
+		if _, _, _, err := DecodeChain(pfxData, DefaultPassword); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+
+	// The ID of a certificate given only in DER is the same.
+	pfxData, err := Modern.WithLocalKeyIDHash(crypto.SHA1).EncodeDER(key, cert.Raw, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := sha1.Sum(cert.RawSubjectPublicKeyInfo)
+	if id := contents[0].Entries[0].LocalKeyID(); !bytes.Equal(id, expected[:]) {
+		t.Errorf("certificate in DER has localKeyId %x, expected %x", id, expected)
+	}
+	if _, err := Modern.WithLocalKeyIDHash(crypto.SHA1).EncodeDER(key, badCert, nil, DefaultPassword); err == nil {
+		t.Error("encoded a certificate that does not parse")
+	}
+
+	if _, err := Modern.WithLocalKeyIDHash(crypto.MD4).EncodeDER(key, cert.Raw, nil, DefaultPassword); err == nil {
+		t.Error("WithLocalKeyIDHash accepted an unavailable hash")
+	}
+}
@@ -6,16 +6,22 @@
 package pkcs12
 
 import (
+	"context"
+	"crypto"
 	"crypto/hmac"
-	"crypto/sha1"
 	"crypto/x509/pkix"
 	"encoding/asn1"
+
+	"github.com/nevissecurity/go-pkcs12/pbe"
 )
 
+// macData is the MacData of a PFX.  Some generators leave out the salt,
+// which is then taken to be empty, and the iteration count defaults to 1
+// as in RFC 7292.
 type macData struct {
 	Mac        digestInfo
-	MacSalt    []byte
-	Iterations int `asn1:"optional,default:1"`
+	MacSalt    []byte `asn1:"optional"`
+	Iterations int    `asn1:"optional,default:1"`
 }
 
 // from PKCS#7:
@@ -25,19 +31,84 @@ type digestInfo struct {
 }
 
 var (
-	oidSHA1 = asn1.ObjectIdentifier([]int{1, 3, 14, 3, 2, 26})
+	oidSHA1   = asn1.ObjectIdentifier([]int{1, 3, 14, 3, 2, 26})
+	oidSHA224 = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 4})
+	oidSHA256 = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 1})
+	oidSHA384 = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 2})
+	oidSHA512 = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 3})
+
+	oidSHA512_224 = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 5})
+	oidSHA512_256 = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 2, 6})
 )
 
-func verifyMac(macData *macData, message, password []byte) error {
-	if !macData.Mac.Algorithm.Algorithm.Equal(oidSHA1) {
-		return NotImplementedError("unknown digest algorithm: " + macData.Mac.Algorithm.Algorithm.String())
+// macAlgorithms maps the hash functions an Encoder can use for the MAC to
+// their digest algorithm OIDs.
+var macAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:       oidSHA1,
+	crypto.SHA224:     oidSHA224,
+	crypto.SHA256:     oidSHA256,
+	crypto.SHA384:     oidSHA384,
+	crypto.SHA512:     oidSHA512,
+	crypto.SHA512_224: oidSHA512_224,
+	crypto.SHA512_256: oidSHA512_256,
+}
+
+// macHashFor returns the hash function identified by the MAC digest
+// algorithm.
+func macHashFor(algorithm asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case algorithm.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case algorithm.Equal(oidSHA224):
+		return crypto.SHA224, nil
+	case algorithm.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case algorithm.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case algorithm.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	case algorithm.Equal(oidSHA512_224):
+		return crypto.SHA512_224, nil
+	case algorithm.Equal(oidSHA512_256):
+		return crypto.SHA512_256, nil
+	}
+	return 0, NotImplementedError("unknown digest algorithm: " + algorithm.String())
+}
+
+// mac computes the HMAC over message with a key derived from password
+// using the PKCS#12 KDF, see https://tools.ietf.org/html/rfc7292#appendix-B.4,
+// or with the HMAC of the HSM carried by ctx.
+func mac(ctx context.Context, macData *macData, message, password []byte) ([]byte, error) {
+	h, err := macHashFor(macData.Mac.Algorithm.Algorithm)
+	if err != nil {
+		return nil, err
 	}
 
-	key := pbkdf(sha1Sum, 20, 64, macData.MacSalt, password, macData.Iterations, 3, 20)
+	if hsm := hsmFor(ctx); hsm != nil {
+		mac, err := hsm.NewMAC(ctx, h, password, macData.MacSalt, macData.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		mac.Write(message)
+		return mac.Sum(nil), nil
+	}
+
+	key, err := kdfFor(ctx).PKCS12(ctx, h, pbe.MACKey, password, macData.MacSalt, macData.Iterations, h.Size())
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
 
-	mac := hmac.New(sha1.New, key)
+	mac := hmac.New(h.New, key)
 	mac.Write(message)
-	expectedMAC := mac.Sum(nil)
+	return mac.Sum(nil), nil
+}
+
+func verifyMac(ctx context.Context, macData *macData, message, password []byte) error {
+	expectedMAC, err := mac(ctx, macData, message, password)
+	if err != nil {
+		return err
+	}
 
 	if !hmac.Equal(macData.Mac.Digest, expectedMAC) {
 		return ErrIncorrectPassword
@@ -45,16 +116,7 @@ func verifyMac(macData *macData, message, password []byte) error {
 	return nil
 }
 
-func computeMac(macData *macData, message, password []byte) error {
-	if !macData.Mac.Algorithm.Algorithm.Equal(oidSHA1) {
-		return NotImplementedError("unknown digest algorithm: " + macData.Mac.Algorithm.Algorithm.String())
-	}
-
-	key := pbkdf(sha1Sum, 20, 64, macData.MacSalt, password, macData.Iterations, 3, 20)
-
-	mac := hmac.New(sha1.New, key)
-	mac.Write(message)
-	macData.Mac.Digest = mac.Sum(nil)
-
-	return nil
+func computeMac(ctx context.Context, macData *macData, message, password []byte) (err error) {
+	macData.Mac.Digest, err = mac(ctx, macData, message, password)
+	return err
 }
@@ -7,7 +7,9 @@ package pkcs12
 
 import (
 	"bytes"
+	"context"
 	"encoding/asn1"
+	"os"
 	"testing"
 )
 
@@ -24,19 +26,19 @@ func TestVerifyMac(t *testing.T) {
 	password, _ := bmpString("")
 
 	td.Mac.Algorithm.Algorithm = asn1.ObjectIdentifier([]int{1, 2, 3})
-	err := verifyMac(&td, message, password)
+	err := verifyMac(context.Background(), &td, message, password)
 	if _, ok := err.(NotImplementedError); !ok {
 		t.Errorf("err: %v", err)
 	}
 
 	td.Mac.Algorithm.Algorithm = asn1.ObjectIdentifier([]int{1, 3, 14, 3, 2, 26})
-	err = verifyMac(&td, message, password)
+	err = verifyMac(context.Background(), &td, message, password)
 	if err != ErrIncorrectPassword {
 		t.Errorf("Expected incorrect password, got err: %v", err)
 	}
 
 	password, _ = bmpString("Sesame open")
-	err = verifyMac(&td, message, password)
+	err = verifyMac(context.Background(), &td, message, password)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -53,13 +55,13 @@ func TestComputeMac(t *testing.T) {
 	password, _ := bmpString("Sesame open")
 
 	td.Mac.Algorithm.Algorithm = asn1.ObjectIdentifier([]int{1, 2, 3})
-	err := computeMac(&td, message, password)
+	err := computeMac(context.Background(), &td, message, password)
 	if _, ok := err.(NotImplementedError); !ok {
 		t.Errorf("err: %v", err)
 	}
 
 	td.Mac.Algorithm.Algorithm = asn1.ObjectIdentifier([]int{1, 3, 14, 3, 2, 26})
-	err = computeMac(&td, message, password)
+	err = computeMac(context.Background(), &td, message, password)
 	if err != nil {
 		t.Errorf("err: %v", err)
 	}
@@ -71,3 +73,171 @@ func TestComputeMac(t *testing.T) {
 	}
 
 }
+
+func TestMacDataDefaults(t *testing.T) {
+	// Written by openssl pkcs12 -export -nomaciter, which leaves out the
+	// iteration count of the MAC.
+	pfxData, err := os.ReadFile("testdata/openssl-3.0-nomaciter.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe, err := Probe(pfxData); err != nil || probe.MAC == nil || probe.MAC.Iterations != 1 {
+		t.Errorf("unexpected probe %+v, %v", probe, err)
+	}
+	if _, _, _, err := DecodeChain(pfxData, "password"); err != nil {
+		t.Error(err)
+	}
+	if _, _, _, err := DecodeChain(pfxData, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+
+	key, cert := makeTestCertificate(t, "leaf", false, nil, nil)
+	for _, test := range []struct {
+		name     string
+		password string
+		salt     []byte
+	}{
+		{"empty salt", DefaultPassword, []byte{}},
+		{"absent salt", DefaultPassword, nil},
+		{"absent salt and empty password", "", nil},
+	} {
+		pfxData, err := Modern.Encode(key, cert, nil, test.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pfx pfxPdu
+		if err := unmarshal(pfxData, &pfx); err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := parsePFX(pfxData, DefaultLimits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pfx.MacData.MacSalt = test.salt
+		pfx.MacData.Iterations = 1
+		password, _ := bmpString(test.password)
+		if err := computeMac(context.Background(), &pfx.MacData, parsed.AuthSafe.Content.Bytes, password); err != nil {
+			t.Fatal(err)
+		}
+		if test.salt != nil {
+			pfxData, err = asn1.Marshal(pfx)
+		} else {
+			// A nil slice would still be encoded as an empty salt.
+			pfxData, err = asn1.Marshal(struct {
+				Version  int
+				AuthSafe contentInfo
+				MacData  struct{ Mac digestInfo }
+			}{pfx.Version, pfx.AuthSafe, struct{ Mac digestInfo }{pfx.MacData.Mac}})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if probe, err := Probe(pfxData); err != nil || probe.MAC == nil || probe.MAC.SaltLen != 0 || probe.MAC.Iterations != 1 {
+			t.Errorf("%s: unexpected probe %+v, %v", test.name, probe, err)
+		}
+		if _, certificate, err := Decode(pfxData, test.password); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !certificate.Equal(cert) {
+			t.Errorf("%s: unexpected certificate", test.name)
+		}
+	}
+}
+
+// unsortedSafeBag is a safeBag whose attributes are kept as they are,
+// rather than sorted into DER order.
+type unsortedSafeBag struct {
+	Id         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes asn1.RawValue
+}
+
+func TestMacOverStoredBytes(t *testing.T) {
+	_, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	certBags, localKeyID, err := Modern.makeChainBags(cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	friendlyName, err := friendlyNameBagAttribute("leaf", NameBMPString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// BER, unlike DER, leaves the order of a SET OF free.  Write the
+	// attributes out of DER order, as some tools do.
+	first, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := asn1.Marshal(friendlyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(first, second) < 0 {
+		first, second = second, first
+	}
+	bag := unsortedSafeBag{
+		Id:         certBags[0].Id,
+		Value:      certBags[0].Value,
+		Attributes: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: append(append([]byte(nil), first...), second...)},
+	}
+	if certBags[0].Raw, err = asn1.Marshal(bag); err != nil {
+		t.Fatal(err)
+	}
+	ci, err := Modern.makeSafeContents(certBags[:1], nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := bmpString(DefaultPassword)
+	pfxData, err := Modern.marshalPFX([]contentInfo{ci}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-encoding the bag sorts its attributes, so a MAC over a
+	// re-encoding of the authenticated safe would not verify.
+	var decoded safeBag
+	if err := unmarshal(certBags[0].Raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	decoded.Raw = nil
+	if reencoded, err := asn1.Marshal(decoded); err != nil {
+		t.Fatal(err)
+	} else if bytes.Equal(reencoded, certBags[0].Raw) {
+		t.Fatal("the bag is in DER already")
+	}
+
+	contents, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents[0].Entries[0].RawBag, certBags[0].Raw) {
+		t.Error("the bag was not kept as stored")
+	}
+
+	// The bytes and parameters Probe exposes verify the MAC elsewhere.
+	probe, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	td := macData{MacSalt: probe.MAC.Salt, Iterations: probe.MAC.Iterations}
+	td.Mac.Algorithm.Algorithm = probe.MAC.Algorithm
+	td.Mac.Digest = probe.MAC.Digest
+	if err := verifyMac(context.Background(), &td, probe.AuthenticatedSafe, password); err != nil {
+		t.Errorf("the MAC does not verify over AuthenticatedSafe: %v", err)
+	}
+
+	// Segmenting the OCTET STRING changes the file but not the message.
+	pfxData, err = Modern.WithAuthSafeEncoding(AuthSafeConstructed).marshalPFX([]contentInfo{ci}, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segmented, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(segmented.AuthenticatedSafe, probe.AuthenticatedSafe) {
+		t.Error("the segments of the authenticated safe were not joined")
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+)
+
+// Merge combines the entries of the PKCS#12 files a and b, protected by
+// passA and passB, into one file protected by passOut.  The SafeContents
+// of a come first, followed by those of b.
+//
+// Certificates of b which are already in a, and private keys of b which
+// are already in a, are dropped, so that an identity present in both
+// files appears once.  Distinct identities are all kept: when a
+// localKeyId of b is also used in a for a different key, b's entries are
+// given a new one, so that each key stays linked to its own certificate.
+//
+// Merge uses DefaultDecoder to read the files.
+func (enc *Encoder) Merge(a, b []byte, passA, passB, passOut string) (pfxData []byte, err error) {
+	first := NewPFX(passA)
+	if err = first.Unmarshal(a); err != nil {
+		return nil, err
+	}
+	second := NewPFX(passB)
+	if err = second.Unmarshal(b); err != nil {
+		return nil, err
+	}
+
+	var certs [][]byte
+	var keys []crypto.PrivateKey
+	var keyIDs [][]byte
+	usedIDs := make(map[string]bool)
+	for i := range first.Contents {
+		for _, entry := range first.Contents[i].Entries {
+			if entry.Certificate != nil {
+				certs = append(certs, entry.Certificate.Raw)
+			}
+			if entry.PrivateKey != nil {
+				keys = append(keys, entry.PrivateKey)
+				keyIDs = append(keyIDs, entry.LocalKeyID())
+			}
+			if id := entry.LocalKeyID(); id != nil {
+				usedIDs[string(id)] = true
+			}
+		}
+	}
+
+	// Work out the new localKeyId of each of b's keys: that of the same key in
+	// a, or a fresh one if a uses it already.
+	newIDs := make(map[string][]byte)
+	for i := range second.Contents {
+		for _, entry := range second.Contents[i].Entries {
+			if entry.PrivateKey == nil || entry.LocalKeyID() == nil {
+				continue
+			}
+			if j := indexKey(keys, entry.PrivateKey); j >= 0 && keyIDs[j] != nil {
+				newIDs[string(entry.LocalKeyID())] = keyIDs[j]
+			}
+		}
+	}
+	for i := range second.Contents {
+		for _, entry := range second.Contents[i].Entries {
+			id := entry.LocalKeyID()
+			if id == nil || !usedIDs[string(id)] {
+				continue
+			}
+			if _, ok := newIDs[string(id)]; ok {
+				continue
+			}
+			fresh := id
+			for n := byte(0); usedIDs[string(fresh)]; n++ {
+				sum := sha1.Sum(append(append([]byte(nil), id...), n))
+				fresh = sum[:]
+			}
+			usedIDs[string(fresh)] = true
+			newIDs[string(id)] = fresh
+		}
+	}
+
+	merged := first.Contents
+	for i := range second.Contents {
+		var entries []Entry
+		for _, entry := range second.Contents[i].Entries {
+			if entry.Certificate != nil && indexBytes(certs, entry.Certificate.Raw) >= 0 {
+				continue
+			}
+			if entry.PrivateKey != nil && indexKey(keys, entry.PrivateKey) >= 0 {
+				continue
+			}
+			if id, ok := newIDs[string(entry.LocalKeyID())]; ok {
+				entry.SetAttribute(LocalKeyIDAttribute(id))
+			}
+			if entry.Certificate != nil {
+				certs = append(certs, entry.Certificate.Raw)
+			}
+			if entry.PrivateKey != nil {
+				keys = append(keys, entry.PrivateKey)
+			}
+			entries = append(entries, entry)
+		}
+		if len(entries) != 0 {
+			merged = append(merged, SafeContents{Encrypted: second.Contents[i].Encrypted, Entries: entries})
+		}
+	}
+
+	out := PFX{Contents: merged, Encoder: enc, password: passOut}
+	return out.Marshal()
+}
+
+// Merge is equivalent to Modern.Merge.
+func Merge(a, b []byte, passA, passB, passOut string) (pfxData []byte, err error) {
+	return Modern.Merge(a, b, passA, passB, passOut)
+}
+
+func indexBytes(list [][]byte, b []byte) int {
+	for i := range list {
+		if bytes.Equal(list[i], b) {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexKey(keys []crypto.PrivateKey, key crypto.PrivateKey) int {
+	k, ok := key.(interface{ Equal(crypto.PrivateKey) bool })
+	if !ok {
+		return -1
+	}
+	for i := range keys {
+		if k.Equal(keys[i]) {
+			return i
+		}
+	}
+	return -1
+}
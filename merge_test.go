@@ -0,0 +1,104 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	_, otherCA := makeTestCertificate(t, "Other CA", true, nil, nil)
+
+	identity, err := Encode(rand.Reader, key, cert, []*x509.Certificate{caCert}, "customer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := EncodeTrustStore(rand.Reader, map[string]*x509.Certificate{"test": caCert, "other": otherCA}, "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := Merge(identity, bundle, "customer", "bundle", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, certificate, caCerts, err := DecodeChain(merged, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !certificate.Equal(cert) {
+		t.Error("unexpected leaf certificate")
+	}
+	if err := MatchKeyToCert(privateKey, certificate); err != nil {
+		t.Error(err)
+	}
+	if len(caCerts) != 2 || !caCerts[0].Equal(caCert) || !caCerts[1].Equal(otherCA) {
+		t.Errorf("expected the CA certificates once each, got %d", len(caCerts))
+	}
+
+	// Merging a file with itself changes nothing.
+	again, err := Merge(merged, merged, "out", "out", "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DecodeContents(merged, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeContents(again, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !EqualContents(want, got) {
+		t.Error("merging a file with itself changed its contents")
+	}
+}
+
+func TestMergeLocalKeyIDCollision(t *testing.T) {
+	id := LocalKeyIDAttribute([]byte{1})
+	makeIdentity := func(commonName string) []byte {
+		key, cert := makeTestCertificate(t, commonName, false, nil, nil)
+		pfxData, err := Modern.EncodeContents([]SafeContents{
+			{Entries: []Entry{{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{id}}}},
+			{Encrypted: true, Entries: []Entry{{BagType: CertBag, Certificate: cert, Attributes: []Attribute{id}}}},
+		}, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pfxData
+	}
+	a := makeIdentity("a.example.com")
+	b := makeIdentity("b.example.com")
+
+	merged, err := Merge(a, b, "password", "password", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(merged, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 4 {
+		t.Fatalf("expected 4 SafeContents, got %d", len(contents))
+	}
+	for i := 0; i < 2; i++ {
+		key, cert := contents[i*2].Entries[0], contents[i*2+1].Entries[0]
+		if !bytes.Equal(key.LocalKeyID(), cert.LocalKeyID()) {
+			t.Errorf("identity %d: key and certificate are not linked", i)
+		}
+		if err := MatchKeyToCert(key.PrivateKey, cert.Certificate); err != nil {
+			t.Errorf("identity %d: %v", i, err)
+		}
+	}
+	if bytes.Equal(contents[0].Entries[0].LocalKeyID(), contents[2].Entries[0].LocalKeyID()) {
+		t.Error("the identities share a localKeyId")
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"unicode/utf16"
+)
+
+// A NameEncoding selects how an Encoder encodes friendlyName attributes,
+// which PKCS#9 defines as a BMPString.
+type NameEncoding int
+
+const (
+	// NameBMPString encodes friendly names as UCS-2 BMPStrings and refuses
+	// names with characters outside the Basic Multilingual Plane, such as
+	// emoji.  It is the default, and every reader understands it.
+	NameBMPString NameEncoding = iota
+	// NameUTF16 writes characters outside the Basic Multilingual Plane as
+	// UTF-16 surrogate pairs in the BMPString, as the jks package and the
+	// java keytool, whose strings are UTF-16, do.  OpenSSL does not decode
+	// the surrogate pairs.
+	NameUTF16
+	// NameUTF8String falls back to a UTF8String for names which cannot be
+	// encoded as a UCS-2 BMPString.  This package reads such names back
+	// and "openssl pkcs12" prints them, but programs which expect a
+	// BMPString, like the java keytool, ignore them.
+	NameUTF8String
+	// NameBMPAndUTF8String writes every friendly name twice, as the two
+	// values of the attribute: a BMPString, encoded as by NameUTF16, and
+	// then a UTF8String, for consumers some of which understand only one
+	// of them.  PKCS#9 allows a single value, but readers which take the
+	// first one, like this package, OpenSSL and the java keytool, see the
+	// BMPString.
+	NameBMPAndUTF8String
+)
+
+// WithNameEncoding creates a new Encoder identical to enc except that the
+// aliases of EncodeTrustStore and the attributes of
+// Encoder.FriendlyNameAttribute are encoded as selected by encoding.
+func (enc Encoder) WithNameEncoding(encoding NameEncoding) *Encoder {
+	enc.nameEncoding = encoding
+	return &enc
+}
+
+// FriendlyNameAttribute returns a friendlyName attribute holding name,
+// encoded as selected by WithNameEncoding.
+func (enc *Encoder) FriendlyNameAttribute(name string) (Attribute, error) {
+	values, err := friendlyNameValues(name, enc.nameEncoding)
+	if err != nil {
+		return Attribute{}, err
+	}
+	return Attribute{Type: OIDFriendlyName, Values: values}, nil
+}
+
+// marshalFriendlyName returns the DER encoding of the values of a
+// friendlyName attribute holding name, the content of its SET.
+func marshalFriendlyName(name string, encoding NameEncoding) ([]byte, error) {
+	values, err := friendlyNameValues(name, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join(values, nil), nil
+}
+
+// friendlyNameValues returns the DER encoding of each value of a
+// friendlyName attribute holding name.
+func friendlyNameValues(name string, encoding NameEncoding) ([][]byte, error) {
+	if encoding == NameBMPAndUTF8String {
+		bmp, err := friendlyNameValue(name, NameUTF16)
+		if err != nil {
+			return nil, err
+		}
+		utf8, err := asn1.MarshalWithParams(name, "utf8")
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{bmp, utf8}, nil
+	}
+
+	der, err := friendlyNameValue(name, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{der}, nil
+}
+
+// friendlyNameValue returns the DER encoding of name as a single
+// friendlyName value.
+func friendlyNameValue(name string, encoding NameEncoding) ([]byte, error) {
+	der, err := marshalBmpString(name)
+	if err == nil || encoding == NameBMPString {
+		return der, err
+	}
+
+	if encoding == NameUTF8String {
+		return asn1.MarshalWithParams(name, "utf8")
+	}
+	var value []byte
+	for _, u := range utf16.Encode([]rune(name)) {
+		value = append(value, byte(u>>8), byte(u))
+	}
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: value})
+}
+
+// unmarshalFriendlyName decodes a friendlyName value, a BMPString or, as
+// written with NameUTF8String, a UTF8String.
+func unmarshalFriendlyName(der []byte) (string, error) {
+	if len(der) != 0 && der[0] == asn1.TagUTF8String {
+		var name string
+		if err := unmarshal(der, &name); err != nil {
+			return "", err
+		}
+		return name, nil
+	}
+	return unmarshalBmpString(der)
+}
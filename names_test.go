@@ -0,0 +1,97 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+)
+
+func TestNameEncoding(t *testing.T) {
+	_, caCert := makeTestCertificate(t, "ca", true, nil, nil)
+	const alias = "root \U0001f512 路"
+
+	if _, err := Modern.EncodeTrustStore(map[string]*x509.Certificate{alias: caCert}, "password"); err == nil {
+		t.Error("expected the default encoding to refuse a name outside the BMP")
+	}
+
+	for _, test := range []struct {
+		encoding NameEncoding
+		der      []byte
+	}{
+		{NameUTF16, []byte{30, 2 * 9, 0, 'r', 0, 'o', 0, 'o', 0, 't', 0, ' ', 0xd8, 0x3d, 0xdd, 0x12, 0, ' ', 0x8d, 0xef}},
+		{NameUTF8String, append([]byte{12, byte(len(alias))}, alias...)},
+	} {
+		enc := Modern.WithNameEncoding(test.encoding)
+
+		attribute, err := enc.FriendlyNameAttribute(alias)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(attribute.Values[0], test.der) {
+			t.Errorf("encoding %d: expected %x, got %x", test.encoding, test.der, attribute.Values[0])
+		}
+		entry := Entry{BagType: CertBag, Certificate: caCert, Attributes: []Attribute{attribute}}
+		if name := entry.FriendlyName(); name != alias {
+			t.Errorf("encoding %d: FriendlyName returned %q", test.encoding, name)
+		}
+
+		pfxData, err := enc.EncodeTrustStore(map[string]*x509.Certificate{alias: caCert}, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs, err := DecodeTrustStore(pfxData, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !certs[alias].Equal(caCert) {
+			t.Errorf("encoding %d: alias not decoded, got %v", test.encoding, certs)
+		}
+		pfxData, err = enc.EncodeContents([]SafeContents{{Entries: []Entry{entry}}}, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks, err := ToPEM(pfxData, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := blocks[0].Headers["friendlyName"]; name != alias {
+			t.Errorf("encoding %d: ToPEM returned friendlyName %q", test.encoding, name)
+		}
+	}
+
+	// Both encodings, the BMPString first.
+	attribute, err := Modern.WithNameEncoding(NameBMPAndUTF8String).FriendlyNameAttribute("路")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attribute.Values) != 2 || !bytes.Equal(attribute.Values[0], []byte{30, 2, 0x8d, 0xef}) || !bytes.Equal(attribute.Values[1], []byte("\x0c\x03路")) {
+		t.Errorf("unexpected values %x", attribute.Values)
+	}
+	pfxData, err := Modern.WithNameEncoding(NameBMPAndUTF8String).EncodeTrustStore(map[string]*x509.Certificate{alias: caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := contents[0].Entries[0].Attribute(OIDFriendlyName); !ok || len(a.Values) != 2 || contents[0].Entries[0].FriendlyName() != alias {
+		t.Errorf("expected two values for %q, got %+v", alias, a)
+	}
+
+	// BMP names are encoded the same way whatever the encoding.
+	for _, encoding := range []NameEncoding{NameBMPString, NameUTF16, NameUTF8String} {
+		attribute, err := Modern.WithNameEncoding(encoding).FriendlyNameAttribute("路")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []byte{30, 2, 0x8d, 0xef}; !bytes.Equal(attribute.Values[0], expected) {
+			t.Errorf("encoding %d: expected %x, got %x", encoding, expected, attribute.Values[0])
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"os"
+	"testing"
+)
+
+// The testdata/nss-3.87-*.p12 files were written by the PKCS#12 library of
+// NSS 3.87.1 with testdata/nss/pk12.c, which drives it the way pk12util
+// does, not by the pk12util binary.  An EC key, with a certificate issued
+// by a CA, was generated by OpenSSL and imported, then exported with
+//
+//	pk12 import sql:db input.p12 password
+//	pk12 export sql:db nss nss-3.87-<name>.p12 <password> <key> <certs> <mac>
+//
+// NSS writes BER, with indefinite lengths and segmented OCTET STRINGs, and
+// 600000 iterations.  It keys the empty password, in
+// nss-3.87-emptypass.p12, with its NUL terminator.  nss-3.87-reexport.p12
+// was exported likewise after importing testdata/nss/imported.p12, written
+// by Modern.
+var nssInteropTests = []struct {
+	file     string
+	password string
+	mac      asn1.ObjectIdentifier
+	// certs is the certificate encryption scheme, or nil if the
+	// certificates are stored with the key; for PBES2, cipher is
+	// the encryption scheme.
+	certs, certsCipher asn1.ObjectIdentifier
+	key, keyCipher     asn1.ObjectIdentifier
+}{
+	{"nss-3.87-aes.p12", "password", oidSHA256, oidPBES2, oidAES128CBC, oidPBES2, oidAES256CBC},
+	{"nss-3.87-legacy.p12", "password", oidSHA1, oidPBEWithSHAAnd40BitRC2CBC, nil, oidPBEWithSHAAnd3KeyTripleDESCBC, nil},
+	{"nss-3.87-mixed.p12", "password", oidSHA256, oidPBEWithSHAAnd40BitRC2CBC, nil, oidPBES2, oidAES256CBC},
+	{"nss-3.87-plaincerts.p12", "password", oidSHA256, nil, nil, oidPBES2, oidAES256CBC},
+	{"nss-3.87-emptypass.p12", "", oidSHA256, oidPBES2, oidAES128CBC, oidPBES2, oidAES256CBC},
+}
+
+func TestNSSFixtures(t *testing.T) {
+	for _, test := range nssInteropTests {
+		pfxData, err := os.ReadFile("testdata/" + test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		probe, err := Probe(pfxData)
+		if err != nil {
+			t.Fatalf("%s: %v", test.file, err)
+		}
+		if probe.MAC == nil || !probe.MAC.Algorithm.Equal(test.mac) || probe.MAC.Iterations != 600000 {
+			t.Errorf("%s: unexpected MAC %+v", test.file, probe.MAC)
+		}
+		var keys int
+		for _, sc := range probe.SafeContents {
+			if sc.Encryption != nil && !equalEncryption(sc.Encryption, test.certs, test.certsCipher, 600000) {
+				t.Errorf("%s: unexpected certificate encryption %+v", test.file, sc.Encryption)
+			}
+			for _, bag := range sc.Bags {
+				if bag.Type.Equal(oidPKCS8ShroundedKeyBag) {
+					keys++
+					if !equalEncryption(bag.Encryption, test.key, test.keyCipher, 600000) {
+						t.Errorf("%s: unexpected key encryption %+v", test.file, bag.Encryption)
+					}
+				}
+			}
+		}
+		if keys != 1 || (test.certs == nil) != (len(probe.SafeContents) == 1) {
+			t.Errorf("%s: unexpected layout %+v", test.file, probe.SafeContents)
+		}
+
+		privateKey, certificate, caCerts, err := DecodeChain(pfxData, test.password)
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+		if _, ok := privateKey.(*ecdsa.PrivateKey); !ok {
+			t.Errorf("%s: unexpected key type %T", test.file, privateKey)
+		}
+		if certificate.Subject.CommonName != "nss.example.com" || len(caCerts) != 1 || caCerts[0].Subject.CommonName != "NSS CA" {
+			t.Errorf("%s: unexpected certificates", test.file)
+		}
+		if err := MatchKeyToCert(privateKey, certificate); err != nil {
+			t.Errorf("%s: %v", test.file, err)
+		}
+		if _, _, err := Decode(pfxData, test.password+"x"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected ErrIncorrectPassword for a wrong password, got %v", test.file, err)
+		}
+
+		// Re-encoding writes DER, which decodes to the same contents.
+		contents, err := DecodeContents(pfxData, test.password)
+		if err != nil {
+			t.Fatalf("%s: %v", test.file, err)
+		}
+		for _, sc := range contents {
+			for _, e := range sc.Entries {
+				if e.PrivateKey != nil && e.FriendlyName() != "nss" {
+					t.Errorf("%s: unexpected friendlyName %q", test.file, e.FriendlyName())
+				}
+			}
+		}
+		reencoded, err := Modern.AllowWeakAlgorithms().EncodeContents(contents, test.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := DecodeContents(reencoded, test.password)
+		if err != nil {
+			t.Fatalf("%s: re-encoded: %v", test.file, err)
+		}
+		if !EqualContents(contents, again) {
+			t.Errorf("%s: contents changed by re-encoding", test.file)
+		}
+	}
+}
+
+// TestNSSReexport checks that a file written by Modern and imported by NSS
+// is exported by NSS with the same key and certificate.
+func TestNSSReexport(t *testing.T) {
+	imported, err := os.ReadFile("testdata/nss/imported.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := os.ReadFile("testdata/nss-3.87-reexport.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, cert, err := Decode(imported, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, certificate, err := Decode(exported, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !certificate.Equal(cert) || !privateKey.(*ecdsa.PrivateKey).Equal(key) {
+		t.Error("NSS exported another key or certificate than it imported")
+	}
+}
+
+// makeNSSPFX returns a PFX of key and cert encoded with enc, but keyed
+// with the BMPString of password without its NUL terminator if omitNUL is
+// set, the convention AllowUnterminatedPasswords accepts.  The files are
+// built by this package, not by NSS; see TestNSSFixtures for those.
+func makeNSSPFX(t *testing.T, enc *Encoder, key *ecdsa.PrivateKey, cert *x509.Certificate, password string, omitNUL bool) []byte {
+	t.Helper()
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if omitNUL {
+		encodedPassword = encodedPassword[:len(encodedPassword)-2]
+	}
+
+	certBags, localKeyIdAttr, err := Modern.makeChainBags(cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyBag safeBag
+	keyBag.Id = oidPKCS8ShroundedKeyBag
+	keyBag.Value.Class = 2
+	keyBag.Value.Tag = 0
+	keyBag.Value.IsCompound = true
+	if keyBag.Value.Bytes, err = enc.encodePkcs8ShroudedKeyBag(key, encodedPassword); err != nil {
+		t.Fatal(err)
+	}
+	keyBag.Attributes = append(keyBag.Attributes, localKeyIdAttr)
+
+	authenticatedSafe, err := enc.layoutSafeContents(certBags, keyBag, encodedPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := enc.marshalPFX(authenticatedSafe, encodedPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pfxData
+}
+
+func TestNSSInterop(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	// PBES2 keys mixed with legacy PBE certificates within one file.
+	mixed := *Modern
+	mixed.certAlgorithm = oidPBEWithSHAAnd3KeyTripleDESCBC
+	mixed.allowWeak = true
+
+	encoders := []struct {
+		name string
+		enc  *Encoder
+	}{
+		{"Modern", Modern},
+		{"LegacyRC2", LegacyRC2.AllowWeakAlgorithms()},
+		{"LegacyDES", LegacyDES.AllowWeakAlgorithms()},
+		{"mixed", &mixed},
+	}
+	dec := DefaultDecoder.AllowUnterminatedPasswords()
+	for _, e := range encoders {
+		for _, password := range []string{"", "password", "pässwörd"} {
+			for _, omitNUL := range []bool{false, true} {
+				pfxData := makeNSSPFX(t, e.enc, key, cert, password, omitNUL)
+
+				// Only the empty password is retried
+				// without its NUL terminator by default.
+				if _, _, err := Decode(pfxData, password); (err == nil) != (!omitNUL || password == "") {
+					t.Errorf("%s, %q, omitNUL %v: DefaultDecoder returned %v", e.name, password, omitNUL, err)
+				}
+
+				privateKey, certificate, err := dec.Decode(pfxData, password)
+				if err != nil {
+					t.Errorf("%s, %q, omitNUL %v: %v", e.name, password, omitNUL, err)
+					continue
+				}
+				if !certificate.Equal(cert) || MatchKeyToCert(privateKey, certificate) != nil {
+					t.Errorf("%s, %q, omitNUL %v: unexpected key or certificate", e.name, password, omitNUL)
+				}
+
+				// Re-encoding writes the standard convention,
+				// which decodes in turn.
+				contents, err := dec.DecodeContents(pfxData, password)
+				if err != nil {
+					t.Fatalf("%s, %q, omitNUL %v: %v", e.name, password, omitNUL, err)
+				}
+				reencoded, err := e.enc.EncodeContents(contents, password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				again, err := DecodeContents(reencoded, password)
+				if err != nil {
+					t.Fatalf("%s, %q, omitNUL %v: re-encoded: %v", e.name, password, omitNUL, err)
+				}
+				if !EqualContents(contents, again) {
+					t.Errorf("%s, %q, omitNUL %v: contents changed by re-encoding", e.name, password, omitNUL)
+				}
+
+				if _, _, err := dec.Decode(pfxData, password+"x"); err != ErrIncorrectPassword {
+					t.Errorf("%s, %q, omitNUL %v: expected ErrIncorrectPassword for a wrong password, got %v", e.name, password, omitNUL, err)
+				}
+			}
+		}
+	}
+}
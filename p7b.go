@@ -0,0 +1,102 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+var oidSignedDataContentType = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 7, 2})
+
+// DecodeP7B returns the certificates of a DER-encoded "certs-only" PKCS#7
+// SignedData, the format of .p7b files and of "openssl crl2pkcs7 -nocrl", as
+// certificate bag entries which can be stored in a SafeContents and encoded
+// with Encoder.EncodeContents.  The signers and CRLs of the SignedData, if
+// any, are ignored.  PEM-encoded files must be decoded with encoding/pem
+// first; their blocks are of type "PKCS7".
+func DecodeP7B(p7bData []byte) ([]Entry, error) {
+	var ci contentInfo
+	if err := unmarshal(p7bData, &ci); err != nil {
+		return nil, errors.New("pkcs12: error decoding PKCS#7 content info: " + err.Error())
+	}
+	if !ci.ContentType.Equal(oidSignedDataContentType) {
+		return nil, NotImplementedError("only PKCS#7 signed data is supported")
+	}
+
+	// SignedData is parsed element by element since encoding/asn1 cannot
+	// match the optional IMPLICIT [0] certificates to a RawValue field.
+	var signedData asn1.RawValue
+	if err := unmarshal(ci.Content.Bytes, &signedData); err != nil {
+		return nil, errors.New("pkcs12: error decoding PKCS#7 signed data: " + err.Error())
+	}
+	rest := signedData.Bytes
+	var version int
+	var digestAlgorithms, encapContentInfo asn1.RawValue
+	for _, field := range []interface{}{&version, &digestAlgorithms, &encapContentInfo} {
+		var err error
+		if rest, err = asn1.Unmarshal(rest, field); err != nil {
+			return nil, errors.New("pkcs12: error decoding PKCS#7 signed data: " + err.Error())
+		}
+	}
+
+	var entries []Entry
+	for len(rest) != 0 {
+		var element asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &element); err != nil {
+			return nil, errors.New("pkcs12: error decoding PKCS#7 signed data: " + err.Error())
+		}
+		if element.Class != asn1.ClassContextSpecific || element.Tag != 0 {
+			continue
+		}
+		certs, err := x509.ParseCertificates(element.Bytes)
+		if err != nil {
+			return nil, errors.New("pkcs12: error parsing PKCS#7 certificates: " + err.Error())
+		}
+		for _, cert := range certs {
+			entries = append(entries, Entry{BagType: CertBag, Certificate: cert})
+		}
+	}
+
+	return entries, nil
+}
+
+// EncodeP7B returns certs as a DER-encoded "certs-only" PKCS#7 SignedData,
+// without signers, which can be saved as a .p7b file.
+func EncodeP7B(certs []*x509.Certificate) ([]byte, error) {
+	var certificates []byte
+	for _, cert := range certs {
+		certificates = append(certificates, cert.Raw...)
+	}
+
+	emptySet := asn1.RawValue{Tag: asn1.TagSet, IsCompound: true}
+	signedData := struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      contentInfo
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      contentInfo{ContentType: oidDataContentType},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates},
+		SignerInfos:      emptySet,
+	}
+
+	var ci contentInfo
+	ci.ContentType = oidSignedDataContentType
+	ci.Content.Class = 2
+	ci.Content.Tag = 0
+	ci.Content.IsCompound = true
+	var err error
+	if ci.Content.Bytes, err = asn1.Marshal(signedData); err != nil {
+		return nil, errors.New("pkcs12: error encoding PKCS#7 signed data: " + err.Error())
+	}
+	return asn1.Marshal(ci)
+}
@@ -0,0 +1,82 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeP7B(t *testing.T) {
+	// openssl-chain.p7b holds the certificates of openssl-legacy-chain.p12,
+	// converted with "openssl crl2pkcs7 -nocrl".
+	p7bData, err := os.ReadFile(filepath.Join("testdata", "openssl-chain.p7b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := DecodeP7B(p7bData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfxData, err := os.ReadFile(filepath.Join("testdata", "openssl-legacy-chain.p12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cert, caCerts, err := DecodeChain(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]*x509.Certificate{cert}, caCerts...)
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d certificates, got %d", len(expected), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.BagType != CertBag || !entry.Certificate.Equal(expected[i]) {
+			t.Errorf("entry %d: expected %s", i, expected[i].Subject)
+		}
+	}
+
+	// The output of EncodeP7B is identical to that of OpenSSL.
+	reencoded, err := EncodeP7B(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reencoded, p7bData) {
+		t.Error("EncodeP7B output differs from OpenSSL's")
+	}
+
+	if _, err := DecodeP7B(pfxData); err == nil {
+		t.Error("expected an error decoding a PFX as PKCS#7")
+	}
+}
+
+func TestP7BRoundTrip(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	_, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	for _, certs := range [][]*x509.Certificate{nil, {cert, caCert}} {
+		p7bData, err := EncodeP7B(certs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := DecodeP7B(p7bData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(certs) {
+			t.Fatalf("expected %d certificates, got %d", len(certs), len(entries))
+		}
+		for i := range certs {
+			if !entries[i].Certificate.Equal(certs[i]) {
+				t.Errorf("certificate %d differs", i)
+			}
+		}
+	}
+}
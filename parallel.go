@@ -0,0 +1,107 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"runtime"
+	"sync"
+)
+
+// WithParallelism creates a new Decoder identical to dec except that it
+// derives the keys of the MAC and of the encrypted SafeContents using at
+// most n goroutines.  Every derivation pays the full iteration count of the
+// file, so running them concurrently roughly halves the time it takes to
+// decode a typical file.  If n is 1, keys are derived one after the other;
+// if n is 0 or less, which is the default, up to GOMAXPROCS goroutines are
+// used.
+func (dec Decoder) WithParallelism(n int) *Decoder {
+	dec.parallelism = n
+	return &dec
+}
+
+// A decryption is the result of decrypting an encrypted SafeContents ahead
+// of time.
+type decryption struct {
+	info *encryptedContentInfo
+	data []byte
+	err  error
+}
+
+// verifyMacAndDecrypt verifies the MAC of pfx with password, returning the
+// same error as verifyMac.  Meanwhile, the encrypted SafeContents of
+// authenticatedSafe that dec would decrypt are decrypted with password,
+// which is only safe to use once the MAC has been verified: the result is
+// indexed like authenticatedSafe, with nil for content that was not
+// decrypted, or is nil as a whole if nothing was decrypted or the MAC did
+// not verify, in which case the plaintext is wiped.
+func (dec *Decoder) verifyMacAndDecrypt(pfx *pfxPdu, authenticatedSafe []contentInfo, password []byte) ([]*decryption, error) {
+	verify := func() error {
+		return verifyMac(dec.kdfContext(), &pfx.MacData, pfx.AuthSafe.Content.Bytes, password)
+	}
+
+	n := dec.parallelism
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	if n == 1 {
+		return nil, verify()
+	}
+
+	var decrypted []*decryption
+	for i := range authenticatedSafe {
+		if !authenticatedSafe[i].ContentType.Equal(oidEncryptedDataContentType) {
+			continue
+		}
+		info, err := dec.encryptedContentInfo(&authenticatedSafe[i])
+		if err != nil {
+			continue
+		}
+		if decrypted == nil {
+			decrypted = make([]*decryption, len(authenticatedSafe))
+		}
+		decrypted[i] = &decryption{info: info}
+	}
+	if decrypted == nil {
+		return nil, verify()
+	}
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, n)
+	run := func(f func()) {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func() {
+			defer wg.Done()
+			f()
+			<-limit
+		}()
+	}
+
+	var err error
+	run(func() { err = verify() })
+	for _, d := range decrypted {
+		if d := d; d != nil {
+			run(func() { d.data, d.err = pbDecrypt(dec.kdfContext(), d.info, password) })
+		}
+	}
+	wg.Wait()
+	if err != nil {
+		// The plaintext of a file which failed authentication is
+		// never used.
+		wipeDecrypted(decrypted)
+		return nil, err
+	}
+	return decrypted, nil
+}
+
+// wipeDecrypted wipes the content decrypted ahead of time.
+func wipeDecrypted(decrypted []*decryption) {
+	for _, d := range decrypted {
+		if d != nil {
+			wipe(d.data)
+		}
+	}
+}
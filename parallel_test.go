@@ -0,0 +1,87 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithParallelism(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 1, 2, 8} {
+		dec := DefaultDecoder.WithParallelism(n)
+		contents, err := dec.DecodeContents(pfxData, "password")
+		if err != nil {
+			t.Errorf("parallelism %d: %v", n, err)
+			continue
+		}
+		if len(contents) != 2 || contents[0].Entries[0].Certificate == nil || contents[1].Entries[0].PrivateKey == nil {
+			t.Errorf("parallelism %d: unexpected contents %+v", n, contents)
+		}
+		if _, _, err := dec.Decode(pfxData, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("parallelism %d: expected ErrIncorrectPassword, got %v", n, err)
+		}
+	}
+}
+
+func TestWithParallelismPasswordFunc(t *testing.T) {
+	pfxData := makeMultiPasswordPFX(t)
+	passwords := map[string]string{
+		PasswordHintMAC:          "mac",
+		PasswordHintSafeContents: "certs",
+		PasswordHintPrivateKey:   "key",
+	}
+
+	for _, n := range []int{1, 4} {
+		var hints []string
+		privateKey, cert, _, err := DefaultDecoder.WithParallelism(n).DecodeChainFunc(pfxData, func(hint string) ([]byte, error) {
+			hints = append(hints, hint)
+			return []byte(passwords[hint]), nil
+		})
+		if err != nil {
+			t.Errorf("parallelism %d: %v", n, err)
+			continue
+		}
+		if err := MatchKeyToCert(privateKey, cert); err != nil {
+			t.Errorf("parallelism %d: %v", n, err)
+		}
+		expected := []string{PasswordHintMAC, PasswordHintSafeContents, PasswordHintPrivateKey}
+		if !reflect.DeepEqual(hints, expected) {
+			t.Errorf("parallelism %d: expected hints %q, got %q", n, expected, hints)
+		}
+	}
+}
+
+func TestVerifyMacAndDecryptFailedMAC(t *testing.T) {
+	pfx, err := parsePFX(makeMultiPasswordPFX(t), DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticatedSafe, err := parseAuthenticatedSafe(pfx.AuthSafe.Content.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The SafeContents password decrypts the certificates but does not
+	// verify the MAC, so nothing decrypted may be returned.
+	certsPassword, _ := bmpString("certs")
+	decrypted, err := DefaultDecoder.WithParallelism(2).verifyMacAndDecrypt(pfx, authenticatedSafe, certsPassword)
+	if err != ErrIncorrectPassword || decrypted != nil {
+		t.Errorf("expected nothing and ErrIncorrectPassword, got %v and %v", decrypted, err)
+	}
+
+	data := []byte("plaintext")
+	wipeDecrypted([]*decryption{nil, {data: data}})
+	if string(data) != "\x00\x00\x00\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("not wiped: %q", data)
+	}
+}
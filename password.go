@@ -0,0 +1,105 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto"
+	"crypto/x509"
+)
+
+// A PasswordFunc returns the UTF-8 encoded password for the part of a
+// PKCS#12 file described by hint, one of the PasswordHint constants.  It
+// lets the *Func decoding methods ask a prompt, keychain or secret agent
+// for a password only when one is needed.  The returned slice is not
+// retained and can be wiped once the decoding method has returned.  An
+// error returned by the PasswordFunc aborts decoding and is returned as is.
+type PasswordFunc func(hint string) ([]byte, error)
+
+// The hints passed to a PasswordFunc.
+const (
+	// PasswordHintMAC asks for the password of the file as a whole, which
+	// verifies the MAC and is tried first for everything else.
+	PasswordHintMAC = "MAC"
+	// PasswordHintSafeContents asks for the password of an encrypted
+	// SafeContents which cannot be decrypted with the MAC password.
+	PasswordHintSafeContents = "SafeContents"
+	// PasswordHintPrivateKey asks for the password of a PKCS#8 shrouded key
+	// bag which cannot be decrypted with the MAC password.
+	PasswordHintPrivateKey = "private key"
+)
+
+// DecodeChainFunc is like DecodeChain, but obtains the passwords from
+// password: once for the MAC, and again for each encrypted SafeContents or
+// private key the MAC password does not decrypt.  Each of those is tried
+// with one password only; if it is wrong too, ErrIncorrectPassword is
+// returned.
+func (dec *Decoder) DecodeChainFunc(pfxData []byte, password PasswordFunc) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	dec, encodedPassword, err := dec.withPasswordFunc(password)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeChain(pfxData, encodedPassword)
+}
+
+// DecodeChainFunc decodes pfxData using the DefaultDecoder, obtaining the
+// passwords from password.  See Decoder.DecodeChainFunc.
+func DecodeChainFunc(pfxData []byte, password PasswordFunc) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	return DefaultDecoder.DecodeChainFunc(pfxData, password)
+}
+
+// DecodeContentsFunc is like DecodeContents, but obtains the passwords from
+// password the way DecodeChainFunc does.
+func (dec *Decoder) DecodeContentsFunc(pfxData []byte, password PasswordFunc) ([]SafeContents, error) {
+	dec, encodedPassword, err := dec.withPasswordFunc(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeContents(pfxData, encodedPassword)
+}
+
+// DecodeContentsFunc decodes every safe bag of pfxData using the
+// DefaultDecoder, obtaining the passwords from password.  See
+// Decoder.DecodeContentsFunc.
+func DecodeContentsFunc(pfxData []byte, password PasswordFunc) ([]SafeContents, error) {
+	return DefaultDecoder.DecodeContentsFunc(pfxData, password)
+}
+
+// withPasswordFunc returns a copy of dec which asks password for the
+// passwords that differ from the one of the MAC, together with the encoded
+// MAC password.  The caller must wipe the latter.
+func (dec Decoder) withPasswordFunc(password PasswordFunc) (*Decoder, []byte, error) {
+	dec.passwordFunc = password
+	encodedPassword, err := dec.askPassword(PasswordHintMAC)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &dec, encodedPassword, nil
+}
+
+// askPassword returns the encoded password that dec.passwordFunc returns
+// for hint.
+func (dec *Decoder) askPassword(hint string) ([]byte, error) {
+	password, err := dec.passwordFunc(hint)
+	if err != nil {
+		return nil, err
+	}
+	return bmpStringBytes(password)
+}
+
+// retryPassword calls decrypt with the password that dec.passwordFunc
+// returns for hint, after decryption with the MAC password has failed.
+func (dec *Decoder) retryPassword(hint string, decrypt func(password []byte) error) error {
+	password, err := dec.askPassword(hint)
+	if err != nil {
+		return err
+	}
+	defer wipe(password)
+	return decrypt(password)
+}
@@ -0,0 +1,121 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// makeMultiPasswordPFX returns a PFX whose MAC, certificate SafeContents and
+// private key are protected by the passwords "mac", "certs" and "key".
+func makeMultiPasswordPFX(t *testing.T) []byte {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	macPassword, _ := bmpString("mac")
+	certsPassword, _ := bmpString("certs")
+	keyPassword, _ := bmpString("key")
+
+	certBags, localKeyIdAttr, err := Modern.makeChainBags(cert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keyBag safeBag
+	keyBag.Id = oidPKCS8ShroundedKeyBag
+	keyBag.Value.Class = 2
+	keyBag.Value.Tag = 0
+	keyBag.Value.IsCompound = true
+	if keyBag.Value.Bytes, err = Modern.encodePkcs8ShroudedKeyBag(key, keyPassword); err != nil {
+		t.Fatal(err)
+	}
+	keyBag.Attributes = append(keyBag.Attributes, localKeyIdAttr)
+
+	certsCI, err := Modern.makeSafeContents(certBags, Modern.certAlgorithm, certsPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyCI, err := Modern.makeSafeContents([]safeBag{keyBag}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := Modern.marshalPFX([]contentInfo{certsCI, keyCI}, macPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pfxData
+}
+
+func TestDecodeChainFunc(t *testing.T) {
+	pfxData := makeMultiPasswordPFX(t)
+	passwords := map[string]string{
+		PasswordHintMAC:          "mac",
+		PasswordHintSafeContents: "certs",
+		PasswordHintPrivateKey:   "key",
+	}
+
+	var hints []string
+	ask := func(hint string) ([]byte, error) {
+		hints = append(hints, hint)
+		return []byte(passwords[hint]), nil
+	}
+	privateKey, cert, _, err := DecodeChainFunc(pfxData, ask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := MatchKeyToCert(privateKey, cert); err != nil {
+		t.Error(err)
+	}
+	expected := []string{PasswordHintMAC, PasswordHintSafeContents, PasswordHintPrivateKey}
+	if !reflect.DeepEqual(hints, expected) {
+		t.Errorf("expected hints %q, got %q", expected, hints)
+	}
+
+	hints = nil
+	contents, err := DecodeContentsFunc(pfxData, ask)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 2 || contents[1].Entries[0].PrivateKey == nil {
+		t.Errorf("unexpected contents %+v", contents)
+	}
+	if !reflect.DeepEqual(hints, expected) {
+		t.Errorf("expected hints %q, got %q", expected, hints)
+	}
+
+	passwords[PasswordHintPrivateKey] = "wrong"
+	if _, _, _, err := DecodeChainFunc(pfxData, ask); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+
+	cancelled := errors.New("cancelled")
+	if _, _, _, err := DecodeChainFunc(pfxData, func(hint string) ([]byte, error) {
+		if hint == PasswordHintSafeContents {
+			return nil, cancelled
+		}
+		return []byte(passwords[hint]), nil
+	}); err != cancelled {
+		t.Errorf("expected the error of the PasswordFunc, got %v", err)
+	}
+}
+
+func TestDecodeChainFuncSinglePassword(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	if _, _, _, err := DecodeChainFunc(pfxData, func(hint string) ([]byte, error) {
+		calls++
+		return []byte("password"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the PasswordFunc to be called once, got %d calls", calls)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pbe
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/hex"
+	"testing"
+)
+
+// deriveTests are test vectors for the PKCS#12 KDF.  The SHA-1 ones are
+// the vectors published with OpenSSL and Bouncy Castle; the others were
+// computed with the PKCS12KDF of OpenSSL 3.  The lengths exceed the
+// output of the hash, so that several blocks are derived.
+var deriveTests = []struct {
+	hash       crypto.Hash
+	purpose    Purpose
+	password   string
+	salt       string
+	iterations int
+	key        string
+}{
+	{crypto.SHA1, KeyMaterial, "smeg", "0a58cf64530d823f", 1, "8aaae6297b6cb04642ab5b077851284eb7128f1a2a7fbca3"},
+	{crypto.SHA1, IV, "smeg", "0a58cf64530d823f", 1, "79993dfe048d3b76"},
+	{crypto.SHA1, KeyMaterial, "smeg", "642b99ab44fb4b1f", 1, "f3a95fec48d7711e985cfe67908c5ab79fa3d7c5caa5d966"},
+	{crypto.SHA1, IV, "smeg", "642b99ab44fb4b1f", 1, "c0a38d64a79bea1d"},
+	{crypto.SHA1, MACKey, "smeg", "3d83c0e4546ac140", 1, "8d967d88f6caa9d714800ab3d48051d63f73a312"},
+	{crypto.SHA1, KeyMaterial, "queeg", "05dec959acff72f7", 1000, "ed2034e36328830ff09df1e1a07dd357185dac0d4f9eb3d4"},
+	{crypto.SHA1, IV, "queeg", "05dec959acff72f7", 1000, "11dedad7758d4860"},
+	{crypto.SHA1, KeyMaterial, "queeg", "1682c0fc5b3f7ec5", 1000, "483dd6e919d7de2e8e648ba8f862f3fbfbdc2bcb2c02957f"},
+	{crypto.SHA1, IV, "queeg", "1682c0fc5b3f7ec5", 1000, "9d461d1b00355c50"},
+	{crypto.SHA1, MACKey, "queeg", "263216fcc2fab31c", 1000, "5ec4c7a80df652294c3925b6489a7ab857c83476"},
+	{crypto.SHA224, KeyMaterial, "queeg", "05dec959acff72f7", 1000, "62bbe4fa34fa5f16f203d66e35cf5842bb8bca6aea4393e7c3e28d41f14926d5a0c5820500cc337f"},
+	{crypto.SHA224, IV, "queeg", "05dec959acff72f7", 1000, "f7d225338f4b563226a830088c2a2b9c112ce78d325f0327da0a1a3216c391867d4990b8f436da30"},
+	{crypto.SHA224, MACKey, "queeg", "05dec959acff72f7", 1000, "2dcff0c7567bc5b755f0e8f13f537fab619588dc48654ed03939fe9ac5cf485ce1db4cdc542b7499"},
+	{crypto.SHA256, KeyMaterial, "queeg", "05dec959acff72f7", 1000, "74903b2a07c2fc6bff672a1a8c30583ddc4f7c1a68930ac40917dcfe6baa5ae3d7867c4ca9a8fb54"},
+	{crypto.SHA256, IV, "queeg", "05dec959acff72f7", 1000, "b0bb0cf2f9fcc24242e3ba481dcfbfcd1deef3cf4315d9f01bed9ee741e9473e16b08057882e2eca"},
+	{crypto.SHA256, MACKey, "queeg", "05dec959acff72f7", 1000, "64bd552bb4ecec8224b888d4fa1dc49c6ff894bfc59b10402d83ad46c0d8da4b3939ebc25b29da4f"},
+	{crypto.SHA384, KeyMaterial, "queeg", "05dec959acff72f7", 1000, "1e6c40d076cdd4bae3eff3622192695785ee8dbbfbac0176a18df0eb93ca2dc28a412baad4f416fb"},
+	{crypto.SHA384, IV, "queeg", "05dec959acff72f7", 1000, "5e7a95bf7c187f4572e06a94f15695599a466f881c62605ec92c7be4b48e055cfddce10cd4f093a4"},
+	{crypto.SHA384, MACKey, "queeg", "05dec959acff72f7", 1000, "31663c7b6c0584ba23eb34bf4850b5953bcc64edd2279d13a0176afd9e71aa3593d198a8e2f4b18d"},
+	{crypto.SHA512, KeyMaterial, "queeg", "05dec959acff72f7", 1000, "48a60029f1245327351b185bfabd92ed3a2115bcd2366d65fb3540d88367cf077ef7795700d6ef83"},
+	{crypto.SHA512, IV, "queeg", "05dec959acff72f7", 1000, "5408398bcd3184327daf8ef80b748ab7925a134aefbe79912085b538208bc5475a46d683dfcc1e92"},
+	{crypto.SHA512, MACKey, "queeg", "05dec959acff72f7", 1000, "b2587204c6cbda975c143740c4d3309850ac282bf83fb0e44f0fb90f2f76ae37ed6f81cfe5b0709a"},
+	{crypto.SHA512_224, KeyMaterial, "queeg", "05dec959acff72f7", 1000, "c3d1763eada18f0692d061a710be097cefa9773d8f3104d4f141df97520ccfc4a188711ceb8bf5a6"},
+	{crypto.SHA512_224, IV, "queeg", "05dec959acff72f7", 1000, "5c72ecfea6e15cfa725586ffd2e1dc44ca56e60e9f50151aad9a56296458e2e258794060ec7a8b8b"},
+	{crypto.SHA512_224, MACKey, "queeg", "05dec959acff72f7", 1000, "7c76d210a0c5b3197af4535b768873efea3e92d53d77f76a691d0cdb831890fb76f4cccfa941b447"},
+	{crypto.SHA512_256, KeyMaterial, "queeg", "05dec959acff72f7", 1000, "7990bfd62573d800bc49548d1c2a2eda4c2b8119b5565a010f7968b45159126f3792aff6a43ce247"},
+	{crypto.SHA512_256, IV, "queeg", "05dec959acff72f7", 1000, "86b2e633f3a20dc85fcf21561c210c5c855f1d6917af4083f8de5eaa66031f21a020c07750b51b40"},
+	{crypto.SHA512_256, MACKey, "queeg", "05dec959acff72f7", 1000, "08b135f7afca0f6168d9e864724800233ca83430bdc7d9ee7fb6a19958066d1d9c83c6ef805667e8"},
+}
+
+func TestDeriveVectors(t *testing.T) {
+	for _, test := range deriveTests {
+		password, err := EncodePassword(test.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		salt, _ := hex.DecodeString(test.salt)
+		expected, _ := hex.DecodeString(test.key)
+		key, err := Derive(context.Background(), test.hash.New, test.purpose, password, salt, test.iterations, len(expected))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, expected) {
+			t.Errorf("%v, ID %d, %q, salt %s, %d iterations: expected %x, got %x", test.hash, test.purpose, test.password, test.salt, test.iterations, expected, key)
+		}
+	}
+}
+
+func TestThatPBKDFWorksCorrectlyForLongKeys(t *testing.T) {
+	salt := []byte("\xff\xff\xff\xff\xff\xff\xff\xff")
+	password, _ := EncodePassword("sesame")
+	key, err := Derive(context.Background(), sha1.New, KeyMaterial, password, salt, 2048, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []byte("\x7c\xd9\xfd\x3e\x2b\x3b\xe7\x69\x1a\x44\xe3\xbe\xf0\xf9\xea\x0f\xb9\xb8\x97\xd4\xe3\x25\xd9\xd1"); bytes.Compare(key, expected) != 0 {
+		t.Fatalf("expected key '%x', but found '%x'", expected, key)
+	}
+}
+
+func TestThatPBKDFHandlesLeadingZeros(t *testing.T) {
+	// This test triggers a case where I_j (in step 6C) ends up with leading zero
+	// byte, meaning that len(Ijb) < v (leading zeros get stripped by big.Int).
+	// This was previously causing bug whereby certain inputs would break the
+	// derivation and produce the wrong output.
+	key, err := Derive(context.Background(), sha1.New, KeyMaterial, []byte("\x00\x00"), []byte("\xf3\x7e\x05\xb5\x18\x32\x4b\x4b"), 2048, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte("\x00\xf7\x59\xff\x47\xd1\x4d\xd0\x36\x65\xd5\x94\x3c\xb3\xc4\xa3\x9a\x25\x55\xc0\x2a\xed\x66\xe1")
+	if bytes.Compare(key, expected) != 0 {
+		t.Fatalf("expected key '%x', but found '%x'", expected, key)
+	}
+}
+
+func TestDeriveCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Derive(ctx, sha1.New, KeyMaterial, []byte{0, 0}, nil, 100*checkInterval, 24); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pbe implements the password-based encryption of PKCS#12 on its
+// own: the key derivation function of
+// https://tools.ietf.org/html/rfc7292#appendix-B and the
+// pbeWithSHAAnd3-KeyTripleDES-CBC and pbeWithSHAAnd40BitRC2-CBC schemes of
+// appendix C, which the pkcs12 package is built on.  It is meant for data
+// encrypted with these schemes outside of a PKCS#12 file, such as a
+// standalone EncryptedData or a proprietary container.
+//
+// PBES2, which PKCS#12 files also use, is PBKDF2 with an ordinary block
+// cipher and is not covered here.
+package pbe
+
+import (
+	"context"
+	"crypto"
+	"crypto/cipher"
+	"crypto/des"
+	_ "crypto/sha1" // for crypto.SHA1
+	"crypto/subtle"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"unicode/utf16"
+
+	"github.com/nevissecurity/go-pkcs12/internal/rc2"
+)
+
+var (
+	// OIDSHAAnd3KeyTripleDESCBC identifies pbeWithSHAAnd3-KeyTripleDES-CBC.
+	OIDSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 3})
+	// OIDSHAAnd40BitRC2CBC identifies pbeWithSHAAnd40BitRC2-CBC.
+	OIDSHAAnd40BitRC2CBC = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 1, 6})
+)
+
+var (
+	// ErrDecryption is returned by Decrypt when the padding of the
+	// decrypted data is wrong, which usually means the password is.
+	ErrDecryption = errors.New("pbe: decryption error, incorrect padding")
+
+	// ErrUnsupportedAlgorithm is returned for an AlgorithmIdentifier
+	// which is not one of the schemes of this package.
+	ErrUnsupportedAlgorithm = errors.New("pbe: unsupported algorithm")
+)
+
+// Params are the parameters of the PKCS#12 PBE schemes, the pkcs-12PbeParams
+// of https://tools.ietf.org/html/rfc7292#appendix-C.  They are marshalled
+// with encoding/asn1 into the Parameters of an AlgorithmIdentifier.
+type Params struct {
+	Salt       []byte
+	Iterations int
+}
+
+// A scheme describes a PKCS#12 PBE scheme.
+type scheme struct {
+	keyLen int
+	create func(key []byte) (cipher.Block, error)
+}
+
+func schemeFor(algorithm asn1.ObjectIdentifier) (*scheme, error) {
+	switch {
+	case algorithm.Equal(OIDSHAAnd3KeyTripleDESCBC):
+		return &scheme{24, des.NewTripleDESCipher}, nil
+	case algorithm.Equal(OIDSHAAnd40BitRC2CBC):
+		return &scheme{5, func(key []byte) (cipher.Block, error) {
+			return rc2.New(key, len(key)*8)
+		}}, nil
+	}
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// EncodePassword returns password as the PKCS#12 KDF expects it: a BMPString
+// with a zero terminator, see https://tools.ietf.org/html/rfc7292#appendix-B.1.
+// Characters outside the Basic Multilingual Plane cannot be encoded.
+func EncodePassword(password string) ([]byte, error) {
+	ret := make([]byte, 0, 2*len(password)+2)
+
+	for _, r := range password {
+		if t, _ := utf16.EncodeRune(r); t != 0xfffd {
+			return nil, errors.New("pbe: password contains characters that cannot be encoded in UCS-2")
+		}
+		ret = append(ret, byte(r/256), byte(r%256))
+	}
+
+	return append(ret, 0, 0), nil
+}
+
+// NewCipher returns the block cipher and IV of a PKCS#12 PBE scheme, keyed
+// from password, which must be encoded as by EncodePassword, and the salt
+// and iteration count in the Params of algorithm.  The ciphers are used in
+// CBC mode.  NewCipher returns ErrUnsupportedAlgorithm for other
+// algorithms, and stops with the error of ctx if ctx is cancelled before
+// the keys are derived.
+func NewCipher(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte) (block cipher.Block, iv []byte, err error) {
+	return NewCipherFunc(ctx, algorithm, password, derive)
+}
+
+// A DeriveFunc derives keys like Derive does, but takes the hash function
+// as a crypto.Hash, so that it can hand the derivation to an
+// implementation other than the one of this package.
+type DeriveFunc func(ctx context.Context, h crypto.Hash, purpose Purpose, password, salt []byte, iterations, size int) ([]byte, error)
+
+// derive is the DeriveFunc of Derive.
+func derive(ctx context.Context, h crypto.Hash, purpose Purpose, password, salt []byte, iterations, size int) ([]byte, error) {
+	return Derive(ctx, h.New, purpose, password, salt, iterations, size)
+}
+
+// NewCipherFunc is like NewCipher, but derives the key and IV with
+// derive, which is given crypto.SHA1 as the hash function, instead of
+// Derive.
+func NewCipherFunc(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte, derive DeriveFunc) (block cipher.Block, iv []byte, err error) {
+	scheme, err := schemeFor(algorithm.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var params Params
+	rest, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		return nil, nil, errors.New("pbe: error decoding parameters: " + err.Error())
+	}
+	if len(rest) != 0 {
+		return nil, nil, errors.New("pbe: trailing data after parameters")
+	}
+
+	key, err := derive(ctx, crypto.SHA1, KeyMaterial, password, params.Salt, params.Iterations, scheme.keyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wipe(key)
+	if iv, err = derive(ctx, crypto.SHA1, IV, password, params.Salt, params.Iterations, 8); err != nil {
+		return nil, nil, err
+	}
+
+	if block, err = scheme.create(key); err != nil {
+		return nil, nil, err
+	}
+	return block, iv, nil
+}
+
+// Encrypt encrypts plaintext with the PKCS#12 PBE scheme of algorithm, as
+// prepared by NewCipher, after adding the PKCS#7 padding.
+func Encrypt(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password, plaintext []byte) ([]byte, error) {
+	block, iv, err := NewCipher(ctx, algorithm, password)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := block.BlockSize()
+	psLen := blockSize - len(plaintext)%blockSize
+	encrypted := make([]byte, len(plaintext)+psLen)
+	copy(encrypted, plaintext)
+	for i := len(plaintext); i < len(encrypted); i++ {
+		encrypted[i] = byte(psLen)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+	return encrypted, nil
+}
+
+// Decrypt decrypts ciphertext with the PKCS#12 PBE scheme of algorithm, as
+// prepared by NewCipher, and removes the PKCS#7 padding.  A wrong padding
+// is reported as ErrDecryption; it is checked in constant time.
+func Decrypt(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password, ciphertext []byte) ([]byte, error) {
+	block, iv, err := NewCipher(ctx, algorithm, password)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := block.BlockSize()
+	if len(ciphertext) == 0 || len(ciphertext)%blockSize != 0 {
+		return nil, errors.New("pbe: ciphertext is not a non-zero multiple of the block size")
+	}
+	decrypted := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, ciphertext)
+
+	psLen := int(decrypted[len(decrypted)-1])
+	good := subtle.ConstantTimeLessOrEq(1, psLen) & subtle.ConstantTimeLessOrEq(psLen, blockSize)
+	for i := 1; i <= blockSize; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i, psLen)
+		matches := subtle.ConstantTimeByteEq(decrypted[len(decrypted)-i], byte(psLen))
+		good &= subtle.ConstantTimeSelect(inPadding, matches, 1)
+	}
+	if good != 1 {
+		wipe(decrypted)
+		return nil, ErrDecryption
+	}
+	return decrypted[:len(decrypted)-psLen], nil
+}
+
+// wipe overwrites b with zeros.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
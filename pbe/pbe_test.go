@@ -0,0 +1,90 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pbe
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+func makeAlgorithm(t *testing.T, oid asn1.ObjectIdentifier) pkix.AlgorithmIdentifier {
+	t.Helper()
+	params, err := asn1.Marshal(Params{Salt: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Iterations: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.RawValue{FullBytes: params}}
+}
+
+func TestNewCipher(t *testing.T) {
+	password, _ := EncodePassword("Sesame open")
+
+	block, iv, err := NewCipher(context.Background(), makeAlgorithm(t, OIDSHAAnd3KeyTripleDESCBC), password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	expected := []byte{185, 73, 135, 249, 137, 1, 122, 247}
+	decrypted := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, ciphertext)
+	if !bytes.Equal(decrypted, expected) {
+		t.Errorf("bad plaintext, got %x but wanted %x", decrypted, expected)
+	}
+
+	if _, _, err := NewCipher(context.Background(), makeAlgorithm(t, asn1.ObjectIdentifier{1, 2, 3}), password); err != ErrUnsupportedAlgorithm {
+		t.Errorf("expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	password, _ := EncodePassword("Sesame open")
+	wrong, _ := EncodePassword("Sesame close")
+
+	for _, oid := range []asn1.ObjectIdentifier{OIDSHAAnd3KeyTripleDESCBC, OIDSHAAnd40BitRC2CBC} {
+		algorithm := makeAlgorithm(t, oid)
+		for _, plaintext := range [][]byte{nil, []byte("open"), []byte("exactly8")} {
+			ciphertext, err := Encrypt(context.Background(), algorithm, password, plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ciphertext) != len(plaintext)/8*8+8 {
+				t.Errorf("%s: unexpected ciphertext length %d for %d bytes", oid, len(ciphertext), len(plaintext))
+			}
+			decrypted, err := Decrypt(context.Background(), algorithm, password, ciphertext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("%s: expected %q, got %q", oid, plaintext, decrypted)
+			}
+		}
+
+		ciphertext, err := Encrypt(context.Background(), algorithm, password, []byte("open"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decrypt(context.Background(), algorithm, wrong, ciphertext); err != ErrDecryption {
+			t.Errorf("%s: expected ErrDecryption with the wrong password, got %v", oid, err)
+		}
+	}
+}
+
+func TestEncodePassword(t *testing.T) {
+	encoded, err := EncodePassword("Beavis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 'B', 0, 'e', 0, 'a', 0, 'v', 0, 'i', 0, 's', 0, 0}; !bytes.Equal(encoded, expected) {
+		t.Errorf("expected %x, got %x", expected, encoded)
+	}
+	if _, err := EncodePassword("\U0001f512"); err == nil {
+		t.Error("expected an error for a character outside the BMP")
+	}
+}
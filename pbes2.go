@@ -0,0 +1,415 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/nevissecurity/go-pkcs12/internal/camellia"
+	"github.com/nevissecurity/go-pkcs12/internal/chacha20poly1305"
+	"github.com/nevissecurity/go-pkcs12/internal/seed"
+)
+
+var (
+	// see https://tools.ietf.org/html/rfc8018#appendix-A
+	oidPBES2  = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 5, 13})
+	oidPBKDF2 = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 5, 12})
+
+	oidHmacWithSHA1       = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 2, 7})
+	oidHmacWithSHA224     = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 2, 8})
+	oidHmacWithSHA256     = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 2, 9})
+	oidHmacWithSHA384     = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 2, 10})
+	oidHmacWithSHA512     = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 2, 11})
+	oidHmacWithSHA512_224 = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 2, 12})
+	oidHmacWithSHA512_256 = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 2, 13})
+
+	oidAES128CBC = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 1, 2})
+	oidAES192CBC = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 1, 22})
+	oidAES256CBC = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 1, 42})
+
+	// see https://tools.ietf.org/html/rfc3657#section-2.1
+	oidCamellia128CBC = asn1.ObjectIdentifier([]int{1, 2, 392, 200011, 61, 1, 1, 1, 2})
+	oidCamellia192CBC = asn1.ObjectIdentifier([]int{1, 2, 392, 200011, 61, 1, 1, 1, 3})
+	oidCamellia256CBC = asn1.ObjectIdentifier([]int{1, 2, 392, 200011, 61, 1, 1, 1, 4})
+
+	// see https://tools.ietf.org/html/rfc4269#section-4, used by NPKI
+	oidSEEDCBC = asn1.ObjectIdentifier([]int{1, 2, 410, 200004, 1, 4})
+
+	// see https://tools.ietf.org/html/rfc5084#section-3.2
+	oidAES128GCM = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 1, 6})
+	oidAES192GCM = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 1, 26})
+	oidAES256GCM = asn1.ObjectIdentifier([]int{2, 16, 840, 1, 101, 3, 4, 1, 46})
+)
+
+// aeadNonceSize is the nonce size an Encoder uses for AES-GCM and
+// ChaCha20-Poly1305, and gcmTagSize the AES-GCM tag size.
+const (
+	aeadNonceSize = 12
+	gcmTagSize    = 16
+)
+
+// prfAlgorithms maps the hash functions an Encoder can use for the PBKDF2
+// PRF to the OIDs of the corresponding HMACs.
+var prfAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:       oidHmacWithSHA1,
+	crypto.SHA224:     oidHmacWithSHA224,
+	crypto.SHA256:     oidHmacWithSHA256,
+	crypto.SHA384:     oidHmacWithSHA384,
+	crypto.SHA512:     oidHmacWithSHA512,
+	crypto.SHA512_224: oidHmacWithSHA512_224,
+	crypto.SHA512_256: oidHmacWithSHA512_256,
+}
+
+type pbes2Params struct {
+	Kdf              pkix.AlgorithmIdentifier
+	EncryptionScheme pkix.AlgorithmIdentifier
+}
+
+// gcmParams are the parameters of AES-GCM, see
+// https://tools.ietf.org/html/rfc5084#section-3.2
+type gcmParams struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	Prf        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// prfFor returns the hash function underlying the PBKDF2 pseudorandom
+// function identified by algorithm.  An absent PRF defaults to hmacWithSHA1.
+func prfFor(algorithm asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case len(algorithm) == 0, algorithm.Equal(oidHmacWithSHA1):
+		return crypto.SHA1, nil
+	case algorithm.Equal(oidHmacWithSHA224):
+		return crypto.SHA224, nil
+	case algorithm.Equal(oidHmacWithSHA256):
+		return crypto.SHA256, nil
+	case algorithm.Equal(oidHmacWithSHA384):
+		return crypto.SHA384, nil
+	case algorithm.Equal(oidHmacWithSHA512):
+		return crypto.SHA512, nil
+	case algorithm.Equal(oidHmacWithSHA512_224):
+		return crypto.SHA512_224, nil
+	case algorithm.Equal(oidHmacWithSHA512_256):
+		return crypto.SHA512_256, nil
+	}
+	return 0, NotImplementedError("pbkdf2 prf " + algorithm.String() + " is not supported")
+}
+
+// isGCM reports whether the PBES2 encryption scheme identified by algorithm
+// is AES-GCM.
+func isGCM(algorithm asn1.ObjectIdentifier) bool {
+	return algorithm.Equal(oidAES128GCM) || algorithm.Equal(oidAES192GCM) || algorithm.Equal(oidAES256GCM)
+}
+
+// isAEAD reports whether the PBES2 encryption scheme identified by
+// algorithm is an AEAD rather than a block cipher in CBC mode.
+func isAEAD(algorithm asn1.ObjectIdentifier) bool {
+	return isGCM(algorithm) || algorithm.Equal(oidChaCha20Poly1305)
+}
+
+// gcmFor returns the AES-GCM scheme with the same key size as the AES-CBC
+// scheme identified by algorithm, or algorithm itself if it is not AES-CBC.
+func gcmFor(algorithm asn1.ObjectIdentifier) asn1.ObjectIdentifier {
+	switch {
+	case algorithm.Equal(oidAES128CBC):
+		return oidAES128GCM
+	case algorithm.Equal(oidAES192CBC):
+		return oidAES192GCM
+	case algorithm.Equal(oidAES256CBC):
+		return oidAES256GCM
+	}
+	return algorithm
+}
+
+// isCamellia reports whether the PBES2 encryption scheme identified by
+// algorithm is Camellia-CBC.
+func isCamellia(algorithm asn1.ObjectIdentifier) bool {
+	return algorithm.Equal(oidCamellia128CBC) || algorithm.Equal(oidCamellia192CBC) || algorithm.Equal(oidCamellia256CBC)
+}
+
+// camelliaFor returns the Camellia-CBC scheme with the same key size as the
+// AES-CBC scheme identified by algorithm, or algorithm itself if it is not
+// AES-CBC.
+func camelliaFor(algorithm asn1.ObjectIdentifier) asn1.ObjectIdentifier {
+	switch {
+	case algorithm.Equal(oidAES128CBC):
+		return oidCamellia128CBC
+	case algorithm.Equal(oidAES192CBC):
+		return oidCamellia192CBC
+	case algorithm.Equal(oidAES256CBC):
+		return oidCamellia256CBC
+	}
+	return algorithm
+}
+
+// pbes2KeyLen returns the key length in bytes of the PBES2 encryption
+// scheme identified by algorithm.
+func pbes2KeyLen(algorithm asn1.ObjectIdentifier) (int, error) {
+	switch {
+	case algorithm.Equal(oidAES128CBC):
+		return 16, nil
+	case algorithm.Equal(oidAES192CBC):
+		return 24, nil
+	case algorithm.Equal(oidAES256CBC):
+		return 32, nil
+	case algorithm.Equal(oidCamellia128CBC):
+		return 16, nil
+	case algorithm.Equal(oidCamellia192CBC):
+		return 24, nil
+	case algorithm.Equal(oidCamellia256CBC):
+		return 32, nil
+	case algorithm.Equal(oidSEEDCBC):
+		return 16, nil
+	case algorithm.Equal(oidAES128GCM):
+		return 16, nil
+	case algorithm.Equal(oidAES192GCM):
+		return 24, nil
+	case algorithm.Equal(oidAES256GCM):
+		return 32, nil
+	case algorithm.Equal(oidChaCha20Poly1305):
+		return chacha20poly1305.KeySize, nil
+	}
+	return 0, NotImplementedError("pbes2 encryption scheme " + algorithm.String() + " is not supported")
+}
+
+// parsePBES2Params decodes the parameters of a PBES2 AlgorithmIdentifier,
+// returning the PBES2 parameters and the nested PBKDF2 parameters.
+func parsePBES2Params(algorithm pkix.AlgorithmIdentifier) (*pbes2Params, *pbkdf2Params, error) {
+	var params pbes2Params
+	if err := unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding PBES2 parameters: " + err.Error())
+	}
+	if !params.Kdf.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, NotImplementedError("pbes2 kdf " + params.Kdf.Algorithm.String() + " is not supported")
+	}
+	var kdfParams pbkdf2Params
+	if err := unmarshal(params.Kdf.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding PBKDF2 parameters: " + err.Error())
+	}
+	return &params, &kdfParams, nil
+}
+
+// checkPBES2Params decodes and checks the parameters of a PBES2
+// AlgorithmIdentifier, returning the PBES2 and PBKDF2 parameters with the
+// hash of the PRF and the key length of the encryption scheme.
+func checkPBES2Params(algorithm pkix.AlgorithmIdentifier) (params *pbes2Params, kdfParams *pbkdf2Params, prf crypto.Hash, keyLen int, err error) {
+	if params, kdfParams, err = parsePBES2Params(algorithm); err != nil {
+		return
+	}
+	if prf, err = prfFor(kdfParams.Prf.Algorithm); err != nil {
+		return
+	}
+	if keyLen, err = pbes2KeyLen(params.EncryptionScheme.Algorithm); err != nil {
+		return
+	}
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keyLen {
+		err = errors.New("pkcs12: PBKDF2 key length does not match the encryption scheme")
+	}
+	return
+}
+
+// pbes2Key derives the key for the PBES2 encryption scheme in algorithm
+// from password, returning it with the PBES2 parameters.
+//
+// Unlike the PKCS#12 PBE schemes, PBES2 consumes the password as UTF-8
+// rather than as a NUL-terminated BMPString, see
+// https://tools.ietf.org/html/rfc9579#section-3
+func pbes2Key(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte) (*pbes2Params, []byte, error) {
+	params, kdfParams, prf, keyLen, err := checkPBES2Params(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hsmFor(ctx) != nil {
+		return nil, nil, hsmUnsupported(algorithmName(params.EncryptionScheme.Algorithm))
+	}
+
+	utf8Password, err := bmpToUTF8(password)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wipe(utf8Password)
+
+	key, err := kdfFor(ctx).PBKDF2(ctx, prf, utf8Password, kdfParams.Salt, kdfParams.Iterations, keyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, key, nil
+}
+
+// pbes2Block returns the block cipher of the PBES2 encryption scheme in
+// algorithm, keyed from password, with the PBES2 parameters.  If ctx
+// carries an HSM, the key is derived and kept by it.
+func pbes2Block(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte) (*pbes2Params, cipher.Block, error) {
+	hsm := hsmFor(ctx)
+	if hsm == nil {
+		params, key, err := pbes2Key(ctx, algorithm, password)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer wipe(key)
+
+		var block cipher.Block
+		switch scheme := params.EncryptionScheme.Algorithm; {
+		case isCamellia(scheme):
+			block, err = camellia.New(key)
+		case scheme.Equal(oidSEEDCBC):
+			block, err = seed.New(key)
+		default:
+			block, err = aes.NewCipher(key)
+		}
+		return params, block, err
+	}
+
+	params, kdfParams, prf, keyLen, err := checkPBES2Params(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := "AES"
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case isCamellia(scheme):
+		name = "Camellia"
+	case scheme.Equal(oidSEEDCBC):
+		name = "SEED"
+	case scheme.Equal(oidChaCha20Poly1305):
+		return nil, nil, hsmUnsupported(algorithmName(scheme))
+	}
+
+	utf8Password, err := bmpToUTF8(password)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer wipe(utf8Password)
+
+	block, err := hsm.NewCipher(ctx, name, keyLen, prf, utf8Password, kdfParams.Salt, kdfParams.Iterations)
+	if err != nil {
+		return nil, nil, err
+	}
+	return params, block, nil
+}
+
+// pbes2CipherFor returns the block cipher and IV described by the PBES2
+// parameters in algorithm, keyed from password.
+func pbes2CipherFor(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.Block, []byte, error) {
+	params, block, err := pbes2Block(ctx, algorithm, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isAEAD(params.EncryptionScheme.Algorithm) {
+		return nil, nil, errors.New("pkcs12: " + algorithmName(params.EncryptionScheme.Algorithm) + " is not a block cipher mode")
+	}
+
+	var iv []byte
+	if err := unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding PBES2 IV: " + err.Error())
+	}
+	// Camellia and SEED have the same block size as AES.
+	if len(iv) != aes.BlockSize {
+		return nil, nil, errors.New("pkcs12: invalid PBES2 IV length")
+	}
+
+	return block, iv, nil
+}
+
+// pbes2AEADFor returns the AEAD and nonce described by the PBES2
+// parameters in algorithm, keyed from password.  For AES-GCM, only the
+// standard nonce size of 12 bytes is accepted with tags shorter than 16
+// bytes.
+func pbes2AEADFor(ctx context.Context, algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.AEAD, []byte, error) {
+	params, _, err := parsePBES2Params(algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if params.EncryptionScheme.Algorithm.Equal(oidChaCha20Poly1305) {
+		var nonce []byte
+		if err := unmarshal(params.EncryptionScheme.Parameters.FullBytes, &nonce); err != nil {
+			return nil, nil, errors.New("pkcs12: error decoding ChaCha20-Poly1305 nonce: " + err.Error())
+		}
+		if len(nonce) != chacha20poly1305.NonceSize {
+			return nil, nil, errors.New("pkcs12: invalid ChaCha20-Poly1305 nonce length")
+		}
+		_, key, err := pbes2Key(ctx, algorithm, password)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer wipe(key)
+		aead, err := chacha20poly1305.New(key)
+		return aead, nonce, err
+	}
+
+	var gcm gcmParams
+	if err := unmarshal(params.EncryptionScheme.Parameters.FullBytes, &gcm); err != nil {
+		return nil, nil, errors.New("pkcs12: error decoding AES-GCM parameters: " + err.Error())
+	}
+	_, block, err := pbes2Block(ctx, algorithm, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var aead cipher.AEAD
+	switch {
+	case len(gcm.Nonce) == aeadNonceSize:
+		aead, err = cipher.NewGCMWithTagSize(block, gcm.ICVLen)
+	case len(gcm.Nonce) > 0 && gcm.ICVLen == gcmTagSize:
+		aead, err = cipher.NewGCMWithNonceSize(block, len(gcm.Nonce))
+	default:
+		err = errors.New("unsupported nonce and tag sizes")
+	}
+	if err != nil {
+		return nil, nil, errors.New("pkcs12: invalid AES-GCM parameters: " + err.Error())
+	}
+	return aead, gcm.Nonce, nil
+}
+
+// usesAEAD reports whether algorithm is PBES2 with an AEAD.
+func usesAEAD(algorithm pkix.AlgorithmIdentifier) bool {
+	if !algorithm.Algorithm.Equal(oidPBES2) {
+		return false
+	}
+	params, _, err := parsePBES2Params(algorithm)
+	return err == nil && isAEAD(params.EncryptionScheme.Algorithm)
+}
+
+// makePBES2Params returns the DER-encoded PBES2 parameters for encrypting
+// with the given encryption scheme and PBKDF2 PRF.  For an AEAD, iv is the
+// nonce.
+func makePBES2Params(encryptionScheme, prf asn1.ObjectIdentifier, salt, iv []byte, iterations int) ([]byte, error) {
+	var err error
+	var kdfParams pbkdf2Params
+	kdfParams.Salt = salt
+	kdfParams.Iterations = iterations
+	kdfParams.Prf.Algorithm = prf
+	kdfParams.Prf.Parameters = asn1.NullRawValue
+
+	var params pbes2Params
+	params.Kdf.Algorithm = oidPBKDF2
+	if params.Kdf.Parameters.FullBytes, err = asn1.Marshal(kdfParams); err != nil {
+		return nil, err
+	}
+	params.EncryptionScheme.Algorithm = encryptionScheme
+	if isGCM(encryptionScheme) {
+		params.EncryptionScheme.Parameters.FullBytes, err = asn1.Marshal(gcmParams{Nonce: iv, ICVLen: gcmTagSize})
+	} else {
+		params.EncryptionScheme.Parameters.FullBytes, err = asn1.Marshal(iv)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(params)
+}
@@ -0,0 +1,256 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// Test vectors from https://tools.ietf.org/html/rfc6070 and
+	// https://tools.ietf.org/html/rfc7914#section-11
+	for _, test := range []struct {
+		sha256     bool
+		password   string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		{false, "password", "salt", 1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{false, "password", "salt", 2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{false, "password", "salt", 4096, "4b007901b765489abead49d926f721d065a429c1"},
+		{false, "passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{true, "passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	} {
+		expected, _ := hex.DecodeString(test.expected)
+		h := sha1.New
+		if test.sha256 {
+			h = sha256.New
+		}
+		key, err := pbkdf2(context.Background(), h, []byte(test.password), []byte(test.salt), test.iterations, len(expected))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, expected) {
+			t.Errorf("pbkdf2(%q, %q, %d): expected %x, got %x", test.password, test.salt, test.iterations, expected, key)
+		}
+	}
+}
+
+func TestDecodeOpenSSL3Default(t *testing.T) {
+	p12, _ := base64.StdEncoding.DecodeString(openSSL3DefaultP12)
+
+	privateKey, certificate, err := Decode(p12, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := privateKey.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("unexpected private key type %T", privateKey)
+	}
+	if certificate.Subject.CommonName != "openssl3.example.com" {
+		t.Errorf("unexpected common name %q", certificate.Subject.CommonName)
+	}
+
+	if _, _, err := Decode(p12, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+}
+
+func TestModernRoundTrip(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	pfxData, err := Modern.Encode(key, cert, nil, "päss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, algorithm := range safeContentsAlgorithms(t, pfxData) {
+		if algorithm != nil && !algorithm.Equal(oidPBES2) {
+			t.Errorf("unexpected algorithm %v", algorithm)
+		}
+	}
+
+	decodedKey, decodedCert, err := Decode(pfxData, "päss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decodedKey.(*ecdsa.PrivateKey).Equal(key) || !decodedCert.Equal(cert) {
+		t.Error("round trip did not preserve the key and certificate")
+	}
+}
+
+// openSSL3DefaultP12 was produced by OpenSSL 3.0 with
+// "openssl pkcs12 -export -passout pass:password".
+var openSSL3DefaultP12 = `MIIELAIBAzCCA+IGCSqGSIb3DQEHAaCCA9MEggPPMIIDyzCCAoIGCSqGSIb3DQEHBqCCAnMwggJv
+AgEAMIICaAYJKoZIhvcNAQcBMFcGCSqGSIb3DQEFDTBKMCkGCSqGSIb3DQEFDDAcBAhR0BIFtw2z
+kgICCAAwDAYIKoZIhvcNAgkFADAdBglghkgBZQMEASoEEBqi6cSVmjki8bi8k4ovKheAggIA8kt8
+78gnDV9LZqj9Jq2kA4/np5o15IjmPoHQbw/9GDX9iyha0KmtgFMWvJjIV48wbv8GbPJdnorEFt1h
+Kyq6nZzTpETvYDBVCzu+w7ONKPTnIqbnEuPPhZiiQ1qrWX/bYKVPSSC+QtW0jYg0bpbKiTDJoQV6
+frUyVU4kaHTdb8J4RjVUWIcny6HUcmmaVzOKxBLMpwIK1ClSVVKzMZq6Q4AXqx8OuHomJS/NVxhg
+QCuZoZr7sRECSBXbVQ947Vqh1P25BlOh2RXtmdumQNLdPl1hSMZFea/WdfpLaWTJ67G7pdP7BTIg
+Am9sH8EaKQmW8XwcsOp/PKK0Nx2+4wfocn10G6TpaE5V5z/MfHneIxg/bK8mbQ7I2lz5oZGEG4Yg
+EwYnZWC++ANDseDjrbezrNmtv3crnXkibcHwcEaUIDpf8LWbUI24UEeB/tids1dY6CVnLKvRQsHt
+90BSVFmYtG4Ch0h3X6eFu8firqwr3wTgImL4y/lDRykxkKuFBCQETggaeQCoeqbgwAsgX4w4+ypF
+np9JfhYZ8e695ijb0ZyfLygAUir46F0u2tjM6MHDD7sG92DbrLLKYvJ6/lk0RBTew6/oHhhNNEdK
+nqDNNa8g5svLbA6xkYuO48m54+e8MvVQvWWHtcEeOqdvcdYD3U4iC59mNxHhWqLupyJw5SIwggFB
+BgkqhkiG9w0BBwGgggEyBIIBLjCCASowggEmBgsqhkiG9w0BDAoBAqCB7zCB7DBXBgkqhkiG9w0B
+BQ0wSjApBgkqhkiG9w0BBQwwHAQIuPGPtbXLX38CAggAMAwGCCqGSIb3DQIJBQAwHQYJYIZIAWUD
+BAEqBBCc96ijrmHnGkwcZ5RfTlRPBIGQXxfFvNdp7Z62LAHsMNpy8JIgck6fe/24ea5jmgjw79f/
+ZLBIGZUdPDunK3itdzoevEa1OpUMeuBqu6MA0+6vgBOrlaNuOEWQbsg34iFeMhOecJVam1taOBoq
+dPVrzChSQlYA1n0eLqVeaslD+mNU7j2JcmGuJa8nQxYFN+hR6tDNygKk/Ffn4nyFrb4or8AFMSUw
+IwYJKoZIhvcNAQkVMRYEFFHiMiUDrd48VaKYMq6AW78TaPbiMEEwMTANBglghkgBZQMEAgEFAAQg
+9GslLU1fPJesV4U9iEUk2DatsOYyNfcm8Y+4dFpytR4ECCmTozxQkG1TAgIIAA==`
+
+func TestCamelliaRoundTrip(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	pfxData, err := Modern.WithCamellia().Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cipher := probe.SafeContents[0].Encryption.Cipher; !cipher.Equal(oidCamellia256CBC) {
+		t.Errorf("unexpected certificate cipher %s", algorithmName(cipher))
+	}
+	if cipher := probe.SafeContents[1].Bags[0].Encryption.Cipher; !cipher.Equal(oidCamellia256CBC) {
+		t.Errorf("unexpected key cipher %s", algorithmName(cipher))
+	}
+	if _, certificate, err := Decode(pfxData, "password"); err != nil {
+		t.Error(err)
+	} else if !certificate.Equal(cert) {
+		t.Error("unexpected certificate")
+	}
+
+	if _, err := Modern.WithCamellia().WithFIPSMode().Encode(key, cert, nil, "password"); !errors.Is(err, ErrNonFIPSAlgorithm) {
+		t.Errorf("expected ErrNonFIPSAlgorithm, got %v", err)
+	}
+}
+
+func TestGCMRoundTrip(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	pfxData, err := Modern.WithGCM().WithFIPSMode().Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cipher := probe.SafeContents[0].Encryption.Cipher; !cipher.Equal(oidAES256GCM) {
+		t.Errorf("unexpected certificate cipher %s", algorithmName(cipher))
+	}
+	if cipher := probe.SafeContents[1].Bags[0].Encryption.Cipher; !cipher.Equal(oidAES256GCM) {
+		t.Errorf("unexpected key cipher %s", algorithmName(cipher))
+	}
+	if _, certificate, err := DefaultDecoder.WithFIPSMode().Decode(pfxData, "password"); err != nil {
+		t.Error(err)
+	} else if !certificate.Equal(cert) {
+		t.Error("unexpected certificate")
+	}
+	if _, _, err := Decode(pfxData, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+
+	der, err := Modern.WithGCM().WithHashes(crypto.SHA512_256, crypto.SHA512_256).EncryptPrivateKey(key, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if privateKey, err := DefaultDecoder.DecryptPrivateKey(der, "password"); err != nil {
+		t.Error(err)
+	} else if !key.Equal(privateKey) {
+		t.Error("unexpected private key")
+	}
+}
+
+func TestDecryptGCM(t *testing.T) {
+	// Built by hand rather than by the Encoder, to cover the tag and
+	// nonce sizes of RFC 5084 that the Encoder does not use.
+	key, _ := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	plaintext, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("saltsaltsaltsalt")
+
+	for _, test := range []struct {
+		name   string
+		scheme asn1.ObjectIdentifier
+		nonce  []byte
+		tagLen int
+	}{
+		{"AES-128-GCM with a 12-byte tag", oidAES128GCM, bytes.Repeat([]byte{1}, 12), 12},
+		{"AES-192-GCM with a 16-byte nonce", oidAES192GCM, bytes.Repeat([]byte{2}, 16), 16},
+		{"AES-256-GCM", oidAES256GCM, bytes.Repeat([]byte{3}, 12), 16},
+	} {
+		keyLen, _ := pbes2KeyLen(test.scheme)
+		aesKey, err := pbkdf2(context.Background(), sha256.New, []byte("password"), salt, 2048, keyLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := aes.NewCipher(aesKey)
+		var aead cipher.AEAD
+		if len(test.nonce) == 12 {
+			aead, err = cipher.NewGCMWithTagSize(block, test.tagLen)
+		} else {
+			aead, err = cipher.NewGCMWithNonceSize(block, len(test.nonce))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var info encryptedPrivateKeyInfo
+		info.AlgorithmIdentifier.Algorithm = oidPBES2
+		info.AlgorithmIdentifier.Parameters.FullBytes, err = makePBES2Params(test.scheme, oidHmacWithSHA256, salt, nil, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Replace the parameters written by makePBES2Params.
+		params, _, _ := parsePBES2Params(info.AlgorithmIdentifier)
+		if params.EncryptionScheme.Parameters.FullBytes, err = asn1.Marshal(gcmParams{Nonce: test.nonce, ICVLen: test.tagLen}); err != nil {
+			t.Fatal(err)
+		}
+		if info.AlgorithmIdentifier.Parameters.FullBytes, err = asn1.Marshal(*params); err != nil {
+			t.Fatal(err)
+		}
+		info.EncryptedData = aead.Seal(nil, test.nonce, plaintext, nil)
+		der, err := asn1.Marshal(info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if privateKey, err := DefaultDecoder.DecryptPrivateKey(der, "password"); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !key.Equal(privateKey) {
+			t.Errorf("%s: unexpected private key", test.name)
+		}
+		if _, err := DefaultDecoder.DecryptPrivateKey(der, "wrong"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected ErrIncorrectPassword, got %v", test.name, err)
+		}
+
+		info.EncryptedData[0] ^= 1
+		der, _ = asn1.Marshal(info)
+		if _, err := DefaultDecoder.DecryptPrivateKey(der, "password"); err != ErrIncorrectPassword {
+			t.Errorf("%s: expected a corrupted file to fail like a wrong password, got %v", test.name, err)
+		}
+	}
+}
@@ -15,9 +15,7 @@
 package pkcs12 // import "github.com/hetesiistvan/go-pkcs12"
 
 import (
-	"crypto/ecdsa"
-	"crypto/rsa"
-	"crypto/sha1"
+	"crypto"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -25,7 +23,6 @@ import (
 	"encoding/pem"
 	"errors"
 	"io"
-	"reflect"
 )
 
 // DefaultPassword is the string "changeit", a commonly-used password for
@@ -62,6 +59,13 @@ type encryptedData struct {
 	EncryptedContentInfo encryptedContentInfo
 }
 
+// lenientEncryptedData is an encryptedData whose version may be left out,
+// as some generators do.
+type lenientEncryptedData struct {
+	Version              int `asn1:"optional"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
 type encryptedContentInfo struct {
 	ContentType                asn1.ObjectIdentifier
 	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
@@ -77,6 +81,7 @@ func (i encryptedContentInfo) Data() []byte { return i.EncryptedContent }
 func (i *encryptedContentInfo) SetData(data []byte) { i.EncryptedContent = data }
 
 type safeBag struct {
+	Raw        asn1.RawContent
 	Id         asn1.ObjectIdentifier
 	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
 	Attributes []pkcs12Attribute `asn1:"set,optional"`
@@ -129,73 +134,7 @@ func unmarshal(in []byte, out interface{}) error {
 // labeled "PRIVATE KEY".  To decode a PKCS#12 file, use DecodeChain instead,
 // and use the encoding/pem package to convert to PEM if necessary.
 func ToPEM(pfxData []byte, password string) ([]*pem.Block, error) {
-	encodedPassword, err := bmpString(password)
-	if err != nil {
-		return nil, ErrIncorrectPassword
-	}
-
-	bags, encodedPassword, err := getSafeContents(pfxData, encodedPassword)
-
-	if err != nil {
-		return nil, err
-	}
-
-	blocks := make([]*pem.Block, 0, len(bags))
-	for _, bag := range bags {
-		block, err := convertBag(&bag, encodedPassword)
-		if err != nil {
-			return nil, err
-		}
-		blocks = append(blocks, block)
-	}
-
-	return blocks, nil
-}
-
-func convertBag(bag *safeBag, password []byte) (*pem.Block, error) {
-	block := &pem.Block{
-		Headers: make(map[string]string),
-	}
-
-	for _, attribute := range bag.Attributes {
-		k, v, err := convertAttribute(&attribute)
-		if err != nil {
-			return nil, err
-		}
-		block.Headers[k] = v
-	}
-
-	switch {
-	case bag.Id.Equal(oidCertBag):
-		block.Type = certificateType
-		certsData, err := decodeCertBag(bag.Value.Bytes)
-		if err != nil {
-			return nil, err
-		}
-		block.Bytes = certsData
-	case bag.Id.Equal(oidPKCS8ShroundedKeyBag):
-		block.Type = privateKeyType
-
-		key, err := decodePkcs8ShroudedKeyBag(bag.Value.Bytes, password)
-		if err != nil {
-			return nil, err
-		}
-
-		switch key := key.(type) {
-		case *rsa.PrivateKey:
-			block.Bytes = x509.MarshalPKCS1PrivateKey(key)
-		case *ecdsa.PrivateKey:
-			block.Bytes, err = x509.MarshalECPrivateKey(key)
-			if err != nil {
-				return nil, err
-			}
-		default:
-			return nil, errors.New("found unknown private key type in PKCS#8 wrapping")
-		}
-	default:
-		return nil, errors.New("don't know how to convert a safe bag of type " + bag.Id.String())
-	}
-	return block, nil
+	return DefaultDecoder.ToPEM(pfxData, password)
 }
 
 func convertAttribute(attribute *pkcs12Attribute) (key, value string, err error) {
@@ -211,21 +150,32 @@ func convertAttribute(attribute *pkcs12Attribute) (key, value string, err error)
 		// This key is chosen to match OpenSSL.
 		key = "Microsoft CSP Name"
 		isString = true
+	case attribute.Id.Equal(oidMicrosoftLocalKeySet):
+		// This key is chosen to match OpenSSL.  The attribute has no
+		// value.
+		return "Microsoft Local Key set", "", nil
 	default:
 		return "", "", errors.New("pkcs12: unknown attribute with OID " + attribute.Id.String())
 	}
 
+	der := attribute.firstValue()
+	if der == nil {
+		return key, "", nil
+	}
 	if isString {
-		if err := unmarshal(attribute.Value.Bytes, &attribute.Value); err != nil {
+		var bmp asn1.RawValue
+		if err := unmarshal(der, &bmp); err != nil {
 			return "", "", err
 		}
-		if value, err = decodeBMPString(attribute.Value.Bytes); err != nil {
+		if bmp.Tag == asn1.TagUTF8String {
+			value = string(bmp.Bytes)
+		} else if value, err = decodeBMPString(bmp.Bytes); err != nil {
 			return "", "", err
 		}
 	} else {
-		var id []byte
-		if err := unmarshal(attribute.Value.Bytes, &id); err != nil {
-			return "", "", err
+		id, ok := parseOctetString(der)
+		if !ok {
+			return "", "", errors.New("pkcs12: malformed localKeyId")
 		}
 		value = hex.EncodeToString(id)
 	}
@@ -237,195 +187,61 @@ func convertAttribute(attribute *pkcs12Attribute) (key, value string, err error)
 // assumes that there is only one certificate and only one private key in the
 // pfxData.  Since PKCS#12 files often contain more than one certificate, you
 // probably want to use DecodeChain instead.
-func Decode(pfxData []byte, password string) (privateKey interface{}, certificate *x509.Certificate, err error) {
-	var caCerts []*x509.Certificate
-	privateKey, certificate, caCerts, err = DecodeChain(pfxData, password)
-	if len(caCerts) != 0 {
-		err = errors.New("pkcs12: expected exactly two safe bags in the PFX PDU")
-	}
-	return
+func Decode(pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, err error) {
+	return DefaultDecoder.Decode(pfxData, password)
 }
 
 // DecodeTrustStore extracts CA certificates from pfxData.
 func DecodeTrustStore(pfxData []byte, password string) (certs map[string]*x509.Certificate, err error) {
-	encodedPassword, err := bmpString(password)
-	if err != nil {
-		return nil, err
-	}
-
-	bags, encodedPassword, err := getSafeContents(pfxData, encodedPassword)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(bags) == 0 {
-		err = errors.New("pkcs12: no bag was found in trust store")
-		return nil, err
-	}
-
-	certs = map[string]*x509.Certificate{}
-	for _, bag := range bags {
-		if !bag.Id.Equal(oidCertBag) {
-			err = errors.New("pkcs12: expected only cert bags in trust store")
-			return nil, err
-		}
-
-		bagCertsData, err := decodeCertBag(bag.Value.Bytes)
-		if err != nil {
-			return nil, err
-		}
-
-		bagCert, err := x509.ParseCertificate(bagCertsData)
-		if err != nil {
-			return nil, err
-		}
-
-		friendlyName, err := certBagFriendlyName(bag.Attributes)
-		if err != nil {
-			return nil, err
-		}
-
-		certs[friendlyName] = bagCert
-	}
-
-	return
+	return DefaultDecoder.DecodeTrustStore(pfxData, password)
 }
 
 // DecodeChain extracts a certificate, a CA certificate chain, and private key
 // from pfxData. This function checks if there is at least one certificate
-// and only one private key in the pfxData.  The first certificate is assumed to
-// be the leaf certificate, and subsequent certificates, if any, are assumed to
-// comprise the CA certificate chain.
-func DecodeChain(pfxData []byte, password string) (privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
-	encodedPassword, err := bmpString(password)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	bags, encodedPassword, err := getSafeContents(pfxData, encodedPassword)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-
-	for _, bag := range bags {
-		switch {
-		case bag.Id.Equal(oidCertBag):
-			certsData, err := decodeCertBag(bag.Value.Bytes)
-			if err != nil {
-				return nil, nil, nil, err
-			}
-			certs, err := x509.ParseCertificates(certsData)
-			if err != nil {
-				return nil, nil, nil, err
-			}
-			if len(certs) != 1 {
-				err = errors.New("pkcs12: expected exactly one certificate in the certBag")
-				return nil, nil, nil, err
-			}
-			if certificate == nil {
-				certificate = certs[0]
-			} else {
-				caCerts = append(caCerts, certs[0])
-			}
-
-		case bag.Id.Equal(oidPKCS8ShroundedKeyBag):
-			if privateKey != nil {
-				err = errors.New("pkcs12: expected exactly one key bag")
-				return nil, nil, nil, err
-			}
-
-			if privateKey, err = decodePkcs8ShroudedKeyBag(bag.Value.Bytes, encodedPassword); err != nil {
-				return nil, nil, nil, err
-			}
-		}
-	}
-
-	if certificate == nil {
-		return nil, nil, nil, errors.New("pkcs12: certificate missing")
-	}
-	if privateKey == nil {
-		return nil, nil, nil, errors.New("pkcs12: private key missing")
-	}
-
-	return
+// and only one private key in the pfxData.  The leaf certificate is the one
+// whose localKeyId attribute matches that of the private key or, if there is
+// no such certificate, the first one whose public key matches the private
+// key.  If neither is found, the first certificate is assumed to be the leaf
+// certificate.  The other certificates, if any, are assumed to comprise the
+// CA certificate chain, in the order they appear in pfxData.
+//
+// The private key is one of *rsa.PrivateKey, *ecdsa.PrivateKey or
+// ed25519.PrivateKey, and always implements crypto.Signer.  If the leaf
+// certificate was not found by its public key, DecodeChain does not check
+// that the key belongs to it; use MatchKeyToCert for that.
+func DecodeChain(pfxData []byte, password string) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	return DefaultDecoder.DecodeChain(pfxData, password)
 }
 
-func getSafeContents(p12Data, password []byte) (bags []safeBag, updatedPassword []byte, err error) {
-	pfx := new(pfxPdu)
-	if err := unmarshal(p12Data, pfx); err != nil {
-		return nil, nil, errors.New("pkcs12: error reading P12 data: " + err.Error())
-	}
-
-	if pfx.Version != 3 {
-		return nil, nil, NotImplementedError("can only decode v3 PFX PDU's")
-	}
-
-	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
-		return nil, nil, NotImplementedError("only password-protected PFX is implemented")
-	}
-
-	// unmarshal the explicit bytes in the content for type 'data'
-	if err := unmarshal(pfx.AuthSafe.Content.Bytes, &pfx.AuthSafe.Content); err != nil {
-		return nil, nil, err
-	}
-
-	if len(pfx.MacData.Mac.Algorithm.Algorithm) == 0 {
-		return nil, nil, errors.New("pkcs12: no MAC in data")
-	}
-
-	if err := verifyMac(&pfx.MacData, pfx.AuthSafe.Content.Bytes, password); err != nil {
-		if err == ErrIncorrectPassword && len(password) == 2 && password[0] == 0 && password[1] == 0 {
-			// some implementations use an empty byte array
-			// for the empty string password try one more
-			// time with empty-empty password
-			password = nil
-			err = verifyMac(&pfx.MacData, pfx.AuthSafe.Content.Bytes, password)
-		}
-		if err != nil {
-			return nil, nil, err
-		}
-	}
+// DecodeBytesPassword is like Decode, but takes the password as UTF-8
+// encoded bytes so that the caller can wipe it after use.
+func DecodeBytesPassword(pfxData, password []byte) (privateKey crypto.PrivateKey, certificate *x509.Certificate, err error) {
+	return DefaultDecoder.DecodeBytesPassword(pfxData, password)
+}
 
-	var authenticatedSafe []contentInfo
-	if err := unmarshal(pfx.AuthSafe.Content.Bytes, &authenticatedSafe); err != nil {
-		return nil, nil, err
-	}
+// DecodeTrustStoreBytesPassword is like DecodeTrustStore, but takes the
+// password as UTF-8 encoded bytes so that the caller can wipe it after use.
+func DecodeTrustStoreBytesPassword(pfxData, password []byte) (certs map[string]*x509.Certificate, err error) {
+	return DefaultDecoder.DecodeTrustStoreBytesPassword(pfxData, password)
+}
 
-	// if len(authenticatedSafe) != 2 {
-	// 	return nil, nil, NotImplementedError("expected exactly two items in the authenticated safe")
-	// }
-
-	for _, ci := range authenticatedSafe {
-		var data []byte
-
-		switch {
-		case ci.ContentType.Equal(oidDataContentType):
-			if err := unmarshal(ci.Content.Bytes, &data); err != nil {
-				return nil, nil, err
-			}
-		case ci.ContentType.Equal(oidEncryptedDataContentType):
-			var encryptedData encryptedData
-			if err := unmarshal(ci.Content.Bytes, &encryptedData); err != nil {
-				return nil, nil, err
-			}
-			if encryptedData.Version != 0 {
-				return nil, nil, NotImplementedError("only version 0 of EncryptedData is supported")
-			}
-			if data, err = pbDecrypt(encryptedData.EncryptedContentInfo, password); err != nil {
-				return nil, nil, err
-			}
-		default:
-			return nil, nil, NotImplementedError("only data and encryptedData content types are supported in authenticated safe")
-		}
+// DecodeChainBytesPassword is like DecodeChain, but takes the password as
+// UTF-8 encoded bytes so that the caller can wipe it after use.
+func DecodeChainBytesPassword(pfxData, password []byte) (privateKey crypto.PrivateKey, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	return DefaultDecoder.DecodeChainBytesPassword(pfxData, password)
+}
 
-		var safeContents []safeBag
-		if err := unmarshal(data, &safeContents); err != nil {
-			return nil, nil, err
-		}
-		bags = append(bags, safeContents...)
-	}
+// DecryptPrivateKeyInto copies the DER-encoded PKCS#8 private key in
+// pfxData into dst using the DefaultDecoder.  See
+// Decoder.DecryptPrivateKeyInto.
+func DecryptPrivateKeyInto(dst, pfxData []byte, password string) (n int, err error) {
+	return DefaultDecoder.DecryptPrivateKeyInto(dst, pfxData, password)
+}
 
-	return bags, password, nil
+// DecryptPrivateKeyIntoBytesPassword is like DecryptPrivateKeyInto, but
+// takes the password as UTF-8 encoded bytes.
+func DecryptPrivateKeyIntoBytesPassword(dst, pfxData, password []byte) (n int, err error) {
+	return DefaultDecoder.DecryptPrivateKeyIntoBytesPassword(dst, pfxData, password)
 }
 
 // Encode produces pfxData containing one private key (privateKey), an
@@ -440,94 +256,29 @@ func getSafeContents(p12Data, password []byte) (bags []safeBag, updatedPassword
 // The rand argument is used to provide entropy for the encryption, and
 // can be set to rand.Reader from the crypto/rand package.
 //
-// Encode emulates the behavior of OpenSSL's PKCS12_create: it creates two
-// SafeContents: one that's encrypted with RC2 and contains the certificates,
-// and another that is unencrypted and contains the private key shrouded with
-// 3DES  The private key bag and the end-entity certificate bag have the
-// LocalKeyId attribute set to the SHA-1 fingerprint of the end-entity
-// certificate.
+// Encode emulates the behavior of OpenSSL 3's PKCS12_create: it creates two
+// SafeContents: one that's encrypted with AES-256 and contains the
+// certificates, and another that is unencrypted and contains the private key
+// shrouded with AES-256.  The private key bag and the end-entity certificate
+// bag have the LocalKeyId attribute set to the SHA-1 fingerprint of the
+// end-entity certificate.
+//
+// Encode is equivalent to Modern.WithRand(rand).Encode.  Legacy applications
+// which cannot read AES-encrypted files need
+// LegacyRC2.AllowWeakAlgorithms().WithRand(rand).Encode instead.
 func Encode(rand io.Reader, privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, password string) (pfxData []byte, err error) {
-	encodedPassword, err := bmpString(password)
-	if err != nil {
-		return nil, err
-	}
-
-	var pfx pfxPdu
-	pfx.Version = 3
-
-	var certFingerprint = sha1.Sum(certificate.Raw)
-	var localKeyIdAttr pkcs12Attribute
-	localKeyIdAttr.Id = oidLocalKeyID
-	localKeyIdAttr.Value.Class = 0
-	localKeyIdAttr.Value.Tag = 17
-	localKeyIdAttr.Value.IsCompound = true
-	if localKeyIdAttr.Value.Bytes, err = asn1.Marshal(certFingerprint[:]); err != nil {
-		return nil, err
-	}
-
-	var certBags []safeBag
-	var certBag *safeBag
-	if certBag, err = makeCertBag(certificate.Raw, []pkcs12Attribute{localKeyIdAttr}); err != nil {
-		return nil, err
-	}
-	certBags = append(certBags, *certBag)
-
-	for _, cert := range caCerts {
-		if certBag, err = makeCertBag(cert.Raw, []pkcs12Attribute{}); err != nil {
-			return nil, err
-		}
-		certBags = append(certBags, *certBag)
-	}
-
-	var keyBag safeBag
-	keyBag.Id = oidPKCS8ShroundedKeyBag
-	keyBag.Value.Class = 2
-	keyBag.Value.Tag = 0
-	keyBag.Value.IsCompound = true
-	if keyBag.Value.Bytes, err = encodePkcs8ShroudedKeyBag(rand, privateKey, encodedPassword); err != nil {
-		return nil, err
-	}
-	keyBag.Attributes = append(keyBag.Attributes, localKeyIdAttr)
-
-	// Construct an authenticated safe with two SafeContents.
-	// The first SafeContents is encrypted and contains the cert bags.
-	// The second SafeContents is unencrypted and contains the shrouded key bag.
-	var authenticatedSafe [2]contentInfo
-	if authenticatedSafe[0], err = makeSafeContents(rand, certBags, encodedPassword); err != nil {
-		return nil, err
-	}
-	if authenticatedSafe[1], err = makeSafeContents(rand, []safeBag{keyBag}, nil); err != nil {
-		return nil, err
-	}
-
-	var authenticatedSafeBytes []byte
-	if authenticatedSafeBytes, err = asn1.Marshal(authenticatedSafe[:]); err != nil {
-		return nil, err
-	}
-
-	// compute the MAC
-	pfx.MacData.Mac.Algorithm.Algorithm = oidSHA1
-	pfx.MacData.MacSalt = make([]byte, 8)
-	if _, err = rand.Read(pfx.MacData.MacSalt); err != nil {
-		return nil, err
-	}
-	pfx.MacData.Iterations = 1
-	if err = computeMac(&pfx.MacData, authenticatedSafeBytes, encodedPassword); err != nil {
-		return nil, err
-	}
-
-	pfx.AuthSafe.ContentType = oidDataContentType
-	pfx.AuthSafe.Content.Class = 2
-	pfx.AuthSafe.Content.Tag = 0
-	pfx.AuthSafe.Content.IsCompound = true
-	if pfx.AuthSafe.Content.Bytes, err = asn1.Marshal(authenticatedSafeBytes); err != nil {
-		return nil, err
-	}
+	return Modern.WithRand(rand).Encode(privateKey, certificate, caCerts, password)
+}
 
-	if pfxData, err = asn1.Marshal(pfx); err != nil {
-		return nil, errors.New("pkcs12: error writing P12 data: " + err.Error())
-	}
-	return
+// EncodeKeyOnly produces pfxData containing one private key (privateKey)
+// and no certificate.
+//
+// The rand argument is used to provide entropy for the encryption, and
+// can be set to rand.Reader from the crypto/rand package.
+//
+// EncodeKeyOnly is equivalent to Modern.WithRand(rand).EncodeKeyOnly.
+func EncodeKeyOnly(rand io.Reader, privateKey interface{}, password string) (pfxData []byte, err error) {
+	return Modern.WithRand(rand).EncodeKeyOnly(privateKey, password)
 }
 
 // EncodeTrustStore produces pfxData containing any number of CA certificates
@@ -539,65 +290,10 @@ func Encode(rand io.Reader, privateKey interface{}, certificate *x509.Certificat
 // EncodeTrustStore creates one SafeContent: that contains the certificates,
 // The certificate bag have the LocalKeyId attribute set to the SHA-1 fingerprint of the end-entity
 // certificate.
+//
+// EncodeTrustStore is equivalent to Modern.WithRand(rand).EncodeTrustStore.
 func EncodeTrustStore(rand io.Reader, certs map[string]*x509.Certificate, password string) (pfxData []byte, err error) {
-	encodedPassword, err := bmpString(password)
-	if err != nil {
-		return nil, err
-	}
-
-	var pfx pfxPdu
-	pfx.Version = 3
-
-	var certBags []safeBag
-	var certBag *safeBag
-
-	for alias, cert := range certs {
-		var attributes []pkcs12Attribute
-		if attributes, err = certBagAttributes(alias); err != nil {
-			return nil, err
-		}
-		if certBag, err = makeCertBag(cert.Raw, attributes); err != nil {
-			return nil, err
-		}
-		certBags = append(certBags, *certBag)
-	}
-
-	// Construct an authenticated safe with two SafeContents.
-	// The first SafeContents is encrypted and contains the cert bags.
-	// The second SafeContents is unencrypted and contains the shrouded key bag.
-	var authenticatedSafe [1]contentInfo
-	if authenticatedSafe[0], err = makeSafeContents(rand, certBags, encodedPassword); err != nil {
-		return nil, err
-	}
-
-	var authenticatedSafeBytes []byte
-	if authenticatedSafeBytes, err = asn1.Marshal(authenticatedSafe[:]); err != nil {
-		return nil, err
-	}
-
-	// compute the MAC
-	pfx.MacData.Mac.Algorithm.Algorithm = oidSHA1
-	pfx.MacData.MacSalt = make([]byte, 8)
-	if _, err = rand.Read(pfx.MacData.MacSalt); err != nil {
-		return nil, err
-	}
-	pfx.MacData.Iterations = 1
-	if err = computeMac(&pfx.MacData, authenticatedSafeBytes, encodedPassword); err != nil {
-		return nil, err
-	}
-
-	pfx.AuthSafe.ContentType = oidDataContentType
-	pfx.AuthSafe.Content.Class = 2
-	pfx.AuthSafe.Content.Tag = 0
-	pfx.AuthSafe.Content.IsCompound = true
-	if pfx.AuthSafe.Content.Bytes, err = asn1.Marshal(authenticatedSafeBytes); err != nil {
-		return nil, err
-	}
-
-	if pfxData, err = asn1.Marshal(pfx); err != nil {
-		return nil, errors.New("pkcs12: error writing P12 data: " + err.Error())
-	}
-	return
+	return Modern.WithRand(rand).EncodeTrustStore(certs, password)
 }
 
 // certBagAttributes returns a list of pkcs12 attributes needed for a cert bag
@@ -607,10 +303,11 @@ func EncodeTrustStore(rand io.Reader, certs map[string]*x509.Certificate, passwo
 // 2.16.840.1.113894.746875.1.1
 // See https://github.com/kaikramer/keystore-explorer/issues/35
 //
-// Additionally an alias is also added to the attribute list.
-func certBagAttributes(alias string) (attributes []pkcs12Attribute, err error) {
+// Additionally an alias is also added to the attribute list, encoded as
+// selected by encoding.
+func certBagAttributes(alias string, encoding NameEncoding) (attributes []pkcs12Attribute, err error) {
 	var aliasBytes []byte
-	if aliasBytes, err = marshalBmpString(alias); err != nil {
+	if aliasBytes, err = marshalFriendlyName(alias, encoding); err != nil {
 		return nil, err
 	}
 	var extKeyUsageOidBytes []byte
@@ -644,11 +341,8 @@ func certBagAttributes(alias string) (attributes []pkcs12Attribute, err error) {
 }
 
 func certBagFriendlyName(attributes []pkcs12Attribute) (friendlyName string, err error) {
-	for _, attribute := range attributes {
-		if reflect.DeepEqual(attribute.Id, oidFriendlyName) {
-			friendlyName, err = unmarshalBmpString(attribute.Value.Bytes)
-			return
-		}
+	if value := firstAttributeValue(attributes, oidFriendlyName); value != nil {
+		return unmarshalFriendlyName(value)
 	}
 	return "", errors.New("pkcs12: friendly name not specified for cert bag")
 }
@@ -665,48 +359,3 @@ func makeCertBag(certBytes []byte, attributes []pkcs12Attribute) (certBag *safeB
 	certBag.Attributes = attributes
 	return
 }
-
-func makeSafeContents(rand io.Reader, bags []safeBag, password []byte) (ci contentInfo, err error) {
-	var data []byte
-	if data, err = asn1.Marshal(bags); err != nil {
-		return
-	}
-
-	if password == nil {
-		ci.ContentType = oidDataContentType
-		ci.Content.Class = 2
-		ci.Content.Tag = 0
-		ci.Content.IsCompound = true
-		if ci.Content.Bytes, err = asn1.Marshal(data); err != nil {
-			return
-		}
-	} else {
-		randomSalt := make([]byte, 8)
-		if _, err = rand.Read(randomSalt); err != nil {
-			return
-		}
-
-		var algo pkix.AlgorithmIdentifier
-		algo.Algorithm = oidPBEWithSHAAnd40BitRC2CBC
-		if algo.Parameters.FullBytes, err = asn1.Marshal(pbeParams{Salt: randomSalt, Iterations: 2048}); err != nil {
-			return
-		}
-
-		var encryptedData encryptedData
-		encryptedData.Version = 0
-		encryptedData.EncryptedContentInfo.ContentType = oidDataContentType
-		encryptedData.EncryptedContentInfo.ContentEncryptionAlgorithm = algo
-		if err = pbEncrypt(&encryptedData.EncryptedContentInfo, data, password); err != nil {
-			return
-		}
-
-		ci.ContentType = oidEncryptedDataContentType
-		ci.Content.Class = 2
-		ci.Content.Tag = 0
-		ci.Content.IsCompound = true
-		if ci.Content.Bytes, err = asn1.Marshal(encryptedData); err != nil {
-			return
-		}
-	}
-	return
-}
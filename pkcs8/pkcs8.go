@@ -0,0 +1,37 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pkcs8 encrypts and decrypts standalone PKCS#8 private keys, the
+// "ENCRYPTED PRIVATE KEY" PEM blocks written by "openssl pkcs8 -topk8", with
+// the password-based encryption of the pkcs12 package.
+package pkcs8
+
+import (
+	"crypto"
+
+	pkcs12 "github.com/nevissecurity/go-pkcs12"
+)
+
+// PEMType is the type of the PEM blocks holding the output of Encrypt.
+const PEMType = "ENCRYPTED PRIVATE KEY"
+
+// Encrypt returns key as a DER-encoded EncryptedPrivateKeyInfo encrypted
+// with password.  The key algorithm, iteration count, salt length and
+// random number generator of opts are used, as for encrypting the key of a
+// PKCS#12 file; a nil opts means pkcs12.Modern, which uses the algorithms
+// and iteration count OpenSSL 3 uses by default.
+func Encrypt(key crypto.PrivateKey, password string, opts *pkcs12.Encoder) ([]byte, error) {
+	if opts == nil {
+		opts = pkcs12.Modern
+	}
+	return opts.EncryptPrivateKey(key, password)
+}
+
+// Decrypt decrypts a DER-encoded EncryptedPrivateKeyInfo with password.  It
+// supports PBES2 with AES and the PKCS#12 PBE schemes, and reports a wrong
+// password as pkcs12.ErrIncorrectPassword.
+func Decrypt(der []byte, password string) (crypto.PrivateKey, error) {
+	return pkcs12.DefaultDecoder.DecryptPrivateKey(der, password)
+}
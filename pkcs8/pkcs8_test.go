@@ -0,0 +1,89 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs8
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pkcs12 "github.com/nevissecurity/go-pkcs12"
+)
+
+func readPEM(t *testing.T, name string) *pem.Block {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("%s: no PEM block", name)
+	}
+	return block
+}
+
+// The files in testdata were written by OpenSSL 3.0 with
+// "openssl pkcs8 -topk8" from the same key, with the password "password".
+func TestDecryptOpenSSL(t *testing.T) {
+	expected, err := x509.ParsePKCS8PrivateKey(readPEM(t, "openssl-unencrypted.pem").Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"openssl-aes256.pem", "openssl-3des.pem", "openssl-seed.pem"} {
+		block := readPEM(t, name)
+		if block.Type != PEMType {
+			t.Errorf("%s: unexpected PEM type %q", name, block.Type)
+		}
+		key, err := Decrypt(block.Bytes, "password")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !key.(*ecdsa.PrivateKey).Equal(expected) {
+			t.Errorf("%s: decrypted key differs", name)
+		}
+		if _, err := Decrypt(block.Bytes, "wrong"); !errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			t.Errorf("%s: expected ErrIncorrectPassword, got %v", name, err)
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, opts := range map[string]*pkcs12.Encoder{
+		"default":   nil,
+		"LegacyDES": pkcs12.LegacyDES.AllowWeakAlgorithms(),
+	} {
+		der, err := Encrypt(key, "password", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		decrypted, err := Decrypt(der, "password")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !decrypted.(crypto.Signer).Public().(*ecdsa.PublicKey).Equal(&key.PublicKey) {
+			t.Errorf("%s: decrypted key differs", name)
+		}
+	}
+
+	if _, err := Encrypt(key, "password", pkcs12.LegacyDES); !errors.Is(err, pkcs12.ErrWeakAlgorithm) {
+		t.Errorf("expected ErrWeakAlgorithm, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"errors"
+	"strconv"
+)
+
+// A Platform is an importer which WithPlatformTarget tunes the output of
+// an Encoder for.  Each names the oldest release known to read the files
+// written for it; newer releases read Modern files too.
+type Platform int
+
+const (
+	// IOS12 targets iOS and iPadOS before 15, which reject files
+	// encrypted with AES: certificates are encrypted with 40-bit RC2 and
+	// keys with 3DES, under a SHA-1 MAC, as LegacyRC2 does.
+	IOS12 Platform = iota + 1
+	// Android10 targets the certificate installer of Android before 12,
+	// which cannot read PBES2: everything is encrypted with 3DES, under a
+	// SHA-1 MAC, as LegacyDES does, and the key and the certificates get
+	// generated aliases, which the installer suggests as the name.
+	Android10
+	// Win10 targets Windows 10 and Windows Server 2016, which cannot read
+	// PBES2 with AES-256 and SHA-256: everything is encrypted with 3DES,
+	// under a SHA-1 MAC, as LegacyDES does and as Windows exports.
+	Win10
+	// Java8 targets Java before 8u301, whose PKCS12 keystore cannot read
+	// PBES2: certificates are encrypted with 40-bit RC2 and keys with
+	// 3DES, under a SHA-1 MAC, as LegacyRC2 does and as the keystore
+	// writes.
+	Java8
+)
+
+// platformTargets holds the Encoder whose parameters each Platform uses.
+var platformTargets = map[Platform]*Encoder{
+	IOS12:     LegacyRC2,
+	Android10: LegacyDES.WithGeneratedAliases(true),
+	Win10:     LegacyDES,
+	Java8:     LegacyRC2,
+}
+
+// WithPlatformTarget creates a new Encoder identical to enc except that it
+// uses the MAC and encryption algorithms, iteration counts and salt lengths
+// known to be read by platform, and the aliases it needs, in place of those
+// of enc.  The layout and the other options of enc are kept.  If platform
+// is unknown, the Encode methods of the result return an error.
+//
+// Every platform needs weak algorithms, so the result must be combined
+// with AllowWeakAlgorithms to be usable, and it cannot be used in FIPS
+// mode.  It is STRONGLY RECOMMENDED to protect the files by other means, see
+// LegacyRC2.
+func (enc Encoder) WithPlatformTarget(platform Platform) *Encoder {
+	target, ok := platformTargets[platform]
+	if !ok {
+		enc.setErr(errors.New("pkcs12: unknown platform " + strconv.Itoa(int(platform))))
+		return &enc
+	}
+	enc.macAlgorithm = target.macAlgorithm
+	enc.certAlgorithm = target.certAlgorithm
+	enc.keyAlgorithm = target.keyAlgorithm
+	enc.macIterations = target.macIterations
+	enc.encryptionIterations = target.encryptionIterations
+	enc.macSaltLen = target.macSaltLen
+	enc.saltLen = target.saltLen
+	enc.pbes2Cipher = target.pbes2Cipher
+	enc.pbes2PRF = target.pbes2PRF
+	if target.generateAliases {
+		enc.generateAliases = true
+	}
+	return &enc
+}
@@ -0,0 +1,66 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/asn1"
+	"errors"
+	"testing"
+)
+
+func TestWithPlatformTarget(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	for _, test := range []struct {
+		name     string
+		platform Platform
+		certAlg  asn1.ObjectIdentifier
+		aliases  bool
+	}{
+		{"IOS12", IOS12, oidPBEWithSHAAnd40BitRC2CBC, false},
+		{"Android10", Android10, oidPBEWithSHAAnd3KeyTripleDESCBC, true},
+		{"Win10", Win10, oidPBEWithSHAAnd3KeyTripleDESCBC, false},
+		{"Java8", Java8, oidPBEWithSHAAnd40BitRC2CBC, false},
+	} {
+		enc := Modern.WithBagOrder(KeyFirst).WithPlatformTarget(test.platform)
+		if _, err := enc.Encode(key, cert, nil, DefaultPassword); !errors.Is(err, ErrWeakAlgorithm) {
+			t.Errorf("%s: expected ErrWeakAlgorithm, got %v", test.name, err)
+		}
+		pfxData, err := enc.AllowWeakAlgorithms().Encode(key, cert, nil, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		probe, err := Probe(pfxData)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !probe.MAC.Algorithm.Equal(oidSHA1) || probe.MAC.Iterations != 2048 {
+			t.Errorf("%s: unexpected MAC %+v", test.name, probe.MAC)
+		}
+		// The key comes first, as selected before the target.
+		keyInfo, certInfo := probe.SafeContents[0], probe.SafeContents[1]
+		if keyInfo.Encryption != nil || len(keyInfo.Bags) != 1 || !keyInfo.Bags[0].Encryption.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC) {
+			t.Errorf("%s: unexpected key SafeContents %+v", test.name, keyInfo)
+		}
+		if certInfo.Encryption == nil || !certInfo.Encryption.Algorithm.Equal(test.certAlg) {
+			t.Errorf("%s: certificates encrypted with %+v", test.name, certInfo.Encryption)
+		}
+
+		contents, err := DecodeContents(pfxData, DefaultPassword)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if name := contents[0].Entries[0].FriendlyName(); (name != "") != test.aliases {
+			t.Errorf("%s: key has friendlyName %q", test.name, name)
+		}
+	}
+
+	if _, err := Modern.WithPlatformTarget(0).AllowWeakAlgorithms().Encode(key, cert, nil, DefaultPassword); err == nil {
+		t.Error("WithPlatformTarget accepted an unknown platform")
+	}
+}
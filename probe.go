@@ -0,0 +1,350 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math"
+)
+
+// oidScrypt identifies the scrypt key derivation function, see
+// https://tools.ietf.org/html/rfc7914#section-7.  Probe describes it, but
+// it cannot be decrypted.
+var oidScrypt = asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 11591, 4, 11})
+
+// scryptParams are the parameters of scrypt, see
+// https://tools.ietf.org/html/rfc7914#section-7.1
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// A ProbeResult describes the structure of a PKCS#12 file as far as it can
+// be determined without the password.
+type ProbeResult struct {
+	Version int
+	// MAC is nil if the file has no MacData.
+	MAC          *MACInfo
+	SafeContents []SafeContentsInfo
+	// AuthenticatedSafe is the DER encoding of the authenticated safe,
+	// byte for byte as stored, with the segments of a constructed OCTET
+	// STRING joined.  This is the message the MAC is computed over; the
+	// decoder verifies the MAC over these bytes rather than over a
+	// re-encoding, which could differ for files that are not strictly
+	// DER.
+	AuthenticatedSafe []byte
+}
+
+// MACInfo describes the MAC of a PKCS#12 file.
+type MACInfo struct {
+	Algorithm  asn1.ObjectIdentifier
+	Name       string
+	Iterations int
+	SaltLen    int
+	// Salt and Digest are the macSalt and the MAC value, for verifying
+	// the MAC over AuthenticatedSafe elsewhere.
+	Salt   []byte
+	Digest []byte
+}
+
+// EncryptionInfo describes a password-based encryption algorithm.
+type EncryptionInfo struct {
+	Algorithm asn1.ObjectIdentifier
+	Name      string
+	// Iterations and SaltLen are zero if the parameters could not be
+	// decoded.
+	Iterations int
+	SaltLen    int
+	// PRF and Cipher are only set for PBES2.
+	PRF    asn1.ObjectIdentifier
+	Cipher asn1.ObjectIdentifier
+	// KDF is the key derivation function of PBES2, PBKDF2 or scrypt.
+	KDF asn1.ObjectIdentifier
+	// Scrypt is set for PBES2 with scrypt, which this package does not
+	// decrypt, so that its cost can still be judged.
+	Scrypt *ScryptParams
+}
+
+// ScryptParams are the cost parameters of scrypt, see
+// https://tools.ietf.org/html/rfc7914#section-2.
+type ScryptParams struct {
+	// N is the CPU/memory cost parameter.
+	N int
+	// R is the block size and P the parallelization parameter.
+	R int
+	P int
+}
+
+// A KDFCost estimates the work of deriving the keys of a PKCS#12 file from
+// its password, before anything is decrypted, so that services decoding
+// untrusted uploads can reject absurdly expensive files or queue them
+// apart.  Sums saturate at the largest int.
+type KDFCost struct {
+	// Derivations counts the key derivations seen: the MAC, every
+	// encrypted SafeContents and every shrouded key bag of a plain
+	// SafeContents.
+	Derivations int
+	// Iterations is the total of the iteration counts of the PKCS#12 and
+	// PBKDF2 derivations, and MaxIterations the largest of them.
+	Iterations    int
+	MaxIterations int
+	// ScryptMemory is the memory, in bytes, which the most demanding
+	// scrypt derivation needs: 128·N·r.
+	ScryptMemory int
+	// Incomplete is set if the file may cost more: encrypted
+	// SafeContents can hold shrouded key bags, which are only seen once
+	// decrypted, and some parameters may not have been decoded.
+	Incomplete bool
+}
+
+// SafeContentsInfo describes one SafeContents of the authenticated safe.
+type SafeContentsInfo struct {
+	// Encryption is nil if the SafeContents is stored as plain data.
+	Encryption *EncryptionInfo
+	// Bags lists the bags of a plain SafeContents.  It is nil if the
+	// SafeContents is encrypted, since the bags cannot be seen without the
+	// password.
+	Bags []BagInfo
+}
+
+// BagInfo describes a safe bag found in a plain SafeContents.
+type BagInfo struct {
+	Type asn1.ObjectIdentifier
+	// Encryption is set for PKCS#8 shrouded key bags.
+	Encryption *EncryptionInfo
+	// Certificate is set for certificate bags holding a certificate that
+	// could be parsed.
+	Certificate *x509.Certificate
+}
+
+// describeEncryption returns the parameters of a password-based encryption
+// AlgorithmIdentifier, as far as they are understood.
+func describeEncryption(algorithm pkix.AlgorithmIdentifier) *EncryptionInfo {
+	info := &EncryptionInfo{
+		Algorithm: algorithm.Algorithm,
+		Name:      algorithmName(algorithm.Algorithm),
+	}
+
+	switch {
+	case algorithm.Algorithm.Equal(oidPBES2):
+		var params pbes2Params
+		if err := unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			break
+		}
+		if params.Kdf.Algorithm.Equal(oidScrypt) {
+			var kdfParams scryptParams
+			if err := unmarshal(params.Kdf.Parameters.FullBytes, &kdfParams); err != nil {
+				break
+			}
+			info.KDF = oidScrypt
+			info.SaltLen = len(kdfParams.Salt)
+			info.Scrypt = &ScryptParams{N: kdfParams.CostParameter, R: kdfParams.BlockSize, P: kdfParams.ParallelizationParameter}
+			info.Cipher = params.EncryptionScheme.Algorithm
+			info.Name += "(scrypt, " + algorithmName(info.Cipher) + ")"
+			break
+		}
+		_, kdfParams, err := parsePBES2Params(algorithm)
+		if err != nil {
+			break
+		}
+		info.KDF = oidPBKDF2
+		info.Iterations = kdfParams.Iterations
+		info.SaltLen = len(kdfParams.Salt)
+		info.PRF = kdfParams.Prf.Algorithm
+		if len(info.PRF) == 0 {
+			info.PRF = oidHmacWithSHA1
+		}
+		info.Cipher = params.EncryptionScheme.Algorithm
+		info.Name += "(" + algorithmName(info.PRF) + ", " + algorithmName(info.Cipher) + ")"
+	default:
+		var params pbeParams
+		if err := unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			break
+		}
+		info.Iterations = params.Iterations
+		info.SaltLen = len(params.Salt)
+	}
+
+	return info
+}
+
+// Probe describes the structure of pfxData without verifying its MAC or
+// decrypting anything: the MAC and encryption algorithms with their
+// parameters, and the bags stored in plain SafeContents.  DefaultLimits
+// are applied, and PEM or base64 armor is stripped like DefaultDecoder
+// does.  Files of any version are described.  The KDFCost of the result
+// estimates the work decoding would take.
+func Probe(pfxData []byte) (*ProbeResult, error) {
+	pfxData, err := unarmor(pfxData)
+	if err != nil {
+		return nil, err
+	}
+	if pfxData, err = DefaultLimits.berToDER(pfxData); err != nil {
+		return nil, err
+	}
+	pfx, err := parsePFX(pfxData, DefaultLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProbeResult{Version: pfx.Version, AuthenticatedSafe: pfx.AuthSafe.Content.Bytes}
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) != 0 {
+		result.MAC = &MACInfo{
+			Algorithm:  pfx.MacData.Mac.Algorithm.Algorithm,
+			Name:       algorithmName(pfx.MacData.Mac.Algorithm.Algorithm),
+			Iterations: pfx.MacData.Iterations,
+			SaltLen:    len(pfx.MacData.MacSalt),
+			Salt:       pfx.MacData.MacSalt,
+			Digest:     pfx.MacData.Mac.Digest,
+		}
+	}
+
+	authenticatedSafeDER, err := DefaultLimits.berToDER(pfx.AuthSafe.Content.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	authenticatedSafe, err := parseAuthenticatedSafe(authenticatedSafeDER)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ci := range authenticatedSafe {
+		var info SafeContentsInfo
+
+		switch {
+		case ci.ContentType.Equal(oidDataContentType):
+			var data []byte
+			if err := unmarshal(ci.Content.Bytes, &data); err != nil {
+				return nil, err
+			}
+			if data, err = DefaultLimits.berToDER(data); err != nil {
+				return nil, err
+			}
+			if err := DefaultLimits.checkDER(data); err != nil {
+				return nil, err
+			}
+			safeContents, err := parseSafeContents(data)
+			if err != nil {
+				return nil, err
+			}
+			if err := DefaultLimits.checkBagCount(len(safeContents)); err != nil {
+				return nil, err
+			}
+			info.Bags = make([]BagInfo, 0, len(safeContents))
+			for _, bag := range safeContents {
+				info.Bags = append(info.Bags, probeBag(&bag))
+			}
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			var encryptedData lenientEncryptedData
+			if err := unmarshal(ci.Content.Bytes, &encryptedData); err != nil {
+				return nil, err
+			}
+			info.Encryption = describeEncryption(encryptedData.EncryptedContentInfo.Algorithm())
+		default:
+			return nil, NotImplementedError("only data and encryptedData content types are supported in authenticated safe")
+		}
+
+		result.SafeContents = append(result.SafeContents, info)
+	}
+
+	return result, nil
+}
+
+// KDFCost estimates the cost of the key derivations of the file r
+// describes, see KDFCost.
+func (r *ProbeResult) KDFCost() KDFCost {
+	var cost KDFCost
+	if r.MAC != nil {
+		cost.addIterations(r.MAC.Iterations)
+	}
+	for _, sc := range r.SafeContents {
+		if sc.Encryption != nil {
+			cost.add(sc.Encryption)
+			// It may hold shrouded key bags.
+			cost.Incomplete = true
+		}
+		for _, bag := range sc.Bags {
+			if bag.Encryption != nil {
+				cost.add(bag.Encryption)
+			} else if bag.Type.Equal(oidPKCS8ShroundedKeyBag) {
+				cost.Derivations = saturatingAdd(cost.Derivations, 1)
+				cost.Incomplete = true
+			}
+		}
+	}
+	return cost
+}
+
+// add counts the key derivation of info.
+func (c *KDFCost) add(info *EncryptionInfo) {
+	if s := info.Scrypt; s != nil {
+		c.Derivations = saturatingAdd(c.Derivations, 1)
+		if s.N <= 0 || s.R <= 0 || s.P <= 0 {
+			c.Incomplete = true
+			return
+		}
+		if memory := saturatingMul(128, saturatingMul(s.N, s.R)); memory > c.ScryptMemory {
+			c.ScryptMemory = memory
+		}
+		return
+	}
+	c.addIterations(info.Iterations)
+}
+
+// addIterations counts a derivation with the given iteration count, which
+// is zero or negative if it is not known.
+func (c *KDFCost) addIterations(iterations int) {
+	c.Derivations = saturatingAdd(c.Derivations, 1)
+	if iterations <= 0 {
+		c.Incomplete = true
+		return
+	}
+	c.Iterations = saturatingAdd(c.Iterations, iterations)
+	if iterations > c.MaxIterations {
+		c.MaxIterations = iterations
+	}
+}
+
+// saturatingAdd returns a+b, or the largest int if that overflows.  a and
+// b must not be negative.
+func saturatingAdd(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// saturatingMul returns a·b, or the largest int if that overflows.  a and
+// b must be positive.
+func saturatingMul(a, b int) int {
+	if a > math.MaxInt/b {
+		return math.MaxInt
+	}
+	return a * b
+}
+
+func probeBag(bag *safeBag) BagInfo {
+	info := BagInfo{Type: bag.Id}
+
+	switch {
+	case bag.Id.Equal(oidPKCS8ShroundedKeyBag):
+		pkinfo := new(encryptedPrivateKeyInfo)
+		if err := unmarshal(bag.Value.Bytes, pkinfo); err == nil {
+			info.Encryption = describeEncryption(pkinfo.Algorithm())
+		}
+	case bag.Id.Equal(oidCertBag):
+		if certData, err := decodeCertBag(bag.Value.Bytes); err == nil {
+			info.Certificate, _ = x509.ParseCertificate(certData)
+		}
+	}
+
+	return info
+}
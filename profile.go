@@ -0,0 +1,215 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+)
+
+// payloadTypePKCS12 is the PayloadType of the PKCS#12 payloads of an Apple
+// configuration profile.
+const payloadTypePKCS12 = "com.apple.security.pkcs12"
+
+// A ProfilePKCS12 is a PKCS#12 payload of an Apple configuration profile.
+type ProfilePKCS12 struct {
+	// Identifier is the PayloadIdentifier of the payload.
+	Identifier string
+	// Password is the Password of the payload, or "" if the profile does
+	// not include it and the user is asked for it on installation.
+	Password string
+	// Data is the PKCS#12 file.
+	Data []byte
+}
+
+// ProfilePKCS12Payloads returns the PKCS#12 payloads of profile, an Apple
+// configuration profile (.mobileconfig) as distributed by MDM servers and
+// Apple Configurator.  profile is either an XML property list or such a
+// list signed as PKCS#7 SignedData, whose signature is not verified.
+// Binary property lists are not supported.
+//
+// The files exported by the macOS Keychain and embedded in profiles
+// encrypt the certificates with 40-bit RC2 and the keys with 3DES, under a
+// SHA-1 MAC, which DefaultDecoder reads; Data can be passed to any of the
+// decoding functions.
+func ProfilePKCS12Payloads(profile []byte) ([]ProfilePKCS12, error) {
+	if len(profile) != 0 && profile[0] == 0x30 {
+		var err error
+		if profile, err = signedContent(profile); err != nil {
+			return nil, err
+		}
+	}
+	if bytes.HasPrefix(profile, []byte("bplist")) {
+		return nil, NotImplementedError("binary property lists are not supported")
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(profile))
+	var root interface{}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, errors.New("pkcs12: error reading property list: " + err.Error())
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			if start.Name.Local != "plist" {
+				return nil, errors.New("pkcs12: not a property list")
+			}
+			if root, err = decodePlistValue(d, nil); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	var payloads []ProfilePKCS12
+	collectPKCS12Payloads(root, &payloads)
+	return payloads, nil
+}
+
+// collectPKCS12Payloads appends the PKCS#12 payloads found anywhere in v,
+// a decoded property list value, to payloads.
+func collectPKCS12Payloads(v interface{}, payloads *[]ProfilePKCS12) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v["PayloadType"] == payloadTypePKCS12 {
+			if data, ok := v["PayloadContent"].([]byte); ok {
+				payload := ProfilePKCS12{Data: data}
+				payload.Identifier, _ = v["PayloadIdentifier"].(string)
+				payload.Password, _ = v["Password"].(string)
+				*payloads = append(*payloads, payload)
+				return
+			}
+		}
+		for _, value := range v {
+			collectPKCS12Payloads(value, payloads)
+		}
+	case []interface{}:
+		for _, value := range v {
+			collectPKCS12Payloads(value, payloads)
+		}
+	}
+}
+
+// decodePlistValue decodes the next value of a property list: a dict as a
+// map[string]interface{}, an array as a []interface{}, data as a []byte,
+// true and false as a bool, and string, integer, real and date elements as
+// strings.  If start is nil, the element is read from d first.  It returns
+// nil at the end of the enclosing element.
+func decodePlistValue(d *xml.Decoder, start *xml.StartElement) (interface{}, error) {
+	for start == nil {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, errors.New("pkcs12: error reading property list: " + err.Error())
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			start = &tok
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]interface{})
+		for {
+			key, err := decodePlistValue(d, nil)
+			if err != nil {
+				return nil, err
+			}
+			if key == nil {
+				return dict, nil
+			}
+			name, ok := key.(plistKey)
+			if !ok {
+				return nil, errors.New("pkcs12: malformed property list dict")
+			}
+			value, err := decodePlistValue(d, nil)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				return nil, errors.New("pkcs12: malformed property list dict")
+			}
+			dict[string(name)] = value
+		}
+	case "array":
+		array := []interface{}{}
+		for {
+			value, err := decodePlistValue(d, nil)
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				return array, nil
+			}
+			array = append(array, value)
+		}
+	case "true", "false":
+		if err := d.Skip(); err != nil {
+			return nil, errors.New("pkcs12: error reading property list: " + err.Error())
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := d.DecodeElement(&text, start); err != nil {
+		return nil, errors.New("pkcs12: error reading property list: " + err.Error())
+	}
+	switch start.Name.Local {
+	case "key":
+		return plistKey(text), nil
+	case "data":
+		data, err := base64.StdEncoding.DecodeString(string(stripSpace([]byte(text))))
+		if err != nil {
+			return nil, errors.New("pkcs12: error decoding property list data: " + err.Error())
+		}
+		return data, nil
+	}
+	return text, nil
+}
+
+// A plistKey is the key of a dict entry, kept apart from string values.
+type plistKey string
+
+// signedContent returns the encapsulated content of a DER-encoded PKCS#7
+// SignedData, without verifying its signatures.
+func signedContent(der []byte) ([]byte, error) {
+	var ci contentInfo
+	if err := unmarshal(der, &ci); err != nil {
+		return nil, errors.New("pkcs12: error decoding PKCS#7 content info: " + err.Error())
+	}
+	if !ci.ContentType.Equal(oidSignedDataContentType) {
+		return nil, NotImplementedError("only PKCS#7 signed data is supported")
+	}
+
+	// As in DecodeP7B, SignedData is parsed element by element, up to
+	// the encapsulated content.
+	var signedData asn1.RawValue
+	if err := unmarshal(ci.Content.Bytes, &signedData); err != nil {
+		return nil, errors.New("pkcs12: error decoding PKCS#7 signed data: " + err.Error())
+	}
+	rest := signedData.Bytes
+	var version int
+	var digestAlgorithms asn1.RawValue
+	var encapContentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     []byte `asn1:"explicit,optional,tag:0"`
+	}
+	for _, field := range []interface{}{&version, &digestAlgorithms, &encapContentInfo} {
+		var err error
+		if rest, err = asn1.Unmarshal(rest, field); err != nil {
+			return nil, errors.New("pkcs12: error decoding PKCS#7 signed data: " + err.Error())
+		}
+	}
+	if encapContentInfo.Content == nil {
+		return nil, errors.New("pkcs12: PKCS#7 signed data has no content")
+	}
+	return encapContentInfo.Content, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"os"
+	"testing"
+)
+
+func TestProfilePKCS12Payloads(t *testing.T) {
+	// The profile embeds a file written by "openssl pkcs12 -export
+	// -legacy", which is laid out like a Keychain export; the signed one
+	// was signed with "openssl cms -sign -nodetach -outform DER".
+	for _, file := range []string{"testdata/openssl-device.mobileconfig", "testdata/openssl-signed.mobileconfig"} {
+		profile, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payloads, err := ProfilePKCS12Payloads(profile)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if len(payloads) != 1 {
+			t.Fatalf("%s: expected one payload, got %d", file, len(payloads))
+		}
+		payload := payloads[0]
+		if payload.Identifier != "com.example.profile.pkcs12" || payload.Password != "password" {
+			t.Errorf("%s: unexpected payload %q, %q", file, payload.Identifier, payload.Password)
+		}
+
+		privateKey, certificate, err := Decode(payload.Data, payload.Password)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if certificate.Subject.CommonName != "device.example.com" {
+			t.Errorf("%s: unexpected certificate %s", file, certificate.Subject)
+		}
+		if err := MatchKeyToCert(privateKey, certificate); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+}
+
+func TestProfilePKCS12PayloadsErrors(t *testing.T) {
+	for _, profile := range []string{
+		"bplist00",
+		"<html></html>",
+		"<plist><dict><key>PayloadType</key></dict></plist>",
+		"<plist><dict><key>PayloadContent</key><data>!!</data></dict></plist>",
+	} {
+		if _, err := ProfilePKCS12Payloads([]byte(profile)); err == nil {
+			t.Errorf("%q: expected an error", profile)
+		}
+	}
+
+	payloads, err := ProfilePKCS12Payloads([]byte("<plist><dict><key>PayloadType</key><string>Configuration</string></dict></plist>"))
+	if err != nil || len(payloads) != 0 {
+		t.Errorf("expected no payloads, got %v, %v", payloads, err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/asn1"
+	"errors"
+	"strconv"
+)
+
+// RewrapKeys returns pfxData with each of its PKCS#8 shrouded key bags
+// encrypted anew with the key algorithm, iteration count and salt length
+// of enc, under the same password, and everything else left as it is: the
+// PKCS#8 encoding of the keys, including any attributes of theirs, the
+// other bags and the attributes of all bags keep their encoding byte for
+// byte, and SafeContents without a key are copied without being encrypted
+// again.  The MAC keeps its algorithm, salt and iteration count, and only
+// its digest is computed anew.  This upgrades the protection of the keys of
+// a large file, or of one written by another implementation, with less risk
+// of disturbing its structure than decoding and encoding it.
+//
+// An encrypted SafeContents which holds a key, as written by
+// WithSingleSafeContents, has to be encrypted again, and is encrypted with
+// the certificate algorithm of enc.  Keys nested in safeContentsBags are
+// left as they are.  RewrapKeys uses DefaultDecoder to read pfxData.
+func (enc *Encoder) RewrapKeys(pfxData []byte, password string) ([]byte, error) {
+	if err := enc.checkOptions(); err != nil {
+		return nil, err
+	}
+
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	dec := DefaultDecoder
+	p12Data, err := dec.unarmor(pfxData)
+	if err != nil {
+		return nil, err
+	}
+	authenticatedSafe, _, updatedPassword, err := dec.verifyAuthenticatedSafe(p12Data, encodedPassword, nil)
+	if err != nil {
+		return nil, err
+	}
+	limits := dec.limits.withDefaults()
+
+	var spent usage
+	for i := range authenticatedSafe {
+		ci := &authenticatedSafe[i]
+		sc, err := dec.decodeSafeContents(ci, nil, updatedPassword, limits, nil)
+		if err == nil {
+			err = limits.add(&spent, &sc)
+		}
+		if err != nil {
+			return nil, locateParseError(err, "authSafe["+strconv.Itoa(i)+"]", p12Data)
+		}
+		rewrapped, err := enc.rewrapKeyBags(dec, sc.bags, updatedPassword, limits, &spent)
+		if err != nil {
+			return nil, err
+		}
+		if !rewrapped {
+			continue
+		}
+		var algorithm asn1.ObjectIdentifier
+		if sc.encrypted {
+			algorithm = enc.certsAlgorithm()
+		}
+		if *ci, err = enc.makeSafeContents(sc.bags, algorithm, updatedPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	pfx, err := parsePFX(p12Data, limits)
+	if err != nil {
+		return nil, err
+	}
+	authenticatedSafeBytes, err := asn1.Marshal(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+	if err = computeMac(enc.kdfContext(), &pfx.MacData, authenticatedSafeBytes, updatedPassword); err != nil {
+		return nil, err
+	}
+	if pfx.AuthSafe.Content, err = enc.authSafeEncoding.content(authenticatedSafeBytes); err != nil {
+		return nil, err
+	}
+
+	if pfxData, err = asn1.Marshal(*pfx); err != nil {
+		return nil, errors.New("pkcs12: error writing P12 data: " + err.Error())
+	}
+	return enc.applyArmor(pfxData), nil
+}
+
+// RewrapKeys is equivalent to Modern.RewrapKeys.
+func RewrapKeys(pfxData []byte, password string) ([]byte, error) {
+	return Modern.RewrapKeys(pfxData, password)
+}
+
+// rewrapKeyBags encrypts the PKCS#8 shrouded key bags among bags anew, in
+// place, and reports whether there were any.  The decrypted keys are added
+// to spent and checked against limits.
+func (enc *Encoder) rewrapKeyBags(dec *Decoder, bags []safeBag, password []byte, limits Limits, spent *usage) (rewrapped bool, err error) {
+	for i := range bags {
+		bag := &bags[i]
+		if !bag.Id.Equal(oidPKCS8ShroundedKeyBag) {
+			continue
+		}
+		pkData, err := dec.decryptPkcs8ShroudedKeyBag(bag.Value.Bytes, password)
+		if err != nil {
+			return false, err
+		}
+		if err := limits.addDecrypted(spent, len(pkData)); err != nil {
+			wipe(pkData)
+			return false, err
+		}
+		value, err := enc.encryptPKCS8(pkData, password)
+		wipe(pkData)
+		if err != nil {
+			return false, err
+		}
+		// Clearing Raw and FullBytes makes the bag be encoded from its
+		// fields; the attributes keep the encoding they were stored with.
+		bag.Raw = nil
+		bag.Value = asn1.RawValue{Class: 2, Tag: 0, IsCompound: true, Bytes: value}
+		rewrapped = true
+	}
+	return rewrapped, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRewrapKeys(t *testing.T) {
+	pfxData, err := os.ReadFile("testdata/openssl-legacy-chain.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := DecodeContents(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, err := RewrapKeys(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(rewrapped, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !EqualContents(original, contents) {
+		t.Error("contents differ after rewrapping the keys")
+	}
+
+	before, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Probe(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The MAC value changes with the rewrapped keys, its parameters do
+	// not.
+	beforeMAC, afterMAC := *before.MAC, *after.MAC
+	beforeMAC.Digest, afterMAC.Digest = nil, nil
+	if !reflect.DeepEqual(afterMAC, beforeMAC) || len(after.SafeContents) != len(before.SafeContents) {
+		t.Errorf("MAC or layout changed: %+v, %+v", beforeMAC, afterMAC)
+	}
+	for i, sc := range after.SafeContents {
+		if sc.Encryption != nil {
+			if !reflect.DeepEqual(sc.Encryption, before.SafeContents[i].Encryption) {
+				t.Errorf("SafeContents %d: encryption changed from %+v to %+v", i, before.SafeContents[i].Encryption, sc.Encryption)
+			}
+			continue
+		}
+		for j, bag := range sc.Bags {
+			if bag.Encryption != nil && !bag.Encryption.Algorithm.Equal(oidPBES2) {
+				t.Errorf("SafeContents %d, bag %d: key encrypted with %s", i, j, bag.Encryption.Name)
+			}
+		}
+	}
+	// The certificates and the attributes of the key keep their encoding.
+	for i := range original {
+		for j := range original[i].Entries {
+			o, r := &original[i].Entries[j], &contents[i].Entries[j]
+			if o.BagType == CertBag && !bytes.Equal(o.RawBag, r.RawBag) {
+				t.Errorf("SafeContents %d, bag %d: certificate bag changed", i, j)
+			}
+		}
+	}
+
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	single, err := LegacyDES.AllowWeakAlgorithms().WithSingleSafeContents(true).Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewrapped, err = RewrapKeys(single, DefaultPassword); err != nil {
+		t.Fatal(err)
+	}
+	if decodedKey, _, err := Decode(rewrapped, DefaultPassword); err != nil || !key.Equal(decodedKey) {
+		t.Errorf("key differs after rewrapping a single SafeContents: %v", err)
+	}
+
+	if _, err := RewrapKeys(pfxData, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+	if _, err := LegacyDES.RewrapKeys(pfxData, "password"); err == nil {
+		t.Error("rewrapped with weak algorithms without AllowWeakAlgorithms")
+	}
+}
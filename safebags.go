@@ -6,17 +6,22 @@
 package pkcs12
 
 import (
+	"context"
+	"crypto"
 	"crypto/x509"
 	"encoding/asn1"
 	"errors"
-	"io"
 )
 
 var (
 	// see https://tools.ietf.org/html/rfc7292#appendix-D
 	oidCertTypeX509Certificate = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 9, 22, 1})
+	oidKeyBag                  = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 10, 1, 1})
 	oidPKCS8ShroundedKeyBag    = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 10, 1, 2})
 	oidCertBag                 = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 10, 1, 3})
+	oidCRLBag                  = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 10, 1, 4})
+	oidSecretBag               = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 10, 1, 5})
+	oidSafeContentsBag         = asn1.ObjectIdentifier([]int{1, 2, 840, 113549, 1, 12, 10, 1, 6})
 )
 
 type certBag struct {
@@ -24,20 +29,17 @@ type certBag struct {
 	Data []byte `asn1:"tag:0,explicit"`
 }
 
-func decodePkcs8ShroudedKeyBag(asn1Data, password []byte) (privateKey interface{}, err error) {
-	pkinfo := new(encryptedPrivateKeyInfo)
-	if err = unmarshal(asn1Data, pkinfo); err != nil {
-		return nil, errors.New("pkcs12: error decoding PKCS#8 shrouded key bag: " + err.Error())
-	}
-
-	pkData, err := pbDecrypt(pkinfo, password)
+// decodePkcs8ShroudedKeyBag decrypts and parses the private key held in a
+// shrouded key bag.  The decrypted key is added to spent and checked
+// against the limits of dec.
+func (dec *Decoder) decodePkcs8ShroudedKeyBag(asn1Data, password []byte, spent *usage) (privateKey crypto.PrivateKey, err error) {
+	pkData, err := dec.decryptPkcs8ShroudedKeyBag(asn1Data, password)
 	if err != nil {
-		return nil, errors.New("pkcs12: error decrypting PKCS#8 shrouded key bag: " + err.Error())
+		return nil, err
 	}
-
-	ret := new(asn1.RawValue)
-	if err = unmarshal(pkData, ret); err != nil {
-		return nil, errors.New("pkcs12: error unmarshaling decrypted private key: " + err.Error())
+	defer wipe(pkData)
+	if err := dec.limits.withDefaults().addDecrypted(spent, len(pkData)); err != nil {
+		return nil, err
 	}
 
 	if privateKey, err = x509.ParsePKCS8PrivateKey(pkData); err != nil {
@@ -47,34 +49,49 @@ func decodePkcs8ShroudedKeyBag(asn1Data, password []byte) (privateKey interface{
 	return privateKey, nil
 }
 
-func encodePkcs8ShroudedKeyBag(rand io.Reader, privateKey interface{}, password []byte) (asn1Data []byte, err error) {
-	var pkData []byte
-	if pkData, err = x509.MarshalPKCS8PrivateKey(privateKey); err != nil {
-		return nil, errors.New("pkcs12: error encoding PKCS#8 private key: " + err.Error())
+// decryptPkcs8ShroudedKeyBag returns the DER-encoded PKCS#8 private key
+// held in a shrouded key bag.  The caller should wipe the result once it is
+// no longer needed.
+func (dec *Decoder) decryptPkcs8ShroudedKeyBag(asn1Data, password []byte) (pkData []byte, err error) {
+	pkinfo := new(encryptedPrivateKeyInfo)
+	if err = unmarshal(asn1Data, pkinfo); err != nil {
+		return nil, errors.New("pkcs12: error decoding PKCS#8 shrouded key bag: " + err.Error())
 	}
 
-	randomSalt := make([]byte, 8)
-	if _, err = rand.Read(randomSalt); err != nil {
-		return nil, errors.New("pkcs12: error reading random salt: " + err.Error())
-	}
-	var paramBytes []byte
-	if paramBytes, err = asn1.Marshal(pbeParams{Salt: randomSalt, Iterations: 2048}); err != nil {
-		return nil, errors.New("pkcs12: error encoding params: " + err.Error())
+	if err = dec.checkEncryptionAlgorithm(pkinfo.Algorithm()); err != nil {
+		return nil, err
 	}
 
-	var pkinfo encryptedPrivateKeyInfo
-	pkinfo.AlgorithmIdentifier.Algorithm = oidPBEWithSHAAnd3KeyTripleDESCBC
-	pkinfo.AlgorithmIdentifier.Parameters.FullBytes = paramBytes
+	pkData, err = decryptPrivateKeyInfo(dec.kdfContext(), pkinfo, password)
+	if err == ErrIncorrectPassword && dec.passwordFunc != nil {
+		err = dec.retryPassword(PasswordHintPrivateKey, func(password []byte) (err error) {
+			pkData, err = decryptPrivateKeyInfo(dec.kdfContext(), pkinfo, password)
+			return
+		})
+	}
+	return pkData, err
+}
 
-	if err = pbEncrypt(&pkinfo, pkData, password); err != nil {
-		return nil, errors.New("pkcs12: error encrypting PKCS#8 shrouded key bag: " + err.Error())
+// decryptPrivateKeyInfo decrypts pkinfo with password.  A padding failure
+// and a result which is not DER both mean that the password is incorrect.
+func decryptPrivateKeyInfo(ctx context.Context, pkinfo *encryptedPrivateKeyInfo, password []byte) (pkData []byte, err error) {
+	if pkData, err = pbDecrypt(ctx, pkinfo, password); err != nil {
+		if err == ErrDecryption {
+			return nil, ErrIncorrectPassword
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, errors.New("pkcs12: error decrypting PKCS#8 shrouded key bag: " + err.Error())
 	}
 
-	if asn1Data, err = asn1.Marshal(pkinfo); err != nil {
-		return nil, errors.New("pkcs12: error encoding PKCS#8 shrouded key bag: " + err.Error())
+	ret := new(asn1.RawValue)
+	if err = unmarshal(pkData, ret); err != nil {
+		wipe(pkData)
+		return nil, ErrIncorrectPassword
 	}
 
-	return asn1Data, nil
+	return pkData, nil
 }
 
 func decodeCertBag(asn1Data []byte) (x509Certificates []byte, err error) {
@@ -0,0 +1,233 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"golang.org/x/crypto/cryptobyte"
+	cryptobyte_asn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// The PFX PDU, the authenticated safe, the SafeContents and the values of
+// bag attributes are parsed with cryptobyte rather than encoding/asn1, whose
+// reflection allocates for every field of every bag.  The results are the
+// same as asn1.Unmarshal's: the raw fields are subslices of the input, from
+// which ParseError offsets are computed.  What is left to encoding/asn1 is
+// parsed at most once per key or SafeContents, such as encryption
+// parameters and PKCS#8 keys, or into a type of the caller's choosing, by
+// Attribute.Unmarshal.
+
+var (
+	errMalformedPFX          = errors.New("pkcs12: malformed PFX PDU")
+	errMalformedAuthSafe     = errors.New("pkcs12: malformed authenticated safe")
+	errMalformedSafeContents = errors.New("pkcs12: malformed SafeContents")
+)
+
+// explicitTag0 is the tag of the content of a ContentInfo and of the value
+// of a SafeBag.
+var explicitTag0 = cryptobyte_asn1.Tag(0).ContextSpecific().Constructed()
+
+// parsePFXPdu parses der, the DER encoding of a PFX PDU.  Unlike
+// asn1.Unmarshal with pfxPdu, it takes any element following the content
+// type of the authSafe as its content, so that an implicitly tagged one is
+// found, see AuthSafeImplicit; parseAuthSafeContent checks it.
+func parsePFXPdu(der []byte) (*pfxPdu, error) {
+	seq, ok := readSequenceOf(der)
+	if !ok {
+		return nil, errMalformedPFX
+	}
+	pfx := new(pfxPdu)
+	if !seq.ReadASN1Integer(&pfx.Version) || !readContentInfo(&seq, &pfx.AuthSafe, true) {
+		return nil, errMalformedPFX
+	}
+	if seq.PeekASN1Tag(cryptobyte_asn1.SEQUENCE) && !readMacData(&seq, &pfx.MacData) {
+		return nil, errMalformedPFX
+	}
+	return pfx, nil
+}
+
+// readMacData reads a MacData from s into mac.  Like asn1.Unmarshal, it
+// copies the digest and the salt.
+func readMacData(s *cryptobyte.String, mac *macData) bool {
+	var md, di, digest cryptobyte.String
+	if !s.ReadASN1(&md, cryptobyte_asn1.SEQUENCE) || !md.ReadASN1(&di, cryptobyte_asn1.SEQUENCE) ||
+		!readAlgorithmIdentifier(&di, &mac.Mac.Algorithm) || !di.ReadASN1(&digest, cryptobyte_asn1.OCTET_STRING) {
+		return false
+	}
+	mac.Mac.Digest = append([]byte{}, digest...)
+	if md.PeekASN1Tag(cryptobyte_asn1.OCTET_STRING) {
+		var salt cryptobyte.String
+		if !md.ReadASN1(&salt, cryptobyte_asn1.OCTET_STRING) {
+			return false
+		}
+		mac.MacSalt = append([]byte{}, salt...)
+	}
+	mac.Iterations = 1
+	return !md.PeekASN1Tag(cryptobyte_asn1.INTEGER) || md.ReadASN1Integer(&mac.Iterations)
+}
+
+// readAlgorithmIdentifier reads an AlgorithmIdentifier from s into
+// algorithm.
+func readAlgorithmIdentifier(s *cryptobyte.String, algorithm *pkix.AlgorithmIdentifier) bool {
+	var ai cryptobyte.String
+	if !s.ReadASN1(&ai, cryptobyte_asn1.SEQUENCE) || !ai.ReadASN1ObjectIdentifier(&algorithm.Algorithm) {
+		return false
+	}
+	return ai.Empty() || readRawValue(&ai, &algorithm.Parameters)
+}
+
+// parseAuthenticatedSafe parses der, the DER encoding of an
+// AuthenticatedSafe.
+func parseAuthenticatedSafe(der []byte) ([]contentInfo, error) {
+	seq, ok := readSequenceOf(der)
+	if !ok {
+		return nil, errMalformedAuthSafe
+	}
+	authenticatedSafe := make([]contentInfo, 0, countElements(seq))
+	for !seq.Empty() {
+		var ci contentInfo
+		if !readContentInfo(&seq, &ci, false) {
+			return nil, errMalformedAuthSafe
+		}
+		authenticatedSafe = append(authenticatedSafe, ci)
+	}
+	return authenticatedSafe, nil
+}
+
+// readContentInfo reads a ContentInfo from s into ci.  Like encoding/asn1,
+// it treats anything other than an explicit [0] as an absent content, unless
+// anyContent is set, and ignores the elements after it.
+func readContentInfo(s *cryptobyte.String, ci *contentInfo, anyContent bool) bool {
+	var body cryptobyte.String
+	if !s.ReadASN1(&body, cryptobyte_asn1.SEQUENCE) || !body.ReadASN1ObjectIdentifier(&ci.ContentType) {
+		return false
+	}
+	if body.Empty() || !anyContent && !body.PeekASN1Tag(explicitTag0) {
+		return true
+	}
+	return readRawValue(&body, &ci.Content)
+}
+
+// parseSafeContents parses der, the DER encoding of a SafeContents.
+func parseSafeContents(der []byte) ([]safeBag, error) {
+	seq, ok := readSequenceOf(der)
+	if !ok {
+		return nil, errMalformedSafeContents
+	}
+	bags := make([]safeBag, 0, countElements(seq))
+	for !seq.Empty() {
+		var bag safeBag
+		if !readSafeBag(&seq, &bag) {
+			return nil, errMalformedSafeContents
+		}
+		bags = append(bags, bag)
+	}
+	return bags, nil
+}
+
+// readSafeBag reads a SafeBag from s into bag.
+func readSafeBag(s *cryptobyte.String, bag *safeBag) bool {
+	var element cryptobyte.String
+	if !s.ReadASN1Element(&element, cryptobyte_asn1.SEQUENCE) {
+		return false
+	}
+	bag.Raw = asn1.RawContent(element)
+
+	var body cryptobyte.String
+	if !element.ReadASN1(&body, cryptobyte_asn1.SEQUENCE) ||
+		!body.ReadASN1ObjectIdentifier(&bag.Id) ||
+		!body.PeekASN1Tag(explicitTag0) || !readRawValue(&body, &bag.Value) {
+		return false
+	}
+	if !body.PeekASN1Tag(cryptobyte_asn1.SET) {
+		return true
+	}
+	var set cryptobyte.String
+	if !body.ReadASN1(&set, cryptobyte_asn1.SET) {
+		return false
+	}
+	bag.Attributes = make([]pkcs12Attribute, 0, countElements(set))
+	for !set.Empty() {
+		var attr pkcs12Attribute
+		var a cryptobyte.String
+		if !set.ReadASN1(&a, cryptobyte_asn1.SEQUENCE) || !a.ReadASN1ObjectIdentifier(&attr.Id) || !readRawValue(&a, &attr.Value) {
+			return false
+		}
+		bag.Attributes = append(bag.Attributes, attr)
+	}
+	return true
+}
+
+// splitValues returns the DER encodings of the elements of set, the content
+// of a SET OF, or false if one of them is malformed.
+func splitValues(set []byte) (values [][]byte, ok bool) {
+	s := cryptobyte.String(set)
+	values = make([][]byte, 0, countElements(s))
+	for !s.Empty() {
+		var value cryptobyte.String
+		if !s.ReadAnyASN1Element(&value, nil) {
+			return nil, false
+		}
+		values = append(values, value)
+	}
+	return values, true
+}
+
+// parseOctetString returns the content of der, the DER encoding of an
+// OCTET STRING, or false if it is not one.  The content refers to der.
+func parseOctetString(der []byte) (octets []byte, ok bool) {
+	s := cryptobyte.String(der)
+	var content cryptobyte.String
+	if !s.ReadASN1(&content, cryptobyte_asn1.OCTET_STRING) || !s.Empty() {
+		return nil, false
+	}
+	return content, true
+}
+
+// readSequenceOf returns the elements of der, which must be a single
+// SEQUENCE.
+func readSequenceOf(der []byte) (seq cryptobyte.String, ok bool) {
+	input := cryptobyte.String(der)
+	if !input.ReadASN1(&seq, cryptobyte_asn1.SEQUENCE) || !input.Empty() {
+		return nil, false
+	}
+	return seq, true
+}
+
+// readRawValue reads any element from s into out, as asn1.Unmarshal does
+// for a RawValue.
+func readRawValue(s *cryptobyte.String, out *asn1.RawValue) bool {
+	var element, content cryptobyte.String
+	var tag cryptobyte_asn1.Tag
+	if !s.ReadAnyASN1Element(&element, &tag) {
+		return false
+	}
+	if full := element; !full.ReadAnyASN1(&content, nil) {
+		return false
+	}
+	*out = asn1.RawValue{
+		Class:      int(tag >> 6),
+		Tag:        int(tag & 0x1f),
+		IsCompound: tag&0x20 != 0,
+		Bytes:      content,
+		FullBytes:  element,
+	}
+	return true
+}
+
+// countElements returns the number of elements in s, counting those before
+// a malformed one, so that slices can be allocated once.
+func countElements(s cryptobyte.String) int {
+	var element cryptobyte.String
+	n := 0
+	for s.ReadAnyASN1Element(&element, nil) {
+		n++
+	}
+	return n
+}
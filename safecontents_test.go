@@ -0,0 +1,285 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fixtureSafeContents returns the authenticated safes of the fixtures and
+// the SafeContents they hold unencrypted, converted to DER.
+func fixtureSafeContents(tb testing.TB) (authSafes, safeContents [][]byte) {
+	for _, pfxData := range fixturePFXs(tb) {
+		var pfx pfxPdu
+		if err := unmarshal(pfxData, &pfx); err != nil {
+			tb.Fatal(err)
+		}
+		var octets []byte
+		if err := unmarshal(pfx.AuthSafe.Content.Bytes, &octets); err != nil {
+			tb.Fatal(err)
+		}
+		octets, err := DefaultLimits.berToDER(octets)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		authSafes = append(authSafes, octets)
+		var authenticatedSafe []contentInfo
+		if err := unmarshal(octets, &authenticatedSafe); err != nil {
+			tb.Fatal(err)
+		}
+		for _, ci := range authenticatedSafe {
+			if !ci.ContentType.Equal(oidDataContentType) {
+				continue
+			}
+			var data []byte
+			if err := unmarshal(ci.Content.Bytes, &data); err != nil {
+				tb.Fatal(err)
+			}
+			if data, err = DefaultLimits.berToDER(data); err != nil {
+				tb.Fatal(err)
+			}
+			safeContents = append(safeContents, data)
+		}
+	}
+	if len(safeContents) == 0 {
+		tb.Fatal("no unencrypted SafeContents in the fixtures")
+	}
+	return authSafes, safeContents
+}
+
+func TestParseSafeContents(t *testing.T) {
+	authSafes, safeContents := fixtureSafeContents(t)
+	for i, der := range authSafes {
+		var want []contentInfo
+		if err := unmarshal(der, &want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseAuthenticatedSafe(der)
+		if err != nil {
+			t.Fatalf("authenticated safe %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("authenticated safe %d: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	// A safeContentsBag holding the bags of the first SafeContents, with
+	// an empty attribute set.
+	var bags []safeBag
+	if err := unmarshal(safeContents[0], &bags); err != nil {
+		t.Fatal(err)
+	}
+	nestBag, err := asn1.Marshal(struct {
+		Id         asn1.ObjectIdentifier
+		Value      asn1.RawValue
+		Attributes []pkcs12Attribute `asn1:"set"`
+	}{
+		Id:         oidSafeContentsBag,
+		Value:      asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: safeContents[0]},
+		Attributes: []pkcs12Attribute{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: append(nestBag, bags[0].Raw...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, der := range append(safeContents, nested) {
+		var want []safeBag
+		if err := unmarshal(der, &want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseSafeContents(der)
+		if err != nil {
+			t.Fatalf("SafeContents %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("SafeContents %d: got %+v, want %+v", i, got, want)
+		}
+		for j := range got {
+			if &got[j].Raw[0] != &der[cap(der)-cap(got[j].Raw)] {
+				t.Errorf("SafeContents %d: bag %d does not refer to the input", i, j)
+			}
+		}
+	}
+
+	der := safeContents[0]
+	for _, test := range []struct {
+		name string
+		der  []byte
+	}{
+		{"empty", nil},
+		{"truncated", der[:len(der)-1]},
+		{"trailing data", append(append([]byte{}, der...), 0)},
+		{"SET", append([]byte{0x31}, der[1:]...)},
+		{"bag without value", []byte{0x30, 0x0d, 0x30, 0x0b, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x0c, 0x0a}},
+		{"implicit value", bytes.Replace(der, []byte{0xa0}, []byte{0x80}, 1)},
+	} {
+		if _, err := parseSafeContents(test.der); err == nil {
+			t.Errorf("%s: parsed", test.name)
+		}
+	}
+}
+
+// BenchmarkParseSafeContents compares the parsing of the fixtures'
+// SafeContents with cryptobyte to that with encoding/asn1, which the
+// decoder used before.
+func BenchmarkParseSafeContents(b *testing.B) {
+	_, safeContents := fixtureSafeContents(b)
+	b.Run("encoding/asn1", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, der := range safeContents {
+				var bags []safeBag
+				if err := unmarshal(der, &bags); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("cryptobyte", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, der := range safeContents {
+				if _, err := parseSafeContents(der); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// BenchmarkParseAuthenticatedSafe is BenchmarkParseSafeContents for the
+// authenticated safes.
+func BenchmarkParseAuthenticatedSafe(b *testing.B) {
+	authSafes, _ := fixtureSafeContents(b)
+	b.Run("encoding/asn1", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, der := range authSafes {
+				var authenticatedSafe []contentInfo
+				if err := unmarshal(der, &authenticatedSafe); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("cryptobyte", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, der := range authSafes {
+				if _, err := parseAuthenticatedSafe(der); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+// fixturePFXs returns the contents of the fixtures, converted to DER.
+func fixturePFXs(tb testing.TB) [][]byte {
+	names, err := filepath.Glob(filepath.Join("testdata", "*.p12"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var pfxs [][]byte
+	for _, name := range names {
+		pfxData, err := os.ReadFile(name)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if pfxData, err = DefaultLimits.berToDER(pfxData); err != nil {
+			tb.Fatal(err)
+		}
+		pfxs = append(pfxs, pfxData)
+	}
+	return pfxs
+}
+
+func TestParsePFXPdu(t *testing.T) {
+	for i, pfxData := range fixturePFXs(t) {
+		var want pfxPdu
+		if err := unmarshal(pfxData, &want); err != nil {
+			t.Fatal(err)
+		}
+		got, err := parsePFXPdu(pfxData)
+		if err != nil {
+			t.Fatalf("fixture %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("fixture %d: got %+v, want %+v", i, *got, want)
+		}
+	}
+
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.WithAuthSafeEncoding(AuthSafeImplicit).Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx, err := parsePFXPdu(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := pfx.AuthSafe.Content; content.Class != asn1.ClassContextSpecific || content.Tag != 0 || content.IsCompound {
+		t.Errorf("unexpected implicit content %+v", content)
+	}
+
+	for _, der := range [][]byte{nil, pfxData[:len(pfxData)-1], append(append([]byte{}, pfxData...), 0)} {
+		if _, err := parsePFXPdu(der); err == nil {
+			t.Errorf("parsed %d malformed bytes", len(der))
+		}
+	}
+}
+
+func TestSplitValues(t *testing.T) {
+	set := []byte{0x04, 0x01, 0xaa, 0x1e, 0x02, 0x00, 0x61}
+	values, ok := splitValues(set)
+	if !ok || !reflect.DeepEqual(values, [][]byte{set[:3], set[3:]}) {
+		t.Errorf("unexpected values %x", values)
+	}
+	if _, ok := splitValues(set[:len(set)-1]); ok {
+		t.Error("split a truncated value")
+	}
+	if id, ok := parseOctetString(set[:3]); !ok || !bytes.Equal(id, []byte{0xaa}) {
+		t.Errorf("unexpected OCTET STRING %x", id)
+	}
+	if _, ok := parseOctetString(set[3:]); ok {
+		t.Error("parsed a BMPString as an OCTET STRING")
+	}
+}
+
+// BenchmarkParsePFXPdu is BenchmarkParseSafeContents for the PFX PDUs.
+func BenchmarkParsePFXPdu(b *testing.B) {
+	pfxs := fixturePFXs(b)
+	b.Run("encoding/asn1", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, der := range pfxs {
+				var pfx pfxPdu
+				if err := unmarshal(der, &pfx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("cryptobyte", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, der := range pfxs {
+				if _, err := parsePFXPdu(der); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
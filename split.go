@@ -0,0 +1,106 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+)
+
+// Split fans the PKCS#12 file pfxData out into one file per identity, for
+// consumers which expect a single private key.  Each identity holds a
+// private key, its certificate, paired by localKeyId or public key like
+// ToJWKS does, and the chain built from the other certificates by issuer;
+// it is laid out like Encode lays it out.  A CA certificate shared by
+// several chains is put in each of them, and a key whose certificate is
+// missing gets a file of its own all the same.
+//
+// The certificates which are in no chain are returned in trustStore,
+// marked as trusted for the java keytool like EncodeTrustStore marks
+// them, or trustStore is nil if there are none.  Bag attributes are kept;
+// entries of other bag types are dropped.  All files are protected by
+// password.
+//
+// Split uses DefaultDecoder to read pfxData.
+func (enc *Encoder) Split(pfxData []byte, password string) (identities [][]byte, trustStore []byte, err error) {
+	contents, err := DefaultDecoder.DecodeContents(pfxData, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []Entry
+	for i := range contents {
+		entries = appendEntries(entries, contents[i].Entries)
+	}
+
+	var certs []*x509.Certificate
+	var certIDs [][]byte
+	var certEntries []*Entry
+	for i := range entries {
+		if entries[i].Certificate != nil {
+			certs = append(certs, entries[i].Certificate)
+			certIDs = append(certIDs, entries[i].LocalKeyID())
+			certEntries = append(certEntries, &entries[i])
+		}
+	}
+
+	inChain := make([]bool, len(certs))
+	for i := range entries {
+		entry := &entries[i]
+		if entry.PrivateKey == nil {
+			continue
+		}
+		identity := PFX{Encoder: enc, password: password}
+		identity.AddEntry(*entry)
+		if leaf := findLeaf(entry.PrivateKey, entry.LocalKeyID(), certs, certIDs); leaf < len(certs) && MatchKeyToCert(entry.PrivateKey, certs[leaf]) == nil {
+			for _, j := range chainFrom(certs, leaf) {
+				inChain[j] = true
+				identity.AddEntry(*certEntries[j])
+			}
+		}
+		pfxData, err := identity.Marshal()
+		if err != nil {
+			return nil, nil, err
+		}
+		identities = append(identities, pfxData)
+	}
+
+	var trusted []Entry
+	for i, entry := range certEntries {
+		if inChain[i] {
+			continue
+		}
+		trustedEntry := *entry
+		if _, ok := trustedEntry.Attribute(OIDJavaTrustedKeyUsage); !ok {
+			attribute, err := NewAttribute(OIDJavaTrustedKeyUsage, oidExtendedKeyUsage)
+			if err != nil {
+				return nil, nil, err
+			}
+			trustedEntry.SetAttribute(attribute)
+		}
+		trusted = append(trusted, trustedEntry)
+	}
+	if len(trusted) != 0 {
+		if trustStore, err = enc.EncodeContents([]SafeContents{{Encrypted: true, Entries: trusted}}, password); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return identities, trustStore, nil
+}
+
+// Split is equivalent to Modern.Split.
+func Split(pfxData []byte, password string) (identities [][]byte, trustStore []byte, err error) {
+	return Modern.Split(pfxData, password)
+}
+
+// appendEntries appends entries to all, followed in turn by the entries
+// nested in each safeContentsBag.
+func appendEntries(all, entries []Entry) []Entry {
+	for _, entry := range entries {
+		all = append(all, entry)
+		all = appendEntries(all, entry.Contents)
+	}
+	return all
+}
@@ -0,0 +1,87 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key1, cert1 := makeTestCertificate(t, "one.example.com", false, caCert, caKey)
+	key2, cert2 := makeTestCertificate(t, "two.example.com", false, caCert, caKey)
+	_, orphan := makeTestCertificate(t, "Orphan CA", true, nil, nil)
+
+	orphanName, err := FriendlyNameAttribute("orphan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPFX("password")
+	p.AddEntry(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key1, Attributes: []Attribute{LocalKeyIDAttribute([]byte{1})}})
+	p.AddEntry(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key2, Attributes: []Attribute{LocalKeyIDAttribute([]byte{2})}})
+	p.AddEntry(Entry{BagType: CertBag, Certificate: caCert})
+	p.AddEntry(Entry{BagType: CertBag, Certificate: cert2, Attributes: []Attribute{LocalKeyIDAttribute([]byte{2})}})
+	p.AddEntry(Entry{BagType: CertBag, Certificate: orphan, Attributes: []Attribute{orphanName}})
+	p.AddEntry(Entry{BagType: CertBag, Certificate: cert1, Attributes: []Attribute{LocalKeyIDAttribute([]byte{1})}})
+	pfxData, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identities, trustStore, err := Split(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 2 {
+		t.Fatalf("expected 2 identities, got %d", len(identities))
+	}
+	for i, want := range []*x509.Certificate{cert1, cert2} {
+		privateKey, certificate, caCerts, err := DecodeChain(identities[i], "password")
+		if err != nil {
+			t.Fatalf("identity %d: %v", i, err)
+		}
+		if !certificate.Equal(want) {
+			t.Errorf("identity %d: unexpected leaf certificate %s", i, certificate.Subject)
+		}
+		if err := MatchKeyToCert(privateKey, certificate); err != nil {
+			t.Errorf("identity %d: %v", i, err)
+		}
+		if len(caCerts) != 1 || !caCerts[0].Equal(caCert) {
+			t.Errorf("identity %d: expected the CA certificate as chain", i)
+		}
+	}
+
+	certs, err := DecodeTrustStore(trustStore, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs["orphan"].Equal(orphan) {
+		t.Errorf("expected the orphan certificate in the trust store, got %v", certs)
+	}
+	contents, err := DecodeContents(trustStore, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := contents[0].Entries[0].Attribute(OIDJavaTrustedKeyUsage); !ok {
+		t.Error("orphan certificate not marked as trusted")
+	}
+}
+
+func TestSplitWithoutOrphans(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities, trustStore, err := Split(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || trustStore != nil {
+		t.Errorf("expected one identity and no trust store, got %d and %v", len(identities), trustStore != nil)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+// StripKeys returns the PKCS#12 file pfxData with only its certificate
+// bags, protected by outPassword, for sharing a chain without the private
+// key.  The certificates keep their attributes and their order;
+// certificates nested in safeContentsBags are kept, and SafeContents left
+// empty are dropped.
+//
+// StripKeys uses DefaultDecoder to read pfxData.
+func (enc *Encoder) StripKeys(pfxData []byte, password, outPassword string) ([]byte, error) {
+	p := NewPFX(password)
+	if err := p.Unmarshal(pfxData); err != nil {
+		return nil, err
+	}
+
+	var contents []SafeContents
+	for _, sc := range p.Contents {
+		if sc.Entries = certEntries(sc.Entries); len(sc.Entries) != 0 {
+			contents = append(contents, sc)
+		}
+	}
+	p.Contents = contents
+	p.Encoder = enc
+	p.SetPassword(outPassword)
+	return p.Marshal()
+}
+
+// StripKeys is equivalent to Modern.StripKeys.
+func StripKeys(pfxData []byte, password, outPassword string) ([]byte, error) {
+	return Modern.StripKeys(pfxData, password, outPassword)
+}
+
+// certEntries returns the certificate bags among entries, and the
+// safeContentsBags which still hold some once stripped in turn.
+func certEntries(entries []Entry) []Entry {
+	var certs []Entry
+	for _, entry := range entries {
+		switch entry.BagType {
+		case CertBag:
+			certs = append(certs, entry)
+		case SafeContentsBag:
+			if entry.Value == nil {
+				if entry.Contents = certEntries(entry.Contents); len(entry.Contents) != 0 {
+					certs = append(certs, entry)
+				}
+			}
+		}
+	}
+	return certs
+}
@@ -0,0 +1,41 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestStripKeys(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	pfxData, err := Modern.Encode(key, cert, []*x509.Certificate{caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stripped, err := StripKeys(pfxData, "password", "partner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(stripped, "partner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 1 || len(contents[0].Entries) != 2 {
+		t.Fatalf("expected one SafeContents with two certificates, got %+v", contents)
+	}
+	for i, want := range []*x509.Certificate{cert, caCert} {
+		entry := contents[0].Entries[i]
+		if entry.BagType != CertBag || !entry.Certificate.Equal(want) {
+			t.Errorf("entry %d: expected %s", i, want.Subject)
+		}
+	}
+	if _, _, _, err := DecodeChain(stripped, "partner"); err == nil {
+		t.Error("expected no private key")
+	}
+}
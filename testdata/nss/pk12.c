@@ -0,0 +1,284 @@
+/*
+ * pk12 imports and exports PKCS#12 files with NSS's PKCS#12 library, the
+ * way pk12util -i and pk12util -o do, for the testdata/nss-* files.
+ * Unlike pk12util, it takes the ciphers and the MAC from the command line
+ * and the passwords as arguments.  Build it with
+ *
+ *	cc -o pk12 pk12.c $(pkg-config --cflags --libs nss)
+ *
+ * and see nss_test.go for how it is run.
+ */
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+#include <nss.h>
+#include <cert.h>
+#include <pk11pub.h>
+#include <p12.h>
+#include <p12plcy.h>
+#include <prerror.h>
+#include <secoid.h>
+#include <secport.h>
+#include <ciferfam.h>
+
+static void
+fail(const char *what)
+{
+    fprintf(stderr, "pk12: %s: %s\n", what, PORT_ErrorToName(PORT_GetError()));
+    exit(1);
+}
+
+/* ucs2Conversion is pk12util's conversion between UCS-2 and UTF-8, which
+ * NSS uses for passwords and friendly names: bytes are swapped, if asked
+ * to, only on the way from UCS-2. */
+static PRBool
+ucs2Conversion(PRBool toUnicode, unsigned char *inBuf, unsigned int inBufLen,
+               unsigned char *outBuf, unsigned int maxOutBufLen,
+               unsigned int *outBufLen, PRBool swapBytes)
+{
+    unsigned char *in = inBuf;
+    unsigned int i;
+    PRBool ok;
+
+    if (swapBytes && !toUnicode) {
+        in = malloc(inBufLen);
+        for (i = 0; i + 1 < inBufLen; i += 2) {
+            in[i] = inBuf[i + 1];
+            in[i + 1] = inBuf[i];
+        }
+    }
+    ok = PORT_UCS2_UTF8Conversion(toUnicode, in, inBufLen, outBuf, maxOutBufLen, outBufLen);
+    if (in != inBuf) {
+        free(in);
+    }
+    return ok;
+}
+
+/* The decoder keeps a copy of the file, digest, in memory. */
+static unsigned char *digest;
+static unsigned long digestLen, digestPos;
+
+static SECStatus
+digestOpen(void *arg, PRBool reading)
+{
+    if (!reading) {
+        digestLen = 0;
+    }
+    digestPos = 0;
+    return SECSuccess;
+}
+
+static SECStatus
+digestClose(void *arg, PRBool remove)
+{
+    return SECSuccess;
+}
+
+static int
+digestRead(void *arg, unsigned char *buf, unsigned long len)
+{
+    if (len > digestLen - digestPos) {
+        len = digestLen - digestPos;
+    }
+    memcpy(buf, digest + digestPos, len);
+    digestPos += len;
+    return len;
+}
+
+static int
+digestWrite(void *arg, unsigned char *buf, unsigned long len)
+{
+    digest = realloc(digest, digestLen + len);
+    memcpy(digest + digestLen, buf, len);
+    digestLen += len;
+    return len;
+}
+
+/* nicknameCollision names a certificate without a friendly name, or with
+ * one in use, after its subject, as pk12util does. */
+static SECItem *
+nicknameCollision(SECItem *oldNickname, PRBool *cancel, void *arg)
+{
+    char *nickname = CERT_MakeCANickname((CERTCertificate *)arg);
+    SECItem *item;
+
+    *cancel = PR_FALSE;
+    if (nickname == NULL) {
+        *cancel = PR_TRUE;
+        return NULL;
+    }
+    item = SECITEM_AllocItem(NULL, NULL, strlen(nickname) + 1);
+    memcpy(item->data, nickname, item->len);
+    item->len--;
+    PORT_Free(nickname);
+    return item;
+}
+
+/* unicodePassword returns password as a NUL-terminated BMPString, which
+ * pk12util passes to the decoder. */
+static SECItem *
+unicodePassword(const char *password)
+{
+    SECItem *item = SECITEM_AllocItem(NULL, NULL, 2 * strlen(password) + 2);
+    unsigned int n;
+
+    if (!PORT_UCS2_UTF8Conversion(PR_TRUE, (unsigned char *)password, strlen(password),
+                                  item->data, item->len, &n)) {
+        fail("converting the password");
+    }
+    item->data[n] = item->data[n + 1] = 0;
+    item->len = n + 2;
+    return item;
+}
+
+static void
+import(PK11SlotInfo *slot, const char *file, const char *password)
+{
+    SEC_PKCS12DecoderContext *p12dcx;
+    unsigned char buf[1 << 16];
+    size_t n;
+    FILE *f;
+
+    p12dcx = SEC_PKCS12DecoderStart(unicodePassword(password), slot, NULL,
+                                    digestOpen, digestClose, digestRead, digestWrite, NULL);
+    if (p12dcx == NULL) {
+        fail("starting the decoder");
+    }
+    if ((f = fopen(file, "rb")) == NULL) {
+        perror(file);
+        exit(1);
+    }
+    while ((n = fread(buf, 1, sizeof buf, f)) > 0) {
+        if (SEC_PKCS12DecoderUpdate(p12dcx, buf, n) != SECSuccess) {
+            fail("decoding");
+        }
+    }
+    fclose(f);
+    if (SEC_PKCS12DecoderVerify(p12dcx) != SECSuccess) {
+        fail("verifying");
+    }
+    if (SEC_PKCS12DecoderValidateBags(p12dcx, nicknameCollision) != SECSuccess) {
+        fail("validating the bags");
+    }
+    if (SEC_PKCS12DecoderImportBags(p12dcx) != SECSuccess) {
+        fail("importing the bags");
+    }
+    SEC_PKCS12DecoderFinish(p12dcx);
+}
+
+static SECOidTag
+algorithm(const char *name)
+{
+    static const struct {
+        const char *name;
+        SECOidTag tag;
+    } algorithms[] = {
+        { "none", SEC_OID_UNKNOWN },
+        { "aes128", SEC_OID_AES_128_CBC },
+        { "aes256", SEC_OID_AES_256_CBC },
+        { "3des", SEC_OID_PKCS12_V2_PBE_WITH_SHA1_AND_3KEY_TRIPLE_DES_CBC },
+        { "rc2-40", SEC_OID_PKCS12_V2_PBE_WITH_SHA1_AND_40_BIT_RC2_CBC },
+        { "sha1", SEC_OID_SHA1 },
+        { "sha256", SEC_OID_SHA256 },
+    };
+    size_t i;
+
+    for (i = 0; i < sizeof algorithms / sizeof algorithms[0]; i++) {
+        if (strcmp(name, algorithms[i].name) == 0) {
+            return algorithms[i].tag;
+        }
+    }
+    fprintf(stderr, "pk12: unknown algorithm %s\n", name);
+    exit(1);
+}
+
+static void
+writeOutput(void *arg, const char *buf, unsigned long len)
+{
+    fwrite(buf, 1, len, arg);
+}
+
+static void
+export(PK11SlotInfo *slot, const char *nickname, const char *file, const char *password,
+       SECOidTag keyCipher, SECOidTag certCipher, SECOidTag mac)
+{
+    SEC_PKCS12ExportContext *p12ecx;
+    SEC_PKCS12SafeInfo *keySafe, *certSafe;
+    SECItem pwitem = { siBuffer, (unsigned char *)password, strlen(password) };
+    CERTCertificate *cert;
+    FILE *f;
+
+    if ((cert = PK11_FindCertFromNickname(nickname, NULL)) == NULL) {
+        fail(nickname);
+    }
+    if ((p12ecx = SEC_PKCS12CreateExportContext(NULL, NULL, slot, NULL)) == NULL) {
+        fail("creating the export context");
+    }
+    if (SEC_PKCS12AddPasswordIntegrity(p12ecx, &pwitem, mac) != SECSuccess) {
+        fail("adding the MAC");
+    }
+    keySafe = SEC_PKCS12CreateUnencryptedSafe(p12ecx);
+    if (certCipher == SEC_OID_UNKNOWN) {
+        certSafe = keySafe;
+    } else {
+        certSafe = SEC_PKCS12CreatePasswordPrivSafe(p12ecx, &pwitem, certCipher);
+    }
+    if (keySafe == NULL || certSafe == NULL) {
+        fail("creating the safes");
+    }
+    if (SEC_PKCS12AddCertOrChainAndKey(p12ecx, certSafe, NULL, cert, CERT_GetDefaultCertDB(),
+                                       keySafe, NULL, PR_TRUE, &pwitem, keyCipher,
+                                       PR_TRUE) != SECSuccess) {
+        fail("adding the certificate and key");
+    }
+    if ((f = fopen(file, "wb")) == NULL) {
+        perror(file);
+        exit(1);
+    }
+    if (SEC_PKCS12Encode(p12ecx, writeOutput, f) != SECSuccess) {
+        fail("encoding");
+    }
+    fclose(f);
+    SEC_PKCS12DestroyExportContext(p12ecx);
+    CERT_DestroyCertificate(cert);
+}
+
+int
+main(int argc, char **argv)
+{
+    PK11SlotInfo *slot;
+
+    if (argc < 5 || (strcmp(argv[1], "import") == 0 && argc != 5) ||
+        (strcmp(argv[1], "export") == 0 && argc != 9)) {
+        fprintf(stderr, "usage: pk12 import dbdir file password\n"
+                        "       pk12 export dbdir nickname file password keycipher certcipher mac\n");
+        return 2;
+    }
+    if (NSS_Initialize(argv[2], "", "", SECMOD_DB, 0) != SECSuccess) {
+        fail("initializing NSS");
+    }
+    PORT_SetUCS2_ASCIIConversionFunction(ucs2Conversion);
+    SEC_PKCS12EnableCipher(PKCS12_RC2_CBC_40, 1);
+    SEC_PKCS12EnableCipher(PKCS12_RC2_CBC_128, 1);
+    SEC_PKCS12EnableCipher(PKCS12_DES_EDE3_168, 1);
+    SEC_PKCS12EnableCipher(PKCS12_AES_CBC_128, 1);
+    SEC_PKCS12EnableCipher(PKCS12_AES_CBC_256, 1);
+
+    slot = PK11_GetInternalKeySlot();
+    if (PK11_NeedUserInit(slot) && PK11_InitPin(slot, "", "") != SECSuccess) {
+        fail("initializing the database");
+    }
+    if (strcmp(argv[1], "import") == 0) {
+        import(slot, argv[3], argv[4]);
+    } else {
+        export(slot, argv[3], argv[4], argv[5],
+               algorithm(argv[6]), algorithm(argv[7]), algorithm(argv[8]));
+    }
+    PK11_FreeSlot(slot);
+    if (NSS_Shutdown() != SECSuccess) {
+        fail("shutting down NSS");
+    }
+    return 0;
+}
@@ -0,0 +1,199 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// A TrustStoreCert is a certificate of a trust store, as returned by
+// DecodeTrustStoreCerts, with the properties a caller needs to decide
+// whether to trust it.
+type TrustStoreCert struct {
+	Certificate *x509.Certificate
+	// FriendlyName is the alias of the certificate, or empty if its bag
+	// has none.
+	FriendlyName string
+	// IsCA is set if the basic constraints of the certificate allow it to
+	// issue certificates.  Leaf certificates, and version 1 certificates,
+	// which have no extensions, are not CAs.
+	IsCA bool
+	// JavaTrusted is set if the bag has the OIDJavaTrustedKeyUsage
+	// attribute, without which the java keytool does not treat the
+	// certificate as a trustedCertEntry.
+	JavaTrusted bool
+
+	// attributes are the attributes of the bag, for
+	// DecodeTrustStoreEntries.
+	attributes []Attribute
+}
+
+// A TrustStoreEntry is a certificate of a trust store with its alias and
+// bag attributes, as returned by DecodeTrustStoreEntries, from which a
+// listing of the store like the one of the java keytool can be rebuilt.
+type TrustStoreEntry struct {
+	Cert *x509.Certificate
+	// FriendlyName is the alias of the certificate, or empty if its bag
+	// has none.
+	FriendlyName string
+	// Attributes are all the attributes of the bag, including the
+	// friendlyName and the trust attribute.
+	Attributes []Attribute
+	// Trusted is set if the bag has the OIDJavaTrustedKeyUsage attribute,
+	// which makes the certificate a trustedCertEntry for the java keytool.
+	Trusted bool
+}
+
+// DecodeTrustStoreCerts is like DecodeTrustStore, but returns every
+// certificate of pfxData in order, whether it is a CA certificate or not,
+// and leaves the policy to the caller.  Unlike DecodeTrustStore, it
+// accepts certificates without a friendly name, and skips bags which are
+// not certificates, such as the private key of an identity stored by
+// mistake, without decrypting them.  Certificates sharing a name are
+// handled as selected by WithDuplicateNames.
+func (dec *Decoder) DecodeTrustStoreCerts(pfxData []byte, password string) ([]TrustStoreCert, error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeTrustStoreCerts(pfxData, encodedPassword)
+}
+
+// DecodeTrustStoreCertsBytesPassword is like DecodeTrustStoreCerts, but
+// takes the password as UTF-8 encoded bytes, which the caller can wipe
+// after use.
+func (dec *Decoder) DecodeTrustStoreCertsBytesPassword(pfxData, password []byte) ([]TrustStoreCert, error) {
+	encodedPassword, err := bmpStringBytes(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	return dec.decodeTrustStoreCerts(pfxData, encodedPassword)
+}
+
+func (dec *Decoder) decodeTrustStoreCerts(pfxData, encodedPassword []byte) ([]TrustStoreCert, error) {
+	bags, _, err := dec.getSafeContents(pfxData, encodedPassword, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []TrustStoreCert
+	for _, bag := range bags {
+		if !bag.Id.Equal(oidCertBag) {
+			continue
+		}
+
+		certData, err := decodeCertBag(bag.Value.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(certData)
+		if err != nil {
+			return nil, err
+		}
+
+		var friendlyName string
+		if value := firstAttributeValue(bag.Attributes, oidFriendlyName); value != nil {
+			if friendlyName, err = unmarshalFriendlyName(value); err != nil {
+				return nil, err
+			}
+		}
+
+		attributes, err := decodeAttributes(bag.Attributes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, TrustStoreCert{
+			Certificate:  cert,
+			FriendlyName: friendlyName,
+			IsCA:         cert.BasicConstraintsValid && cert.IsCA,
+			JavaTrusted:  firstAttributeValue(bag.Attributes, OIDJavaTrustedKeyUsage) != nil,
+			attributes:   attributes,
+		})
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("pkcs12: no certificate was found in trust store")
+	}
+
+	names := make([]string, len(certs))
+	for i := range certs {
+		names[i] = certs[i].FriendlyName
+	}
+	if err := dec.applyDuplicateNames(names); err != nil {
+		return nil, err
+	}
+	for i := range certs {
+		certs[i].FriendlyName = names[i]
+	}
+
+	return certs, nil
+}
+
+// DecodeTrustStoreCerts is DefaultDecoder.DecodeTrustStoreCerts.
+func DecodeTrustStoreCerts(pfxData []byte, password string) ([]TrustStoreCert, error) {
+	return DefaultDecoder.DecodeTrustStoreCerts(pfxData, password)
+}
+
+// DecodeTrustStoreEntries is like DecodeTrustStoreCerts, but returns the
+// alias, the bag attributes and the trust of each certificate of pfxData.
+func (dec *Decoder) DecodeTrustStoreEntries(pfxData []byte, password string) ([]TrustStoreEntry, error) {
+	certs, err := dec.DecodeTrustStoreCerts(pfxData, password)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]TrustStoreEntry, len(certs))
+	for i, cert := range certs {
+		entries[i] = TrustStoreEntry{
+			Cert:         cert.Certificate,
+			FriendlyName: cert.FriendlyName,
+			Attributes:   cert.attributes,
+			Trusted:      cert.JavaTrusted,
+		}
+	}
+	return entries, nil
+}
+
+// DecodeTrustStoreEntries is DefaultDecoder.DecodeTrustStoreEntries.
+func DecodeTrustStoreEntries(pfxData []byte, password string) ([]TrustStoreEntry, error) {
+	return DefaultDecoder.DecodeTrustStoreEntries(pfxData, password)
+}
+
+// ToCertPool decodes the trust store pfxData and returns a pool of its
+// certificates marked as trusted with the OIDJavaTrustedKeyUsage attribute,
+// as EncodeTrustStore and the java keytool mark them.  If includeUnmarked
+// is true, every certificate is added, which is needed for trust stores
+// written by tools that do not set the attribute, such as OpenSSL without
+// -jdktrust.  It is an error for the pool to end up empty.
+func (dec *Decoder) ToCertPool(pfxData []byte, password string, includeUnmarked bool) (*x509.CertPool, error) {
+	certs, err := dec.DecodeTrustStoreCerts(pfxData, password)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	added := 0
+	for _, cert := range certs {
+		if cert.JavaTrusted || includeUnmarked {
+			pool.AddCert(cert.Certificate)
+			added++
+		}
+	}
+	if added == 0 {
+		return nil, errors.New("pkcs12: no certificate of the trust store is marked as trusted")
+	}
+
+	return pool, nil
+}
+
+// ToCertPool is DefaultDecoder.ToCertPool.
+func ToCertPool(pfxData []byte, password string, includeUnmarked bool) (*x509.CertPool, error) {
+	return DefaultDecoder.ToCertPool(pfxData, password, includeUnmarked)
+}
@@ -0,0 +1,141 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestDecodeTrustStoreCerts(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "ca", true, nil, nil)
+	leafKey, leafCert := makeTestCertificate(t, "leaf", false, caCert, caKey)
+
+	trustStore, err := Modern.EncodeTrustStore(map[string]*x509.Certificate{"ca": caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(trustStore, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A customer trust store with a stray identity: a leaf certificate
+	// without an alias and its private key.
+	contents[0].Entries = append(contents[0].Entries, Entry{BagType: CertBag, Certificate: leafCert})
+	contents = append(contents, SafeContents{Entries: []Entry{{BagType: PKCS8ShroudedKeyBag, PrivateKey: leafKey}}})
+	pfxData, err := Modern.EncodeContents(contents, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := DecodeTrustStore(pfxData, "password"); err == nil {
+		t.Error("DecodeTrustStore accepted a trust store with a key bag")
+	}
+
+	certs, err := DecodeTrustStoreCerts(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []TrustStoreCert{
+		{Certificate: caCert, FriendlyName: "ca", IsCA: true, JavaTrusted: true},
+		{Certificate: leafCert},
+	}
+	if len(certs) != len(expected) {
+		t.Fatalf("expected %d certificates, got %d", len(expected), len(certs))
+	}
+	for i, want := range expected {
+		got := certs[i]
+		if !got.Certificate.Equal(want.Certificate) || got.FriendlyName != want.FriendlyName || got.IsCA != want.IsCA || got.JavaTrusted != want.JavaTrusted {
+			t.Errorf("certificate %d: expected %s %q CA=%v trusted=%v, got %s %q CA=%v trusted=%v", i,
+				want.Certificate.Subject, want.FriendlyName, want.IsCA, want.JavaTrusted,
+				got.Certificate.Subject, got.FriendlyName, got.IsCA, got.JavaTrusted)
+		}
+	}
+
+	// The entries add the attributes of the bags: for the CA, its
+	// friendlyName and the trust attribute of the java keytool.
+	entries, err := DecodeTrustStoreEntries(pfxData, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[0].Cert.Equal(caCert) || entries[0].FriendlyName != "ca" || !entries[0].Trusted ||
+		!entries[1].Cert.Equal(leafCert) || entries[1].FriendlyName != "" || entries[1].Trusted {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+	if attributes := entries[0].Attributes; len(attributes) != 2 ||
+		!attributes[0].Type.Equal(OIDFriendlyName) || !attributes[1].Type.Equal(OIDJavaTrustedKeyUsage) {
+		t.Errorf("unexpected attributes %v", attributes)
+	}
+	if len(entries[1].Attributes) != 0 {
+		t.Errorf("unexpected attributes %v of a certificate without any", entries[1].Attributes)
+	}
+	if _, err := DecodeTrustStoreEntries(pfxData, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+
+	if _, err := DefaultDecoder.DecodeTrustStoreCertsBytesPassword(pfxData, []byte("password")); err != nil {
+		t.Error(err)
+	}
+	if _, err := DecodeTrustStoreCerts(pfxData, "wrong"); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+}
+
+func TestToCertPool(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "ca", true, nil, nil)
+	_, leafCert := makeTestCertificate(t, "leaf", false, caCert, caKey)
+	_, otherCA := makeTestCertificate(t, "other ca", true, nil, nil)
+
+	trustStore, err := Modern.EncodeTrustStore(map[string]*x509.Certificate{"ca": caCert}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := DecodeContents(trustStore, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents[0].Entries = append(contents[0].Entries, Entry{BagType: CertBag, Certificate: otherCA})
+	pfxData, err := Modern.EncodeContents(contents, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(pool *x509.CertPool, cert *x509.Certificate) error {
+		_, err := cert.Verify(x509.VerifyOptions{Roots: pool})
+		return err
+	}
+
+	pool, err := ToCertPool(pfxData, "password", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(pool, leafCert); err != nil {
+		t.Errorf("leaf does not verify against the trusted CA: %v", err)
+	}
+	if err := verify(pool, otherCA); err == nil {
+		t.Error("unmarked certificate was added to the pool")
+	}
+
+	pool, err = ToCertPool(pfxData, "password", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(pool, otherCA); err != nil {
+		t.Errorf("unmarked certificate missing with includeUnmarked: %v", err)
+	}
+
+	// OpenSSL-style trust store without the attribute.
+	unmarked, err := Modern.EncodeContents([]SafeContents{{Encrypted: true, Entries: []Entry{{BagType: CertBag, Certificate: otherCA}}}}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ToCertPool(unmarked, "password", false); err == nil {
+		t.Error("expected an error for a trust store without marked certificates")
+	}
+	if _, err := ToCertPool(unmarked, "password", true); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validate checks that pfxData is internally consistent, and returns what
+// it found; a file passes if none of the findings is SeverityCritical.
+//
+// Validate verifies the MAC and decrypts every SafeContents and private
+// key.  Each private key must belong to a certificate: the one carrying its
+// localKeyId if there is such a certificate, or else one whose public key
+// matches.  A chain must be buildable by issuer from that certificate up to
+// a self-signed one whose signature verifies; a chain that stops short, or
+// ends in a certificate whose self-signature does not verify, is critical.
+// Every
+// certificate must be within its validity period, and one that expires
+// soon is a warning.  So is a version other than 3, which only a Decoder
+// from AllowAnyVersion accepts, a friendlyName shared by two keystore
+// entries, which the java keytool refuses, and an entry with several
+// different friendlyNames, of which most tools only read the first.  A SafeContents or private key
+// which does not decrypt with password is critical, but the rest of the
+// file is still checked.
+func (dec *Decoder) Validate(pfxData []byte, password string) []Finding {
+	return dec.validate(pfxData, password, time.Now())
+}
+
+// Validate is equivalent to DefaultDecoder.Validate.
+func Validate(pfxData []byte, password string) []Finding {
+	return DefaultDecoder.Validate(pfxData, password)
+}
+
+func (dec *Decoder) validate(pfxData []byte, password string, now time.Time) []Finding {
+	var report Report
+
+	contents, err := dec.DecodeContentsPartial(pfxData, password)
+	var partialErr *PartialDecodeError
+	if err != nil && !errors.As(err, &partialErr) {
+		report.add(SeverityCritical, "", "cannot decode file: %v", err)
+		return report.Findings
+	}
+	if partialErr != nil {
+		for _, i := range partialErr.SafeContents {
+			report.add(SeverityCritical, "", "SafeContents %d is encrypted with another password than the file", i+1)
+		}
+		for _, keyErr := range partialErr.Keys {
+			if keyErr.FriendlyName == "" {
+				report.add(SeverityCritical, "", "a private key is encrypted with another password than the file, which the java keytool refuses")
+			} else {
+				report.add(SeverityCritical, "", "private key %q is encrypted with another password than the file, which the java keytool refuses", keyErr.FriendlyName)
+			}
+		}
+	}
+	if probe, err := Probe(pfxData); err == nil && probe.Version != 3 {
+		report.add(SeverityWarning, "", "file has version %d instead of 3", probe.Version)
+	}
+	var entries []Entry
+	for i := range contents {
+		entries = appendEntries(entries, contents[i].Entries)
+	}
+
+	var certs []*x509.Certificate
+	var certIDs [][]byte
+	for i := range entries {
+		if entries[i].Certificate != nil {
+			certs = append(certs, entries[i].Certificate)
+			certIDs = append(certIDs, entries[i].LocalKeyID())
+		}
+	}
+
+	var keys int
+	for i := range entries {
+		entry := &entries[i]
+		if entry.PrivateKey == nil {
+			continue
+		}
+		keys++
+		if len(certs) == 0 {
+			report.add(SeverityCritical, "", "private key %d has no certificate", keys)
+			continue
+		}
+		leaf := findLeaf(entry.PrivateKey, entry.LocalKeyID(), certs, certIDs)
+		if err := MatchKeyToCert(entry.PrivateKey, certs[leaf]); err != nil {
+			if id := entry.LocalKeyID(); len(id) != 0 && bytes.Equal(certIDs[leaf], id) {
+				report.add(SeverityCritical, "", "private key %d does not match certificate %q, which has its localKeyId", keys, certs[leaf].Subject.String())
+			} else {
+				report.add(SeverityCritical, "", "private key %d has no certificate", keys)
+			}
+			continue
+		}
+
+		chain := chainFrom(certs, leaf)
+		root := certs[chain[len(chain)-1]]
+		switch {
+		case !bytes.Equal(root.RawIssuer, root.RawSubject):
+			report.add(SeverityCritical, "", "chain of certificate %q cannot be built: it stops at %q, whose issuer %q is missing", certs[leaf].Subject.String(), root.Subject.String(), root.Issuer.String())
+		case len(chain) > 1 && root.CheckSignatureFrom(root) != nil,
+			// A self-signed end-entity certificate need not be
+			// allowed to sign certificates.
+			len(chain) == 1 && root.CheckSignature(root.SignatureAlgorithm, root.RawTBSCertificate, root.Signature) != nil:
+			report.add(SeverityCritical, "", "chain of certificate %q cannot be built: the signature of the self-signed %q does not verify", certs[leaf].Subject.String(), root.Subject.String())
+		}
+	}
+
+	for _, name := range duplicateNames(entries) {
+		report.add(SeverityWarning, "", "friendlyName %q is used by more than one entry, which the java keytool refuses", name)
+	}
+	for i := range entries {
+		if names := entries[i].FriendlyNames(); len(names) > 1 {
+			quoted := make([]string, len(names))
+			for j, name := range names {
+				quoted[j] = strconv.Quote(name)
+			}
+			report.add(SeverityWarning, "", "an entry has the friendlyNames %s, of which most tools only read the first", strings.Join(quoted, ", "))
+		}
+	}
+
+	for _, cert := range certs {
+		subject := cert.Subject.String()
+		switch {
+		case now.Before(cert.NotBefore):
+			report.add(SeverityCritical, "", "certificate %q is not valid before %s", subject, cert.NotBefore.Format(time.RFC3339))
+		case now.After(cert.NotAfter):
+			report.add(SeverityCritical, "", "certificate %q expired on %s", subject, cert.NotAfter.Format(time.RFC3339))
+		case now.Add(certExpiryWarning).After(cert.NotAfter):
+			report.add(SeverityWarning, "", "certificate %q expires on %s", subject, cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	return report.Findings
+}
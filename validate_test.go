@@ -0,0 +1,167 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+	otherKey, otherCert := makeTestCertificate(t, "other.example.com", false, nil, nil)
+	// The CA certificate with the last byte of its signature changed.
+	badRaw := append([]byte{}, caCert.Raw...)
+	badRaw[len(badRaw)-1] ^= 1
+	badCA, err := x509.ParseCertificate(badRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(entries ...Entry) []byte {
+		p := NewPFX("password")
+		for _, entry := range entries {
+			p.AddEntry(entry)
+		}
+		pfxData, err := p.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pfxData
+	}
+	id := LocalKeyIDAttribute([]byte{1})
+	leafEntry := Entry{BagType: CertBag, Certificate: cert, Attributes: []Attribute{id}}
+	caEntry := Entry{BagType: CertBag, Certificate: caCert}
+	name, err := FriendlyNameAttribute("leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bothEncodings, err := Modern.WithNameEncoding(NameBMPAndUTF8String).FriendlyNameAttribute("leaf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := friendlyNameValue("other", NameBMPString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoNames := Attribute{Type: oidFriendlyName, Values: [][]byte{name.Values[0], other}}
+
+	tests := []struct {
+		name     string
+		pfxData  []byte
+		password string
+		want     []Severity
+		message  string
+	}{
+		{
+			name:    "consistent",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{id}}, leafEntry, caEntry),
+		},
+		{
+			name:    "name of a key and its certificate",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{id, name}}, Entry{BagType: CertBag, Certificate: cert, Attributes: []Attribute{id, name}}, caEntry),
+		},
+		{
+			name:    "duplicate name",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{id, name}}, leafEntry, Entry{BagType: CertBag, Certificate: caCert, Attributes: []Attribute{name}}),
+			want:    []Severity{SeverityWarning},
+			message: "is used by more than one entry",
+		},
+		{
+			name:    "name in two encodings",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{id, bothEncodings}}, leafEntry, caEntry),
+		},
+		{
+			name:    "two names",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{id, twoNames}}, leafEntry, caEntry),
+			want:    []Severity{SeverityWarning},
+			message: `friendlyNames "leaf", "other"`,
+		},
+		{
+			name:     "wrong password",
+			pfxData:  encode(leafEntry),
+			password: "wrong",
+			want:     []Severity{SeverityCritical},
+			message:  "cannot decode file",
+		},
+		{
+			name:    "key without certificate",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: otherKey}, leafEntry, caEntry),
+			want:    []Severity{SeverityCritical},
+			message: "has no certificate",
+		},
+		{
+			name:    "mismatched localKeyId",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: otherKey, Attributes: []Attribute{id}}, leafEntry, caEntry),
+			want:    []Severity{SeverityCritical},
+			message: "does not match",
+		},
+		{
+			name:    "incomplete chain",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{id}}, leafEntry),
+			want:    []Severity{SeverityCritical},
+			message: "issuer",
+		},
+		{
+			name:    "root with a bad signature",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{id}}, leafEntry, Entry{BagType: CertBag, Certificate: badCA}),
+			want:    []Severity{SeverityCritical},
+			message: "does not verify",
+		},
+		{
+			name:    "self-signed leaf",
+			pfxData: encode(Entry{BagType: PKCS8ShroudedKeyBag, PrivateKey: otherKey}, Entry{BagType: CertBag, Certificate: otherCert}),
+		},
+	}
+	for _, test := range tests {
+		password := test.password
+		if password == "" {
+			password = "password"
+		}
+		// The validity of the certificates is left to
+		// TestValidateCertificateValidity.
+		var findings []Finding
+		for _, f := range Validate(test.pfxData, password) {
+			if !strings.HasPrefix(f.Message, "certificate ") {
+				findings = append(findings, f)
+			}
+		}
+		if len(findings) != len(test.want) {
+			t.Errorf("%s: expected %d findings, got %+v", test.name, len(test.want), findings)
+			continue
+		}
+		for i, f := range findings {
+			if f.Severity != test.want[i] || !strings.Contains(f.Message, test.message) {
+				t.Errorf("%s: unexpected finding %+v", test.name, f)
+			}
+		}
+	}
+}
+
+func TestValidateCertificateValidity(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		now  time.Time
+		want []Severity
+	}{
+		{cert.NotBefore.Add(-time.Hour), []Severity{SeverityCritical}},
+		{cert.NotAfter.Add(-time.Hour), []Severity{SeverityWarning}},
+		{cert.NotAfter.Add(time.Hour), []Severity{SeverityCritical}},
+	} {
+		findings := DefaultDecoder.validate(pfxData, "password", test.now)
+		if len(findings) != len(test.want) || (len(findings) == 1 && findings[0].Severity != test.want[0]) {
+			t.Errorf("at %s: unexpected findings %+v", test.now, findings)
+		}
+	}
+}
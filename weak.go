@@ -0,0 +1,128 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// A WeakAlgorithm reports a weak algorithm found in a PKCS#12 file.
+type WeakAlgorithm struct {
+	// Usage is the part of the file protected by the algorithm.
+	Usage AlgorithmUsage
+	// Algorithm identifies the weak algorithm.  For PBES2 this is the
+	// weak component, such as the PBKDF2 PRF, not PBES2 itself.
+	Algorithm asn1.ObjectIdentifier
+	// Name is the OpenSSL name of Algorithm.
+	Name string
+}
+
+// usedAlgorithm records an algorithm encountered while decoding.
+type usedAlgorithm struct {
+	usage     AlgorithmUsage
+	algorithm pkix.AlgorithmIdentifier
+}
+
+// weakComponent returns the weak component of an algorithm used in a
+// PKCS#12 file, or nil if the algorithm is not considered weak.  SHA-1 and
+// the PKCS#12 PBE schemes (RC2, 3DES) are weak.
+func weakComponent(used usedAlgorithm) asn1.ObjectIdentifier {
+	algorithm := used.algorithm.Algorithm
+	switch {
+	case used.usage == UsageMAC:
+		if algorithm.Equal(oidSHA1) {
+			return algorithm
+		}
+	case algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC), algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		return algorithm
+	case algorithm.Equal(oidPBES2):
+		_, kdfParams, err := parsePBES2Params(used.algorithm)
+		if err == nil && (len(kdfParams.Prf.Algorithm) == 0 || kdfParams.Prf.Algorithm.Equal(oidHmacWithSHA1)) {
+			return oidHmacWithSHA1
+		}
+	}
+	return nil
+}
+
+// weakAlgorithms returns the weak algorithms among used, in order.
+func weakAlgorithms(used []usedAlgorithm) []WeakAlgorithm {
+	var weak []WeakAlgorithm
+	for _, u := range used {
+		if algorithm := weakComponent(u); algorithm != nil {
+			weak = append(weak, WeakAlgorithm{Usage: u.usage, Algorithm: algorithm, Name: algorithmName(algorithm)})
+		}
+	}
+	return weak
+}
+
+// WeakAlgorithms decodes pfxData and reports every weak algorithm used in
+// it: a SHA-1 MAC, SafeContents or key bags encrypted with RC2 or 3DES, and
+// PBES2 keyed with HMAC-SHA-1.  The password is needed because key bags may
+// be stored inside encrypted SafeContents.  A nil result means no weak
+// algorithms were found.
+func (dec *Decoder) WeakAlgorithms(pfxData []byte, password string) ([]WeakAlgorithm, error) {
+	encodedPassword, err := bmpString(password)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(encodedPassword)
+
+	var used []usedAlgorithm
+	bags, _, err := dec.getSafeContents(pfxData, encodedPassword, &used, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bag := range bags {
+		if !bag.Id.Equal(oidPKCS8ShroundedKeyBag) {
+			continue
+		}
+		pkinfo := new(encryptedPrivateKeyInfo)
+		if err := unmarshal(bag.Value.Bytes, pkinfo); err != nil {
+			return nil, fmt.Errorf("pkcs12: error decoding PKCS#8 shrouded key bag: %v", err)
+		}
+		used = append(used, usedAlgorithm{UsageKeyBag, pkinfo.Algorithm()})
+	}
+
+	return weakAlgorithms(used), nil
+}
+
+// WeakAlgorithms reports every weak algorithm used in pfxData using the
+// DefaultDecoder.
+func WeakAlgorithms(pfxData []byte, password string) ([]WeakAlgorithm, error) {
+	return DefaultDecoder.WeakAlgorithms(pfxData, password)
+}
+
+// AllowWeakAlgorithms creates a new Encoder identical to enc except that it
+// is permitted to produce files using weak algorithms.  Without it, encoding
+// with an Encoder configured for RC2, 3DES or SHA-1, such as LegacyRC2 and
+// LegacyDES, fails with an error wrapping ErrWeakAlgorithm.
+func (enc Encoder) AllowWeakAlgorithms() *Encoder {
+	enc.allowWeak = true
+	return &enc
+}
+
+// checkWeak refuses weak algorithms unless the encoder allows them.
+func (enc *Encoder) checkWeak() error {
+	if enc.allowWeak {
+		return nil
+	}
+	used := []usedAlgorithm{{UsageMAC, pkix.AlgorithmIdentifier{Algorithm: enc.macAlgorithm}}}
+	for _, algorithm := range []asn1.ObjectIdentifier{enc.certsAlgorithm(), enc.keyAlgorithm} {
+		if algorithm != nil && !algorithm.Equal(oidPBES2) {
+			used = append(used, usedAlgorithm{UsageSafeContents, pkix.AlgorithmIdentifier{Algorithm: algorithm}})
+		}
+	}
+	if enc.pbes2PRF.Equal(oidHmacWithSHA1) {
+		return fmt.Errorf("%w: %s", ErrWeakAlgorithm, algorithmName(oidHmacWithSHA1))
+	}
+	if weak := weakAlgorithms(used); len(weak) != 0 {
+		return fmt.Errorf("%w: %s", ErrWeakAlgorithm, weak[0].Name)
+	}
+	return nil
+}
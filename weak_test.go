@@ -0,0 +1,70 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestWeakAlgorithmsRequireOptIn(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	for name, enc := range map[string]*Encoder{"LegacyRC2": LegacyRC2, "LegacyDES": LegacyDES} {
+		if _, err := enc.Encode(key, cert, nil, DefaultPassword); !errors.Is(err, ErrWeakAlgorithm) {
+			t.Errorf("%s: expected ErrWeakAlgorithm, got %v", name, err)
+		}
+		if _, err := enc.AllowWeakAlgorithms().Encode(key, cert, nil, DefaultPassword); err != nil {
+			t.Errorf("%s with AllowWeakAlgorithms: %v", name, err)
+		}
+	}
+
+	pfxData, err := Encode(rand.Reader, key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak, err := WeakAlgorithms(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(weak) != 0 {
+		t.Errorf("default Encode produced weak algorithms: %v", weak)
+	}
+}
+
+func TestWeakAlgorithmsReport(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+
+	pfxData, err := LegacyRC2.AllowWeakAlgorithms().Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weak, err := WeakAlgorithms(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []WeakAlgorithm{
+		{UsageMAC, oidSHA1, "sha1"},
+		{UsageSafeContents, oidPBEWithSHAAnd40BitRC2CBC, "pbeWithSHA1And40BitRC2-CBC"},
+		{UsageKeyBag, oidPBEWithSHAAnd3KeyTripleDESCBC, "pbeWithSHA1And3-KeyTripleDES-CBC"},
+	}
+	if len(weak) != len(expected) {
+		t.Fatalf("expected %d weak algorithms, got %v", len(expected), weak)
+	}
+	for i := range expected {
+		if weak[i].Usage != expected[i].Usage || !weak[i].Algorithm.Equal(expected[i].Algorithm) || weak[i].Name != expected[i].Name {
+			t.Errorf("weak algorithm %d: expected %v, got %v", i, expected[i], weak[i])
+		}
+	}
+
+	p12, _ := base64.StdEncoding.DecodeString(openSSL3DefaultP12)
+	if weak, err := WeakAlgorithms(p12, "password"); err != nil || len(weak) != 0 {
+		t.Errorf("OpenSSL 3 default file: unexpected result %v, %v", weak, err)
+	}
+}
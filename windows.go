@@ -0,0 +1,64 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"encoding/asn1"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// Files exported by Windows differ from what OpenSSL writes in ways the
+// decoder handles deliberately.  The first four are those of the Windows
+// export among the fixtures of pkcs12_test.go, "Windows Azure Tools"; the
+// others are reported for CryptoAPI and CNG exports but are only tested
+// with files built to show them, since no such export is checked in,
+// whether from CryptoAPI or CNG, from the user or the machine store:
+//
+//   - The empty password may be turned into a zero-length key rather than
+//     into the two zero bytes of an empty BMPString; verifyAuthenticatedSafe
+//     tries both.
+//   - Certificates are put in a SafeContents encrypted with 40-bit RC2,
+//     which every Decoder except one in FIPS mode reads.
+//   - localKeyId values are small integers such as 01 00 00 00 rather than
+//     certificate fingerprints, and are only unique within the file.
+//   - Key bags carry the Microsoft CSP name attribute; Entry keeps it, and
+//     ToPEM writes it as OpenSSL does.
+//   - Attributes may come with an empty SET of values, such as a
+//     friendlyName of a certificate without a name, or the Microsoft local
+//     key set attribute of machine store exports, which never has a value.
+//     An attribute without a value is treated as absent, and ToPEM writes
+//     it as a header with an empty value.
+//   - Attributes may be repeated, or have several values.  The first value
+//     of the first attribute of a type that has one is used.
+
+// oidMicrosoftLocalKeySet marks a key exported from the machine store.
+var oidMicrosoftLocalKeySet = asn1.ObjectIdentifier([]int{1, 3, 6, 1, 4, 1, 311, 17, 2})
+
+// firstAttributeValue returns the DER encoding of the first value of the
+// first attribute of type attrType among attributes that has a value, or
+// nil if there is none.
+func firstAttributeValue(attributes []pkcs12Attribute, attrType asn1.ObjectIdentifier) []byte {
+	for _, attribute := range attributes {
+		if !attribute.Id.Equal(attrType) {
+			continue
+		}
+		if value := attribute.firstValue(); value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// firstValue returns the DER encoding of the first value of attribute, or
+// nil if its SET of values is empty or malformed.
+func (attribute *pkcs12Attribute) firstValue() []byte {
+	var value cryptobyte.String
+	if set := cryptobyte.String(attribute.Value.Bytes); !set.ReadAnyASN1Element(&value, nil) {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,160 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// TestWindowsExport checks the quirks listed in windows.go against the
+// Windows export among the fixtures of pkcs12_test.go.
+func TestWindowsExport(t *testing.T) {
+	pfxData, err := base64.StdEncoding.DecodeString(testdata["Windows Azure Tools"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfx, err := parsePFX(pfxData, DefaultLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifyMac(DefaultDecoder.kdfContext(), &pfx.MacData, pfx.AuthSafe.Content.Bytes, nil) != nil {
+		t.Error("MAC is not keyed with a zero-length password")
+	}
+	probe, err := Probe(pfxData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probe.SafeContents) != 2 || probe.SafeContents[1].Encryption == nil || !probe.SafeContents[1].Encryption.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC) {
+		t.Errorf("certificates not encrypted with 40-bit RC2: %+v", probe.SafeContents)
+	}
+
+	decoded, err := DecodeContents(pfxData, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, cert := decoded[0].Entries[0], decoded[1].Entries[0]
+	if id := []byte{1, 0, 0, 0}; !bytes.Equal(key.LocalKeyID(), id) || !bytes.Equal(cert.LocalKeyID(), id) {
+		t.Errorf("unexpected localKeyIds %x and %x", key.LocalKeyID(), cert.LocalKeyID())
+	}
+	blocks, err := ToPEM(pfxData, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Headers["Microsoft CSP Name"] != "Microsoft Software Key Storage Provider" {
+		t.Errorf("unexpected PEM blocks %v", blocks)
+	}
+}
+
+// TestWindowsQuirks decodes a file built by the test with the quirks listed
+// in windows.go, as a machine store export is reported to have them; it is
+// not a Windows export.
+func TestWindowsQuirks(t *testing.T) {
+	caKey, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, caCert, caKey)
+
+	id := []byte{1, 0, 0, 0}
+	idValue := LocalKeyIDAttribute(id).Values[0]
+	cspName, err := marshalBmpString("Microsoft Software Key Storage Provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyName, err := FriendlyNameAttribute("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := []SafeContents{
+		{Encrypted: true, Entries: []Entry{
+			{BagType: CertBag, Certificate: cert, Attributes: []Attribute{
+				{Type: OIDFriendlyName},
+				{Type: OIDLocalKeyID, Values: [][]byte{idValue, idValue}},
+			}},
+			{BagType: CertBag, Certificate: caCert, Attributes: []Attribute{emptyName}},
+		}},
+		{Entries: []Entry{
+			{BagType: PKCS8ShroudedKeyBag, PrivateKey: key, Attributes: []Attribute{
+				LocalKeyIDAttribute(id),
+				LocalKeyIDAttribute(id),
+				{Type: OIDMicrosoftCSP, Values: [][]byte{cspName}},
+				{Type: oidMicrosoftLocalKeySet},
+			}},
+		}},
+	}
+	pfxData, err := LegacyRC2.AllowWeakAlgorithms().EncodeContents(contents, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKey, certificate, caCerts, err := DecodeChain(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !certificate.Equal(cert) || len(caCerts) != 1 || !caCerts[0].Equal(caCert) {
+		t.Error("unexpected certificates")
+	}
+	if err := MatchKeyToCert(privateKey, certificate); err != nil {
+		t.Error(err)
+	}
+
+	decoded, err := DecodeContents(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := decoded[0].Entries[0]
+	if name := leaf.FriendlyName(); name != "" {
+		t.Errorf("unexpected friendlyName %q", name)
+	}
+	if !bytes.Equal(leaf.LocalKeyID(), id) || !bytes.Equal(decoded[1].Entries[0].LocalKeyID(), id) {
+		t.Error("unexpected localKeyId")
+	}
+
+	blocks, err := ToPEM(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 PEM blocks, got %d", len(blocks))
+	}
+	if name, ok := blocks[0].Headers["friendlyName"]; !ok || name != "" {
+		t.Errorf("unexpected friendlyName header %q", name)
+	}
+	keyHeaders := blocks[2].Headers
+	if keyHeaders["localKeyId"] != "01000000" || keyHeaders["Microsoft CSP Name"] != "Microsoft Software Key Storage Provider" {
+		t.Errorf("unexpected key headers %v", keyHeaders)
+	}
+	if _, ok := keyHeaders["Microsoft Local Key set"]; !ok {
+		t.Errorf("missing Microsoft Local Key set header in %v", keyHeaders)
+	}
+}
+
+func TestWindowsQuirksTrustStore(t *testing.T) {
+	_, caCert := makeTestCertificate(t, "Test CA", true, nil, nil)
+	name, err := FriendlyNameAttribute("test ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfxData, err := LegacyRC2.AllowWeakAlgorithms().EncodeContents([]SafeContents{
+		{Encrypted: true, Entries: []Entry{
+			{BagType: CertBag, Certificate: caCert, Attributes: []Attribute{
+				{Type: OIDFriendlyName},
+				{Type: OIDFriendlyName, Values: [][]byte{name.Values[0], name.Values[0]}},
+			}},
+		}},
+	}, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := DecodeTrustStore(pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs["test ca"].Equal(caCert) {
+		t.Errorf("unexpected trust store %v", certs)
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+// wipe overwrites b with zeros.
+//
+// It is used on a best-effort basis to limit how long encoded passwords,
+// password-derived keys and decrypted private keys stay on the heap.  It
+// cannot reach copies made by the Go runtime, by the standard library (for
+// example expanded cipher key schedules), or the parsed private keys
+// returned to the caller.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
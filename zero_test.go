@@ -0,0 +1,84 @@
+// Copyright 2015, 2018, 2019 Opsmate, Inc. All rights reserved.
+// Copyright 2015 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pkcs12
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"io"
+	"testing"
+)
+
+func TestDecryptPrivateKeyInto(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	expected, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pfxData, err := Modern.Encode(key, cert, nil, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := make([]byte, 4096)
+	n, err := DecryptPrivateKeyInto(dst, pfxData, DefaultPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dst[:n], expected) {
+		t.Errorf("expected %x, got %x", expected, dst[:n])
+	}
+
+	short := make([]byte, len(expected)-1)
+	if n, err := DecryptPrivateKeyInto(short, pfxData, DefaultPassword); err != io.ErrShortBuffer || n != 0 {
+		t.Errorf("expected io.ErrShortBuffer, got %d, %v", n, err)
+	}
+	if !bytes.Equal(short, make([]byte, len(short))) {
+		t.Error("short buffer was written to")
+	}
+}
+
+func TestBmpToUTF8(t *testing.T) {
+	for _, s := range []string{"", "changeit", "päss wörd", "☃"} {
+		encoded, err := bmpString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := bmpToUTF8(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded) != s {
+			t.Errorf("expected %q, got %q", s, decoded)
+		}
+	}
+}
+
+func TestDecodeBytesPassword(t *testing.T) {
+	key, cert := makeTestCertificate(t, "leaf.example.com", false, nil, nil)
+	pfxData, err := Modern.Encode(key, cert, nil, "päss wörd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password := []byte("päss wörd")
+	decodedKey, decodedCert, err := DecodeBytesPassword(pfxData, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(password) != "päss wörd" {
+		t.Error("password was modified")
+	}
+	if !decodedKey.(*ecdsa.PrivateKey).Equal(key) || !decodedCert.Equal(cert) {
+		t.Error("decoded key or certificate does not match")
+	}
+
+	if _, _, err := DecodeBytesPassword(pfxData, []byte("wrong")); err != ErrIncorrectPassword {
+		t.Errorf("expected ErrIncorrectPassword, got %v", err)
+	}
+}